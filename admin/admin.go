@@ -0,0 +1,109 @@
+package admin
+
+// Status and profiles of a running server for operators
+// The admin service is served on a listener of its own, on the admin host rather than the public port,
+// and uses json instead of protobufs, so it can change without regenerating code
+// Metrics and net/http/pprof are served over http on the admin port
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type StatusRequest struct{}
+
+type Status struct {
+	Server        int
+	Address       string
+	Round         int
+	Layer         int
+	PathRound     bool
+	RoundComplete bool
+	// as reported to the grpc health service
+	Live         bool
+	Ready        bool
+	HealthReason string
+	Synchronizer SyncStatus
+	// number of keys in the lookup table of each layer
+	Keys     []int
+	Peers    []PeerStatus
+	Groups   []GroupStatus
+	Memory   MemoryStatus
+	Failures FailureStatus
+}
+
+type SyncStatus struct {
+	Round     int
+	Layer     int
+	Processed int
+	Threshold int
+	// servers that have sent their batch for the layer
+	Started []int
+	Waiting []int
+}
+
+type PeerStatus struct {
+	Id       int
+	Address  string
+	Incoming string
+	Outgoing string
+	Churned  bool
+}
+
+type GroupStatus struct {
+	Id      int
+	Servers []int
+	// signatures of the last checkpoint still missing
+	MissingSignatures int
+}
+
+type MemoryStatus struct {
+	HeapAlloc  uint64
+	HeapInuse  uint64
+	Sys        uint64
+	NumGC      uint32
+	Goroutines int
+	Uptime     time.Duration
+}
+
+// profile a round, or one layer of it, once the server reaches it
+type ProfileRequest struct {
+	// cpu, or heap at the end of the round or layer
+	Kind  string
+	Round int
+	Layer int
+}
+
+const AllLayers = -1
+
+const (
+	CPUProfile  = "cpu"
+	HeapProfile = "heap"
+)
+
+// pprof encoded, samples are labeled with round, layer and phase
+type Profile struct {
+	Server int
+	Kind   string
+	Round  int
+	Layer  int
+	Data   []byte
+}
+
+// e.g. server2-round5-layer3.cpu.pprof
+func (p *Profile) FileName() string {
+	layer := "all"
+	if p.Layer != AllLayers {
+		layer = strconv.Itoa(p.Layer)
+	}
+	return fmt.Sprintf("server%d-round%d-layer%s.%s.pprof", p.Server, p.Round, layer, p.Kind)
+}
+
+type FailureStatus struct {
+	State          string
+	EnvelopeErrors map[int]map[string]int
+	Churned        map[int]string
+	Missing        int
+	Reason         string
+}
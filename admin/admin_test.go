@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testServer struct {
+	UnimplementedAdminServer
+	status *Status
+}
+
+func (t *testServer) Status(context.Context, *StatusRequest) (*Status, error) {
+	return t.status, nil
+}
+
+func (t *testServer) Profile(_ context.Context, req *ProfileRequest) (*Profile, error) {
+	return &Profile{Server: t.status.Server, Kind: req.Kind, Round: req.Round, Layer: req.Layer, Data: []byte{1, 2, 3}}, nil
+}
+
+func dial(t *testing.T, srv AdminServer) *AdminClient {
+	lis := bufconn.Listen(1 << 16)
+	s := grpc.NewServer()
+	RegisterAdminServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewAdminClient(cc)
+}
+
+func TestStatus(t *testing.T) {
+	expected := &Status{
+		Server:       2,
+		Round:        3,
+		Layer:        1,
+		Synchronizer: SyncStatus{Layer: 1, Processed: 1, Threshold: 2, Started: []int{0}, Waiting: []int{1}},
+		Keys:         []int{10, 10},
+		Peers:        []PeerStatus{{Id: 0, Incoming: "up", Outgoing: "failed", Churned: true}},
+		Groups:       []GroupStatus{{Id: 0, Servers: []int{0, 2}}},
+		Failures:     FailureStatus{State: "running", EnvelopeErrors: map[int]map[string]int{0: {"Duplicate message": 2}}, Churned: map[int]string{0: "Network failure"}},
+	}
+	c := dial(t, &testServer{status: expected})
+	s, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("Wrong status %+v", s)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	c := dial(t, &testServer{status: &Status{Server: 2}})
+	p, err := c.Profile(context.Background(), &ProfileRequest{Kind: CPUProfile, Round: 5, Layer: AllLayers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Data, []byte{1, 2, 3}) || p.FileName() != "server2-round5-layerall.cpu.pprof" {
+		t.Fatalf("Wrong profile %+v %s", p, p.FileName())
+	}
+}
+
+func TestUnimplemented(t *testing.T) {
+	c := dial(t, UnimplementedAdminServer{})
+	_, err := c.Status(context.Background())
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Wrong error %v", err)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		h := Handler(enabled)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Metrics returned %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+		if (w.Code == http.StatusOK) != enabled {
+			t.Fatalf("Pprof returned %d with pprof enabled %v", w.Code, enabled)
+		}
+	}
+	if config.AdminAddress("10.0.0.1:50000") != "127.0.0.1:52000" {
+		t.Fatalf("Admin http listens on %s", config.AdminAddress("10.0.0.1:50000"))
+	}
+}
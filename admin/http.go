@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/simonlangowski/lightning1/logging"
+	"github.com/simonlangowski/lightning1/metrics"
+)
+
+// serve net/http/pprof on the admin port, off unless set
+const PprofEnv = "LIGHTNING_PPROF"
+
+// /metrics, and /debug/pprof if enabled
+func Handler(enablePprof bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// serve the admin http endpoints, addr is on the admin host (loopback by default)
+func ServeHTTP(addr string, enablePprof bool) *http.Server {
+	server := &http.Server{Addr: addr, Handler: Handler(enablePprof)}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logging.For("admin").Error("admin http server stopped", "addr", addr, "error", err)
+		}
+	}()
+	return server
+}
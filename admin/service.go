@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// content subtype of admin rpcs, application/grpc+json
+const codecName = "json"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)   { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error { return json.Unmarshal(b, v) }
+func (jsonCodec) Name() string                            { return codecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type AdminServer interface {
+	Status(context.Context, *StatusRequest) (*Status, error)
+	// waits for the round to be reached and profiled
+	Profile(context.Context, *ProfileRequest) (*Profile, error)
+}
+
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) Status(context.Context, *StatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+
+func (UnimplementedAdminServer) Profile(context.Context, *ProfileRequest) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Profile not implemented")
+}
+
+const serviceName = "lightning.Admin"
+
+func statusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Status"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func profileHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Profile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Profile"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Profile(ctx, req.(*ProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Status", Handler: statusHandler},
+		{MethodName: "Profile", Handler: profileHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin/service.go",
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+type AdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) *AdminClient {
+	return &AdminClient{cc}
+}
+
+func (c *AdminClient) Status(ctx context.Context, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/Status", &StatusRequest{}, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// profiles can be larger than the default message limit
+const maxProfileSize = 64 << 20
+
+func (c *AdminClient) Profile(ctx context.Context, in *ProfileRequest, opts ...grpc.CallOption) (*Profile, error) {
+	out := new(Profile)
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName), grpc.MaxCallRecvMsgSize(maxProfileSize)}, opts...)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/Profile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -6,15 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"runtime"
 	"sync"
 
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
+	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/server/common"
 	"github.com/simonlangowski/lightning1/server/prepareMessages"
@@ -24,6 +25,8 @@ import (
 
 const blockSize = 10000
 
+var log = logging.For("client")
+
 type ClientRunner struct {
 	C                 *common.CommonState
 	Clients           map[int64]*prepareMessages.Client
@@ -33,12 +36,14 @@ type ClientRunner struct {
 	idx               int
 	mu                sync.Mutex
 	RecordedClients   []*prepareMessages.MarshallableClient
+	// set when the clients move to new epochs by themselves
+	directory *config.DirectorySource
 	coord.UnimplementedCoordinatorHandlerServer
 }
 
 func NewClientRunner(servers map[int64]*config.Server, groups map[int64]*config.Group) *ClientRunner {
 	return &ClientRunner{
-		C:       common.NewCommonState(servers, 0, &config.Groups{Groups: groups}),
+		C:       common.NewPublicCommonState(servers, &config.Groups{Groups: groups}),
 		Clients: make(map[int64]*prepareMessages.Client),
 		Sem:     make(chan bool, 32*runtime.NumCPU()),
 	}
@@ -46,7 +51,7 @@ func NewClientRunner(servers map[int64]*config.Server, groups map[int64]*config.
 
 func (c *ClientRunner) Connect() error {
 	var err error
-	c.Caller, err = network.NewCaller(c.C.Configs)
+	c.Caller, err = network.NewCaller(c.C.Configs, nil)
 	if err != nil {
 		return err
 	}
@@ -54,6 +59,44 @@ func (c *ClientRunner) Connect() error {
 	return nil
 }
 
+// move to the epoch of the directory in source when the coordinator starts a round of a newer epoch
+func (c *ClientRunner) FollowDirectory(source *config.DirectorySource) {
+	c.directory = source
+}
+
+// move to the servers and groups of a new epoch, called between rounds
+// the group keys stay the same, so the clients are made again with the keys they had
+func (c *ClientRunner) Reconfigure(epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) error {
+	err := c.C.Reconfigure(epoch, servers, &config.Groups{Groups: groups})
+	if err != nil {
+		return err
+	}
+	// each client has a copy of the common state
+	c.Clients = make(map[int64]*prepareMessages.Client)
+	if c.Caller != nil {
+		err = c.Caller.Reconfigure(servers)
+		if err != nil {
+			return err
+		}
+		c.Caller.SetGroups(groups)
+	}
+	return nil
+}
+
+func (c *ClientRunner) advance(epoch int) error {
+	if epoch <= c.C.Epoch {
+		return nil
+	}
+	if c.directory == nil {
+		return fmt.Errorf("the clients are in epoch %d and do not follow a directory to move to epoch %d", c.C.Epoch, epoch)
+	}
+	d, err := c.directory.FetchEpoch(epoch)
+	if err != nil {
+		return err
+	}
+	return c.Reconfigure(d.Epoch, d.Servers, d.Groups)
+}
+
 func (c *ClientRunner) KeySet(_ context.Context, m *coord.KeyInformation) (*coord.KeyInformation, error) {
 	c.C.CombinedKey = &token.TokenPublicKey{}
 	err := c.C.CombinedKey.InterpretFrom(m.TokenPublicKey)
@@ -64,6 +107,13 @@ func (c *ClientRunner) KeySet(_ context.Context, m *coord.KeyInformation) (*coor
 	if err != nil {
 		return nil, err
 	}
+	if len(m.TicketPublicKey) > 0 {
+		c.C.TicketKey = &token.TokenPublicKey{}
+		err = c.C.TicketKey.InterpretFrom(m.TicketPublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &coord.KeyInformation{}, nil
 }
 
@@ -80,12 +130,19 @@ func (c *ClientRunner) AddClient(id int64) error {
 }
 
 func (c *ClientRunner) ClientStart(_ context.Context, i *coord.RoundInfo) (*coord.Empty, error) {
-	if i.Round == 0 && i.Interval > 0 {
-		errors.MonitorMemory("client", c.C.MyId, i.Interval)
+	err := c.advance(int(i.Epoch))
+	if err != nil {
+		return nil, err
 	}
 	c.C.NumLayers = int(i.NumLayers)
 	c.C.Round = int(i.Round)
 	c.C.BoomerangLimit = int(i.BoomerangLimit)
+	c.C.Suite = crypto.CipherSuite(i.CipherSuite)
+	c.C.KeyAgreement = crypto.KeyAgreement(i.KeyAgreement)
+	c.C.PostingQuota = int(i.PostingQuota)
+	if !c.C.SupportsKeyAgreement(c.C.KeyAgreement) {
+		return nil, errors.UnrecognizedError().InRound(int(i.Round))
+	}
 	for id := i.StartId; id < i.EndId; id++ {
 		if c.Clients[id] == nil {
 			c.AddClient(id)
@@ -103,6 +160,10 @@ func (c *ClientRunner) ClientStart(_ context.Context, i *coord.RoundInfo) (*coor
 				cli := c.Clients[id]
 				cli.Common.Round = int(i.Round)
 				cli.Common.NumLayers = int(i.NumLayers)
+				cli.Common.Suite = c.C.Suite
+				cli.Common.KeyAgreement = c.C.KeyAgreement
+				cli.Common.PostingQuota = c.C.PostingQuota
+				cli.Common.TicketKey = c.C.TicketKey
 				if i.PathEstablishment {
 					err := cli.RegisterClient(c.Caller)
 					if err != nil {
@@ -121,6 +182,14 @@ func (c *ClientRunner) ClientStart(_ context.Context, i *coord.RoundInfo) (*coor
 					}
 				} else {
 					if i.SkipPathGen {
+						if c.C.PostingQuota > 0 && !cli.Registered() {
+							// tickets are only signed for registered clients
+							err := cli.RegisterClient(c.Caller)
+							if err != nil {
+								done <- err
+								return
+							}
+						}
 						cli.SkipPathGen(c.Caller, i)
 					}
 					m := make([]byte, i.MessageSize)
@@ -130,15 +199,20 @@ func (c *ClientRunner) ClientStart(_ context.Context, i *coord.RoundInfo) (*coor
 			}(id)
 		}
 	}()
+	// wait for every client, so none is still running when the clients change between rounds
+	var failed error
 	for id := i.StartId; id < i.EndId; id++ {
 		err := <-done
-		if err != nil {
-			return nil, err
+		if err != nil && failed == nil {
+			failed = err
 		}
 		if id%1024 == 512 {
-			log.Printf("%d/%d", id-i.StartId, (i.EndId - i.StartId))
+			log.Info("clients prepared", "done", id-i.StartId, "total", i.EndId-i.StartId)
 		}
 	}
+	if failed != nil {
+		return nil, failed
+	}
 	if len(c.RecordMessageFile) > 0 && i.PathEstablishment {
 		c.WriteClientsToFile()
 	}
@@ -220,7 +294,7 @@ func (c *ClientRunner) readClientsFromFile(fn string, idx int) error {
 	if err != nil {
 		return err
 	}
-	log.Printf("Read %s", n)
+	log.Info("read recorded clients", "file", n)
 	err = json.Unmarshal(b, &c.RecordedClients)
 	if err != nil {
 		panic(err)
@@ -238,7 +312,7 @@ func (c *ClientRunner) sendLoadedMessages() {
 		c.Clients[m.ID] = cli
 		go func(message []byte) {
 			pm := common.PathEstablishmentEnvelope{}
-			pm.InterpretFrom(message)
+			pm.InterpretFrom(message, c.C.KeyAgreement.CiphertextSize())
 			done <- cli.SubmitPathEstablishmentMessage(c.Caller, &pm)
 		}(m.Message)
 	}
@@ -254,6 +328,7 @@ func (c *ClientRunner) SendLoadedMessages(i *coord.RoundInfo) {
 	c.C.NumLayers = int(i.NumLayers)
 	c.C.Round = int(i.Round)
 	c.C.BoomerangLimit = int(i.BoomerangLimit)
+	c.C.KeyAgreement = crypto.KeyAgreement(i.KeyAgreement)
 
 	for {
 		err := c.readClientsFromFile(c.RecordMessageFile, c.idx)
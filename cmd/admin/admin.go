@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/simonlangowski/lightning1/admin"
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/network"
+)
+
+// query the status of running servers, profile a round on them, audit a verifiable round or run the beacon of an epoch
+
+type statusCmd struct {
+	Json    bool          `default:"False"`
+	Timeout time.Duration `default:"5s"`
+}
+
+type profileCmd struct {
+	Kind  string `default:"cpu" help:"cpu or heap"`
+	Round int    `arg:"required"`
+	Layer int    `default:"-1" help:"a single layer, or -1 for the whole round"`
+	// the servers wait for the round to be reached
+	Timeout time.Duration `default:"10m"`
+	Dir     string        `default:"." help:"directory for the profiles"`
+}
+
+// every server is audited, so the challenges can be checked
+type auditCmd struct {
+	Round   int           `arg:"required" help:"the current round of the servers, which must be verifiable"`
+	Timeout time.Duration `default:"1m"`
+}
+
+// the transcript lets anyone check the output, and the groups of the epoch formed from it
+type beaconCmd struct {
+	Epoch      int           `arg:"required"`
+	Transcript string        `default:"beacon.bin" help:"the transcript is written here, or read with --check"`
+	Check      bool          `default:"False" help:"check the transcript of an earlier run instead of running the beacon"`
+	GroupFile  string        `default:"" help:"write the anytrust groups of the epoch here"`
+	NumGroups  int           `default:"1"`
+	GroupSize  int           `default:"1"`
+	Timeout    time.Duration `default:"1m"`
+}
+
+var args struct {
+	ServerFile string      `default:"servers.json"`
+	Servers    []int       `help:"server ids to query, all if empty"`
+	Status     *statusCmd  `arg:"subcommand:status"`
+	Profile    *profileCmd `arg:"subcommand:profile"`
+	Audit      *auditCmd   `arg:"subcommand:audit"`
+	Beacon     *beaconCmd  `arg:"subcommand:beacon"`
+}
+
+func main() {
+	p := arg.MustParse(&args)
+	if args.Status == nil && args.Profile == nil && args.Audit == nil && args.Beacon == nil {
+		p.WriteHelp(os.Stdout)
+		return
+	}
+	servers, err := config.UnmarshalServersFromFile(args.ServerFile)
+	if err != nil {
+		log.Fatalf("Could not read servers file %s", args.ServerFile)
+	}
+	ids := args.Servers
+	if len(ids) == 0 {
+		for sid := range servers {
+			ids = append(ids, int(sid))
+		}
+		sort.Ints(ids)
+	}
+	// no client certificate is needed to ask for status
+	conns, err := network.GetConnections(servers, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if args.Audit != nil {
+		if !runAudit(servers, conns) {
+			os.Exit(1)
+		}
+		return
+	}
+	if args.Beacon != nil {
+		if !runBeacon(servers, conns) {
+			os.Exit(1)
+		}
+		return
+	}
+	// the admin service is on its own port on the admin host
+	adminConns, err := network.GetAdminConnections(servers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clients := make(map[int]*admin.AdminClient)
+	for _, sid := range ids {
+		cc, ok := adminConns[sid]
+		if !ok {
+			log.Fatalf("No server %d in %s", sid, args.ServerFile)
+		}
+		clients[sid] = admin.NewAdminClient(cc)
+	}
+	var ok bool
+	if args.Status != nil {
+		ok = runStatus(ids, clients)
+	} else {
+		ok = runProfile(ids, clients)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func runStatus(ids []int, clients map[int]*admin.AdminClient) bool {
+	ok := true
+	for _, sid := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), args.Status.Timeout)
+		status, err := clients[sid].Status(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("server %d: %v\n", sid, err)
+			ok = false
+			continue
+		}
+		if args.Status.Json {
+			b, _ := json.Marshal(status)
+			fmt.Println(string(b))
+		} else {
+			printStatus(status)
+		}
+	}
+	return ok
+}
+
+// all servers profile the same round at once
+func runProfile(ids []int, clients map[int]*admin.AdminClient) bool {
+	cmd := args.Profile
+	req := &admin.ProfileRequest{Kind: cmd.Kind, Round: cmd.Round, Layer: cmd.Layer}
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.Timeout)
+	defer cancel()
+	errs := make(chan error, len(ids))
+	for _, sid := range ids {
+		go func(sid int) {
+			p, err := clients[sid].Profile(ctx, req)
+			if err == nil {
+				path := filepath.Join(cmd.Dir, p.FileName())
+				err = ioutil.WriteFile(path, p.Data, 0644)
+				if err == nil {
+					fmt.Printf("server %d: wrote %s\n", sid, path)
+				}
+			}
+			if err != nil {
+				err = fmt.Errorf("server %d: %v", sid, err)
+			}
+			errs <- err
+		}(sid)
+	}
+	ok := true
+	for range ids {
+		if err := <-errs; err != nil {
+			fmt.Println(err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func printStatus(s *admin.Status) {
+	complete := ""
+	if s.RoundComplete {
+		complete = " (complete)"
+	}
+	roundType := "lightning"
+	if s.PathRound {
+		roundType = "path"
+	}
+	fmt.Printf("server %d %s: %s round %d layer %d%s\n", s.Server, s.Address, roundType, s.Round, s.Layer, complete)
+	health := "ready"
+	if !s.Live {
+		health = "not live: " + s.HealthReason
+	} else if !s.Ready {
+		health = "not ready: " + s.HealthReason
+	}
+	fmt.Printf("  health: %s\n", health)
+	fmt.Printf("  synchronizer: round %d layer %d, %d/%d processed, waiting for %v\n",
+		s.Synchronizer.Round, s.Synchronizer.Layer, s.Synchronizer.Processed, s.Synchronizer.Threshold, s.Synchronizer.Waiting)
+	fmt.Printf("  keys per layer: %v\n", s.Keys)
+	for _, p := range s.Peers {
+		churned := ""
+		if p.Churned {
+			churned = " churned"
+		}
+		fmt.Printf("  peer %d %s: in %s, out %s%s\n", p.Id, p.Address, p.Incoming, p.Outgoing, churned)
+	}
+	for _, g := range s.Groups {
+		fmt.Printf("  group %d %v: %d signatures missing\n", g.Id, g.Servers, g.MissingSignatures)
+	}
+	fmt.Printf("  memory: heap %d MB in use, %d MB from os, %d gcs, %d goroutines, up %v\n",
+		s.Memory.HeapInuse>>20, s.Memory.Sys>>20, s.Memory.NumGC, s.Memory.Goroutines, s.Memory.Uptime.Round(time.Second))
+	f := s.Failures
+	errs := make([]string, 0)
+	for peer, kinds := range f.EnvelopeErrors {
+		for kind, n := range kinds {
+			errs = append(errs, fmt.Sprintf("%d from %d: %s", n, peer, kind))
+		}
+	}
+	sort.Strings(errs)
+	fmt.Printf("  failures: %s, %d missing, envelope errors [%s]", f.State, f.Missing, strings.Join(errs, "; "))
+	if f.Reason != "" {
+		fmt.Printf(", reason: %s", f.Reason)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/processMessages"
+	"google.golang.org/grpc"
+)
+
+// open the links of every layer of a verifiable round on all servers and check them,
+// without trusting the coordinator or any server
+func runAudit(servers map[int64]*config.Server, conns map[int]*grpc.ClientConn) bool {
+	cmd := args.Audit
+	fetch := func(layer int) []*processMessages.ShuffleAudit {
+		audits := make([]*processMessages.ShuffleAudit, len(servers))
+		done := make(chan error)
+		for sid := range audits {
+			go func(sid int) {
+				a, err := fetchAudit(sid, layer, servers[int64(sid)], conns[sid])
+				if err != nil {
+					err = fmt.Errorf("server %d layer %d: %v", sid, layer, err)
+				}
+				audits[sid] = a
+				done <- err
+			}(sid)
+		}
+		for range audits {
+			if err := <-done; err != nil {
+				fmt.Println(err)
+			}
+		}
+		return audits
+	}
+	// the first answer gives the number of layers
+	audits := [][]*processMessages.ShuffleAudit{fetch(0)}
+	numLayers := 0
+	for _, a := range audits[0] {
+		if a != nil {
+			numLayers = a.NumLayers
+			break
+		}
+	}
+	if numLayers == 0 {
+		fmt.Printf("no server answered for round %d\n", cmd.Round)
+		return false
+	}
+	for layer := 1; layer < numLayers; layer++ {
+		audits = append(audits, fetch(layer))
+	}
+	failures := processMessages.VerifyAudits(cmd.Round, audits, verificationKeys(servers))
+	for _, err := range failures {
+		fmt.Println(err)
+	}
+	for layer := range audits {
+		for sid, a := range audits[layer] {
+			if a != nil {
+				fmt.Printf("server %d layer %d: %d links, %d opened, %d inputs rejected\n", sid, layer, a.Commitment.NumLinks, len(a.Openings), a.Commitment.Rejected())
+			}
+		}
+	}
+	return len(failures) == 0
+}
+
+func fetchAudit(sid, layer int, server *config.Server, cc *grpc.ClientConn) (*processMessages.ShuffleAudit, error) {
+	req := messages.NewSignedMessage(0, args.Audit.Round, layer, 0, 0, 0, 1, messages.NetworkMessage_ShuffleAudit)
+	// packs the metadata, audits do not need to be signed
+	req.GetSignedData()
+	m, err := send(sid, cc, req, args.Audit.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return processMessages.ReadAudit(m, crypto.VerificationKey(server.VerificationKey))
+}
+
+// send an unsigned request to a server, and parse its answer
+func send(sid int, cc *grpc.ClientConn, req *messages.SignedMessage, timeout time.Duration) (*messages.SignedMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := messages.NewMessageHandlersClient(cc).HandleSignedMessage(ctx, req.AsNetworkMessage())
+	if err != nil {
+		return nil, err
+	}
+	m := messages.ParseSignedMessage(resp)
+	if m == nil || m.Sender != sid {
+		return nil, fmt.Errorf("not an answer from server %d", sid)
+	}
+	return m, nil
+}
+
+// the verification keys of every server, by id
+func verificationKeys(servers map[int64]*config.Server) []crypto.VerificationKey {
+	vks := make([]crypto.VerificationKey, config.IdSpace(servers))
+	for sid := range vks {
+		if s, ok := servers[int64(sid)]; ok {
+			vks[sid] = s.VerificationKey
+		}
+	}
+	return vks
+}
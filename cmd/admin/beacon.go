@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/beacon"
+	"google.golang.org/grpc"
+)
+
+// run the beacon of an epoch among all servers, or check the transcript of an earlier run,
+// and form the anytrust groups of the epoch from its output
+func runBeacon(servers map[int64]*config.Server, conns map[int]*grpc.ClientConn) bool {
+	cmd := args.Beacon
+	label := beacon.EpochLabel(cmd.Epoch)
+	vks := verificationKeys(servers)
+	var transcript *beacon.Transcript
+	var output beacon.Output
+	var err error
+	if cmd.Check {
+		transcript = &beacon.Transcript{}
+		var b []byte
+		b, err = ioutil.ReadFile(cmd.Transcript)
+		if err == nil {
+			err = transcript.InterpretFrom(b)
+		}
+		if err == nil && transcript.Label != label {
+			err = fmt.Errorf("the transcript is for %v", transcript.Label)
+		}
+		if err == nil {
+			output, err = transcript.Verify(vks)
+		}
+	} else {
+		transcript, output, err = beacon.Run(label, 0, vks, func(sid int, req *messages.SignedMessage) (*messages.SignedMessage, error) {
+			cc, ok := conns[sid]
+			if !ok {
+				return nil, fmt.Errorf("no server %d in %s", sid, args.ServerFile)
+			}
+			return send(sid, cc, req, cmd.Timeout)
+		})
+		if err == nil {
+			err = ioutil.WriteFile(cmd.Transcript, transcript.Marshal(), 0644)
+		}
+	}
+	if err != nil {
+		fmt.Printf("%v: %v\n", label, err)
+		return false
+	}
+	fmt.Printf("%v: %s\n", label, hex.EncodeToString(output[:]))
+	if cmd.GroupFile == "" {
+		return true
+	}
+	ids := make([]int64, 0, len(servers))
+	for sid := range servers {
+		ids = append(ids, sid)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	groups := config.CreateSeparateGroupsWithSize(cmd.NumGroups, cmd.GroupSize, ids, output[:])
+	err = config.MarshalGroupsToFile(cmd.GroupFile, groups)
+	if err != nil {
+		fmt.Printf("Could not write group file %s: %v\n", cmd.GroupFile, err)
+		return false
+	}
+	fmt.Printf("wrote %d groups of %d to %s\n", cmd.NumGroups, cmd.GroupSize, cmd.GroupFile)
+	return true
+}
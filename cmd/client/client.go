@@ -4,9 +4,11 @@ import (
 	"log"
 	"os"
 
+	"github.com/simonlangowski/lightning1/admin"
 	"github.com/simonlangowski/lightning1/client"
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network"
 )
 
@@ -16,23 +18,37 @@ func main() {
 	clientsFile := os.Args[3]
 	addr := os.Args[4]
 	errors.Addr = addr
-	servers, err := config.UnmarshalServersFromFile(serversFile)
-	if err != nil {
-		log.Fatalf("Could not read servers file %s", serversFile)
+	errors.DumpOnError = os.Getenv(logging.DumpEnv) != ""
+	if err := logging.ConfigureFromEnv(); err != nil {
+		log.Fatal(err)
 	}
-	groups, err := config.UnmarshalGroupsFromFile(groupsFile)
+	// from the signed directory in LIGHTNING_DIRECTORY, if it is set
+	d, err := config.LoadNetwork(serversFile, groupsFile)
 	if err != nil {
-		log.Fatalf("Could not read group file %s", groupsFile)
+		log.Fatal(err)
 	}
 	clients, err := config.UnmarshalServersFromFile(clientsFile)
 	if err != nil {
 		log.Fatalf("Could not read clients file %s", clientsFile)
 	}
+	err = config.LoadOwnSecrets(clients, clientsFile, addr)
+	if err != nil {
+		log.Fatalf("Could not read keystore: %v", err)
+	}
 
-	clientRunner := client.NewClientRunner(servers, groups)
+	clientRunner := client.NewClientRunner(d.Servers, d.Groups)
+	clientRunner.C.Epoch = d.Epoch
+	directory, err := config.DirectoryFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if directory != nil {
+		clientRunner.FollowDirectory(directory)
+	}
 	err = clientRunner.Connect()
 	if err != nil {
 		log.Fatalf("Could not make clients %v", err)
 	}
+	admin.ServeHTTP(config.AdminAddress(addr), os.Getenv(admin.PprofEnv) != "")
 	network.RunServer(nil, clientRunner, clients, addr)
 }
@@ -7,23 +7,15 @@ headers = ["NumServers", "NumUsers", "F", "path", "NumLayers", "GroupSize", "Num
 def main():
     file = sys.argv[1]
     f = open(file, "r")
-    contents = f.read()
-    objects = contents.split("}{")
-    results = []
-    for o in objects:
-        if not o.startswith("{"):
-            o = "{" + o
-        if not o.endswith("}"):
-            o = o + "}"
-        j = json.loads(o)
-        results.append(j)
+    # one json record per line
+    results = [json.loads(line) for line in f if line.strip()]
     pathTimes = {}
     broadcastTimes = {}
     for r in results:
-        pathEstablishment = r["Info"].get("pathEstablishment", False)
+        pathEstablishment = r["PathEstablishment"]
         ctime = r["ClientAndServerTokenTime"]
         stime = r["ServerRoundTime"]
-        k = tuple(sorted((r["Notes"].items())))
+        k = tuple(sorted((r["Params"].items())))
         if pathEstablishment :
             acc = pathTimes.get(k, {"ctime": 0, "stime": 0, "path": True})
             acc["ctime"] += ctime
@@ -1,18 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"encoding/csv"
-	"fmt"
 	"log"
 	"math"
 	"os"
-	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/coordinator"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/results"
 	"github.com/simonlangowski/lightning1/server/prepareMessages"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 // run the coordinator, who starts and measures the experiment
@@ -36,7 +36,6 @@ var args struct {
 	NoCheck          bool   `default:"False"`
 	LoadMessages     bool   `default:"False"`
 	StartIdx         int    `default:"0"`
-	Interval         int    `default:"0"`
 	ServerFile       string `default:"servers.json"`
 	GroupFile        string `default:"groups.json"`
 	ClientFile       string `default:"clients.json"`
@@ -44,7 +43,14 @@ var args struct {
 	MessageFile      string `default:"messages.json"`
 	Ips              string `default:"../experiments/ip.list"`
 	Notes            string `default:""`
-	OutFile          string `default:"res.json"`
+	OutFile          string `default:"res.jsonl" help:"results of every run are also appended here"`
+	OutDir           string `default:"results" help:"each experiment writes its rounds to a new directory here"`
+	Spec             string `default:"" help:"json experiment specification, overrides the sizes and rounds given by flags"`
+	NumLightning     int    `default:"5"`
+	CipherSuite      string `default:"" help:"legacy, aes-ctr, chacha20 or chacha20-poly1305"`
+	KeyAgreement     string `default:"" help:"dh or hybrid (DH with ML-KEM-768) for the path keys"`
+	Verifiable       bool   `default:"False" help:"servers commit to each lightning layer and check each other's commitments"`
+	PostingQuota     int    `default:"0" help:"posts each client may make in an epoch, each with a blind signed ticket, 0 for no limit"`
 	NoDummies        bool   `default:"True"`
 
 	Latency   int `default:"0"`
@@ -54,6 +60,19 @@ var args struct {
 func main() {
 	var net *coordinator.CoordinatorNetwork
 	p := arg.MustParse(&args)
+	if err := tracing.StartFromEnv("coordinator"); err != nil {
+		log.Fatal(err)
+	}
+	defer tracing.Stop()
+	var spec *coordinator.Spec
+	if args.Spec != "" {
+		var err error
+		spec, err = coordinator.LoadSpec(args.Spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		specArgs(spec)
+	}
 	if args.NumServers == 0 || args.NumUsers == 0 {
 		log.Printf("Set numservers and numusers")
 		p.WriteHelp(os.Stdout)
@@ -106,15 +125,17 @@ func main() {
 		// run in separate process on the same machine
 		serverConfigs, groupConfigs, clientConfigs := coordinator.NewLocalConfig(args.NumServers, args.NumGroups, args.GroupSize, args.NumClientServers, false)
 		if args.LoadMessages {
-			oldServers, err := config.UnmarshalServersFromFile(args.ServerFile)
+			oldServers, err := config.UnmarshalServersWithKeystores(args.ServerFile)
 			if err != nil {
-				log.Fatalf("Could not read servers file %s", args.ServerFile)
+				log.Fatalf("Could not read servers file %s and its keystores: %v", args.ServerFile, err)
 			}
 			// copy old keys
 			for id, s := range serverConfigs {
 				old := oldServers[id]
 				s.PrivateKey = old.PrivateKey
 				s.PublicKey = old.PublicKey
+				s.KemPrivateKey = old.KemPrivateKey
+				s.KemPublicKey = old.KemPublicKey
 			}
 		}
 		net = coordinator.NewLocalNetwork(serverConfigs, groupConfigs, clientConfigs)
@@ -152,18 +173,18 @@ func main() {
 			}
 		}
 		ids = ids[:args.NumServers]
-		groups := config.CreateSeparateGroupsWithSize(args.NumGroups, args.GroupSize, ids)
+		groups := config.CreateSeparateGroupsWithSize(args.NumGroups, args.GroupSize, ids, config.Seed)
 		if args.NumClientServers > 0 {
-			err := config.MarshalServersToFile(args.ClientFile, clients)
+			err := config.MarshalServersWithKeystores(args.ClientFile, clients)
 			if err != nil {
 				log.Fatalf("Could not write clients file %s", args.ClientFile)
 			}
 		}
 
 		if args.LoadMessages {
-			oldServers, err := config.UnmarshalServersFromFile(args.ServerFile)
+			oldServers, err := config.UnmarshalServersWithKeystores(args.ServerFile)
 			if err != nil {
-				log.Fatalf("Could not read servers file %s", args.ServerFile)
+				log.Fatalf("Could not read servers file %s and its keystores: %v", args.ServerFile, err)
 			}
 			// copy old keys
 			for id, s := range servers {
@@ -173,9 +194,10 @@ func main() {
 			}
 		}
 
-		err := config.MarshalServersToFile(args.ServerFile, servers)
+		// the servers file is public, each server gets only its own keystore
+		err := config.MarshalServersWithKeystores(args.ServerFile, servers)
 		if err != nil {
-			log.Fatalf("Could not write servers file %s", args.ServerFile)
+			log.Fatalf("Could not write servers file %s: %v", args.ServerFile, err)
 		}
 		err = config.MarshalGroupsToFile(args.GroupFile, groups)
 		if err != nil {
@@ -188,8 +210,16 @@ func main() {
 		expectation = math.Ceil(expectation)
 		log.Printf("Dummy overhead: %.2f%%", 100*(float64(args.BinSize)/expectation-1))
 		log.Printf("Group overhead: %f", config.GroupSizeCost(args.GroupSize, args.NumGroups, args.NumServers))
-		bufferSizePath := prepareMessages.PathEstablishmentLengths(args.NumLayers, 8, args.LimitSize)[0] * args.BinSize * args.NumServers
-		bufferSizeLightning := prepareMessages.LightningMessageLengths(args.NumLayers, args.MessageSize)[0] * args.BinSize * args.NumServers
+		agreement := crypto.KeyAgreementDH
+		if args.KeyAgreement != "" {
+			var err error
+			agreement, err = crypto.ParseKeyAgreement(args.KeyAgreement)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		bufferSizePath := prepareMessages.PathEstablishmentLengths(args.NumLayers, 8, args.LimitSize, crypto.DefaultSuite, agreement)[0] * args.BinSize * args.NumServers
+		bufferSizeLightning := prepareMessages.LightningMessageLengths(args.NumLayers, args.MessageSize, crypto.DefaultSuite)[0] * args.BinSize * args.NumServers
 		numDummies := args.BinSize*args.NumServers*args.NumServers - args.NumUsers
 		log.Printf("Total path buffer size: %fG, lightning size %fG, numDummies: %v", float64(bufferSizePath)/1000000000, float64(bufferSizeLightning)/1000000000, numDummies)
 		// log.Printf("Simulated time: %d path, %d broadcast", )
@@ -199,9 +229,9 @@ func main() {
 		args.GroupSize = 1
 		net = coordinator.NewInProcessNetwork(args.NumServers, args.NumGroups, args.GroupSize)
 		if args.LoadMessages {
-			oldServers, err := config.UnmarshalServersFromFile(args.ServerFile)
+			oldServers, err := config.UnmarshalServersWithKeystores(args.ServerFile)
 			if err != nil {
-				log.Fatalf("Could not read servers file %s", args.ServerFile)
+				log.Fatalf("Could not read servers file %s and its keystores: %v", args.ServerFile, err)
 			}
 			// copy old keys
 			for id, s := range net.ServerConfigs {
@@ -212,90 +242,99 @@ func main() {
 			// have to rebuild if we changed the keys...
 			net.SetupInProcess(args.NumServers)
 		}
-		err := config.MarshalServersToFile(args.ServerFile, net.ServerConfigs)
+		err := config.MarshalServersWithKeystores(args.ServerFile, net.ServerConfigs)
 		if err != nil {
-			log.Fatalf("Could not write servers file %s", args.ServerFile)
+			log.Fatalf("Could not write servers file %s: %v", args.ServerFile, err)
 		}
 	}
-	numLayers := args.NumLayers
-	numServers := args.NumServers
-	numMessages := args.NumUsers
-	numLightning := 5
+	if spec == nil {
+		spec = coordinator.DefaultSpec(args.NumLightning, args.MessageSize, args.SkipPathGen, args.LoadMessages)
+		spec.Notes = args.Notes
+		spec.CipherSuite = args.CipherSuite
+		spec.KeyAgreement = args.KeyAgreement
+		spec.PostingQuota = args.PostingQuota
+		for i := range spec.Phases {
+			spec.Phases[i].NoCheck = args.NoCheck
+			spec.Phases[i].Verifiable = args.Verifiable && spec.Phases[i].Type == coordinator.LightningPhase
+		}
+	}
+	spec.NumServers, spec.NumUsers, spec.F = args.NumServers, args.NumUsers, args.F
+	spec.NumGroups, spec.GroupSize, spec.NumLayers = args.NumGroups, args.GroupSize, args.NumLayers
+	spec.BinSize, spec.LimitSize = args.BinSize, args.LimitSize
+	steps, err := spec.Steps()
+	if err != nil {
+		log.Fatal(err)
+	}
 	c := coordinator.NewCoordinator(net)
+	// epoch phases of the spec move the network to the signed directory in LIGHTNING_DIRECTORY
+	c.Directory, err = config.DirectoryFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	if args.LoadMessages {
 		c.LoadKeys(args.KeyFile)
 		c.LoadMessages(args.MessageFile)
 	}
-	l := 0
-	if !args.SkipPathGen {
-		for i := 0; i < numLayers; i++ {
-			log.Printf("Round %v", i)
-			exp := c.NewExperiment(i, numLayers, numServers, numMessages, args)
-			if i == 0 {
-				exp.KeyGen = !args.LoadMessages
-				exp.LoadKeys = args.LoadMessages
-			}
-			exp.Info.PathEstablishment = true
-			exp.Info.LastLayer = (i == numLayers-1)
-			exp.Info.Check = !args.NoCheck
-			exp.Info.Interval = int64(args.Interval)
-			if args.BinSize > 0 {
-				exp.Info.BinSize = int64(args.BinSize)
-			} else if i == 0 {
-				log.Printf("Using bin size %d", exp.Info.BinSize)
-			}
-			if args.LimitSize > 0 {
-				exp.Info.BoomerangLimit = int64(args.LimitSize)
-			} else {
-				exp.Info.BoomerangLimit = int64(numLayers)
-			}
-			exp.Info.ReceiptLayer = 0
-			if i-int(exp.Info.BoomerangLimit) > 0 {
-				exp.Info.ReceiptLayer = int64(i) - exp.Info.BoomerangLimit
-			}
-			exp.Info.NextLayer = int64(i)
-			if args.RunType == 5 {
-				exp.Info.StartId = int64(args.StartIdx)
-				exp.Info.EndId = exp.Info.StartId + int64(numMessages)
-				c.WriteKeys(args.KeyFile)
-				c.WriteMessages(args.MessageFile, exp)
-				return
-			}
-			err := c.DoAction(exp)
-			if err != nil {
-				log.Print(err)
-				return
-			}
-			log.Printf("Path round %v took %v", i, time.Since(exp.ExperimentStartTime))
-			exp.RecordToFile(args.OutFile)
-			RecordToCsv(args.OutFile+".csv", exp)
-		}
-		l = numLayers
+	if args.RunType == 5 {
+		exp := c.StepExperiment(spec, steps[0])
+		exp.Info.StartId = int64(args.StartIdx)
+		exp.Info.EndId = exp.Info.StartId + int64(args.NumUsers)
+		c.WriteKeys(args.KeyFile)
+		c.WriteMessages(args.MessageFile, exp)
+		return
 	}
-	for i := l; i < l+numLightning; i++ {
-		log.Printf("Round %v", i)
-		exp := c.NewExperiment(i, numLayers, numServers, numMessages, args)
-		exp.Info.PathEstablishment = false
-		exp.Info.MessageSize = int64(args.MessageSize)
-		exp.Info.Check = !args.NoCheck
-		if args.BinSize > 0 {
-			exp.Info.BinSize = int64(args.BinSize)
-		}
-		if args.SkipPathGen && (i == 0) {
-			exp.Info.SkipPathGen = true
-			exp.KeyGen = true
-		}
-		exp.Info.Interval = int64(args.Interval)
-		err := c.DoAction(exp)
-		if err != nil {
+	dir, err := coordinator.ExperimentDir(args.OutDir, spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Writing results to %s", dir)
+	store, err := results.OpenStore(args.OutFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	params := spec.Params()
+	params.NumClientServers, params.RunType = args.NumClientServers, args.RunType
+	params.Bandwidth, params.Latency = args.Bandwidth, args.Latency
+	err = c.RunSpec(spec, dir, params, func(r *results.Record) {
+		if err := store.Append(r); err != nil {
 			log.Print(err)
-			return
 		}
-		log.Printf("Lightning round %v took %v", i, time.Since(exp.ExperimentStartTime))
-		exp.RecordToFile(args.OutFile)
-		RecordToCsv(args.OutFile+".csv", exp)
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// sizes set in the spec take the place of flags
+func specArgs(spec *coordinator.Spec) {
+	if spec.NumServers != 0 {
+		args.NumServers = spec.NumServers
+	}
+	if spec.NumUsers != 0 {
+		args.NumUsers = spec.NumUsers
+	}
+	if spec.F != 0 {
+		args.F = spec.F
+	}
+	if spec.NumGroups != 0 {
+		args.NumGroups = spec.NumGroups
+	}
+	if spec.GroupSize != 0 {
+		args.GroupSize = spec.GroupSize
+	}
+	if spec.NumLayers != 0 {
+		args.NumLayers = spec.NumLayers
+	}
+	if spec.BinSize != 0 {
+		args.BinSize = spec.BinSize
+	}
+	if spec.LimitSize != 0 {
+		args.LimitSize = spec.LimitSize
+	}
+	if spec.Notes != "" {
+		args.Notes = spec.Notes
 	}
-	l += numLightning
 }
 
 func ReadCsv(fn string) []string {
@@ -315,32 +354,3 @@ func ReadCsv(fn string) []string {
 	}
 	return output
 }
-
-func RecordToCsv(fn string, e *coordinator.Experiment) {
-	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-	b := bufio.NewWriter(f)
-
-	fmt.Fprintf(b, "%v, ", args.NumServers)
-	fmt.Fprintf(b, "%v, ", args.NumUsers)
-	fmt.Fprintf(b, "%v, ", args.F)
-	fmt.Fprintf(b, "%v, ", args.MessageSize)
-
-	fmt.Fprintf(b, "%v, ", args.NumLayers)
-	fmt.Fprintf(b, "%v, ", args.BinSize)
-	fmt.Fprintf(b, "%v, ", args.Bandwidth)
-	fmt.Fprintf(b, "%v, ", args.Latency)
-
-	fmt.Fprintf(b, "%v, ", e.Info.Round)
-	fmt.Fprintf(b, "%v, ", e.Info.PathEstablishment)
-	fmt.Fprintf(b, "%d\n", e.ServerRoundTime)
-
-	err = b.Flush()
-	if err != nil {
-		panic(err)
-	}
-
-}
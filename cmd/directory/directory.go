@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/server/beacon"
+)
+
+// create, sign, check and serve the signed network directory
+
+// add an authority with a new key to the authorities file
+type authorityCmd struct {
+	Key       string `arg:"required" help:"the new signing key is written here"`
+	Threshold int    `default:"0" help:"the number of authorities that must sign, unchanged if 0"`
+}
+
+// the directory of an epoch from the servers and groups files, not yet signed
+type createCmd struct {
+	Epoch      int           `arg:"required"`
+	ServerFile string        `default:"servers.json"`
+	GroupFile  string        `default:"groups.json" help:"the groups, unless they are formed from a beacon transcript"`
+	Validity   time.Duration `default:"24h"`
+	// form the groups from the epoch's beacon output, so anyone can check them
+	Beacon    string `default:"" help:"a beacon transcript of the epoch from admin beacon"`
+	NumGroups int    `default:"1"`
+	GroupSize int    `default:"1"`
+
+	NumLayers    int  `default:"0"`
+	BinSize      int  `default:"0"`
+	MessageSize  int  `default:"0"`
+	CipherSuite  int  `default:"0"`
+	KeyAgreement int  `default:"0"`
+	Verifiable   bool `default:"False"`
+}
+
+type signCmd struct {
+	Authority int    `arg:"required"`
+	Key       string `arg:"required"`
+}
+
+type verifyCmd struct{}
+
+// the directory file can be fetched over http by servers and clients
+type serveCmd struct {
+	Addr string `default:":8080"`
+}
+
+var args struct {
+	Directory   string        `default:"directory.json" help:"the signed directory"`
+	Authorities string        `default:"authorities.json"`
+	Authority   *authorityCmd `arg:"subcommand:authority"`
+	Create      *createCmd    `arg:"subcommand:create"`
+	Sign        *signCmd      `arg:"subcommand:sign"`
+	Verify      *verifyCmd    `arg:"subcommand:verify"`
+	Serve       *serveCmd     `arg:"subcommand:serve"`
+}
+
+func main() {
+	p := arg.MustParse(&args)
+	var err error
+	switch {
+	case args.Authority != nil:
+		err = newAuthority()
+	case args.Create != nil:
+		err = create()
+	case args.Sign != nil:
+		err = sign()
+	case args.Verify != nil:
+		err = verify()
+	case args.Serve != nil:
+		err = serve()
+	default:
+		p.WriteHelp(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newAuthority() error {
+	cmd := args.Authority
+	a := &config.Authorities{}
+	if _, err := os.Stat(args.Authorities); err == nil {
+		a, err = config.UnmarshalAuthoritiesFromFile(args.Authorities)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(cmd.Key); err == nil {
+		return fmt.Errorf("%s already exists", cmd.Key)
+	}
+	vk, sk := crypto.NewSigningKeyPair()
+	err := config.WriteAuthorityKey(cmd.Key, sk)
+	if err != nil {
+		return err
+	}
+	a.Keys = append(a.Keys, vk)
+	if cmd.Threshold > 0 {
+		a.Threshold = cmd.Threshold
+	} else if a.Threshold == 0 {
+		a.Threshold = 1
+	}
+	err = config.MarshalAuthoritiesToFile(args.Authorities, a)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("authority %d, %d of %d authorities sign\n", len(a.Keys)-1, a.Threshold, len(a.Keys))
+	return nil
+}
+
+func create() error {
+	cmd := args.Create
+	servers, err := config.UnmarshalServersFromFile(cmd.ServerFile)
+	if err != nil {
+		return err
+	}
+	params := config.Parameters{
+		NumLayers:    cmd.NumLayers,
+		BinSize:      cmd.BinSize,
+		MessageSize:  cmd.MessageSize,
+		CipherSuite:  cmd.CipherSuite,
+		KeyAgreement: cmd.KeyAgreement,
+		Verifiable:   cmd.Verifiable,
+	}
+	d := config.NewDirectory(cmd.Epoch, servers, nil, params, cmd.Validity)
+	if cmd.Beacon != "" {
+		output, err := checkBeacon(cmd.Beacon, cmd.Epoch, servers)
+		if err != nil {
+			return err
+		}
+		d.Parameters.GroupSize = cmd.GroupSize
+		d.Parameters.GroupSeed = output[:]
+		d.Groups = config.CreateSeparateGroupsWithSize(cmd.NumGroups, cmd.GroupSize, d.ServerIds(), output[:])
+	} else {
+		d.Groups, err = config.UnmarshalGroupsFromFile(cmd.GroupFile)
+		if err != nil {
+			return err
+		}
+	}
+	sd, err := config.NewSignedDirectory(d)
+	if err != nil {
+		return err
+	}
+	// checked as it will be once it is signed
+	_, err = config.ParseDirectory(sd.Body)
+	if err != nil {
+		return err
+	}
+	err = config.WriteSignedDirectory(args.Directory, sd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote the directory of epoch %d with %d servers and %d groups to %s, valid until %v\n", d.Epoch, len(d.Servers), len(d.Groups), args.Directory, d.NotAfter)
+	return nil
+}
+
+// the transcript is signed by the servers of the epoch, which run the beacon before the directory is made
+func checkBeacon(fn string, epoch int, servers map[int64]*config.Server) (beacon.Output, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return beacon.Output{}, err
+	}
+	t := &beacon.Transcript{}
+	err = t.InterpretFrom(b)
+	if err != nil {
+		return beacon.Output{}, err
+	}
+	if t.Label != beacon.EpochLabel(epoch) {
+		return beacon.Output{}, fmt.Errorf("the transcript is for %v", t.Label)
+	}
+	// servers that left the network have no key
+	vks := make([]crypto.VerificationKey, config.IdSpace(servers))
+	for sid, s := range servers {
+		vks[sid] = s.VerificationKey
+	}
+	return t.Verify(vks)
+}
+
+func sign() error {
+	cmd := args.Sign
+	a, err := config.UnmarshalAuthoritiesFromFile(args.Authorities)
+	if err != nil {
+		return err
+	}
+	key, err := config.ReadAuthorityKey(cmd.Key)
+	if err != nil {
+		return err
+	}
+	err = a.CheckKey(cmd.Authority, key)
+	if err != nil {
+		return err
+	}
+	sd, err := config.ReadSignedDirectory(args.Directory)
+	if err != nil {
+		return err
+	}
+	// an authority only signs a directory it has read
+	d, err := config.ParseDirectory(sd.Body)
+	if err != nil {
+		return err
+	}
+	sd.Sign(cmd.Authority, key)
+	err = config.WriteSignedDirectory(args.Directory, sd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("authority %d signed the directory of epoch %d, %d signatures\n", cmd.Authority, d.Epoch, len(sd.Signatures))
+	return nil
+}
+
+func verify() error {
+	a, err := config.UnmarshalAuthoritiesFromFile(args.Authorities)
+	if err != nil {
+		return err
+	}
+	d, err := config.FetchDirectory(args.Directory, a)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("epoch %d, valid from %v to %v\n", d.Epoch, d.NotBefore, d.NotAfter)
+	for _, sid := range d.ServerIds() {
+		fmt.Printf("  server %d %s\n", sid, d.Servers[sid].Address)
+	}
+	for gid := int64(0); gid < int64(len(d.Groups)); gid++ {
+		if g, ok := d.Groups[gid]; ok {
+			fmt.Printf("  group %d %v\n", gid, g.Servers)
+		}
+	}
+	return nil
+}
+
+// the current directory file is served, so it can be replaced for the next epoch while serving
+func serve() error {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, args.Directory)
+	})
+	log.Printf("serving %s at %s", args.Directory, args.Serve.Addr)
+	return http.ListenAndServe(args.Serve.Addr, nil)
+}
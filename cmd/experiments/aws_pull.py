@@ -14,8 +14,8 @@ def runRemoteCommand(dest, cmd):
 
 def pullAndBuild(ip):
     runRemoteCommand(ip, "cd Lightning1; git pull")
-    runRemoteCommand(ip, "cd Lightning1/cmd/server; go install && go build")
-    runRemoteCommand(ip, "cd Lightning1/cmd/client; go install && go build")
+    runRemoteCommand(ip, "cd Lightning1/cmd/server; go install -tags mcl && go build -tags mcl")
+    runRemoteCommand(ip, "cd Lightning1/cmd/client; go install -tags mcl && go build -tags mcl")
 
 ips = []
 with open('ip.list') as f:
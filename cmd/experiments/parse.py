@@ -6,35 +6,19 @@ outFileName = "res.csv"
 if len(sys.argv) > 2:
     outFileName = sys.argv[2]
 
-def parseJson(s):
-    return json.loads(s)
-
-def parseMultiJson(s):
-    level = 0
-    start = 0
-    objs = []
-    for pos, c in enumerate(s):
-        if c == '{':
-            if level == 0:
-                start = pos
-            level += 1
-        elif c == '}':
-            level -= 1
-            if level == 0:
-                objs.append(parseJson(s[start:pos+1]))
-    return objs
-
+# results are written one json record per line (see results/results.go)
 def readAndParse(fn):
     f = open(fn, "r")
-    data = f.read()
+    objs = [json.loads(line) for line in f if line.strip()]
     f.close()
-    return parseMultiJson(data)
+    return objs
 
 def groupByNotes(d, path):
     grouped = {}
     for x in d:
-        if x["Info"].get("pathEstablishment", False) == path :
-            n = x["Notes"]
+        if x["PathEstablishment"] == path:
+            n = dict(x["Params"])
+            n["MessageSize"] = x["MessageSize"]
             k = tuple(sorted(n.items()))
             cur = grouped.get(k, [])
             cur.append(x["ServerRoundTime"])
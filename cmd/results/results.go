@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alexflint/go-arg"
+	"github.com/simonlangowski/lightning1/results"
+)
+
+// compare the results of coordinator runs, or export them for plotting
+
+type compareCmd struct {
+	Base      string  `arg:"positional,required" help:"results file or experiment directory"`
+	Current   string  `arg:"positional,required" help:"results file or experiment directory"`
+	Threshold float64 `default:"0.1" help:"relative increase of the median round time that counts as a regression"`
+}
+
+type csvCmd struct {
+	Files []string `arg:"positional,required"`
+}
+
+var args struct {
+	Compare *compareCmd `arg:"subcommand:compare"`
+	Csv     *csvCmd     `arg:"subcommand:csv"`
+}
+
+func main() {
+	p := arg.MustParse(&args)
+	switch {
+	case args.Compare != nil:
+		if !compare(args.Compare) {
+			os.Exit(1)
+		}
+	case args.Csv != nil:
+		records := make([]*results.Record, 0)
+		for _, fn := range args.Csv.Files {
+			r, err := results.Load(fn)
+			if err != nil {
+				log.Fatal(err)
+			}
+			records = append(records, r...)
+		}
+		err := results.WriteCsv(os.Stdout, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		p.WriteHelp(os.Stdout)
+	}
+}
+
+// print the comparison, false if any configuration regressed
+func compare(c *compareCmd) bool {
+	base, err := results.Load(c.Base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current, err := results.Load(c.Current)
+	if err != nil {
+		log.Fatal(err)
+	}
+	comparisons := results.Compare(base, current, c.Threshold)
+	if len(comparisons) == 0 {
+		log.Fatal("No configurations in common")
+	}
+	ok := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "configuration\tbase\tcurrent\tchange\t")
+	for _, cmp := range comparisons {
+		flag := ""
+		if cmp.Regression {
+			flag = "REGRESSION"
+			ok = false
+		}
+		fmt.Fprintf(w, "%v\t%v (%d)\t%v (%d)\t%+.1f%%\t%s\n", cmp.Key, cmp.Base, cmp.BaseRounds, cmp.Current, cmp.CurrentRounds, 100*cmp.Change, flag)
+	}
+	w.Flush()
+	return ok
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
+	"github.com/simonlangowski/lightning1/admin"
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/server"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 func main() {
@@ -16,25 +20,52 @@ func main() {
 	groupsFile := os.Args[2]
 	addr := os.Args[len(os.Args)-1]
 	errors.Addr = addr
-	servers, err := config.UnmarshalServersFromFile(serversFile)
+	errors.DumpOnError = os.Getenv(logging.DumpEnv) != ""
+	if err := logging.ConfigureFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	// from the signed directory in LIGHTNING_DIRECTORY, if it is set
+	d, err := config.LoadNetwork(serversFile, groupsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	directory, err := config.DirectoryFromEnv()
 	if err != nil {
-		log.Fatalf("Could not read servers file %s", serversFile)
+		log.Fatal(err)
 	}
-	groups, err := config.UnmarshalGroupsFromFile(groupsFile)
+	servers, groups := d.Servers, d.Groups
+	// the servers file is public, this server's secrets are in its keystore
+	err = config.LoadOwnSecrets(servers, serversFile, addr)
 	if err != nil {
-		log.Fatalf("Could not read group file %s", groupsFile)
+		log.Fatalf("Could not read keystore: %v", err)
 	}
 
 	// will start in blocked state
 	h := server.NewHandler()
 	server := server.NewServer(&config.Servers{Servers: servers}, &config.Groups{Groups: groups}, h, addr)
+	server.CommonState.Epoch = d.Epoch
+	server.CommonState.Parameters = d.Parameters
+	if directory != nil {
+		// later epochs add and remove servers without restarting this one
+		server.FollowDirectory(directory)
+	}
+	err = logging.OpenFile(fmt.Sprintf("log%d.log", server.CommonState.MyId))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tracing.StartFromEnv(fmt.Sprintf("server%d", server.CommonState.MyId))
+	if err != nil {
+		log.Fatal(err)
+	}
 	// f, err := os.Create("path.pprof")
 	// if err != nil {
 	// 	log.Fatal(err)
 	// }
 	// pprof.StartCPUProfile(f)
 	// defer pprof.StopCPUProfile()
+	admin.ServeHTTP(config.AdminAddress(addr), os.Getenv(admin.PprofEnv) != "")
 	server.TcpConnections.LaunchAccepts()
 	network.RunServer(h, server, servers, addr)
-	config.Flush()
+	logging.Flush()
+	tracing.Stop()
 }
@@ -66,21 +66,21 @@ func CalcGroupSize(nServers, nGroups int, f float64) int {
 	}
 }
 
-func CreateRandomGroups(nGroups int, f float64, serverIds []int64) map[int64]*Group {
+// the seed is public randomness for the epoch, such as the output of the servers' beacon
+func CreateRandomGroups(nGroups int, f float64, serverIds []int64, seed []byte) map[int64]*Group {
 	n := len(serverIds)
 	size := CalcGroupSize(len(serverIds), nGroups, f)
 	if size > n { // this should never happen in practice, but useful for testing..
 		size = n
 	}
-	return CreateRandomGroupsWithSize(nGroups, size, serverIds)
+	return CreateRandomGroupsWithSize(nGroups, size, serverIds, seed)
 }
 
-func CreateRandomGroupsWithSize(nGroups, size int, serverIds []int64) map[int64]*Group {
+func CreateRandomGroupsWithSize(nGroups, size int, serverIds []int64, seed []byte) map[int64]*Group {
 	log.Printf("Warning: fix group distribution")
 	n := len(serverIds)
 	groups := make(map[int64]*Group)
-	// seeding for determinstic tests; a public unbiased source of randomness should be used
-	r := SeededShuffler()
+	r := NewSeededShuffler(seed)
 	for i := 0; i < nGroups; i++ {
 		indices := r.SelectRandom(n, size)
 		servers := make([]int64, size)
@@ -92,10 +92,10 @@ func CreateRandomGroupsWithSize(nGroups, size int, serverIds []int64) map[int64]
 	return groups
 }
 
-func CreateSeparateGroupsWithSize(nGroups, size int, serverIds []int64) map[int64]*Group {
+func CreateSeparateGroupsWithSize(nGroups, size int, serverIds []int64, seed []byte) map[int64]*Group {
 	n := len(serverIds)
 	// Select from disjoint sets of a random permutation
-	s := SeededShuffler()
+	s := NewSeededShuffler(seed)
 	permutation := s.Perm(n)
 	groups := make(map[int64]*Group)
 	index := 0
@@ -106,7 +106,7 @@ func CreateSeparateGroupsWithSize(nGroups, size int, serverIds []int64) map[int6
 			if index >= len(permutation) {
 				permutation = append(permutation, s.Perm(n)...)
 			}
-			servers[j] = int64(permutation[index])
+			servers[j] = serverIds[permutation[index]]
 			if used[servers[j]] {
 				// duplicate
 				// we want to reselect the permutation so that this didn't happen
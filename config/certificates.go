@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/simonlangowski/lightning1/crypto"
@@ -77,6 +79,14 @@ func CreateServerWithCertificate(addr string, id int64, cert, key []byte) *Serve
 		VerificationKey: ver,
 		SignatureKey:    sign,
 	}
+	if crypto.KEMSupported {
+		kemPriv, kemPub, err := crypto.NewKEMKeyPair()
+		if err != nil {
+			panic(err)
+		}
+		s.KemPublicKey = kemPub
+		s.KemPrivateKey = kemPriv.Seed
+	}
 	return s
 }
 
@@ -174,6 +184,34 @@ func Port(addr string) string {
 	return ":" + strings.Split(addr, ":")[1]
 }
 
+// the admin http endpoint (metrics and pprof) listens AdminPortOffset above the rpc port, on the admin host
+func AdminAddress(addr string) string {
+	port, err := strconv.Atoi(Port(addr)[1:])
+	if err != nil {
+		panic(err)
+	}
+	return net.JoinHostPort(AdminHost(), strconv.Itoa(port+AdminPortOffset))
+}
+
+// the host admin endpoints listen on
+const AdminHostEnv = "LIGHTNING_ADMIN_HOST"
+
+func AdminHost() string {
+	if host := os.Getenv(AdminHostEnv); host != "" {
+		return host
+	}
+	return DefaultAdminHost
+}
+
+// the admin rpc service listens AdminRPCPortOffset above the rpc port, on the admin host
+func AdminRPCAddress(addr string) string {
+	port, err := strconv.Atoi(Port(addr)[1:])
+	if err != nil {
+		panic(err)
+	}
+	return net.JoinHostPort(AdminHost(), strconv.Itoa(port+AdminRPCPortOffset))
+}
+
 func Host(addr string) string {
 	if strings.Contains(addr, ":") {
 		return strings.Split(addr, ":")[0]
@@ -22,7 +22,7 @@ const (
 
 // Fields that are secrets are included here for convience
 // public value and server members agreement is out of scope
-// Each server would have their own secrets
+// Servers files only have the public fields, each server reads its own secrets from a keystore (keystore.go)
 type Server struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -43,6 +43,10 @@ type Server struct {
 	VerificationKey []byte `protobuf:"bytes,7,opt,name=verification_key,json=verificationKey,proto3" json:"verification_key,omitempty"`
 	// Signature key (this should not be public)
 	SignatureKey []byte `protobuf:"bytes,8,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	// ML-KEM-768 encapsulation key for hybrid key agreement (public)
+	KemPublicKey []byte `protobuf:"bytes,9,opt,name=kem_public_key,json=kemPublicKey,proto3" json:"kem_public_key,omitempty"`
+	// Seed of the ML-KEM-768 decapsulation key (this should not be public)
+	KemPrivateKey []byte `protobuf:"bytes,10,opt,name=kem_private_key,json=kemPrivateKey,proto3" json:"kem_private_key,omitempty"`
 }
 
 func (x *Server) Reset() {
@@ -133,6 +137,20 @@ func (x *Server) GetSignatureKey() []byte {
 	return nil
 }
 
+func (x *Server) GetKemPublicKey() []byte {
+	if x != nil {
+		return x.KemPublicKey
+	}
+	return nil
+}
+
+func (x *Server) GetKemPrivateKey() []byte {
+	if x != nil {
+		return x.KemPrivateKey
+	}
+	return nil
+}
+
 type Group struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -287,7 +305,7 @@ var File_config_proto protoreflect.FileDescriptor
 
 var file_config_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
-	0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x22, 0x89, 0x02, 0x0a, 0x06, 0x53, 0x65, 0x72, 0x76, 0x65,
+	0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x22, 0xd9, 0x02, 0x0a, 0x06, 0x53, 0x65, 0x72, 0x76, 0x65,
 	0x72, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01,
 	0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x0e, 0x0a, 0x02, 0x69,
 	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x69,
@@ -304,6 +322,11 @@ var file_config_proto_rawDesc = []byte{
 	0x72, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4b, 0x65, 0x79, 0x12, 0x23, 0x0a,
 	0x0d, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x08,
 	0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x4b,
+	0x65, 0x79, 0x12, 0x25, 0x0a, 0x0e, 0x6b, 0x65, 0x6d, 0x5f, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63,
+	0x5f, 0x6b, 0x65, 0x79, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0d, 0x6b, 0x65, 0x6d, 0x50,
+	0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x12, 0x27, 0x0a, 0x0f, 0x6b, 0x65, 0x6d,
+	0x5f, 0x70, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x0e, 0x6b, 0x65, 0x6d, 0x50, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x5f, 0x6b,
 	0x65, 0x79, 0x22, 0x33, 0x0a, 0x05, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x67,
 	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x67, 0x69, 0x64, 0x12, 0x18, 0x0a,
 	0x07, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x03, 0x52, 0x07,
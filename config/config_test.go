@@ -1,6 +1,7 @@
 package config
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -26,7 +27,7 @@ func TestGroupSelection(t *testing.T) {
 	}
 	for groupSize := 3; groupSize < 10; groupSize++ {
 		for numGroups := 2; numGroups < 5; numGroups++ {
-			groups := CreateSeparateGroupsWithSize(numGroups, groupSize, ids)
+			groups := CreateSeparateGroupsWithSize(numGroups, groupSize, ids, Seed)
 			groupList := make([][]int64, 0)
 			for _, v := range groups {
 				groupList = append(groupList, v.Servers)
@@ -36,6 +37,25 @@ func TestGroupSelection(t *testing.T) {
 	}
 }
 
+func TestGroupSeed(t *testing.T) {
+	ids := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	same := func(a, b map[int64]*Group) bool {
+		for gid := range a {
+			if !reflect.DeepEqual(a[gid].Servers, b[gid].Servers) {
+				return false
+			}
+		}
+		return true
+	}
+	groups := CreateRandomGroupsWithSize(5, 3, ids, Seed)
+	if !same(groups, CreateRandomGroupsWithSize(5, 3, ids, Seed)) {
+		t.Fatal("the same seed gave different groups")
+	}
+	if same(groups, CreateRandomGroupsWithSize(5, 3, ids, []byte("another epoch"))) {
+		t.Fatal("another seed gave the same groups")
+	}
+}
+
 const LinkOverflowProbability = -32
 
 func TestBinSize(t *testing.T) {
@@ -0,0 +1,485 @@
+package config
+
+/*
+The network directory is the document servers and clients learn the network from.
+It lists the public fields of every server, the anytrust groups and the protocol parameters of one epoch,
+and is valid for a period of time. It is signed by a threshold of directory authorities,
+so no single authority can add a server, and a new directory adds or removes servers for the next epoch.
+A directory is fetched from a file or an http(s) url and only used once its signatures are verified.
+
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// a signed directory, a file or an http(s) url, which replaces the servers and groups files
+	DirectoryEnv = "LIGHTNING_DIRECTORY"
+	// the authorities file the directory is verified with
+	AuthoritiesEnv = "LIGHTNING_AUTHORITIES"
+)
+
+const directoryVersion = 1
+
+var directoryDomain = []byte("lightning directory v1")
+
+// the protocol parameters of an epoch, zero when not fixed by the directory
+type Parameters struct {
+	NumLayers    int  `json:"num_layers,omitempty"`
+	BinSize      int  `json:"bin_size,omitempty"`
+	MessageSize  int  `json:"message_size,omitempty"`
+	CipherSuite  int  `json:"cipher_suite,omitempty"`
+	KeyAgreement int  `json:"key_agreement,omitempty"`
+	Verifiable   bool `json:"verifiable,omitempty"`
+	GroupSize    int  `json:"group_size,omitempty"`
+	// the beacon output of the epoch, when the groups were formed from it
+	GroupSeed []byte `json:"group_seed,omitempty"`
+}
+
+// the network during one epoch
+type Directory struct {
+	Epoch      int
+	NotBefore  time.Time
+	NotAfter   time.Time
+	Parameters Parameters
+	// the public fields of the servers
+	Servers map[int64]*Server
+	Groups  map[int64]*Group
+}
+
+// the signed json, servers and groups are in the same json as their files
+type directoryBody struct {
+	Version    int             `json:"version"`
+	Epoch      int             `json:"epoch"`
+	NotBefore  time.Time       `json:"not_before"`
+	NotAfter   time.Time       `json:"not_after"`
+	Parameters Parameters      `json:"parameters"`
+	Servers    json.RawMessage `json:"servers"`
+	Groups     json.RawMessage `json:"groups"`
+}
+
+type DirectorySignature struct {
+	Authority int    `json:"authority"`
+	Signature []byte `json:"signature"`
+}
+
+// the body is kept as the authorities signed it, so it is never encoded again
+type SignedDirectory struct {
+	Body       []byte               `json:"body"`
+	Signatures []DirectorySignature `json:"signatures"`
+}
+
+// the verification keys of the directory authorities, indexed by authority
+type Authorities struct {
+	Threshold int                      `json:"threshold"`
+	Keys      []crypto.VerificationKey `json:"keys"`
+}
+
+// the directory of an epoch, valid from now for the validity period
+// only the public fields of the servers are included
+func NewDirectory(epoch int, servers map[int64]*Server, groups map[int64]*Group, params Parameters, validity time.Duration) *Directory {
+	d := &Directory{
+		Epoch:      epoch,
+		NotBefore:  time.Now().UTC().Truncate(time.Second),
+		Parameters: params,
+		Servers:    make(map[int64]*Server),
+		Groups:     groups,
+	}
+	d.NotAfter = d.NotBefore.Add(validity)
+	for sid, s := range servers {
+		d.Servers[sid] = Public(s)
+	}
+	return d
+}
+
+func (d *Directory) Marshal() ([]byte, error) {
+	servers, err := protojson.Marshal(&Servers{Servers: d.Servers})
+	if err != nil {
+		return nil, err
+	}
+	groups, err := protojson.Marshal(&Groups{Groups: d.Groups})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&directoryBody{
+		Version:    directoryVersion,
+		Epoch:      d.Epoch,
+		NotBefore:  d.NotBefore,
+		NotAfter:   d.NotAfter,
+		Parameters: d.Parameters,
+		Servers:    servers,
+		Groups:     groups,
+	})
+}
+
+// parse and check a directory body, its signatures are checked by Authorities.Verify
+func ParseDirectory(b []byte) (*Directory, error) {
+	body := &directoryBody{}
+	err := json.Unmarshal(b, body)
+	if err != nil {
+		return nil, err
+	}
+	if body.Version != directoryVersion {
+		return nil, fmt.Errorf("unknown directory version %d", body.Version)
+	}
+	servers := &Servers{}
+	err = protojson.Unmarshal(body.Servers, servers)
+	if err != nil {
+		return nil, err
+	}
+	groups := &Groups{}
+	err = protojson.Unmarshal(body.Groups, groups)
+	if err != nil {
+		return nil, err
+	}
+	d := &Directory{
+		Epoch:      body.Epoch,
+		NotBefore:  body.NotBefore,
+		NotAfter:   body.NotAfter,
+		Parameters: body.Parameters,
+		Servers:    servers.Servers,
+		Groups:     groups.Groups,
+	}
+	if d.Servers == nil {
+		d.Servers = make(map[int64]*Server)
+	}
+	if d.Groups == nil {
+		d.Groups = make(map[int64]*Group)
+	}
+	return d, d.check()
+}
+
+func (d *Directory) check() error {
+	if !d.NotBefore.Before(d.NotAfter) {
+		return errors.New("the directory is never valid")
+	}
+	addresses := make(map[string]bool)
+	for sid, s := range d.Servers {
+		if s.Id != sid {
+			return fmt.Errorf("server %d has id %d", sid, s.Id)
+		}
+		if HasSecrets(s) {
+			return fmt.Errorf("the directory has the secrets of server %d", sid)
+		}
+		if len(s.VerificationKey) != crypto.VERIFICATION_KEY_SIZE {
+			return fmt.Errorf("server %d has no verification key", sid)
+		}
+		if addresses[s.Address] {
+			return fmt.Errorf("two servers at %s", s.Address)
+		}
+		addresses[s.Address] = true
+	}
+	for gid, g := range d.Groups {
+		if g.Gid != gid || len(g.Servers) == 0 {
+			return fmt.Errorf("bad group %d", gid)
+		}
+		if d.Parameters.GroupSize != 0 && len(g.Servers) != d.Parameters.GroupSize {
+			return fmt.Errorf("group %d has %d servers", gid, len(g.Servers))
+		}
+		for _, sid := range g.Servers {
+			if _, ok := d.Servers[sid]; !ok {
+				return fmt.Errorf("group %d has server %d, which is not in the directory", gid, sid)
+			}
+		}
+	}
+	if len(d.Parameters.GroupSeed) > 0 {
+		// anyone can check the groups were formed from the beacon and not chosen by the authorities
+		groups := CreateSeparateGroupsWithSize(len(d.Groups), d.Parameters.GroupSize, d.ServerIds(), d.Parameters.GroupSeed)
+		for gid, g := range groups {
+			if !proto.Equal(g, d.Groups[gid]) {
+				return fmt.Errorf("group %d was not formed from the group seed", gid)
+			}
+		}
+	}
+	return nil
+}
+
+// an error if a round differs from a parameter the directory fixes
+// path establishment rounds have their own message size and are never verifiable
+func (p *Parameters) CheckRound(round Parameters, pathEstablishment bool) error {
+	if p.NumLayers != 0 && round.NumLayers != p.NumLayers {
+		return fmt.Errorf("the round has %d layers, the directory fixes %d", round.NumLayers, p.NumLayers)
+	}
+	if p.BinSize != 0 && round.BinSize != p.BinSize {
+		return fmt.Errorf("the round has bin size %d, the directory fixes %d", round.BinSize, p.BinSize)
+	}
+	if p.CipherSuite != 0 && round.CipherSuite != p.CipherSuite {
+		return fmt.Errorf("the round has cipher suite %d, the directory fixes %d", round.CipherSuite, p.CipherSuite)
+	}
+	if p.KeyAgreement != 0 && round.KeyAgreement != p.KeyAgreement {
+		return fmt.Errorf("the round has key agreement %d, the directory fixes %d", round.KeyAgreement, p.KeyAgreement)
+	}
+	if pathEstablishment {
+		return nil
+	}
+	if p.MessageSize != 0 && round.MessageSize != p.MessageSize {
+		return fmt.Errorf("the round has message size %d, the directory fixes %d", round.MessageSize, p.MessageSize)
+	}
+	if p.Verifiable && !round.Verifiable {
+		return errors.New("the round is not verifiable, the directory requires verifiable rounds")
+	}
+	return nil
+}
+
+// the ids of the servers in increasing order
+func (d *Directory) ServerIds() []int64 {
+	return ServerIds(d.Servers)
+}
+
+// the directory can replace prev, the directory of an earlier epoch
+func (d *Directory) Follows(prev *Directory) error {
+	if d.Epoch <= prev.Epoch {
+		return fmt.Errorf("the directory of epoch %d does not follow epoch %d", d.Epoch, prev.Epoch)
+	}
+	return nil
+}
+
+// the servers that joined and left since prev
+// a server whose public fields changed has left and joined again
+func (d *Directory) Changes(prev *Directory) (added []int64, removed []int64) {
+	return ServerChanges(prev.Servers, d.Servers)
+}
+
+func NewSignedDirectory(d *Directory) (*SignedDirectory, error) {
+	b, err := d.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &SignedDirectory{Body: b}, nil
+}
+
+func signedDirectoryData(body []byte) []byte {
+	return append(append([]byte{}, directoryDomain...), body...)
+}
+
+// add the signature of an authority, replacing its earlier signature
+func (sd *SignedDirectory) Sign(authority int, key crypto.SigningKey) {
+	sig := DirectorySignature{Authority: authority, Signature: crypto.Sign(key, signedDirectoryData(sd.Body))}
+	for i := range sd.Signatures {
+		if sd.Signatures[i].Authority == authority {
+			sd.Signatures[i] = sig
+			return
+		}
+	}
+	sd.Signatures = append(sd.Signatures, sig)
+}
+
+func (a *Authorities) check() error {
+	if a.Threshold < 1 || a.Threshold > len(a.Keys) {
+		return fmt.Errorf("bad threshold %d of %d authorities", a.Threshold, len(a.Keys))
+	}
+	for i, k := range a.Keys {
+		if len(k) != crypto.VERIFICATION_KEY_SIZE {
+			return fmt.Errorf("bad key of authority %d", i)
+		}
+	}
+	return nil
+}
+
+// the directory, if a threshold of distinct authorities signed it and it is valid at time now
+func (a *Authorities) Verify(sd *SignedDirectory, now time.Time) (*Directory, error) {
+	err := a.check()
+	if err != nil {
+		return nil, err
+	}
+	data := signedDirectoryData(sd.Body)
+	signed := make(map[int]bool)
+	for _, sig := range sd.Signatures {
+		if sig.Authority < 0 || sig.Authority >= len(a.Keys) || signed[sig.Authority] {
+			continue
+		}
+		if crypto.Verify(a.Keys[sig.Authority], data, sig.Signature) {
+			signed[sig.Authority] = true
+		}
+	}
+	if len(signed) < a.Threshold {
+		return nil, fmt.Errorf("the directory is signed by %d authorities, %d are needed", len(signed), a.Threshold)
+	}
+	d, err := ParseDirectory(sd.Body)
+	if err != nil {
+		return nil, err
+	}
+	if now.Add(DirectoryClockSkew).Before(d.NotBefore) || !now.Add(-DirectoryClockSkew).Before(d.NotAfter) {
+		return nil, fmt.Errorf("the directory of epoch %d is valid from %v to %v", d.Epoch, d.NotBefore, d.NotAfter)
+	}
+	return d, nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func readSource(source string) ([]byte, error) {
+	if !isURL(source) {
+		return ioutil.ReadFile(source)
+	}
+	c := &http.Client{Timeout: DirectoryFetchTimeout}
+	resp, err := c.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxDirectorySize+1))
+	if err == nil && len(b) > MaxDirectorySize {
+		err = fmt.Errorf("the directory at %s is larger than %d bytes", source, MaxDirectorySize)
+	}
+	return b, err
+}
+
+func ReadSignedDirectory(source string) (*SignedDirectory, error) {
+	b, err := readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	sd := &SignedDirectory{}
+	err = json.Unmarshal(b, sd)
+	return sd, err
+}
+
+func WriteSignedDirectory(fn string, sd *SignedDirectory) error {
+	b, err := json.Marshal(sd)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, b, 0644)
+}
+
+// fetch a signed directory from a file or an http(s) url, and verify it
+func FetchDirectory(source string, authorities *Authorities) (*Directory, error) {
+	sd, err := ReadSignedDirectory(source)
+	if err != nil {
+		return nil, err
+	}
+	return authorities.Verify(sd, time.Now())
+}
+
+func MarshalAuthoritiesToFile(fn string, a *Authorities) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, b, 0644)
+}
+
+func UnmarshalAuthoritiesFromFile(fn string) (*Authorities, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authorities{}
+	err = json.Unmarshal(b, a)
+	if err != nil {
+		return nil, err
+	}
+	return a, a.check()
+}
+
+// where the directory of each epoch is fetched from, and the authorities it is verified with
+type DirectorySource struct {
+	Source      string
+	Authorities *Authorities
+}
+
+// the signed directory in LIGHTNING_DIRECTORY and the authorities in LIGHTNING_AUTHORITIES,
+// nil when the network is read from files
+func DirectoryFromEnv() (*DirectorySource, error) {
+	source := os.Getenv(DirectoryEnv)
+	if source == "" {
+		return nil, nil
+	}
+	fn := os.Getenv(AuthoritiesEnv)
+	if fn == "" {
+		return nil, fmt.Errorf("%s is set without %s", DirectoryEnv, AuthoritiesEnv)
+	}
+	authorities, err := UnmarshalAuthoritiesFromFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("could not read authorities file %s: %v", fn, err)
+	}
+	return &DirectorySource{Source: source, Authorities: authorities}, nil
+}
+
+// the current directory
+func (s *DirectorySource) Fetch() (*Directory, error) {
+	d, err := FetchDirectory(s.Source, s.Authorities)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch directory %s: %v", s.Source, err)
+	}
+	return d, nil
+}
+
+// the directory of an epoch, once the authorities have published it
+func (s *DirectorySource) FetchEpoch(epoch int) (*Directory, error) {
+	d, err := s.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	if d.Epoch != epoch {
+		return nil, fmt.Errorf("the directory at %s is for epoch %d, not epoch %d", s.Source, d.Epoch, epoch)
+	}
+	return d, nil
+}
+
+// the network, from the verified directory in LIGHTNING_DIRECTORY if it is set,
+// or else from the servers and groups files as epoch 0
+func LoadNetwork(serversFile, groupsFile string) (*Directory, error) {
+	source, err := DirectoryFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		return source.Fetch()
+	}
+	servers, err := UnmarshalServersFromFile(serversFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read servers file %s: %v", serversFile, err)
+	}
+	groups, err := UnmarshalGroupsFromFile(groupsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read group file %s: %v", groupsFile, err)
+	}
+	return &Directory{Servers: servers, Groups: groups}, nil
+}
+
+// the signing key of an authority is kept in a file only the authority can read
+func WriteAuthorityKey(fn string, key crypto.SigningKey) error {
+	return ioutil.WriteFile(fn, key, 0600)
+}
+
+func ReadAuthorityKey(fn string) (crypto.SigningKey, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 2*crypto.VERIFICATION_KEY_SIZE {
+		return nil, fmt.Errorf("%s is not an authority key", fn)
+	}
+	return crypto.SigningKey(b), nil
+}
+
+// the authority key matches the authority's verification key
+func (a *Authorities) CheckKey(authority int, key crypto.SigningKey) error {
+	if authority < 0 || authority >= len(a.Keys) {
+		return fmt.Errorf("no authority %d", authority)
+	}
+	if !bytes.Equal(key[crypto.VERIFICATION_KEY_SIZE:], a.Keys[authority]) {
+		return fmt.Errorf("the key is not the key of authority %d", authority)
+	}
+	return nil
+}
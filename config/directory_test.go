@@ -0,0 +1,170 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/crypto"
+)
+
+func testAuthorities(n, threshold int) (*Authorities, []crypto.SigningKey) {
+	a := &Authorities{Threshold: threshold}
+	keys := make([]crypto.SigningKey, n)
+	for i := range keys {
+		var vk crypto.VerificationKey
+		vk, keys[i] = crypto.NewSigningKeyPair()
+		a.Keys = append(a.Keys, vk)
+	}
+	return a, keys
+}
+
+func testDirectory(epoch int, servers map[int64]*Server) *Directory {
+	d := NewDirectory(epoch, servers, nil, Parameters{NumLayers: 10, GroupSize: 2, GroupSeed: []byte{byte(epoch)}}, time.Hour)
+	d.Groups = CreateSeparateGroupsWithSize(2, 2, d.ServerIds(), d.Parameters.GroupSeed)
+	return d
+}
+
+func TestDirectory(t *testing.T) {
+	a, keys := testAuthorities(3, 2)
+	d := testDirectory(1, testServers(4))
+	sd, err := NewSignedDirectory(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Sign(0, keys[0])
+	if _, err := a.Verify(sd, time.Now()); err == nil {
+		t.Fatal("accepted a directory signed by one authority")
+	}
+	// signing again does not count twice
+	sd.Sign(0, keys[0])
+	if _, err := a.Verify(sd, time.Now()); err == nil {
+		t.Fatal("counted an authority twice")
+	}
+	sd.Sign(2, keys[2])
+
+	fn := filepath.Join(t.TempDir(), "directory.json")
+	err = WriteSignedDirectory(fn, sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(fn))))
+	defer ts.Close()
+	for _, source := range []string{fn, ts.URL + "/directory.json"} {
+		verified, err := FetchDirectory(source, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verified.Epoch != 1 || len(verified.Servers) != 4 || len(verified.Groups) != 2 || verified.Parameters.NumLayers != 10 {
+			t.Fatalf("%s: %+v", source, verified)
+		}
+		for _, s := range verified.Servers {
+			if HasSecrets(s) {
+				t.Fatal("the directory has secrets")
+			}
+		}
+	}
+	if _, err := a.Verify(sd, d.NotAfter.Add(time.Hour)); err == nil {
+		t.Fatal("accepted an expired directory")
+	}
+	if _, err := a.Verify(sd, d.NotBefore.Add(-time.Hour)); err == nil {
+		t.Fatal("accepted a directory before its validity period")
+	}
+}
+
+func TestDirectoryFailures(t *testing.T) {
+	a, keys := testAuthorities(2, 2)
+	d := testDirectory(1, testServers(4))
+	sd, _ := NewSignedDirectory(d)
+	sd.Sign(0, keys[0])
+	sd.Sign(1, keys[1])
+	if _, err := a.Verify(sd, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// a changed body
+	changed := &SignedDirectory{Body: append([]byte{}, sd.Body...), Signatures: sd.Signatures}
+	changed.Body[len(changed.Body)-2] ^= 1
+	if _, err := a.Verify(changed, time.Now()); err == nil {
+		t.Fatal("accepted a changed directory")
+	}
+	// a signature by a key that is not an authority
+	_, other := crypto.NewSigningKeyPair()
+	forged := &SignedDirectory{Body: sd.Body}
+	forged.Sign(0, keys[0])
+	forged.Sign(1, other)
+	if _, err := a.Verify(forged, time.Now()); err == nil {
+		t.Fatal("accepted a signature by another key")
+	}
+	// groups that were not formed from the seed
+	d.Groups[0].Servers, d.Groups[1].Servers = d.Groups[1].Servers, d.Groups[0].Servers
+	chosen, _ := NewSignedDirectory(d)
+	chosen.Sign(0, keys[0])
+	chosen.Sign(1, keys[1])
+	if _, err := a.Verify(chosen, time.Now()); err == nil {
+		t.Fatal("accepted groups that were not formed from the seed")
+	}
+	// the secrets of a server
+	d = testDirectory(1, testServers(4))
+	d.Servers[0] = testServers(1)[0]
+	secret, _ := NewSignedDirectory(d)
+	secret.Sign(0, keys[0])
+	secret.Sign(1, keys[1])
+	if _, err := a.Verify(secret, time.Now()); err == nil {
+		t.Fatal("accepted a directory with secrets")
+	}
+}
+
+// servers are added and removed between epochs
+func TestDirectoryEpochs(t *testing.T) {
+	servers := testServers(5)
+	first := testDirectory(1, servers)
+	delete(first.Servers, 4)
+	first.Groups = CreateSeparateGroupsWithSize(2, 2, first.ServerIds(), first.Parameters.GroupSeed)
+	next := testDirectory(2, servers)
+	delete(next.Servers, 1)
+	next.Groups = CreateSeparateGroupsWithSize(2, 2, next.ServerIds(), next.Parameters.GroupSeed)
+	if err := next.check(); err != nil {
+		t.Fatal(err)
+	}
+	if next.Follows(first) != nil || first.Follows(next) == nil || first.Follows(first) == nil {
+		t.Fatal("wrong epoch order")
+	}
+	added, removed := next.Changes(first)
+	if len(added) != 1 || added[0] != 4 || len(removed) != 1 || removed[0] != 1 {
+		t.Fatalf("added %v removed %v", added, removed)
+	}
+	for _, g := range next.Groups {
+		for _, sid := range g.Servers {
+			if sid == 1 {
+				t.Fatal("a removed server is in a group")
+			}
+		}
+	}
+}
+
+func TestCheckRound(t *testing.T) {
+	fixed := Parameters{NumLayers: 10, BinSize: 4, MessageSize: 1024, Verifiable: true}
+	round := Parameters{NumLayers: 10, BinSize: 4, MessageSize: 1024, Verifiable: true, CipherSuite: 1}
+	if err := fixed.CheckRound(round, false); err != nil {
+		t.Fatal(err)
+	}
+	// path establishment rounds have their own message size and are not verifiable
+	if err := fixed.CheckRound(Parameters{NumLayers: 10, BinSize: 4, MessageSize: 8}, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Parameters{
+		{NumLayers: 9, BinSize: 4, MessageSize: 1024, Verifiable: true},
+		{NumLayers: 10, BinSize: 5, MessageSize: 1024, Verifiable: true},
+		{NumLayers: 10, BinSize: 4, MessageSize: 8, Verifiable: true},
+		{NumLayers: 10, BinSize: 4, MessageSize: 1024},
+	} {
+		if fixed.CheckRound(r, false) == nil {
+			t.Fatalf("accepted %+v", r)
+		}
+	}
+	if (&Parameters{}).CheckRound(round, false) != nil {
+		t.Fatal("parameters that are not fixed refused a round")
+	}
+}
@@ -0,0 +1,364 @@
+package config
+
+/*
+Secrets are kept out of the servers file, which is the public directory of the network.
+Each server reads only its own secrets from a keystore, encrypted with a passphrase (LIGHTNING_KEYSTORE_PASSPHRASE)
+or with the contents of a key file, which is created next to the keystore when it is written.
+
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/logging"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// the keystore of this process, by default next to the servers file
+	KeystoreEnv = "LIGHTNING_KEYSTORE"
+	// decrypt keystores with a passphrase instead of a key file
+	PassphraseEnv = "LIGHTNING_KEYSTORE_PASSPHRASE"
+	// the key file of the keystore, by default the keystore with a .key extension
+	KeyFileEnv = "LIGHTNING_KEYSTORE_KEY"
+)
+
+const (
+	keystoreVersion = 1
+	kdfScrypt       = "scrypt"
+	kdfFile         = "file"
+	keyFileSize     = 32
+	// the cost of scrypt, 2^15 as recommended for interactive logins
+	scryptLogN = 15
+)
+
+// an encrypted copy of a server's secrets
+type Keystore struct {
+	Version int    `json:"version"`
+	Id      int64  `json:"id"`
+	Address string `json:"address"`
+	Kdf     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	LogN    int    `json:"log_n,omitempty"`
+	Nonce   []byte `json:"nonce"`
+	// the secret fields of the server
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// a passphrase, or else the key file
+type KeystoreKey struct {
+	Passphrase []byte
+	KeyFile    string
+}
+
+// the public fields of a server, which can be given to anyone
+func Public(s *Server) *Server {
+	p := proto.Clone(s).(*Server)
+	p.PrivateIdentity = nil
+	p.PrivateKey = nil
+	p.SignatureKey = nil
+	p.KemPrivateKey = nil
+	return p
+}
+
+// the secret fields of a server, with its id and address
+func Secrets(s *Server) *Server {
+	return &Server{
+		Id:              s.Id,
+		Address:         s.Address,
+		PrivateIdentity: s.PrivateIdentity,
+		PrivateKey:      s.PrivateKey,
+		SignatureKey:    s.SignatureKey,
+		KemPrivateKey:   s.KemPrivateKey,
+	}
+}
+
+func HasSecrets(s *Server) bool {
+	return len(s.PrivateIdentity) > 0 || len(s.PrivateKey) > 0 || len(s.SignatureKey) > 0 || len(s.KemPrivateKey) > 0
+}
+
+// add the secrets to the public fields of the same server, once they are checked to match
+func AddSecrets(s *Server, secrets *Server) error {
+	err := checkSecrets(s, secrets)
+	if err != nil {
+		return err
+	}
+	s.PrivateIdentity = secrets.PrivateIdentity
+	s.PrivateKey = secrets.PrivateKey
+	s.SignatureKey = secrets.SignatureKey
+	s.KemPrivateKey = secrets.KemPrivateKey
+	return nil
+}
+
+// the server has its secrets, and they match its public keys
+func CheckSecrets(s *Server) error {
+	return checkSecrets(s, s)
+}
+
+func checkSecrets(s *Server, secrets *Server) error {
+	if s.Id != secrets.Id || s.Address != secrets.Address {
+		return fmt.Errorf("the secrets of server %d (%s) are not for server %d (%s)", secrets.Id, secrets.Address, s.Id, s.Address)
+	}
+	sk := secrets.SignatureKey
+	if len(sk) != 2*crypto.VERIFICATION_KEY_SIZE || !bytes.Equal(sk[crypto.VERIFICATION_KEY_SIZE:], s.VerificationKey) {
+		return fmt.Errorf("the signature key of server %d does not match its verification key", s.Id)
+	}
+	priv := crypto.DHPrivateKey{}
+	if priv.InterpretFrom(secrets.PrivateKey) != nil || !bytes.Equal(priv.PublicKey().Bytes(), s.PublicKey) {
+		return fmt.Errorf("the private key of server %d does not match its public key", s.Id)
+	}
+	if len(s.Identity) > 0 {
+		_, err := tls.X509KeyPair(s.Identity, secrets.PrivateIdentity)
+		if err != nil {
+			return fmt.Errorf("the certificate key of server %d: %v", s.Id, err)
+		}
+	}
+	return nil
+}
+
+// the default keystore of the server at addr, next to the servers file
+func KeystoreFile(serversFile, addr string) string {
+	name := strings.NewReplacer(":", "_", "/", "_").Replace(addr)
+	return filepath.Join(filepath.Dir(serversFile), fmt.Sprintf("keystore-%s.json", name))
+}
+
+// the key of a keystore from the environment
+func KeystoreKeyFromEnv(keystoreFile string) *KeystoreKey {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return &KeystoreKey{Passphrase: []byte(passphrase)}
+	}
+	if keyFile := os.Getenv(KeyFileEnv); keyFile != "" {
+		return &KeystoreKey{KeyFile: keyFile}
+	}
+	return &KeystoreKey{KeyFile: strings.TrimSuffix(keystoreFile, ".json") + ".key"}
+}
+
+// the files a server needs to read its secrets
+func KeystoreFiles(serversFile, addr string) []string {
+	fn := KeystoreFile(serversFile, addr)
+	key := KeystoreKeyFromEnv(fn)
+	if key.Passphrase != nil {
+		return []string{fn}
+	}
+	return []string{fn, key.KeyFile}
+}
+
+// the authenticated data binds the ciphertext to the rest of the keystore
+func (k *Keystore) associatedData() []byte {
+	b := make([]byte, 20)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(k.Version))
+	binary.LittleEndian.PutUint64(b[4:12], uint64(k.Id))
+	binary.LittleEndian.PutUint64(b[12:20], uint64(k.LogN))
+	b = append(b, k.Address...)
+	b = append(b, 0)
+	b = append(b, k.Kdf...)
+	b = append(b, 0)
+	return append(b, k.Salt...)
+}
+
+func (k *Keystore) deriveKey(key *KeystoreKey) ([]byte, error) {
+	switch k.Kdf {
+	case kdfScrypt:
+		if key.Passphrase == nil {
+			return nil, fmt.Errorf("keystore of server %d needs a passphrase (%s)", k.Id, PassphraseEnv)
+		}
+		if k.LogN < 10 || k.LogN > 30 {
+			return nil, fmt.Errorf("bad scrypt cost %d", k.LogN)
+		}
+		return scrypt.Key(key.Passphrase, k.Salt, 1<<k.LogN, 8, 1, chacha20poly1305.KeySize)
+	case kdfFile:
+		if key.Passphrase != nil {
+			return nil, fmt.Errorf("keystore of server %d needs a key file", k.Id)
+		}
+		b, err := ioutil.ReadFile(key.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != keyFileSize {
+			return nil, fmt.Errorf("key file %s is not %d bytes", key.KeyFile, keyFileSize)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown keystore kdf %s", k.Kdf)
+}
+
+// encrypt the secrets of s, creating the key file if it does not exist
+func WriteKeystore(fn string, s *Server, key *KeystoreKey) error {
+	k := &Keystore{
+		Version: keystoreVersion,
+		Id:      s.Id,
+		Address: s.Address,
+		Kdf:     kdfFile,
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if key.Passphrase != nil {
+		k.Kdf = kdfScrypt
+		k.LogN = scryptLogN
+		k.Salt = make([]byte, 16)
+		rand.Read(k.Salt)
+	} else if _, err := os.Stat(key.KeyFile); os.IsNotExist(err) {
+		b := make([]byte, keyFileSize)
+		rand.Read(b)
+		err = ioutil.WriteFile(key.KeyFile, b, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	rand.Read(k.Nonce)
+	secret, err := k.deriveKey(key)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return err
+	}
+	plaintext, err := protojson.Marshal(Secrets(s))
+	if err != nil {
+		return err
+	}
+	k.Ciphertext = aead.Seal(nil, k.Nonce, plaintext, k.associatedData())
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, b, 0600)
+}
+
+// decrypt the secrets in a keystore
+func ReadKeystore(fn string, key *KeystoreKey) (*Server, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keystore{}
+	err = json.Unmarshal(b, k)
+	if err != nil {
+		return nil, err
+	}
+	if k.Version != keystoreVersion {
+		return nil, fmt.Errorf("unknown keystore version %d", k.Version)
+	}
+	secret, err := k.deriveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Nonce) != aead.NonceSize() {
+		return nil, errors.New("bad keystore nonce")
+	}
+	plaintext, err := aead.Open(nil, k.Nonce, k.Ciphertext, k.associatedData())
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt keystore %s, wrong key?", fn)
+	}
+	s := &Server{}
+	err = protojson.Unmarshal(plaintext, s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Id != k.Id || s.Address != k.Address {
+		return nil, errors.New("keystore does not match its secrets")
+	}
+	return s, nil
+}
+
+// add the secrets of the server at addr, and only that server, from its keystore
+func LoadOwnSecrets(servers map[int64]*Server, serversFile, addr string) error {
+	for _, s := range servers {
+		if s.Address != addr {
+			continue
+		}
+		fn := os.Getenv(KeystoreEnv)
+		if fn == "" {
+			fn = KeystoreFile(serversFile, addr)
+		}
+		secrets, err := ReadKeystore(fn, KeystoreKeyFromEnv(fn))
+		if err != nil {
+			return err
+		}
+		return AddSecrets(s, secrets)
+	}
+	return errors.New("Address not found")
+}
+
+// write the public servers file and a keystore for each server next to it
+// for the tools that create a network, servers only get their own keystore
+func MarshalServersWithKeystores(fn string, servers map[int64]*Server) error {
+	err := MarshalServersToFile(fn, servers)
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		ks := KeystoreFile(fn, s.Address)
+		err = WriteKeystore(ks, s, KeystoreKeyFromEnv(ks))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read the servers file with every server's secrets, for the tools that create a network
+func UnmarshalServersWithKeystores(fn string) (map[int64]*Server, error) {
+	servers, err := UnmarshalServersFromFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range servers {
+		ks := KeystoreFile(fn, s.Address)
+		secrets, err := ReadKeystore(ks, KeystoreKeyFromEnv(ks))
+		if err != nil {
+			return nil, err
+		}
+		err = AddSecrets(s, secrets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return servers, nil
+}
+
+// a servers file written before keystores, which must not be sent to other servers
+func ServersFileHasSecrets(fn string) bool {
+	sp := &Servers{}
+	if Unmarshal(fn, sp) != nil {
+		return false
+	}
+	for _, s := range sp.Servers {
+		if HasSecrets(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// servers files from before keystores have every server's secrets
+func stripSecrets(fn string, servers map[int64]*Server) {
+	stripped := 0
+	for sid, s := range servers {
+		if HasSecrets(s) {
+			servers[sid] = Public(s)
+			stripped++
+		}
+	}
+	if stripped > 0 {
+		logging.For("config").Warn("ignoring secrets in servers file, each server reads its own from its keystore", "file", fn, "servers", stripped)
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/logging"
+)
+
+func testServers(n int) map[int64]*Server {
+	servers := make(map[int64]*Server)
+	for id := int64(0); id < int64(n); id++ {
+		servers[id] = CreateServerWithCertificate(fmt.Sprintf("localhost:%d", 8000+id), id, nil, nil)
+	}
+	return servers
+}
+
+func TestKeystore(t *testing.T) {
+	dir := t.TempDir()
+	s := testServers(1)[0]
+	for _, key := range []*KeystoreKey{{KeyFile: filepath.Join(dir, "k.key")}, {Passphrase: []byte("correct horse")}} {
+		fn := filepath.Join(dir, "keystore.json")
+		err := WriteKeystore(fn, s, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(fn)
+		if bytes.Contains(b, s.SignatureKey) || bytes.Contains(b, s.PrivateKey) {
+			t.Fatal("the keystore has the secrets in the clear")
+		}
+		secrets, err := ReadKeystore(fn, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public := Public(s)
+		if HasSecrets(public) || CheckSecrets(public) == nil {
+			t.Fatal("public fields have secrets")
+		}
+		err = AddSecrets(public, secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(public.SignatureKey, s.SignatureKey) || !bytes.Equal(public.PrivateKey, s.PrivateKey) || CheckSecrets(public) != nil {
+			t.Fatal("secrets differ")
+		}
+	}
+}
+
+func TestKeystoreFailures(t *testing.T) {
+	dir := t.TempDir()
+	servers := testServers(2)
+	fn := filepath.Join(dir, "keystore.json")
+	err := WriteKeystore(fn, servers[0], &KeystoreKey{Passphrase: []byte("right")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadKeystore(fn, &KeystoreKey{Passphrase: []byte("wrong")}); err == nil {
+		t.Fatal("decrypted with the wrong passphrase")
+	}
+	if _, err := ReadKeystore(fn, &KeystoreKey{KeyFile: filepath.Join(dir, "missing.key")}); err == nil {
+		t.Fatal("decrypted a passphrase keystore with a key file")
+	}
+	// the id is authenticated
+	b, _ := ioutil.ReadFile(fn)
+	ioutil.WriteFile(fn, bytes.Replace(b, []byte(`"id":0`), []byte(`"id":1`), 1), 0600)
+	if _, err := ReadKeystore(fn, &KeystoreKey{Passphrase: []byte("right")}); err == nil {
+		t.Fatal("accepted a keystore with another id")
+	}
+	// another server's secrets
+	if AddSecrets(Public(servers[1]), Secrets(servers[0])) == nil {
+		t.Fatal("added the secrets of another server")
+	}
+	mixed := Secrets(servers[1])
+	mixed.SignatureKey = servers[0].SignatureKey
+	if AddSecrets(Public(servers[1]), mixed) == nil {
+		t.Fatal("added a signature key that does not match")
+	}
+}
+
+// the servers file is public and each server loads only its own keystore
+func TestServersWithKeystores(t *testing.T) {
+	dir := t.TempDir()
+	servers := testServers(3)
+	fn := filepath.Join(dir, "servers.json")
+	err := MarshalServersWithKeystores(fn, servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ServersFileHasSecrets(fn) {
+		t.Fatal("the servers file has secrets")
+	}
+	public, err := UnmarshalServersFromFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = LoadOwnSecrets(public, fn, servers[1].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, s := range public {
+		if HasSecrets(s) != (id == 1) {
+			t.Fatalf("server %d has secrets %v", id, HasSecrets(s))
+		}
+	}
+	all, err := UnmarshalServersWithKeystores(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, s := range all {
+		if !bytes.Equal(s.SignatureKey, servers[id].SignatureKey) {
+			t.Fatalf("server %d has other secrets", id)
+		}
+	}
+	// an old servers file with every secret is read without them
+	os.Remove(fn)
+	err = Marshal(fn, &Servers{Servers: servers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ServersFileHasSecrets(fn) {
+		t.Fatal("did not find the secrets")
+	}
+	b := &bytes.Buffer{}
+	logging.SetOutput(b)
+	public, err = UnmarshalServersFromFile(fn)
+	logging.SetOutput(os.Stderr)
+	if err != nil || HasSecrets(public[0]) {
+		t.Fatal("read the secrets of an old servers file")
+	}
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(b.Bytes(), &line); err != nil || line["level"] != "warn" || line["file"] != fn || line["servers"] != 3.0 {
+		t.Fatalf("Wrong warning %s", b.String())
+	}
+}
@@ -9,16 +9,24 @@ import (
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
+// only the public fields are written, see keystore.go for the secrets
 func MarshalServersToFile(fn string, servers map[int64]*Server) error {
 	sp := &Servers{
-		Servers: servers,
+		Servers: make(map[int64]*Server),
+	}
+	for sid, s := range servers {
+		sp.Servers[sid] = Public(s)
 	}
 	return Marshal(fn, sp)
 }
 
+// the public directory, without any secrets
 func UnmarshalServersFromFile(fn string) (map[int64]*Server, error) {
 	sp := &Servers{}
 	err := Unmarshal(fn, sp)
+	if err == nil {
+		stripSecrets(fn, sp.Servers)
+	}
 	return sp.Servers, err
 }
 
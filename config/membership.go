@@ -0,0 +1,80 @@
+package config
+
+/*
+Servers join and leave the network at epoch boundaries.
+A server keeps its id while it is in the network and a new server takes a new id,
+so anything kept by server id is never confused between two servers.
+Slices indexed by server id are sized by the id space and have no entry for servers that left.
+
+*/
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// the ids of the servers in increasing order
+func ServerIds(servers map[int64]*Server) []int64 {
+	ids := make([]int64, 0, len(servers))
+	for sid := range servers {
+		ids = append(ids, sid)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// one more than the largest server id, the length of slices indexed by server id
+func IdSpace(servers map[int64]*Server) int {
+	n := 0
+	for sid := range servers {
+		if int(sid) >= n {
+			n = int(sid) + 1
+		}
+	}
+	return n
+}
+
+// the servers that joined and left between two epochs
+// a server whose public config changed has left and joined again,
+// secrets are not compared since a server's own config has them and the directory does not
+func ServerChanges(prev, next map[int64]*Server) (added []int64, removed []int64) {
+	for _, sid := range ServerIds(next) {
+		if p, ok := prev[sid]; !ok || !samePublic(p, next[sid]) {
+			added = append(added, sid)
+		}
+	}
+	for _, sid := range ServerIds(prev) {
+		if s, ok := next[sid]; !ok || !samePublic(s, prev[sid]) {
+			removed = append(removed, sid)
+		}
+	}
+	return added, removed
+}
+
+func samePublic(a, b *Server) bool {
+	return proto.Equal(Public(a), Public(b))
+}
+
+// the groups of the next epoch that need new key shares: new groups,
+// groups whose members or their order changed, and groups with a member whose config changed
+func ChangedGroups(prevServers, nextServers map[int64]*Server, prevGroups, nextGroups map[int64]*Group) []int64 {
+	added, _ := ServerChanges(prevServers, nextServers)
+	joined := make(map[int64]bool)
+	for _, sid := range added {
+		joined[sid] = true
+	}
+	changed := make([]int64, 0)
+	for gid, g := range nextGroups {
+		p, ok := prevGroups[gid]
+		same := ok && len(p.Servers) == len(g.Servers)
+		for i := 0; same && i < len(g.Servers); i++ {
+			same = p.Servers[i] == g.Servers[i] && !joined[g.Servers[i]]
+		}
+		if !same {
+			changed = append(changed, gid)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i] < changed[j] })
+	return changed
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestChangedGroups(t *testing.T) {
+	prev := testServers(6)
+	prevGroups := map[int64]*Group{0: {Servers: []int64{0, 1, 2}}, 1: {Servers: []int64{3, 4, 5}}}
+	next := make(map[int64]*Server)
+	for sid, s := range prev {
+		if sid != 4 {
+			next[sid] = s
+		}
+	}
+	next[6] = CreateServerWithCertificate("localhost:9006", 6, nil, nil)
+	if IdSpace(next) != 7 || len(ServerIds(next)) != 6 {
+		t.Fatalf("ids %v", ServerIds(next))
+	}
+	added, removed := ServerChanges(prev, next)
+	if len(added) != 1 || added[0] != 6 || len(removed) != 1 || removed[0] != 4 {
+		t.Fatalf("added %v removed %v", added, removed)
+	}
+	// group 0 keeps its members, group 1 loses server 4 and group 2 is new
+	nextGroups := map[int64]*Group{0: {Servers: []int64{0, 1, 2}}, 1: {Servers: []int64{3, 5, 6}}, 2: {Servers: []int64{0, 3, 5}}}
+	changed := ChangedGroups(prev, next, prevGroups, nextGroups)
+	if len(changed) != 2 || changed[0] != 1 || changed[1] != 2 {
+		t.Fatalf("changed %v", changed)
+	}
+	// a server's own config has its secrets and the directory does not, which is not a change
+	public := make(map[int64]*Server)
+	for sid, s := range next {
+		public[sid] = Public(s)
+	}
+	if changed := ChangedGroups(next, public, nextGroups, nextGroups); len(changed) != 0 {
+		t.Fatalf("changed %v", changed)
+	}
+	// a member that left and joined again with new keys needs a new share
+	next[1] = CreateServerWithCertificate(prev[1].Address, 1, nil, nil)
+	changed = ChangedGroups(prev, next, prevGroups, nextGroups)
+	if len(changed) != 3 || changed[0] != 0 {
+		t.Fatalf("changed %v", changed)
+	}
+}
+
+func TestDirectorySourceEpoch(t *testing.T) {
+	a, keys := testAuthorities(1, 1)
+	sd, _ := NewSignedDirectory(testDirectory(2, testServers(4)))
+	sd.Sign(0, keys[0])
+	fn := filepath.Join(t.TempDir(), "directory.json")
+	err := WriteSignedDirectory(fn, sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &DirectorySource{Source: fn, Authorities: a}
+	d, err := source.FetchEpoch(2)
+	if err != nil || d.Epoch != 2 {
+		t.Fatal(d, err)
+	}
+	if _, err := source.FetchEpoch(3); err == nil {
+		t.Fatal("the directory of epoch 2 was taken for epoch 3")
+	}
+}
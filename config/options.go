@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // choice of algorithm to compute layers
 const LayerAlgorithm = 0
 
@@ -22,6 +24,49 @@ const StreamSize = 2 * 1024 * 1024
 // Batch verification of signatures
 const BatchSize = 64
 
+// Number of peers a layer is sent to at once
+// each send holds a signed copy of its chunk, so this also bounds memory
+const SendWorkers = 8
+
+// Admission control for client requests, per second and burst size
+// a client needs a token for every layer of its path
+const ClientRequestRate = 256
+const ClientRequestBurst = 1024
+const GlobalRequestRate = 1 << 18
+const GlobalRequestBurst = 1 << 20
+
+// Client requests allowed to wait for a round to start
+const MaxPendingRequests = 1 << 16
+
+// Messages handled at once on a submission stream, further messages wait in flow control
+const StreamWindow = 256
+
+// Client submissions are retried when the server cannot be reached
+const SubmissionAttempts = 5
+const SubmissionRetryDelay = 100 // milliseconds, doubled after each attempt
+
+// A directory is accepted this long before and after its validity period, for clock differences
+const DirectoryClockSkew = 5 * time.Minute
+const DirectoryFetchTimeout = 30 * time.Second
+const MaxDirectorySize = 64 * 1024 * 1024
+
+// Servers and clients serve metrics, and pprof if enabled, over http on their rpc port plus this offset
+// the tcp mesh uses the 1000 ports above rpc port + 1000
+const AdminPortOffset = 2000
+
+// Servers answer admin rpcs (status, profiles) on their rpc port plus this offset,
+// on a listener of their own so clients of the public port cannot reach them
+const AdminRPCPortOffset = 3000
+
+// Admin endpoints listen on loopback unless another host is set in LIGHTNING_ADMIN_HOST
+const DefaultAdminHost = "127.0.0.1"
+
+// A server stuck this long in one layer reports it is not live (seconds)
+const LayerTimeout = 600
+
+// How often health checks are updated (milliseconds)
+const HealthInterval = 1000
+
 // To estimate timeouts
 const Bandwidth = 1000 // mega bits per second
 // the minimum amount of bytes per read system call
@@ -32,8 +77,6 @@ const MASTER_GROUP = 0
 // INSECURE: just for computing messages faster to test other parts of the system
 const SkipToken = true
 
-const LogTimes = false
-
 const PreExpandKeys = false
 
 const NoDummies = true
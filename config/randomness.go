@@ -11,6 +11,7 @@ import (
 
 // just some random bytes. picking a seed in advance to repeat
 // experiments deterministically
+// this is the seed of the first epoch, later epochs use the output of the servers' beacon (server/beacon)
 var Seed = []byte{
 	196, 90, 111, 1, 181, 197, 66, 167, 74, 39, 198, 144, 4, 179, 62, 115,
 	192, 144, 122, 196, 242, 225, 81, 118, 131, 206, 191, 12, 210, 221, 64, 192,
@@ -29,8 +30,13 @@ type Shuffler struct {
 
 // use a fixed seed
 func SeededShuffler() *Shuffler {
+	return NewSeededShuffler(Seed)
+}
+
+// use a public seed, so anyone can repeat the choices
+func NewSeededShuffler(seed []byte) *Shuffler {
 	h := sha3.NewShake128()
-	h.Write(Seed)
+	h.Write(seed)
 	b := make([]byte, 128)
 	h.Read(b)
 	return &Shuffler{src: h, buf: b}
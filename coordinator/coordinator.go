@@ -2,6 +2,7 @@ package coordinator
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"io/ioutil"
@@ -14,21 +15,26 @@ import (
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
 	"github.com/simonlangowski/lightning1/crypto"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/results"
 	"github.com/simonlangowski/lightning1/server/prepareMessages"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 // The coordinator simulates the glocal clock time when the round begins, the time when receipts should have been received by, etc.
 // It also allows the experimenter to set parameters and measure the time things take
 
 type Coordinator struct {
-	privateKeys     []map[int64]*coord.KeyInformation
+	// by server and group
+	privateKeys     map[int64]map[int64]*coord.KeyInformation
 	groupSecretKeys groupSecretKeys
 	publicKeys      *coord.KeyInformation
 	Net             *CoordinatorNetwork
-	mu              sync.Mutex
+	// where the epoch phases of a spec fetch the signed directory, nil when the network never changes
+	Directory *config.DirectorySource
+	mu        sync.Mutex
 }
 
 type groupSecretKeys struct {
@@ -50,26 +56,34 @@ type Experiment struct {
 	ClientAndServerTokenTime time.Duration
 	ServerRoundTime          time.Duration
 	ExperimentStartTime      time.Time
-	Notes                    interface{}
+	// how long to wait for the servers, 0 waits forever
+	Timeout time.Duration `json:"-"`
+	// why the round failed
+	Error string `json:",omitempty"`
+	Notes interface{}
 }
 
 func NewCoordinator(net *CoordinatorNetwork) *Coordinator {
 	cfgs := net.ServerConfigs
 	groups := net.GroupConfigs
-	c := &Coordinator{
-		privateKeys: make([]map[int64]*coord.KeyInformation, len(cfgs)),
+	return &Coordinator{
+		privateKeys: newPrivateKeys(cfgs, groups),
 		publicKeys:  &coord.KeyInformation{},
 		Net:         net,
 	}
-	for i := range c.privateKeys {
-		c.privateKeys[i] = make(map[int64]*coord.KeyInformation)
+}
+
+func newPrivateKeys(servers map[int64]*config.Server, groups map[int64]*config.Group) map[int64]map[int64]*coord.KeyInformation {
+	keys := make(map[int64]map[int64]*coord.KeyInformation)
+	for sid := range servers {
+		keys[sid] = make(map[int64]*coord.KeyInformation)
 	}
 	for gid, group := range groups {
 		for _, sid := range group.Servers {
-			c.privateKeys[sid][gid] = &coord.KeyInformation{}
+			keys[sid][gid] = &coord.KeyInformation{}
 		}
 	}
-	return c
+	return keys
 }
 
 func (c *Coordinator) NewExperiment(round, numLayers, numServers, numMessages int, notes interface{}) *Experiment {
@@ -84,6 +98,7 @@ func (c *Coordinator) NewExperiment(round, numLayers, numServers, numMessages in
 			StartId:           0,
 			EndId:             int64(numMessages),
 			Check:             true,
+			CipherSuite:       int32(crypto.DefaultSuite),
 		},
 		NumMessages: numMessages,
 		DoRound:     true,
@@ -103,6 +118,15 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 		defer pprof.StopCPUProfile()
 	}
 	exp.ExperimentStartTime = time.Now()
+	exp.Info.Epoch = int64(c.Net.Epoch)
+	round := tracing.StartSpan(tracing.SpanContext{}, "round", "round", int(exp.Info.Round), "path", exp.Info.PathEstablishment)
+	defer round.End()
+	ctx := context.Background()
+	if exp.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, exp.Timeout)
+		defer cancel()
+	}
 
 	if exp.KeyGen {
 		c.KeyGenToken()
@@ -118,7 +142,9 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 	keyGenTime := time.Now()
 	if exp.DoRound {
 		if !exp.Info.PathEstablishment || exp.Info.Round == 0 {
-			err := c.Net.SendRoundSetup(exp.Info)
+			setup := tracing.StartSpan(round.Context(), "RoundSetup")
+			err := c.Net.SendRoundSetup(tracing.Inject(ctx, setup.Context()), exp.Info)
+			setup.End()
 			if err != nil {
 				log.Printf("Round setup")
 				return err
@@ -139,7 +165,9 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 		}
 		roundStartTime := time.Now()
 		if !(exp.Info.PathEstablishment && exp.Info.Round == 0) {
-			err := c.Net.SendRoundStart(exp.Info)
+			start := tracing.StartSpan(round.Context(), "RoundStart")
+			err := c.Net.SendRoundStart(tracing.Inject(ctx, start.Context()), exp.Info)
+			start.End()
 			if err != nil {
 				log.Printf("Server start")
 				return err
@@ -156,7 +184,7 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 			} else if exp.Info.ReceiptLayer > 0 {
 				// these receipts are only checked for test purposes
 				// they could not be checked without breaking anonymity
-				messages, err := c.Net.GetMessages(exp.Info)
+				messages, err := c.getMessages(ctx, round.Context(), exp.Info)
 				if err != nil {
 					log.Printf("Get messages")
 					return err
@@ -175,7 +203,7 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 				exp.Passed = true
 			}
 		} else {
-			messages, err := c.Net.GetMessages(exp.Info)
+			messages, err := c.getMessages(ctx, round.Context(), exp.Info)
 			if err != nil {
 				log.Printf("Get messages")
 				return err
@@ -190,6 +218,12 @@ func (c *Coordinator) DoAction(exp *Experiment) error {
 	return nil
 }
 
+func (c *Coordinator) getMessages(ctx context.Context, round tracing.SpanContext, i *coord.RoundInfo) ([][]byte, error) {
+	span := tracing.StartSpan(round, "GetMessages")
+	defer span.End()
+	return c.Net.GetMessages(tracing.Inject(ctx, span.Context()), i)
+}
+
 func (c *Coordinator) Check(messages [][]byte, numExpected int) bool {
 	seen := make(map[uint64]bool)
 	// test messages are consecutive integers up to numExpected
@@ -230,13 +264,13 @@ func (c *Coordinator) CheckReceipts(receipts [][]byte, round int, clients map[in
 
 // return keys to send to each server
 func (c *Coordinator) KeyGenToken() {
-	tokenSecretKey := mcl.Fr{}
+	tokenSecretKey := curve.Fr{}
 	tokenSecretKey.Random()
 	c.keyGenToken(&tokenSecretKey)
 	c.groupSecretKeys.TokenSecretKey = tokenSecretKey.Serialize()
 }
 
-func (c *Coordinator) keyGenToken(tokenSecretKey *mcl.Fr) {
+func (c *Coordinator) keyGenToken(tokenSecretKey *curve.Fr) {
 	if config.SkipToken {
 		log.Print("Warning: Using fixed token key is insecure")
 		tokenSecretKey = &token.SecretKey.Share
@@ -246,6 +280,7 @@ func (c *Coordinator) keyGenToken(tokenSecretKey *mcl.Fr) {
 		for i, sid := range group.Servers {
 			k := c.privateKeys[sid][gid]
 			k.GroupId = gid
+			k.Epoch = int64(c.Net.Epoch)
 			k.TokenPublicKey = make([]byte, pk.Len())
 			pk.PackTo(k.TokenPublicKey)
 			k.TokenKeyShare = make([]byte, shares[i].Share.Len())
@@ -254,8 +289,29 @@ func (c *Coordinator) keyGenToken(tokenSecretKey *mcl.Fr) {
 	}
 	tokenPublicKey := token.TokenPublicKey{}
 	tokenPublicKey.X = token.NewTokenSigningKey(tokenSecretKey).X
+	c.publicKeys.Epoch = int64(c.Net.Epoch)
 	c.publicKeys.TokenPublicKey = make([]byte, tokenPublicKey.Len())
 	tokenPublicKey.PackTo(c.publicKeys.TokenPublicKey)
+	c.keyGenTicket()
+}
+
+// tickets are signed with a new key whenever keys are dealt, so the tickets of an epoch cannot be spent in the next
+func (c *Coordinator) keyGenTicket() {
+	ticketSecretKey := curve.Fr{}
+	ticketSecretKey.Random()
+	ticketPublicKey := token.TokenPublicKey{}
+	ticketPublicKey.X = token.NewTokenSigningKey(&ticketSecretKey).X
+	c.publicKeys.TicketPublicKey = make([]byte, ticketPublicKey.Len())
+	ticketPublicKey.PackTo(c.publicKeys.TicketPublicKey)
+	for gid, group := range c.Net.GroupConfigs {
+		shares, _, _ := token.MockKeyGen(len(group.Servers), &ticketSecretKey)
+		for i, sid := range group.Servers {
+			k := c.privateKeys[sid][gid]
+			k.TicketPublicKey = c.publicKeys.TicketPublicKey
+			k.TicketKeyShare = make([]byte, shares[i].Share.Len())
+			shares[i].Share.PackTo(k.TicketKeyShare)
+		}
+	}
 }
 
 func (c *Coordinator) GenDHKeys() {
@@ -274,6 +330,7 @@ func (c *Coordinator) genDHKeys(ssk crypto.DHPrivateKey, groupKey []byte) {
 			b := shares[idx].Bytes()
 			k := c.privateKeys[sid][gid]
 			k.GroupId = gid
+			k.Epoch = int64(c.Net.Epoch)
 			k.GroupShare = b
 			k.GroupKey = groupKey
 		}
@@ -281,9 +338,32 @@ func (c *Coordinator) genDHKeys(ssk crypto.DHPrivateKey, groupKey []byte) {
 	c.publicKeys.GroupKey = groupKey
 }
 
+// move the network to the servers and groups of a new epoch between rounds
+// the group keys stay the same, the groups whose members changed are dealt new shares of them
+func (c *Coordinator) Reconfigure(epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.Net.Reconfigure(epoch, servers, groups)
+	if err != nil {
+		return err
+	}
+	c.privateKeys = newPrivateKeys(servers, groups)
+	if len(c.groupSecretKeys.TokenSecretKey) == 0 {
+		// no keys were dealt yet
+		return nil
+	}
+	tokenSecretKey := curve.Fr{}
+	tokenSecretKey.Deserialize(c.groupSecretKeys.TokenSecretKey)
+	c.keyGenToken(&tokenSecretKey)
+	c.genDHKeys(c.groupSecretKeys.Ssk, c.groupSecretKeys.GroupKey)
+	// every group needs its shares of the new epoch's ticket key, so all shares are sent again
+	return c.Net.SendKeys(c.privateKeys, c.publicKeys)
+}
+
+// append the experiment to fn as a line of json
 func (e *Experiment) RecordToFile(fn string) {
 	e.Info.PublicKeys = nil
-	data, err := json.MarshalIndent(e, "", " ")
+	data, err := json.Marshal(e)
 	if err != nil {
 		panic(err)
 	}
@@ -292,12 +372,36 @@ func (e *Experiment) RecordToFile(fn string) {
 		panic(err)
 	}
 	defer f.Close()
-	_, err = f.Write(data)
+	_, err = f.Write(append(data, '\n'))
 	if err != nil {
 		panic(err)
 	}
 }
 
+// the results of the round for the results store
+func (e *Experiment) Record(name string, params results.Params) *results.Record {
+	return &results.Record{
+		Schema:                   results.SchemaVersion,
+		Experiment:               name,
+		Round:                    e.Info.Round,
+		PathEstablishment:        e.Info.PathEstablishment,
+		MessageSize:              e.Info.MessageSize,
+		CipherSuite:              crypto.CipherSuite(e.Info.CipherSuite).String(),
+		KeyAgreement:             crypto.KeyAgreement(e.Info.KeyAgreement).String(),
+		Verifiable:               e.Info.Verifiable,
+		NumMessages:              e.NumMessages,
+		Passed:                   e.Passed,
+		Error:                    e.Error,
+		Start:                    e.ExperimentStartTime,
+		KeyGenTime:               e.KeyGenTime,
+		SetupTime:                e.SetupTime,
+		ClientAndServerTokenTime: e.ClientAndServerTokenTime,
+		ServerRoundTime:          e.ServerRoundTime,
+		Params:                   params,
+		Environment:              results.CurrentEnvironment(),
+	}
+}
+
 func (c *Coordinator) WriteKeys(fn string) {
 	// note that server keys are in the server config file
 	// these are the keys for the anytrust groups
@@ -334,7 +438,7 @@ func (c *Coordinator) LoadKeys(fn string) {
 		panic(err)
 	}
 	// regenerate shares for the current group configuration
-	tokenSecretKey := mcl.Fr{}
+	tokenSecretKey := curve.Fr{}
 	tokenSecretKey.Deserialize(c.groupSecretKeys.TokenSecretKey)
 	c.keyGenToken(&tokenSecretKey)
 	c.genDHKeys(c.groupSecretKeys.Ssk, c.groupSecretKeys.GroupKey)
@@ -346,7 +450,7 @@ func (c *Coordinator) WriteMessages(fn string, exp *Experiment) {
 		panic(errors.UnimplementedError())
 	}
 	c.Net.clients.RecordMessageFile = fn
-	err := c.Net.SendRoundSetup(exp.Info)
+	err := c.Net.SendRoundSetup(context.Background(), exp.Info)
 	if err != nil {
 		panic(err)
 	}
@@ -1,12 +1,27 @@
 package coordinator
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/token"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/results"
+	"github.com/simonlangowski/lightning1/server"
+	"github.com/simonlangowski/lightning1/server/beacon"
+	"github.com/simonlangowski/lightning1/server/processMessages"
 )
 
 func memProfile(name string) {
@@ -52,6 +67,42 @@ func TestInprocessLightning(t *testing.T) {
 	}
 }
 
+// servers refuse rounds that differ from the parameters of the signed directory
+func TestInprocessDirectoryParameters(t *testing.T) {
+	numServers := 4
+	numLayers := 4
+	net := NewInProcessNetwork(numServers, 2, 2)
+	c := NewCoordinator(net)
+	for _, s := range net.servers {
+		s.CommonState.Parameters = config.Parameters{NumLayers: numLayers + 1}
+	}
+	exp := c.NewExperiment(0, numLayers, numServers, 10, "")
+	exp.Info.SkipPathGen = true
+	exp.KeyGen = true
+	exp.Info.PathEstablishment = false
+	if err := c.DoAction(exp); err == nil || !strings.Contains(err.Error(), "the directory fixes") {
+		t.Fatalf("ran a round the directory does not allow: %v", err)
+	}
+
+	// the other servers may still be setting up the refused round
+	net = NewInProcessNetwork(numServers, 2, 2)
+	c = NewCoordinator(net)
+	for _, s := range net.servers {
+		s.CommonState.Parameters = config.Parameters{NumLayers: numLayers}
+	}
+	exp = c.NewExperiment(0, numLayers, numServers, 10, "")
+	exp.Info.SkipPathGen = true
+	exp.KeyGen = true
+	exp.Info.PathEstablishment = false
+	err := c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Passed {
+		t.Fatal("Did message check?")
+	}
+}
+
 func TestInprocessPathEstablishment(t *testing.T) {
 	f, err := os.Create("path.pprof")
 	if err != nil {
@@ -219,3 +270,503 @@ func TestInprocessPathAndLightning2(t *testing.T) {
 		}
 	}
 }
+
+// each round may change the cipher suite without setting up paths again
+func TestInprocessCipherSuites(t *testing.T) {
+	numServers := 10
+	numGroups := 3
+	groupSize := 3
+	numLayers := 10
+	numMessages := 100
+	suites := []crypto.CipherSuite{crypto.SuiteLegacy, crypto.SuiteAESCTR, crypto.SuiteChaCha20, crypto.SuiteChaCha20Poly1305}
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	for i, suite := range suites {
+		t.Logf("Round %v: %v", i, suite)
+		exp := c.NewExperiment(i, numLayers, numServers, numMessages, "")
+		exp.Info.CipherSuite = int32(suite)
+		exp.Info.PathEstablishment = false
+		if i == 0 {
+			exp.Info.SkipPathGen = true
+			exp.KeyGen = true
+		}
+		err := c.DoAction(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Passed {
+			t.Fatalf("%v: did message check?", suite)
+		}
+	}
+	exp := c.NewExperiment(len(suites), numLayers, numServers, numMessages, "")
+	exp.Info.CipherSuite = int32(len(suites))
+	exp.Info.PathEstablishment = false
+	if c.DoAction(exp) == nil {
+		t.Fatal("servers accepted an unknown suite")
+	}
+}
+
+// servers commit to each layer and check each other's commitments after the round
+func TestInprocessVerifiable(t *testing.T) {
+	numServers := 10
+	numGroups := 3
+	groupSize := 3
+	numLayers := 10
+	numMessages := 100
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	for i := 0; i < 2; i++ {
+		exp := c.NewExperiment(i, numLayers, numServers, numMessages, "")
+		exp.Info.Verifiable = true
+		exp.Info.PathEstablishment = false
+		if i == 0 {
+			exp.Info.SkipPathGen = true
+			exp.KeyGen = true
+		}
+		err := c.DoAction(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Passed {
+			t.Fatal("Did message check?")
+		}
+		auditRound(t, net, i, numLayers)
+	}
+	exp := c.NewExperiment(2, numLayers, numServers, numMessages, "")
+	exp.Info.Verifiable = true
+	exp.Info.CipherSuite = int32(crypto.SuiteLegacy)
+	exp.Info.PathEstablishment = false
+	if c.DoAction(exp) == nil {
+		t.Fatal("servers accepted a verifiable legacy round")
+	}
+}
+
+// audit every layer of the round on every server, as anyone can
+func auditRound(t *testing.T, net *CoordinatorNetwork, round, numLayers int) {
+	audits := make([][]*processMessages.ShuffleAudit, numLayers)
+	opened := 0
+	for layer := range audits {
+		audits[layer] = make([]*processMessages.ShuffleAudit, len(net.ServerConfigs))
+		for sid := range audits[layer] {
+			req := messages.NewSignedMessage(0, round, layer, 0, 0, 0, 1, messages.NetworkMessage_ShuffleAudit)
+			req.GetSignedData()
+			resp, err := net.clients.Caller.SendSignedMessage(sid, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			audits[layer][sid], err = processMessages.ReadAudit(resp, net.ServerConfigs[int64(sid)].VerificationKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened += len(audits[layer][sid].Openings)
+		}
+	}
+	vks := make([]crypto.VerificationKey, len(net.ServerConfigs))
+	for sid := range vks {
+		vks[sid] = net.ServerConfigs[int64(sid)].VerificationKey
+	}
+	if failures := processMessages.VerifyAudits(round, audits, vks); len(failures) > 0 {
+		t.Fatal(failures)
+	}
+	if opened == 0 {
+		t.Fatal("no links opened")
+	}
+}
+
+// anyone can run the beacon of an epoch, and every run gives the same output
+func TestInprocessBeacon(t *testing.T) {
+	numServers := 10
+	net := NewInProcessNetwork(numServers, 3, 3)
+	c := NewCoordinator(net)
+	exp := c.NewExperiment(0, 4, numServers, 100, "")
+	exp.Info.PathEstablishment = false
+	exp.Info.SkipPathGen = true
+	exp.KeyGen = true
+	err := c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vks := make([]crypto.VerificationKey, numServers)
+	for sid := range vks {
+		vks[sid] = net.ServerConfigs[int64(sid)].VerificationKey
+	}
+	run := func(label beacon.Label) (beacon.Output, error) {
+		transcript, output, err := beacon.Run(label, 0, vks, net.clients.Caller.SendSignedMessage)
+		if err != nil {
+			return output, err
+		}
+		checked := &beacon.Transcript{}
+		err = checked.InterpretFrom(transcript.Marshal())
+		if err != nil {
+			return output, err
+		}
+		o, err := checked.Verify(vks)
+		if err == nil && o != output {
+			t.Fatal("the transcript has another output")
+		}
+		return output, err
+	}
+	first, err := run(beacon.EpochLabel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := run(beacon.EpochLabel(1))
+	if err != nil || again != first {
+		t.Fatalf("a second run gave %x, %v", again, err)
+	}
+	if _, err := run(beacon.EpochLabel(3)); err == nil {
+		t.Fatal("skipped an epoch")
+	}
+	next, err := run(beacon.EpochLabel(2))
+	if err != nil || next == first {
+		t.Fatalf("next epoch %x, %v", next, err)
+	}
+	if _, err := run(beacon.EpochLabel(1)); err == nil {
+		t.Fatal("ran an earlier epoch again")
+	}
+	// round beacons are only run for verifiable rounds
+	if _, err := run(beacon.RoundLabel(0)); err == nil {
+		t.Fatal("ran the beacon of a round that is not verifiable")
+	}
+}
+
+// hybrid path keys are used by the path establishment rounds and then by every lightning round
+func TestInprocessHybridPathAndLightning(t *testing.T) {
+	if !crypto.KEMSupported {
+		t.Skip("ML-KEM needs go 1.24")
+	}
+	numServers := 10
+	numGroups := 3
+	groupSize := 3
+	numLayers := 10
+	numMessages := 100
+	numLightning := 2
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	for i := 0; i < numLayers; i++ {
+		t.Logf("Round %v", i)
+		exp := c.NewExperiment(i, numLayers, numServers, numMessages, "")
+		exp.KeyGen = (i == 0)
+		exp.Info.KeyAgreement = int32(crypto.KeyAgreementHybrid)
+		exp.Info.PathEstablishment = true
+		// boomerangs are decrypted with the outgoing keys
+		exp.Info.BoomerangLimit = int64(numLayers) / 2
+		if i-int(exp.Info.BoomerangLimit) > 0 {
+			exp.Info.ReceiptLayer = int64(i) - exp.Info.BoomerangLimit
+		}
+		exp.Info.NextLayer = int64(i)
+		exp.Info.LastLayer = (i == numLayers-1)
+		err := c.DoAction(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Passed {
+			t.Fatal("Did message check?")
+		}
+	}
+	for i := numLayers; i < numLayers+numLightning; i++ {
+		t.Logf("Round %v", i)
+		exp := c.NewExperiment(i, numLayers, numServers, numMessages, "")
+		exp.Info.PathEstablishment = false
+		err := c.DoAction(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Passed {
+			t.Fatal("Did message check?")
+		}
+	}
+}
+
+func TestInprocessHybridSkipPathGen(t *testing.T) {
+	if !crypto.KEMSupported {
+		t.Skip("ML-KEM needs go 1.24")
+	}
+	numServers := 10
+	numGroups := 3
+	groupSize := 3
+	numLayers := 10
+	numMessages := 100
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	exp := c.NewExperiment(0, numLayers, numServers, numMessages, "")
+	exp.Info.SkipPathGen = true
+	exp.Info.KeyAgreement = int32(crypto.KeyAgreementHybrid)
+	exp.KeyGen = true
+	exp.Info.PathEstablishment = false
+	err := c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Passed {
+		t.Fatal("Did message check?")
+	}
+	exp = c.NewExperiment(1, numLayers, numServers, numMessages, "")
+	exp.Info.KeyAgreement = 2
+	exp.Info.PathEstablishment = false
+	if c.DoAction(exp) == nil {
+		t.Fatal("servers accepted an unknown key agreement")
+	}
+}
+
+func TestInprocessMembershipChange(t *testing.T) {
+	numServers := 6
+	numGroups := 2
+	groupSize := 3
+	numLayers := 4
+	numMessages := 50
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	exp := c.NewExperiment(0, numLayers, numServers, numMessages, "")
+	exp.Info.SkipPathGen = true
+	exp.KeyGen = true
+	exp.Info.PathEstablishment = false
+	err := c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Passed {
+		t.Fatal("Did message check?")
+	}
+
+	// server 1 leaves and servers 6 and 7 join
+	servers := make(map[int64]*config.Server)
+	for sid, cfg := range net.ServerConfigs {
+		if sid != 1 {
+			servers[sid] = cfg
+		}
+	}
+	for _, sid := range []int64{6, 7} {
+		servers[sid] = config.CreateServerWithCertificate(fmt.Sprintf("localhost:%d", base+int(sid)*numServers), sid, nil, nil)
+	}
+	groups := config.CreateSeparateGroupsWithSize(numGroups, groupSize, config.ServerIds(servers), []byte("next epoch"))
+	changed := config.ChangedGroups(net.ServerConfigs, servers, net.GroupConfigs, groups)
+	// status queries and client requests do not wait for the reconfiguration
+	running := append([]*server.Server(nil), net.servers...)
+	stop := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, s := range running {
+				s.Health()
+				s.Status(context.Background(), nil)
+			}
+		}
+	}()
+	err = c.Reconfigure(1, servers, groups)
+	close(stop)
+	<-polled
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) == 0 {
+		t.Fatal("no group changed")
+	}
+	for _, sid := range config.ServerIds(servers) {
+		s := net.servers[sid]
+		if s.CommonState.Epoch != 1 || s.CommonState.NumServers != len(servers) {
+			t.Fatalf("server %d is in epoch %d with %d servers", sid, s.CommonState.Epoch, s.CommonState.NumServers)
+		}
+		for i := uint64(0); i < 100; i++ {
+			hash := [token.HASH_SIZE]byte{}
+			binary.LittleEndian.PutUint64(hash[:], i)
+			if dest := s.CommonState.HashToServer(&hash); dest == 1 {
+				t.Fatalf("server %d hashes to server 1, which left", sid)
+			}
+		}
+		for gid, g := range groups {
+			member := false
+			for _, m := range g.Servers {
+				member = member || m == sid
+			}
+			if (s.GroupAliases[int32(gid)] != nil) != member {
+				t.Fatalf("server %d: membership of group %d", sid, gid)
+			}
+		}
+	}
+
+	// the remaining servers were not restarted, the next round runs over the new servers
+	exp = c.NewExperiment(1, numLayers, len(servers), numMessages, "")
+	exp.Info.SkipPathGen = true
+	exp.Info.PathEstablishment = false
+	err = c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Passed {
+		t.Fatal("messages were lost after the membership change")
+	}
+	if c.Reconfigure(1, servers, groups) == nil {
+		t.Fatal("moved to the same epoch again")
+	}
+}
+
+// a directory of the epoch signed by a single authority
+func testDirectory(t *testing.T, epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) *config.DirectorySource {
+	vk, sk := crypto.NewSigningKeyPair()
+	authorities := &config.Authorities{Threshold: 1, Keys: []crypto.VerificationKey{vk}}
+	sd, err := config.NewSignedDirectory(config.NewDirectory(epoch, servers, groups, config.Parameters{}, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Sign(0, sk)
+	fn := filepath.Join(t.TempDir(), "directory.json")
+	err = config.WriteSignedDirectory(fn, sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.DirectorySource{Source: fn, Authorities: authorities}
+}
+
+// an epoch phase of a spec moves the network to the signed directory, as cmd/coordinator runs it
+func TestInprocessEpochPhase(t *testing.T) {
+	numServers := 6
+	net := NewInProcessNetwork(numServers, 2, 3)
+	c := NewCoordinator(net)
+
+	// server 5 leaves in epoch 1
+	servers := make(map[int64]*config.Server)
+	for sid, cfg := range net.ServerConfigs {
+		if sid != 5 {
+			servers[sid] = cfg
+		}
+	}
+	groups := config.CreateSeparateGroupsWithSize(2, 2, config.ServerIds(servers), []byte("epoch 1"))
+	c.Directory = testDirectory(t, 1, servers, groups)
+
+	spec := &Spec{
+		Name:       "epochs",
+		NumServers: numServers,
+		NumUsers:   50,
+		NumLayers:  4,
+		Phases: []Phase{
+			{Type: KeyGenPhase},
+			{Type: LightningPhase, Rounds: 1},
+			{Type: EpochPhase},
+			{Type: LightningPhase, Rounds: 2},
+		},
+	}
+	passed := 0
+	err := c.RunSpec(spec, t.TempDir(), spec.Params(), func(r *results.Record) {
+		if r.Passed {
+			passed++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passed != 3 {
+		t.Fatalf("%d of 3 rounds passed", passed)
+	}
+	if net.Epoch != 1 || len(net.ServerConfigs) != numServers-1 {
+		t.Fatalf("network in epoch %d with %d servers", net.Epoch, len(net.ServerConfigs))
+	}
+	for _, sid := range config.ServerIds(servers) {
+		if e := net.servers[sid].CommonState.Epoch; e != 1 {
+			t.Fatalf("server %d is in epoch %d", sid, e)
+		}
+	}
+}
+
+func TestInprocessPostingQuota(t *testing.T) {
+	numServers := 6
+	numGroups := 2
+	groupSize := 3
+	numLayers := 4
+	numMessages := 50
+	quota := 2
+	net := NewInProcessNetwork(numServers, numGroups, groupSize)
+	c := NewCoordinator(net)
+	for i := 0; i < quota; i++ {
+		exp := c.NewExperiment(i, numLayers, numServers, numMessages, "")
+		exp.Info.SkipPathGen = (i == 0)
+		exp.KeyGen = (i == 0)
+		exp.Info.PathEstablishment = false
+		exp.Info.PostingQuota = int64(quota)
+		err := c.DoAction(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Passed {
+			t.Fatal("Did message check?")
+		}
+	}
+	exp := c.NewExperiment(quota, numLayers, numServers, numMessages, "")
+	exp.Info.PathEstablishment = false
+	exp.Info.PostingQuota = int64(quota)
+	err := c.DoAction(exp)
+	if !errors.Is(err, errors.ErrQuotaExceeded) {
+		t.Fatalf("clients posted more than their quota: %v", err)
+	}
+
+	// the next epoch has a new ticket key and a new quota
+	// its configs come from the public directory, which does not change the groups
+	members := make(map[[2]int64]interface{})
+	public := make(map[int64]*config.Server)
+	for sid, cfg := range net.ServerConfigs {
+		public[sid] = config.Public(cfg)
+		for gid, g := range net.servers[sid].GroupAliases {
+			members[[2]int64{sid, int64(gid)}] = g
+		}
+	}
+	err = c.Reconfigure(1, public, net.GroupConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, g := range members {
+		if interface{}(net.servers[k[0]].GroupAliases[int32(k[1])]) != g {
+			t.Fatalf("server %d started group %d over", k[0], k[1])
+		}
+	}
+	exp = c.NewExperiment(quota+1, numLayers, numServers, numMessages, "")
+	exp.Info.SkipPathGen = true
+	exp.Info.PathEstablishment = false
+	exp.Info.PostingQuota = int64(quota)
+	err = c.DoAction(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Passed {
+		t.Fatal("the quota did not start over in the next epoch")
+	}
+}
+
+// the quota starts over when a spec moves to the next epoch
+func TestInprocessPostingQuotaEpochs(t *testing.T) {
+	numServers := 6
+	net := NewInProcessNetwork(numServers, 2, 3)
+	c := NewCoordinator(net)
+	c.Directory = testDirectory(t, 1, net.ServerConfigs, net.GroupConfigs)
+	spec := &Spec{
+		Name:         "quota",
+		NumServers:   numServers,
+		NumUsers:     50,
+		NumLayers:    4,
+		PostingQuota: 1,
+		Phases: []Phase{
+			{Type: KeyGenPhase},
+			{Type: LightningPhase, Rounds: 1},
+			{Type: EpochPhase},
+			{Type: LightningPhase, Rounds: 1},
+		},
+	}
+	err := c.RunSpec(spec, t.TempDir(), spec.Params(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a new epoch the second post is over the quota
+	net = NewInProcessNetwork(numServers, 2, 3)
+	c = NewCoordinator(net)
+	spec.Phases = []Phase{{Type: KeyGenPhase}, {Type: LightningPhase, Rounds: 2}}
+	err = c.RunSpec(spec, t.TempDir(), spec.Params(), nil)
+	if !errors.Is(err, errors.ErrQuotaExceeded) {
+		t.Fatalf("clients posted more than their quota: %v", err)
+	}
+}
@@ -77,5 +77,10 @@ func (i *RoundInfo) Copy() *RoundInfo {
 		Check:             i.Check,
 		Interval:          i.Interval,
 		SkipPathGen:       i.SkipPathGen,
+		CipherSuite:       i.CipherSuite,
+		KeyAgreement:      i.KeyAgreement,
+		Verifiable:        i.Verifiable,
+		Epoch:             i.Epoch,
+		PostingQuota:      i.PostingQuota,
 	}
 }
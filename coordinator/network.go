@@ -14,6 +14,7 @@ import (
 	"github.com/simonlangowski/lightning1/client"
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
+	"github.com/simonlangowski/lightning1/errors"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server"
@@ -41,17 +42,29 @@ type CoordinatorNetwork struct {
 	remoteServers []coord.CoordinatorHandlerClient
 	remoteClients []coord.CoordinatorHandlerClient
 	processes     []*exec.Cmd
+	// server processes by id when run on this machine
+	serverProcesses map[int64]*exec.Cmd
+	// servers stopped by a fault
+	killed map[int64]bool
+	// servers join and leave between epochs
+	Epoch int
+	// in process, the handlers of the servers by id and their links
+	handlers  []messages.MessageHandlersServer
+	mockConns *network.MockConnNetwork
 }
 
 func NewRemoteNetwork(serverFile, groupFile, clientsFile string) *CoordinatorNetwork {
-	servers, groups, clients := LoadConfigs(serverFile, groupFile, clientsFile)
+	d, clients := LoadConfigs(serverFile, groupFile, clientsFile)
+	servers, groups := d.Servers, d.Groups
 	ok := TransferFileToAllServers(servers, serverFile)
 	ok = ok && TransferFileToAllServers(servers, groupFile)
+	ok = ok && TransferKeystores(servers, serverFile)
 	ok = ok && StartRemoteServers(servers, ServerProcessName, serverFile, groupFile, clientsFile)
 	if len(clientsFile) > 0 {
 		// ok = ok && TransferFileToAllServers(clients, serverFile)
 		// ok = ok && TransferFileToAllServers(clients, groupFile)
 		ok = ok && TransferFileToAllServers(clients, clientsFile)
+		ok = ok && TransferKeystores(clients, clientsFile)
 		ok = ok && StartRemoteServers(clients, ClientProcessName, serverFile, groupFile, clientsFile)
 	}
 	c := &CoordinatorNetwork{
@@ -60,6 +73,7 @@ func NewRemoteNetwork(serverFile, groupFile, clientsFile string) *CoordinatorNet
 		ClientConfigs: clients,
 		serverNetType: remote,
 		clientNetType: remote,
+		Epoch:         d.Epoch,
 	}
 	if !ok {
 		c.KillAll()
@@ -70,7 +84,7 @@ func NewRemoteNetwork(serverFile, groupFile, clientsFile string) *CoordinatorNet
 	} else {
 		c.clientNetType = inprocess
 		c.SetupInProcess(0)
-		caller, err := network.NewCaller(c.ServerConfigs)
+		caller, err := network.NewCaller(c.ServerConfigs, nil)
 		if err != nil {
 			c.KillAll()
 			panic(err)
@@ -82,14 +96,12 @@ func NewRemoteNetwork(serverFile, groupFile, clientsFile string) *CoordinatorNet
 	return c
 }
 
-func LoadConfigs(serverFile, groupFile, clientsFile string) (map[int64]*config.Server, map[int64]*config.Group, map[int64]*config.Server) {
-	servers, err := config.UnmarshalServersFromFile(serverFile)
+// the network from the verified directory in LIGHTNING_DIRECTORY if it is set, like the servers read it,
+// or else from the servers and groups files as epoch 0
+func LoadConfigs(serverFile, groupFile, clientsFile string) (*config.Directory, map[int64]*config.Server) {
+	d, err := config.LoadNetwork(serverFile, groupFile)
 	if err != nil {
-		log.Fatalf("Could not read servers file %s", serverFile)
-	}
-	groups, err := config.UnmarshalGroupsFromFile(groupFile)
-	if err != nil {
-		log.Fatalf("Could not read group file %s", groupFile)
+		log.Fatal(err)
 	}
 	clients := make(map[int64]*config.Server)
 	if len(clientsFile) > 0 {
@@ -98,16 +110,17 @@ func LoadConfigs(serverFile, groupFile, clientsFile string) (map[int64]*config.S
 			log.Fatalf("Could not read clients file %s", clientsFile)
 		}
 	}
-	return servers, groups, clients
+	return d, clients
 }
 
 func NewLocalNetwork(serverConfigs map[int64]*config.Server, groupConfigs map[int64]*config.Group, clientConfigs map[int64]*config.Server) *CoordinatorNetwork {
 	c := &CoordinatorNetwork{}
 	c.clientNetType = local
 	c.serverNetType = local
+	c.serverProcesses = make(map[int64]*exec.Cmd)
 	c.ServerConfigs, c.GroupConfigs, c.ClientConfigs = serverConfigs, groupConfigs, clientConfigs
-	// write configs to local file system
-	err := config.MarshalServersToFile("servers.json", c.ServerConfigs)
+	// write configs to local file system, with a keystore for each server
+	err := config.MarshalServersWithKeystores("servers.json", c.ServerConfigs)
 	if err != nil {
 		panic(err)
 	}
@@ -125,6 +138,7 @@ func NewLocalNetwork(serverConfigs map[int64]*config.Server, groupConfigs map[in
 			panic(err)
 		}
 		c.processes = append(c.processes, cmd)
+		c.serverProcesses[s.Id] = cmd
 	}
 	// make sure servers are ready
 	time.Sleep(time.Second)
@@ -132,14 +146,14 @@ func NewLocalNetwork(serverConfigs map[int64]*config.Server, groupConfigs map[in
 	if len(c.ClientConfigs) == 0 {
 		c.clientNetType = inprocess
 		c.SetupInProcess(0)
-		caller, err := network.NewCaller(c.ServerConfigs)
+		caller, err := network.NewCaller(c.ServerConfigs, nil)
 		if err != nil {
 			panic(err)
 		}
 		caller.SetGroups(c.GroupConfigs)
 		c.clients.Caller = caller
 	} else {
-		err = config.MarshalServersToFile("clients.json", c.ClientConfigs)
+		err = config.MarshalServersWithKeystores("clients.json", c.ClientConfigs)
 		if err != nil {
 			panic(err)
 		}
@@ -176,7 +190,7 @@ func NewLocalConfig(numServers, numGroups, groupSize, numClients int, inprocess
 	for i := range serverIds {
 		serverIds[i] = int64(i)
 	}
-	groups := config.CreateSeparateGroupsWithSize(numGroups, groupSize, serverIds)
+	groups := config.CreateSeparateGroupsWithSize(numGroups, groupSize, serverIds, config.Seed)
 	servers := make(map[int64]*config.Server)
 	for _, id := range serverIds {
 		addr := fmt.Sprintf("localhost:%d", base+(int(id)*numServers))
@@ -198,6 +212,7 @@ func (c *CoordinatorNetwork) SetupInProcess(numServers int) {
 	c.servers = make([]*server.Server, numServers)
 	mockNetwork := make([]messages.MessageHandlersServer, numServers)
 	mockCondNetwork := network.NewMockConnNetwork()
+	c.handlers, c.mockConns = mockNetwork, mockCondNetwork
 	for i := range c.servers {
 		h := server.NewHandler()
 		mockNetwork[i] = h
@@ -273,7 +288,8 @@ func (c *CoordinatorNetwork) SetupInProcess(numServers int) {
 
 const retries = 3
 
-func (c *CoordinatorNetwork) SendKeys(keyMessages []map[int64]*coord.KeyInformation, publicKeys *coord.KeyInformation) error {
+// send the key information of each server in keyMessages, servers in no group get the public keys
+func (c *CoordinatorNetwork) SendKeys(keyMessages map[int64]map[int64]*coord.KeyInformation, publicKeys *coord.KeyInformation) error {
 	done := make(chan error)
 	for sid, keys := range keyMessages {
 		go func(sid int, keys map[int64]*coord.KeyInformation) {
@@ -304,7 +320,7 @@ func (c *CoordinatorNetwork) SendKeys(keyMessages []map[int64]*coord.KeyInformat
 			done <- nil
 		}(int(sid), keys)
 	}
-	for range c.ServerConfigs {
+	for range keyMessages {
 		err := <-done
 		if err != nil {
 			return err
@@ -412,11 +428,10 @@ func (c *CoordinatorNetwork) CheckClientReceipt(i *coord.RoundInfo, numMessages
 	return nil
 }
 
-func (c *CoordinatorNetwork) SendRoundSetup(i *coord.RoundInfo) error {
+func (c *CoordinatorNetwork) SendRoundSetup(ctx context.Context, i *coord.RoundInfo) error {
 	done := make(chan error)
-	for idx := range c.ServerConfigs {
+	for _, idx := range c.running() {
 		go func(idx int) {
-			ctx := context.Background()
 			var err error
 			if c.serverNetType == inprocess {
 				_, err = c.servers[idx].RoundSetup(ctx, i)
@@ -426,7 +441,7 @@ func (c *CoordinatorNetwork) SendRoundSetup(i *coord.RoundInfo) error {
 			done <- err
 		}(int(idx))
 	}
-	for range c.ServerConfigs {
+	for range c.running() {
 		err := <-done
 		if err != nil {
 			return err
@@ -435,12 +450,11 @@ func (c *CoordinatorNetwork) SendRoundSetup(i *coord.RoundInfo) error {
 	return nil
 }
 
-func (c *CoordinatorNetwork) SendRoundStart(i *coord.RoundInfo) error {
+func (c *CoordinatorNetwork) SendRoundStart(ctx context.Context, i *coord.RoundInfo) error {
 	done := make(chan error)
-	for idx := range c.ServerConfigs {
+	for _, idx := range c.running() {
 		go func(idx int) {
 			var err error
-			ctx := context.Background()
 			if c.serverNetType == inprocess {
 				_, err = c.servers[idx].RoundStart(ctx, i)
 			} else {
@@ -449,7 +463,7 @@ func (c *CoordinatorNetwork) SendRoundStart(i *coord.RoundInfo) error {
 			done <- err
 		}(int(idx))
 	}
-	for range c.ServerConfigs {
+	for range c.running() {
 		err := <-done
 		if err != nil {
 			return err
@@ -458,13 +472,12 @@ func (c *CoordinatorNetwork) SendRoundStart(i *coord.RoundInfo) error {
 	return nil
 }
 
-func (c *CoordinatorNetwork) GetMessages(i *coord.RoundInfo) ([][]byte, error) {
+func (c *CoordinatorNetwork) GetMessages(ctx context.Context, i *coord.RoundInfo) ([][]byte, error) {
 	responses := make([][]byte, 0)
 	mu := sync.Mutex{}
 	done := make(chan error)
-	for idx := range c.ServerConfigs {
+	for _, idx := range c.running() {
 		go func(idx int) {
-			ctx := context.Background()
 			var messages *coord.ServerMessages
 			var err error
 			if c.serverNetType == inprocess {
@@ -484,7 +497,7 @@ func (c *CoordinatorNetwork) GetMessages(i *coord.RoundInfo) ([][]byte, error) {
 			}
 		}(int(idx))
 	}
-	for range c.ServerConfigs {
+	for range c.running() {
 		err := <-done
 		if err != nil {
 			return nil, err
@@ -494,12 +507,12 @@ func (c *CoordinatorNetwork) GetMessages(i *coord.RoundInfo) ([][]byte, error) {
 }
 
 func (c *CoordinatorNetwork) Connect(cfgs map[int64]*config.Server) []coord.CoordinatorHandlerClient {
-	conn, err := network.GetConnections(cfgs)
+	conn, err := network.GetConnections(cfgs, nil)
 	if err != nil {
 		c.KillAll()
 		panic(err)
 	}
-	clients := make([]coord.CoordinatorHandlerClient, len(conn))
+	clients := make([]coord.CoordinatorHandlerClient, config.IdSpace(cfgs))
 	for id, cc := range conn {
 		clients[id] = coord.NewCoordinatorHandlerClient(cc)
 	}
@@ -522,6 +535,125 @@ func (c *CoordinatorNetwork) KillAll() {
 		KillRemoteServers(c.ClientConfigs, ClientProcessName)
 	}
 }
+
+// move to the servers and groups of a new epoch between rounds
+// in process, servers that joined are made and servers that left are shut down,
+// otherwise servers that joined are already running and move to the epoch from the directory
+func (c *CoordinatorNetwork) Reconfigure(epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) error {
+	if epoch <= c.Epoch {
+		return fmt.Errorf("epoch %d does not follow epoch %d", epoch, c.Epoch)
+	}
+	added, removed := config.ServerChanges(c.ServerConfigs, servers)
+	idSpace := config.IdSpace(servers)
+	if c.serverNetType == inprocess {
+		for _, sid := range removed {
+			if _, stays := servers[sid]; stays {
+				// its config changed, which a running server cannot do
+				return errors.WrongServerError().From(int(sid))
+			}
+		}
+		if idSpace > len(c.servers) {
+			c.servers = append(c.servers, make([]*server.Server, idSpace-len(c.servers))...)
+		}
+		// the callers keep the handlers they were given
+		handlers := make([]messages.MessageHandlersServer, len(c.servers))
+		copy(handlers, c.handlers)
+		c.handlers = handlers
+		for _, sid := range removed {
+			c.servers[sid].TcpConnections.ShutDown()
+			c.servers[sid], c.handlers[sid] = nil, nil
+		}
+		joined := make([]*server.Server, 0, len(added))
+		for _, sid := range added {
+			h := server.NewHandler()
+			s := server.NewServer(&config.Servers{Servers: servers}, &config.Groups{Groups: groups}, h, servers[sid].Address)
+			s.CommonState.Epoch = epoch
+			s.Caller = network.NewMockCaller(nil)
+			s.TcpConnections = network.NewConnectionManager(servers, int(sid))
+			s.TcpConnections.SetCaller(s.Caller)
+			s.TcpConnections.SetMock(c.mockConns)
+			c.servers[sid], c.handlers[sid] = s, h
+			joined = append(joined, s)
+		}
+		for _, sid := range config.ServerIds(servers) {
+			c.servers[sid].Caller.SetMockNetwork(c.handlers)
+			c.servers[sid].Caller.SetGroups(groups)
+			if c.servers[sid].CommonState.Epoch < epoch {
+				err := c.servers[sid].Reconfigure(epoch, servers, groups)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, s := range joined {
+			s.TcpConnections.LaunchConnects()
+		}
+	} else {
+		joined := make(map[int64]*config.Server)
+		for _, sid := range added {
+			joined[sid] = servers[sid]
+		}
+		conns := c.Connect(joined)
+		if idSpace > len(c.remoteServers) {
+			c.remoteServers = append(c.remoteServers, make([]coord.CoordinatorHandlerClient, idSpace-len(c.remoteServers))...)
+		}
+		for _, sid := range removed {
+			c.remoteServers[sid] = nil
+		}
+		for _, sid := range added {
+			c.remoteServers[sid] = conns[sid]
+		}
+	}
+	if c.clientNetType == inprocess {
+		err := c.clients.Reconfigure(epoch, servers, groups)
+		if err != nil {
+			return err
+		}
+		if c.serverNetType == inprocess {
+			c.clients.Caller.SetMockNetwork(c.handlers)
+		}
+	}
+	for _, sid := range removed {
+		delete(c.killed, sid)
+	}
+	c.ServerConfigs, c.GroupConfigs, c.Epoch = servers, groups, epoch
+	return nil
+}
+
+// stop a server to inject a fault, the coordinator no longer contacts it
+func (c *CoordinatorNetwork) Kill(sid int64) error {
+	if _, ok := c.ServerConfigs[sid]; !ok {
+		return errors.WrongServerError().From(int(sid))
+	}
+	switch c.serverNetType {
+	case inprocess:
+		c.servers[sid].TcpConnections.ShutDown()
+	case local:
+		err := c.serverProcesses[sid].Process.Signal(syscall.SIGKILL)
+		if err != nil {
+			return err
+		}
+	case remote:
+		KillRemoteServers(map[int64]*config.Server{sid: c.ServerConfigs[sid]}, ServerProcessName)
+	}
+	if c.killed == nil {
+		c.killed = make(map[int64]bool)
+	}
+	c.killed[sid] = true
+	return nil
+}
+
+// the ids of servers that have not been killed
+func (c *CoordinatorNetwork) running() []int64 {
+	ids := make([]int64, 0, len(c.ServerConfigs))
+	for id := range c.ServerConfigs {
+		if !c.killed[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 func (c *CoordinatorNetwork) SetKill() {
 	go func() {
 		sigs := make(chan os.Signal, 1)
@@ -15,22 +15,37 @@ const ServerProcessName = "server"
 const ClientProcessName = "client"
 const waitTime = 10 * time.Second
 
+// the servers file only has the public fields, secrets are sent by TransferKeystores
 func TransferFileToAllServers(servers map[int64]*config.Server, fn string) bool {
+	if config.ServersFileHasSecrets(fn) {
+		log.Printf("Not sending %s to every server, it has secrets: write it again with config.MarshalServersWithKeystores", fn)
+		return false
+	}
 	done := make(chan bool)
 	for _, s := range servers {
 		go func(s *config.Server) {
-			cmd := exec.Command("scp", "-i", "~/.ssh/lkey", "-o", "StrictHostKeyChecking=no", fn,
-				fmt.Sprintf("ec2-user@%s:~/go/bin/%s", config.Host(s.Address), fn))
-			cmd.Stderr = os.Stderr
-			cmd.Stdout = os.Stdout
-			// log.Printf("Running %v", cmd)
-			err := cmd.Run()
-			if err != nil {
-				log.Print(err)
-				done <- false
-			} else {
-				done <- true
+			done <- transferFile(s, fn)
+		}(s)
+	}
+	for range servers {
+		b := <-done
+		if !b {
+			return false
+		}
+	}
+	return true
+}
+
+// send each server its own keystore and key file, and no other server's
+func TransferKeystores(servers map[int64]*config.Server, serversFile string) bool {
+	done := make(chan bool)
+	for _, s := range servers {
+		go func(s *config.Server) {
+			ok := true
+			for _, fn := range config.KeystoreFiles(serversFile, s.Address) {
+				ok = ok && transferFile(s, fn)
 			}
+			done <- ok
 		}(s)
 	}
 	for range servers {
@@ -42,6 +57,20 @@ func TransferFileToAllServers(servers map[int64]*config.Server, fn string) bool
 	return true
 }
 
+func transferFile(s *config.Server, fn string) bool {
+	cmd := exec.Command("scp", "-i", "~/.ssh/lkey", "-o", "StrictHostKeyChecking=no", fn,
+		fmt.Sprintf("ec2-user@%s:~/go/bin/%s", config.Host(s.Address), fn))
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	// log.Printf("Running %v", cmd)
+	err := cmd.Run()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
+
 func KillRemoteServers(servers map[int64]*config.Server, processName string) {
 	done := make(chan bool)
 	for _, s := range servers {
@@ -0,0 +1,424 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/results"
+)
+
+// An experiment specification describes a whole run of the coordinator:
+// the network size and a sequence of phases, each of which is a number of rounds
+
+const (
+	KeyGenPhase    = "keygen"
+	PathPhase      = "path"
+	LightningPhase = "lightning"
+	// move the network to the servers and groups of the next epoch's signed directory
+	EpochPhase = "epoch"
+)
+
+const (
+	// clients that do not submit in a lightning round
+	OmitFault = "omit"
+	// stop a server before the round starts
+	KillFault = "kill"
+)
+
+type Spec struct {
+	Name       string
+	NumServers int
+	NumUsers   int
+	F          float64
+	NumGroups  int
+	GroupSize  int
+	NumLayers  int
+	BinSize    int
+	LimitSize  int
+	// seconds to wait for servers in each round, 0 waits forever
+	RoundTimeout int
+	// name of the cipher suite of every round, the default suite if empty
+	CipherSuite string
+	// agreement of the path keys, dh if empty
+	KeyAgreement string
+	// posts each client may make in an epoch, each with a ticket, 0 for no limit
+	PostingQuota int
+	Notes        string
+	Phases       []Phase
+}
+
+type Phase struct {
+	Type string
+	// defaults to NumLayers for path phases
+	Rounds int
+	// message size of each lightning round, the last size repeats
+	MessageSizes []int
+	// keygen phase: send keys loaded from a file instead of generating them
+	Load    bool
+	NoCheck bool
+	// cipher suite of the rounds in this phase, the spec's suite if empty
+	CipherSuite string
+	// lightning phase: servers commit to each layer and check each other's commitments
+	Verifiable bool
+	// epoch phase: the epoch of the directory to move to, the next epoch if 0
+	Epoch  int
+	Faults []Fault
+}
+
+type Fault struct {
+	// round within the phase
+	Round  int
+	Kind   string
+	Server int64
+	Count  int
+}
+
+// a single round of a spec
+type Step struct {
+	Round       int
+	Phase       int
+	Type        string
+	MessageSize int
+	Suite       crypto.CipherSuite
+	Agreement   crypto.KeyAgreement
+	Verifiable  bool
+	KeyGen      bool
+	Load        bool
+	NoCheck     bool
+	// move to an epoch before the round, Epoch is 0 for the next epoch
+	NewEpoch bool
+	Epoch    int
+	// lightning round that generates paths, as servers have none for this epoch
+	GenPaths bool
+	Faults   []Fault
+}
+
+func LoadSpec(fn string) (*Spec, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("spec %s: %v", fn, err)
+	}
+	return s, nil
+}
+
+// the default experiment: path establishment for every layer then lightning rounds
+func DefaultSpec(numLightning, messageSize int, skipPathGen, load bool) *Spec {
+	s := &Spec{Name: "experiment"}
+	if skipPathGen {
+		s.Phases = append(s.Phases, Phase{Type: KeyGenPhase})
+	} else {
+		s.Phases = append(s.Phases, Phase{Type: KeyGenPhase, Load: load}, Phase{Type: PathPhase})
+	}
+	if numLightning > 0 {
+		s.Phases = append(s.Phases, Phase{Type: LightningPhase, Rounds: numLightning, MessageSizes: []int{messageSize}})
+	}
+	return s
+}
+
+// the rounds of the experiment in order
+func (s *Spec) Steps() ([]*Step, error) {
+	if s.NumServers <= 0 || s.NumUsers <= 0 || s.NumLayers <= 0 {
+		return nil, fmt.Errorf("spec %s: servers, users and layers must be set", s.Name)
+	}
+	agreement := crypto.KeyAgreementDH
+	if s.KeyAgreement != "" {
+		var err error
+		agreement, err = crypto.ParseKeyAgreement(s.KeyAgreement)
+		if err != nil {
+			return nil, fmt.Errorf("spec %s: %v", s.Name, err)
+		}
+		if !agreement.Supported() {
+			return nil, fmt.Errorf("spec %s: %v key agreement is not supported by this build", s.Name, agreement)
+		}
+	}
+	steps := make([]*Step, 0)
+	round := 0
+	keyGen, load, path := false, false, false
+	newEpoch, epoch, lastEpoch := false, 0, 0
+	// paths are established once, servers forget them when the epoch changes
+	genPaths := true
+	for p, phase := range s.Phases {
+		for _, f := range phase.Faults {
+			if err := s.checkFault(phase, f); err != nil {
+				return nil, fmt.Errorf("spec %s phase %d: %v", s.Name, p, err)
+			}
+		}
+		switch phase.Type {
+		case KeyGenPhase:
+			if phase.Rounds != 0 {
+				return nil, fmt.Errorf("spec %s phase %d: keygen has no rounds", s.Name, p)
+			}
+			// keys are sent with the first round that follows
+			keyGen, load = !phase.Load, phase.Load
+			continue
+		case EpochPhase:
+			if phase.Rounds != 0 || len(phase.Faults) > 0 {
+				return nil, fmt.Errorf("spec %s phase %d: epoch has no rounds", s.Name, p)
+			}
+			if newEpoch {
+				return nil, fmt.Errorf("spec %s phase %d: no rounds in the previous epoch", s.Name, p)
+			}
+			if phase.Epoch != 0 && phase.Epoch <= lastEpoch {
+				return nil, fmt.Errorf("spec %s phase %d: epoch %d does not follow epoch %d", s.Name, p, phase.Epoch, lastEpoch)
+			}
+			if phase.Epoch != 0 {
+				lastEpoch = phase.Epoch
+			}
+			// the network moves before the first round that follows
+			newEpoch, epoch, genPaths = true, phase.Epoch, true
+			continue
+		case PathPhase:
+			if path {
+				return nil, fmt.Errorf("spec %s phase %d: paths are already established", s.Name, p)
+			}
+			if phase.Rounds == 0 {
+				phase.Rounds = s.NumLayers
+			}
+			if phase.Rounds != s.NumLayers {
+				return nil, fmt.Errorf("spec %s phase %d: path establishment takes %d rounds", s.Name, p, s.NumLayers)
+			}
+			path, genPaths = true, false
+		case LightningPhase:
+			if phase.Rounds <= 0 {
+				return nil, fmt.Errorf("spec %s phase %d: no rounds", s.Name, p)
+			}
+		default:
+			return nil, fmt.Errorf("spec %s phase %d: unknown type %q", s.Name, p, phase.Type)
+		}
+		suite, err := s.suite(phase)
+		if err != nil {
+			return nil, fmt.Errorf("spec %s phase %d: %v", s.Name, p, err)
+		}
+		if phase.Verifiable && (phase.Type != LightningPhase || suite == crypto.SuiteLegacy) {
+			return nil, fmt.Errorf("spec %s phase %d: only lightning rounds with a suite other than legacy are verifiable", s.Name, p)
+		}
+		if round == 0 && !keyGen && !load {
+			return nil, fmt.Errorf("spec %s: the first round needs a keygen phase", s.Name)
+		}
+		for i := 0; i < phase.Rounds; i++ {
+			step := &Step{
+				Round:      round,
+				Phase:      p,
+				Type:       phase.Type,
+				Suite:      suite,
+				Agreement:  agreement,
+				Verifiable: phase.Verifiable,
+				KeyGen:     keyGen,
+				Load:       load,
+				NoCheck:    phase.NoCheck,
+				NewEpoch:   newEpoch,
+				Epoch:      epoch,
+			}
+			keyGen, load, newEpoch, epoch = false, false, false, 0
+			if phase.Type == LightningPhase {
+				step.GenPaths, genPaths = genPaths, false
+			}
+			if phase.Type == PathPhase {
+				step.MessageSize = 8
+			} else if len(phase.MessageSizes) == 0 {
+				step.MessageSize = 1024
+			} else if i < len(phase.MessageSizes) {
+				step.MessageSize = phase.MessageSizes[i]
+			} else {
+				step.MessageSize = phase.MessageSizes[len(phase.MessageSizes)-1]
+			}
+			for _, f := range phase.Faults {
+				if f.Round == i {
+					step.Faults = append(step.Faults, f)
+				}
+			}
+			steps = append(steps, step)
+			round++
+		}
+	}
+	if keyGen || load {
+		return nil, fmt.Errorf("spec %s: keygen phase without rounds after it", s.Name)
+	}
+	if newEpoch {
+		return nil, fmt.Errorf("spec %s: epoch phase without rounds after it", s.Name)
+	}
+	return steps, nil
+}
+
+func (s *Spec) suite(phase Phase) (crypto.CipherSuite, error) {
+	name := phase.CipherSuite
+	if name == "" {
+		name = s.CipherSuite
+	}
+	if name == "" {
+		return crypto.DefaultSuite, nil
+	}
+	return crypto.ParseCipherSuite(name)
+}
+
+func (s *Spec) checkFault(phase Phase, f Fault) error {
+	switch f.Kind {
+	case OmitFault:
+		if phase.Type != LightningPhase {
+			return fmt.Errorf("clients can only be omitted from lightning rounds")
+		}
+		if f.Count <= 0 || f.Count >= s.NumUsers {
+			return fmt.Errorf("cannot omit %d of %d clients", f.Count, s.NumUsers)
+		}
+	case KillFault:
+		if f.Server < 0 || f.Server >= int64(s.NumServers) {
+			return fmt.Errorf("no server %d", f.Server)
+		}
+	default:
+		return fmt.Errorf("unknown fault %q", f.Kind)
+	}
+	if f.Round < 0 || (phase.Rounds > 0 && f.Round >= phase.Rounds) {
+		return fmt.Errorf("fault in round %d outside of the phase", f.Round)
+	}
+	return nil
+}
+
+func (c *Coordinator) StepExperiment(s *Spec, step *Step) *Experiment {
+	exp := c.NewExperiment(step.Round, s.NumLayers, s.NumServers, s.NumUsers, s.Notes)
+	exp.KeyGen = step.KeyGen
+	exp.LoadKeys = step.Load
+	exp.Info.Check = !step.NoCheck
+	exp.Info.MessageSize = int64(step.MessageSize)
+	exp.Info.CipherSuite = int32(step.Suite)
+	exp.Info.KeyAgreement = int32(step.Agreement)
+	exp.Info.Verifiable = step.Verifiable
+	exp.Info.PostingQuota = int64(s.PostingQuota)
+	if s.BinSize > 0 {
+		exp.Info.BinSize = int64(s.BinSize)
+	}
+	if s.RoundTimeout > 0 {
+		exp.Timeout = time.Duration(s.RoundTimeout) * time.Second
+	}
+	if step.Type == PathPhase {
+		i := step.Round
+		exp.Info.PathEstablishment = true
+		exp.Info.LastLayer = (i == s.NumLayers-1)
+		if s.LimitSize > 0 {
+			exp.Info.BoomerangLimit = int64(s.LimitSize)
+		} else {
+			exp.Info.BoomerangLimit = int64(s.NumLayers)
+		}
+		exp.Info.ReceiptLayer = 0
+		if i-int(exp.Info.BoomerangLimit) > 0 {
+			exp.Info.ReceiptLayer = int64(i) - exp.Info.BoomerangLimit
+		}
+		exp.Info.NextLayer = int64(i)
+	} else {
+		exp.Info.PathEstablishment = false
+		// lightning without path establishment uses generated paths
+		exp.Info.SkipPathGen = step.GenPaths
+	}
+	for _, f := range step.Faults {
+		if f.Kind == OmitFault {
+			exp.NumMessages -= f.Count
+		}
+	}
+	return exp
+}
+
+// create a directory for the results of the experiment and write the spec to it
+func ExperimentDir(outDir string, s *Spec) (string, error) {
+	dir := filepath.Join(outDir, fmt.Sprintf("%s-%s", s.Name, time.Now().Format("20060102-150405")))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return dir, ioutil.WriteFile(filepath.Join(dir, "spec.json"), data, 0644)
+}
+
+// the sizes of the network for the results of each round
+func (s *Spec) Params() results.Params {
+	return results.Params{
+		NumServers: s.NumServers,
+		NumUsers:   s.NumUsers,
+		F:          s.F,
+		NumLayers:  s.NumLayers,
+		NumGroups:  s.NumGroups,
+		GroupSize:  s.GroupSize,
+		BinSize:    s.BinSize,
+		LimitSize:  s.LimitSize,
+		Notes:      s.Notes,
+	}
+}
+
+// run each round of the spec, appending its results to the store in dir
+// record, if set, is also called with every finished round
+func (c *Coordinator) RunSpec(s *Spec, dir string, params results.Params, record func(*results.Record)) error {
+	steps, err := s.Steps()
+	if err != nil {
+		return err
+	}
+	store, err := results.OpenStore(results.StorePath(dir))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	name := filepath.Base(dir)
+	for _, step := range steps {
+		if step.NewEpoch {
+			err = c.nextEpoch(step.Epoch)
+			if err != nil {
+				return fmt.Errorf("round %d: %w", step.Round, err)
+			}
+		}
+		exp := c.StepExperiment(s, step)
+		for _, f := range step.Faults {
+			if f.Kind == KillFault {
+				log.Printf("Round %v: killing server %v", step.Round, f.Server)
+				err = c.Net.Kill(f.Server)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		log.Printf("Round %v", step.Round)
+		err = c.DoAction(exp)
+		if err != nil {
+			exp.Error = err.Error()
+		}
+		r := exp.Record(name, params)
+		if serr := store.Append(r); serr != nil {
+			return serr
+		}
+		if record != nil {
+			record(r)
+		}
+		if err != nil {
+			return fmt.Errorf("round %d: %w", step.Round, err)
+		}
+		log.Printf("%s round %v took %v", step.Type, step.Round, time.Since(exp.ExperimentStartTime))
+	}
+	return nil
+}
+
+// fetch the signed directory of an epoch, the next one if 0, and move the network to it
+func (c *Coordinator) nextEpoch(epoch int) error {
+	if c.Directory == nil {
+		return fmt.Errorf("no directory to move to a new epoch, set %s", config.DirectoryEnv)
+	}
+	if epoch == 0 {
+		epoch = c.Net.Epoch + 1
+	}
+	log.Printf("Moving to epoch %v", epoch)
+	d, err := c.Directory.FetchEpoch(epoch)
+	if err != nil {
+		return err
+	}
+	return c.Reconfigure(d.Epoch, d.Servers, d.Groups)
+}
@@ -0,0 +1,258 @@
+package coordinator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto"
+)
+
+func testSpec() *Spec {
+	return &Spec{
+		Name:       "test",
+		NumServers: 10,
+		NumUsers:   100,
+		NumGroups:  3,
+		GroupSize:  3,
+		NumLayers:  4,
+		Phases: []Phase{
+			{Type: KeyGenPhase},
+			{Type: PathPhase},
+			{
+				Type:         LightningPhase,
+				Rounds:       3,
+				MessageSizes: []int{256, 512},
+				Faults:       []Fault{{Round: 1, Kind: OmitFault, Count: 10}},
+			},
+		},
+	}
+}
+
+func TestSpecSteps(t *testing.T) {
+	s := testSpec()
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 7 {
+		t.Fatalf("expected 7 rounds, got %d", len(steps))
+	}
+	for i, step := range steps {
+		if step.Round != i {
+			t.Fatalf("step %d is round %d", i, step.Round)
+		}
+		if step.KeyGen != (i == 0) {
+			t.Fatalf("keygen in round %d", i)
+		}
+	}
+	sizes := []int{8, 8, 8, 8, 256, 512, 512}
+	for i, step := range steps {
+		if step.MessageSize != sizes[i] {
+			t.Fatalf("round %d: message size %d, expected %d", i, step.MessageSize, sizes[i])
+		}
+	}
+	if len(steps[5].Faults) != 1 || len(steps[4].Faults) != 0 || len(steps[6].Faults) != 0 {
+		t.Fatal("fault in the wrong round")
+	}
+
+	c := &Coordinator{}
+	exp := c.StepExperiment(s, steps[3])
+	if !exp.Info.PathEstablishment || !exp.Info.LastLayer || exp.Info.NextLayer != 3 {
+		t.Fatalf("wrong path round %+v", exp.Info)
+	}
+	exp = c.StepExperiment(s, steps[5])
+	if exp.Info.PathEstablishment || exp.Info.SkipPathGen || exp.Info.MessageSize != 512 {
+		t.Fatalf("wrong lightning round %+v", exp.Info)
+	}
+	if exp.NumMessages != 90 {
+		t.Fatalf("omitted clients still expected: %d", exp.NumMessages)
+	}
+	if exp.Info.CipherSuite != int32(crypto.DefaultSuite) {
+		t.Fatalf("wrong default suite %d", exp.Info.CipherSuite)
+	}
+}
+
+func TestSpecCipherSuite(t *testing.T) {
+	s := testSpec()
+	s.CipherSuite = "chacha20"
+	s.Phases[2].CipherSuite = "chacha20-poly1305"
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Suite != crypto.SuiteChaCha20 || steps[6].Suite != crypto.SuiteChaCha20Poly1305 {
+		t.Fatalf("wrong suites %v %v", steps[0].Suite, steps[6].Suite)
+	}
+	exp := (&Coordinator{}).StepExperiment(s, steps[6])
+	if crypto.CipherSuite(exp.Info.CipherSuite) != crypto.SuiteChaCha20Poly1305 {
+		t.Fatal("suite not set in the round")
+	}
+	s.Phases[1].CipherSuite = "rot13"
+	if _, err := s.Steps(); err == nil {
+		t.Fatal("accepted an unknown suite")
+	}
+}
+
+func TestSpecKeyAgreement(t *testing.T) {
+	s := testSpec()
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Agreement != crypto.KeyAgreementDH {
+		t.Fatal("dh is the default key agreement")
+	}
+	s.KeyAgreement = "hybrid"
+	steps, err = s.Steps()
+	if !crypto.KEMSupported {
+		if err == nil {
+			t.Fatal("accepted hybrid without ML-KEM")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		exp := (&Coordinator{}).StepExperiment(s, step)
+		if crypto.KeyAgreement(exp.Info.KeyAgreement) != crypto.KeyAgreementHybrid {
+			t.Fatalf("round %d: key agreement not set", step.Round)
+		}
+	}
+	s.KeyAgreement = "rsa"
+	if _, err := s.Steps(); err == nil {
+		t.Fatal("accepted an unknown key agreement")
+	}
+}
+
+func TestSpecVerifiable(t *testing.T) {
+	s := testSpec()
+	s.Phases[2].Verifiable = true
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[3].Verifiable || !steps[6].Verifiable {
+		t.Fatal("verifiable set in the wrong rounds")
+	}
+	if !(&Coordinator{}).StepExperiment(s, steps[6]).Info.Verifiable {
+		t.Fatal("verifiable not set in the round")
+	}
+	s.Phases[2].CipherSuite = "legacy"
+	if _, err := s.Steps(); err == nil {
+		t.Fatal("accepted verifiable legacy rounds")
+	}
+	s.Phases[2].CipherSuite = ""
+	s.Phases[1].Verifiable = true
+	if _, err := s.Steps(); err == nil {
+		t.Fatal("accepted verifiable path rounds")
+	}
+}
+
+func TestSpecDefault(t *testing.T) {
+	s := DefaultSpec(5, 1024, true, false)
+	s.NumServers, s.NumUsers, s.NumLayers = 10, 100, 4
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 5 || !steps[0].KeyGen || steps[0].Type != LightningPhase {
+		t.Fatalf("wrong rounds %+v", steps[0])
+	}
+	exp := (&Coordinator{}).StepExperiment(s, steps[0])
+	if !exp.Info.SkipPathGen || !exp.KeyGen {
+		t.Fatal("first lightning round should generate paths")
+	}
+}
+
+func TestSpecEpoch(t *testing.T) {
+	s := testSpec()
+	s.Phases = append(s.Phases, Phase{Type: EpochPhase}, Phase{Type: LightningPhase, Rounds: 2})
+	steps, err := s.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 9 {
+		t.Fatalf("expected 9 rounds, got %d", len(steps))
+	}
+	for i, step := range steps {
+		if step.NewEpoch != (i == 7) {
+			t.Fatalf("new epoch in round %d", i)
+		}
+		// the paths of the first epoch are gone in the next one
+		if step.GenPaths != (i == 7) {
+			t.Fatalf("generated paths in round %d", i)
+		}
+	}
+	if !(&Coordinator{}).StepExperiment(s, steps[7]).Info.SkipPathGen {
+		t.Fatal("first round of the epoch should generate paths")
+	}
+	if err := (&Coordinator{Net: &CoordinatorNetwork{}}).nextEpoch(0); err == nil {
+		t.Fatal("moved to an epoch without a directory")
+	}
+}
+
+func TestSpecInvalid(t *testing.T) {
+	invalid := map[string]func(s *Spec){
+		"no keygen":          func(s *Spec) { s.Phases = s.Phases[1:] },
+		"unknown phase":      func(s *Spec) { s.Phases[1].Type = "shuffle" },
+		"short path":         func(s *Spec) { s.Phases[1].Rounds = 2 },
+		"two paths":          func(s *Spec) { s.Phases = append(s.Phases, Phase{Type: PathPhase}) },
+		"trailing keygen":    func(s *Spec) { s.Phases = append(s.Phases, Phase{Type: KeyGenPhase}) },
+		"omit in path":       func(s *Spec) { s.Phases[1].Faults = []Fault{{Kind: OmitFault, Count: 1}} },
+		"omit everyone":      func(s *Spec) { s.Phases[2].Faults[0].Count = 100 },
+		"unknown server":     func(s *Spec) { s.Phases[2].Faults[0] = Fault{Kind: KillFault, Server: 10} },
+		"fault after rounds": func(s *Spec) { s.Phases[2].Faults[0].Round = 3 },
+		"unknown fault":      func(s *Spec) { s.Phases[2].Faults[0].Kind = "partition" },
+		"no users":           func(s *Spec) { s.NumUsers = 0 },
+		"trailing epoch":     func(s *Spec) { s.Phases = append(s.Phases, Phase{Type: EpochPhase}) },
+		"epoch with rounds":  func(s *Spec) { s.Phases = append(s.Phases[:2], Phase{Type: EpochPhase, Rounds: 1}, s.Phases[2]) },
+		"epochs out of order": func(s *Spec) {
+			s.Phases = append(s.Phases, Phase{Type: EpochPhase, Epoch: 3}, Phase{Type: LightningPhase, Rounds: 1},
+				Phase{Type: EpochPhase, Epoch: 2}, Phase{Type: LightningPhase, Rounds: 1})
+		},
+	}
+	for name, change := range invalid {
+		s := testSpec()
+		change(s)
+		if _, err := s.Steps(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestExperimentDir(t *testing.T) {
+	s := testSpec()
+	dir, err := ExperimentDir(t.TempDir(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	specFile := filepath.Join(dir, "spec.json")
+	if _, err := ioutil.ReadFile(specFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSpec(specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Name != s.Name || len(loaded.Phases) != len(s.Phases) || loaded.Phases[2].Faults[0].Count != 10 {
+		t.Fatalf("spec changed: %+v", loaded)
+	}
+}
+
+func TestSpecExamples(t *testing.T) {
+	files, err := filepath.Glob("../cmd/coordinator/specs/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no example specs", err)
+	}
+	for _, fn := range files {
+		s, err := LoadSpec(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Steps(); err != nil {
+			t.Errorf("%s: %v", fn, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/simonlangowski/lightning1/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A key agreement fixes how the client and a server on its path agree on the shared key of a layer
+// Hybrid rounds combine the DH shared key with an ML-KEM-768 encapsulation to the server,
+// so the layer keys stay secret if either problem is hard
+
+type KeyAgreement int32
+
+const (
+	// edwards25519 DH between the client's layer key and the server's public key
+	KeyAgreementDH KeyAgreement = 0
+	// DH combined with ML-KEM-768
+	KeyAgreementHybrid KeyAgreement = 1
+)
+
+// ML-KEM-768 sizes
+const KEM_PUBLIC_KEY_SIZE = 1184
+const KEM_SEED_SIZE = 64
+const KEM_CIPHERTEXT_SIZE = 1088
+const KEM_SHARED_SIZE = 32
+
+var keyAgreementNames = []string{"dh", "hybrid"}
+
+var hybridSalt = []byte("lightning hybrid layer key v1")
+
+func ParseKeyAgreement(name string) (KeyAgreement, error) {
+	for id, n := range keyAgreementNames {
+		if n == name {
+			return KeyAgreement(id), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key agreement %q", name)
+}
+
+func (a KeyAgreement) String() string {
+	if a < 0 || int(a) >= len(keyAgreementNames) {
+		return fmt.Sprintf("keyagreement(%d)", int32(a))
+	}
+	return keyAgreementNames[a]
+}
+
+// hybrid rounds need ML-KEM from the standard library
+func (a KeyAgreement) Supported() bool {
+	switch a {
+	case KeyAgreementDH:
+		return true
+	case KeyAgreementHybrid:
+		return KEMSupported
+	default:
+		return false
+	}
+}
+
+// bytes of kem ciphertext sent for each shared key
+func (a KeyAgreement) CiphertextSize() int {
+	if a == KeyAgreementHybrid {
+		return KEM_CIPHERTEXT_SIZE
+	}
+	return 0
+}
+
+type KEMPublicKey []byte
+
+// the seed of a decapsulation key, expanded once when it is loaded
+type KEMPrivateKey struct {
+	Seed []byte
+	dk   decapsulator
+}
+
+type decapsulator interface {
+	Decapsulate(ciphertext []byte) ([]byte, error)
+}
+
+func (k *KEMPrivateKey) InterpretFrom(b []byte) error {
+	if len(b) != KEM_SEED_SIZE {
+		return errors.LengthInvalidError()
+	}
+	k.Seed = append([]byte{}, b...)
+	dk, err := newDecapsulationKey(k.Seed)
+	if err != nil {
+		return err
+	}
+	k.dk = dk
+	return nil
+}
+
+func (k *KEMPrivateKey) Decapsulate(ciphertext []byte) ([]byte, error) {
+	if k.dk == nil {
+		return nil, errors.UnimplementedError()
+	}
+	if len(ciphertext) != KEM_CIPHERTEXT_SIZE {
+		return nil, errors.LengthInvalidError()
+	}
+	return k.dk.Decapsulate(ciphertext)
+}
+
+// combine the DH shared key with the kem shared secret
+// the kem ciphertext is bound into the key, as in X-Wing
+func HybridSharedKey(dh DHSharedKey, kemShared, ciphertext []byte) DHSharedKey {
+	ikm := make([]byte, 0, len(dh)+len(kemShared)+len(ciphertext))
+	ikm = append(ikm, dh...)
+	ikm = append(ikm, kemShared...)
+	ikm = append(ikm, ciphertext...)
+	key := make([]byte, POINT_SIZE)
+	_, err := io.ReadFull(hkdf.New(sha256.New, ikm, hybridSalt, nil), key)
+	if err != nil {
+		panic("Could not derive key")
+	}
+	return DHSharedKey(key)
+}
+
+// encapsulate to a server and combine with the DH shared key, returns the key and the kem ciphertext
+func (pk KEMPublicKey) HybridEncapsulate(dh DHSharedKey) (DHSharedKey, []byte, error) {
+	kemShared, ciphertext, err := pk.Encapsulate()
+	if err != nil {
+		return nil, nil, err
+	}
+	return HybridSharedKey(dh, kemShared, ciphertext), ciphertext, nil
+}
+
+// the server side of HybridEncapsulate
+func (k *KEMPrivateKey) HybridDecapsulate(dh DHSharedKey, ciphertext []byte) (DHSharedKey, error) {
+	kemShared, err := k.Decapsulate(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return HybridSharedKey(dh, kemShared, ciphertext), nil
+}
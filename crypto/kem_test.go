@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHybridKeyAgreement(t *testing.T) {
+	if !KEMSupported {
+		t.Skip("ML-KEM needs go 1.24")
+	}
+	serverKem, serverKemPublic, err := NewKEMKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serverKemPublic) != KEM_PUBLIC_KEY_SIZE || len(serverKem.Seed) != KEM_SEED_SIZE {
+		t.Fatal("wrong key sizes")
+	}
+	serverSecret, serverPublic := NewDHKeyPair()
+	clientSecret, clientPublic := NewDHKeyPair()
+
+	// client side
+	clientKey, ciphertext, err := serverKemPublic.HybridEncapsulate(clientSecret.SharedKey(&serverPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != KeyAgreementHybrid.CiphertextSize() || len(clientKey) != POINT_SIZE {
+		t.Fatal("wrong ciphertext size")
+	}
+	// server side, after loading its key from the seed
+	loaded := &KEMPrivateKey{}
+	if err := loaded.InterpretFrom(serverKem.Seed); err != nil {
+		t.Fatal(err)
+	}
+	dh := serverSecret.SharedKey(&clientPublic)
+	serverKey, err := loaded.HybridDecapsulate(dh, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientKey, serverKey) {
+		t.Fatal("client and server keys differ")
+	}
+	if bytes.Equal(serverKey, dh) {
+		t.Fatal("hybrid key is the DH key")
+	}
+
+	// the ciphertext is bound to the key
+	ciphertext[0] ^= 1
+	modified, err := loaded.HybridDecapsulate(dh, ciphertext)
+	if err == nil && bytes.Equal(modified, serverKey) {
+		t.Fatal("modified ciphertext gives the same key")
+	}
+	if _, err := loaded.HybridDecapsulate(dh, ciphertext[:10]); err == nil {
+		t.Fatal("decapsulated a short ciphertext")
+	}
+
+	// the key works with every suite
+	_, k := NewSigningKeyPair()
+	nonce := Nonce(0, 1, 2)
+	for _, suite := range allSuites {
+		box := suite.SignedSecretSeal([]byte("hybrid"), &nonce, clientKey, k)
+		m, err := suite.SecretOpen(box, &nonce, serverKey)
+		if err != nil || string(m) != "hybrid" {
+			t.Fatalf("%v: could not open %v", suite, err)
+		}
+	}
+}
+
+func TestParseKeyAgreement(t *testing.T) {
+	for _, a := range []KeyAgreement{KeyAgreementDH, KeyAgreementHybrid} {
+		parsed, err := ParseKeyAgreement(a.String())
+		if err != nil || parsed != a {
+			t.Fatalf("%v: parsed as %v %v", a, parsed, err)
+		}
+	}
+	if _, err := ParseKeyAgreement("rsa"); err == nil {
+		t.Fatal("parsed an unknown key agreement")
+	}
+	if KeyAgreement(2).Supported() || KeyAgreementDH.CiphertextSize() != 0 {
+		t.Fatal("wrong key agreement properties")
+	}
+	if KeyAgreementHybrid.Supported() != KEMSupported {
+		t.Fatal("hybrid support does not follow ML-KEM")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build go1.24
+// +build go1.24
+
+package crypto
+
+import (
+	"crypto/mlkem"
+
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+const KEMSupported = true
+
+func NewKEMKeyPair() (*KEMPrivateKey, KEMPublicKey, error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &KEMPrivateKey{Seed: dk.Bytes(), dk: dk}, KEMPublicKey(dk.EncapsulationKey().Bytes()), nil
+}
+
+func newDecapsulationKey(seed []byte) (*mlkem.DecapsulationKey768, error) {
+	return mlkem.NewDecapsulationKey768(seed)
+}
+
+// returns the shared secret and the ciphertext to send to the owner of the key
+func (pk KEMPublicKey) Encapsulate() ([]byte, []byte, error) {
+	if len(pk) != KEM_PUBLIC_KEY_SIZE {
+		return nil, nil, errors.LengthInvalidError()
+	}
+	ek, err := mlkem.NewEncapsulationKey768(pk)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, ciphertext := ek.Encapsulate()
+	return shared, ciphertext, nil
+}
@@ -0,0 +1,23 @@
+//go:build !go1.24
+// +build !go1.24
+
+package crypto
+
+import (
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+// ML-KEM is in the standard library from go 1.24, older toolchains only run DH rounds
+const KEMSupported = false
+
+func NewKEMKeyPair() (*KEMPrivateKey, KEMPublicKey, error) {
+	return nil, nil, errors.UnimplementedError()
+}
+
+func newDecapsulationKey(seed []byte) (decapsulator, error) {
+	return nil, errors.UnimplementedError()
+}
+
+func (pk KEMPublicKey) Encapsulate() ([]byte, []byte, error) {
+	return nil, nil, errors.UnimplementedError()
+}
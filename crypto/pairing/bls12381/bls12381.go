@@ -0,0 +1,56 @@
+// Package bls12381 is a pure Go BLS12-381 backend with the subset of the mcl
+// API used by this module, built on circl. Points and scalars use the ZCash
+// compressed encoding and hashing to G1 and G2 follows RFC 9380, so with mcl
+// in ETH serialization and IRTF map-to mode both backends agree byte for byte.
+package bls12381
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	circl "github.com/cloudflare/circl/ecc/bls12381"
+)
+
+const FR_LEN = circl.ScalarSize
+const G1_LEN = circl.G1SizeCompressed
+const G2_LEN = circl.G2SizeCompressed
+
+// domain separation tags for HashAndMapTo, set once at startup like mcl's
+var dstG1 = []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_")
+var dstG2 = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+
+// SetDstG1 --
+// not thread safe, call before hashing
+func SetDstG1(s string) error {
+	if len(s) == 0 || len(s) > 255 {
+		return fmt.Errorf("SetDstG1 bad length %d", len(s))
+	}
+	dstG1 = []byte(s)
+	return nil
+}
+
+// SetDstG2 --
+// not thread safe, call before hashing
+func SetDstG2(s string) error {
+	if len(s) == 0 || len(s) > 255 {
+		return fmt.Errorf("SetDstG2 bad length %d", len(s))
+	}
+	dstG2 = []byte(s)
+	return nil
+}
+
+// GetCurveOrder --
+// return the order of G1 in base 10, as mcl does
+func GetCurveOrder() string {
+	return new(big.Int).SetBytes(circl.Order()).String()
+}
+
+// randomBytes is used to pick random points by hashing
+func randomBytes() []byte {
+	b := make([]byte, 2*FR_LEN)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("Error reading randomness: %v\n", err))
+	}
+	return b
+}
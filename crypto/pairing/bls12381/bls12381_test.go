@@ -0,0 +1,151 @@
+package bls12381
+
+import (
+	"bytes"
+	"testing"
+)
+
+// RFC 9380 appendix J.9.1 and J.10.1
+func TestHashToCurveVectors(t *testing.T) {
+	defer SetDstG1(string(dstG1))
+	defer SetDstG2(string(dstG2))
+	SetDstG1("QUUX-V01-CS02-with-BLS12381G1_XMD:SHA-256_SSWU_RO_")
+	SetDstG2("QUUX-V01-CS02-with-BLS12381G2_XMD:SHA-256_SSWU_RO_")
+
+	g1Vectors := map[string]string{
+		"":    "1 52926add2207b76ca4fa57a8734416c8dc95e24501772c814278700eed6d1e4e8cf62d9c09db0fac349612b759e79a1 8ba738453bfed09cb546dbb0783dbb3a5f1f566ed67bb6be0e8c67e2e81a4cc68ee29813bb7994998f3eae0c9c6a265",
+		"abc": "1 3567bc5ef9c690c2ab2ecdf6a96ef1c139cc0b2f284dca0a9a7943388a49a3aee664ba5379a7655d3c68900be2f6903 b9c15f3fe6e5cf4211f346271d7b01c8f3b28be689c8429c85b67af215533311f0b8dfaaa154fa6b88176c229f2885d",
+	}
+	for msg, expected := range g1Vectors {
+		var p G1
+		p.HashAndMapTo([]byte(msg))
+		if s := p.GetString(16); s != expected {
+			t.Fatalf("G1 %q: %s", msg, s)
+		}
+	}
+
+	var q G2
+	q.HashAndMapTo(nil)
+	expected := "1 141ebfbdca40eb85b87142e130ab689c673cf60f1a3e98d69335266f30d9b8d4ac44c1038e9dcdd5393faf5c41fb78a 5cb8437535e20ecffaef7752baddf98034139c38452458baeefab379ba13dff5bf5dd71b72418717047f5b0f37da03d 503921d7f6a12805e72940b963c0cf3471c7b2a524950ca195d11062ee75ec076daf2d4bc358c4b190c0c98064fdd92 12424ac32561493f3fe3c260708a12b7c620e7be00099a974e259ddc7d1f6395c3c811cdd19f1e8dbf3e9ecfdcbab8d6"
+	if s := q.GetString(16); s != expected {
+		t.Fatalf("G2: %s", s)
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var zero, p, sum G1
+	if !zero.IsZero() || !zero.IsValidOrder() || zero.GetString(10) != "0" {
+		t.Fatal("zero value is not the identity")
+	}
+	infinity := make([]byte, G1_LEN)
+	infinity[0] = 0xc0
+	if !bytes.Equal(zero.Serialize(), infinity) {
+		t.Fatalf("%x", zero.Serialize())
+	}
+	p.Random()
+	G1Add(&sum, &zero, &p)
+	if !sum.IsEqual(&p) {
+		t.Fatal("identity is not neutral")
+	}
+
+	var fr Fr
+	if !fr.IsZero() {
+		t.Fatal("zero scalar")
+	}
+}
+
+func TestScalars(t *testing.T) {
+	var a, b, c Fr
+	a.Random()
+	b.SetInt64(-1)
+	FrAdd(&c, &b, &a)
+	FrSub(&c, &c, &a)
+	FrNeg(&c, &c)
+	if !c.IsOne() {
+		t.Fatalf("-(-1 + a - a) = %s", c.GetString(10))
+	}
+	FrDiv(&c, &a, &a)
+	if !c.IsOne() {
+		t.Fatal("a / a")
+	}
+	FrInv(&c, &a)
+	FrMul(&c, &c, &a)
+	if !c.IsOne() {
+		t.Fatal("a^-1 a")
+	}
+
+	// 2^256 reduces to doubling one 256 times
+	var r Fr
+	b.SetBigEndianMod(append([]byte{1}, make([]byte, FR_LEN)...))
+	r.SetInt64(1)
+	for i := 0; i < 8*FR_LEN; i++ {
+		FrAdd(&r, &r, &r)
+	}
+	if !b.IsEqual(&r) {
+		t.Fatal("SetBigEndianMod")
+	}
+}
+
+func TestMarshalling(t *testing.T) {
+	var a, a2 Fr
+	var p, p2 G1
+	var q, q2 G2
+	a.Random()
+	p.Random()
+	q.Random()
+	b := make([]byte, a.Len())
+	a.PackTo(b)
+	if err := a2.InterpretFrom(b); err != nil || !a.IsEqual(&a2) {
+		t.Fatal(err)
+	}
+	b = make([]byte, p.Len())
+	p.PackTo(b)
+	if err := p2.InterpretFrom(b); err != nil || !p.IsEqual(&p2) {
+		t.Fatal(err)
+	}
+	b = make([]byte, q.Len())
+	q.PackTo(b)
+	if err := q2.InterpretFrom(b); err != nil || !q.IsEqual(&q2) {
+		t.Fatal(err)
+	}
+
+	if p2.InterpretFrom(b[:G1_LEN-1]) == nil {
+		t.Fatal("accepted a short point")
+	}
+	bad := make([]byte, G1_LEN)
+	bad[0] = 0x80
+	bad[G1_LEN-1] = 5
+	if p2.InterpretFrom(bad) == nil {
+		t.Fatal("accepted a point not in G1")
+	}
+}
+
+func TestPairing(t *testing.T) {
+	var p, ap G1
+	var q, aq, negQ G2
+	var a Fr
+	var e1, e2 GT
+	p.Random()
+	G2Generator(&q)
+	a.Random()
+	G1Mul(&ap, &p, &a)
+	G2Mul(&aq, &q, &a)
+
+	Pairing(&e1, &ap, &q)
+	Pairing(&e2, &p, &aq)
+	if !e1.IsEqual(&e2) {
+		t.Fatal("bilinearity")
+	}
+	PrecomputedPairing(&e2, &ap, NewPrecomputed(&q))
+	if !e1.IsEqual(&e2) {
+		t.Fatal("precomputed pairing")
+	}
+
+	G2Neg(&negQ, &q)
+	if !PairingCheck(&ap, NewPrecomputed(&negQ), &p, NewPrecomputed(&aq)) {
+		t.Fatal("pairing check")
+	}
+	if PairingCheck(&p, NewPrecomputed(&negQ), &p, NewPrecomputed(&aq)) {
+		t.Fatal("pairing check accepted a bad signature")
+	}
+}
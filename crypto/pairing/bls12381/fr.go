@@ -0,0 +1,118 @@
+package bls12381
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	circl "github.com/cloudflare/circl/ecc/bls12381"
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+// Fr is an element of the scalar field, the zero value is 0
+type Fr struct {
+	s circl.Scalar
+}
+
+// Clear --
+func (x *Fr) Clear() {
+	x.s = circl.Scalar{}
+}
+
+// SetInt64 --
+func (x *Fr) SetInt64(v int64) {
+	if v >= 0 {
+		x.s.SetUint64(uint64(v))
+		return
+	}
+	x.s.SetUint64(uint64(-v))
+	x.s.Neg()
+}
+
+// Random --
+func (x *Fr) Random() {
+	if err := x.s.Random(rand.Reader); err != nil {
+		panic(err)
+	}
+}
+
+// IsEqual --
+func (x *Fr) IsEqual(rhs *Fr) bool {
+	return x.s.IsEqual(&rhs.s) == 1
+}
+
+// IsZero --
+func (x *Fr) IsZero() bool {
+	return x.s.IsZero() == 1
+}
+
+// IsOne --
+func (x *Fr) IsOne() bool {
+	var one circl.Scalar
+	one.SetOne()
+	return x.s.IsEqual(&one) == 1
+}
+
+// IsValid --
+// scalars are always reduced
+func (x *Fr) IsValid() bool {
+	return true
+}
+
+// SetBigEndianMod --
+func (x *Fr) SetBigEndianMod(buf []byte) error {
+	x.s.SetBytes(buf)
+	return nil
+}
+
+// GetString --
+func (x *Fr) GetString(base int) string {
+	return new(big.Int).SetBytes(x.Serialize()).Text(base)
+}
+
+// Serialize --
+// big endian, as mcl with ETH serialization
+func (x *Fr) Serialize() []byte {
+	b, _ := x.s.MarshalBinary()
+	return b
+}
+
+// Deserialize --
+func (x *Fr) Deserialize(buf []byte) error {
+	if len(buf) != FR_LEN {
+		return errors.LengthInvalidError()
+	}
+	return x.s.UnmarshalBinary(buf)
+}
+
+// FrNeg --
+func FrNeg(out *Fr, x *Fr) {
+	out.s = x.s
+	out.s.Neg()
+}
+
+// FrInv --
+func FrInv(out *Fr, x *Fr) {
+	out.s.Inv(&x.s)
+}
+
+// FrAdd --
+func FrAdd(out *Fr, x *Fr, y *Fr) {
+	out.s.Add(&x.s, &y.s)
+}
+
+// FrSub --
+func FrSub(out *Fr, x *Fr, y *Fr) {
+	out.s.Sub(&x.s, &y.s)
+}
+
+// FrMul --
+func FrMul(out *Fr, x *Fr, y *Fr) {
+	out.s.Mul(&x.s, &y.s)
+}
+
+// FrDiv --
+func FrDiv(out *Fr, x *Fr, y *Fr) {
+	var inv circl.Scalar
+	inv.Inv(&y.s)
+	out.s.Mul(&x.s, &inv)
+}
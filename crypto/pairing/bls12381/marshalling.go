@@ -0,0 +1,66 @@
+package bls12381
+
+import "github.com/simonlangowski/lightning1/errors"
+
+func (f *Fr) Len() int {
+	return FR_LEN
+}
+
+func (f *Fr) PackTo(b []byte) {
+	if len(b) != f.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	copy(b[:], f.Serialize())
+}
+
+func (f *Fr) InterpretFrom(b []byte) error {
+	if len(b) != f.Len() {
+		return errors.LengthInvalidError()
+	}
+	if f.Deserialize(b) != nil {
+		return errors.BadElementError()
+	}
+	return nil
+}
+
+func (f *G1) Len() int {
+	return G1_LEN
+}
+
+func (f *G1) PackTo(b []byte) {
+	if len(b) != f.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	copy(b[:], f.Serialize())
+}
+
+func (f *G1) InterpretFrom(b []byte) error {
+	if len(b) != f.Len() {
+		return errors.LengthInvalidError()
+	}
+	if f.Deserialize(b) != nil {
+		return errors.BadElementError()
+	}
+	return nil
+}
+
+func (f *G2) Len() int {
+	return G2_LEN
+}
+
+func (f *G2) PackTo(b []byte) {
+	if len(b) != f.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	copy(b[:], f.Serialize())
+}
+
+func (f *G2) InterpretFrom(b []byte) error {
+	if len(b) != f.Len() {
+		return errors.LengthInvalidError()
+	}
+	if f.Deserialize(b) != nil {
+		return errors.BadElementError()
+	}
+	return nil
+}
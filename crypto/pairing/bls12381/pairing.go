@@ -0,0 +1,56 @@
+package bls12381
+
+import (
+	circl "github.com/cloudflare/circl/ecc/bls12381"
+)
+
+// GT is an element of the target group
+type GT struct {
+	g circl.Gt
+}
+
+// IsEqual --
+func (x *GT) IsEqual(rhs *GT) bool {
+	return x.g.IsEqual(&rhs.g)
+}
+
+// IsOne --
+func (x *GT) IsOne() bool {
+	return x.g.IsIdentity()
+}
+
+// GTMul --
+func GTMul(out *GT, x *GT, y *GT) {
+	out.g.Mul(&x.g, &y.g)
+}
+
+// Pairing --
+func Pairing(out *GT, x *G1, y *G2) {
+	p, q := x.point(), y.point()
+	out.g = *circl.Pair(&p, &q)
+}
+
+// Precomputed holds a G2 argument that is paired with many G1 points.
+// circl has no line precomputation, so this only saves the conversions.
+type Precomputed struct {
+	q circl.G2
+}
+
+// NewPrecomputed --
+func NewPrecomputed(q *G2) *Precomputed {
+	return &Precomputed{q: q.point()}
+}
+
+// PrecomputedPairing --
+func PrecomputedPairing(out *GT, x *G1, q *Precomputed) {
+	p, q2 := x.point(), q.q
+	out.g = *circl.Pair(&p, &q2)
+}
+
+// PairingCheck returns whether e(x1, q1) * e(x2, q2) = 1 with one final exponentiation
+func PairingCheck(x1 *G1, q1 *Precomputed, x2 *G1, q2 *Precomputed) bool {
+	p1, p2 := x1.point(), x2.point()
+	a, b := q1.q, q2.q
+	e := circl.ProdPairFrac([]*circl.G1{&p1, &p2}, []*circl.G2{&a, &b}, []int{1, 1})
+	return e.IsIdentity()
+}
@@ -0,0 +1,237 @@
+package bls12381
+
+import (
+	"math/big"
+	"strings"
+
+	circl "github.com/cloudflare/circl/ecc/bls12381"
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+// G1 is a point on the curve, the zero value is the identity as in mcl
+type G1 struct {
+	p circl.G1
+}
+
+// G2 is a point on the twist, the zero value is the identity as in mcl
+type G2 struct {
+	p circl.G2
+}
+
+var g1Identity, g2Identity = func() (circl.G1, circl.G2) {
+	var p circl.G1
+	var q circl.G2
+	p.SetIdentity()
+	q.SetIdentity()
+	return p, q
+}()
+
+// point returns a copy, since circl converts its arguments to affine in place
+func (x *G1) point() circl.G1 {
+	if x.p == (circl.G1{}) {
+		return g1Identity
+	}
+	return x.p
+}
+
+func (x *G2) point() circl.G2 {
+	if x.p == (circl.G2{}) {
+		return g2Identity
+	}
+	return x.p
+}
+
+// affineString formats uncompressed coordinates like mcl's getStr
+func affineString(b []byte, base int) string {
+	if b[0]&0x40 != 0 {
+		return "0"
+	}
+	b[0] &= 0x1f
+	const n = 48
+	coords := make([]string, 0, len(b)/n)
+	for i := 0; i < len(b); i += n {
+		coords = append(coords, new(big.Int).SetBytes(b[i:i+n]).Text(base))
+	}
+	if len(coords) == 4 {
+		// ZCash order is c1 then c0, mcl prints c0 first
+		coords[0], coords[1], coords[2], coords[3] = coords[1], coords[0], coords[3], coords[2]
+	}
+	return "1 " + strings.Join(coords, " ")
+}
+
+// Clear --
+func (x *G1) Clear() {
+	x.p.SetIdentity()
+}
+
+// Random --
+func (x *G1) Random() {
+	x.HashAndMapTo(randomBytes())
+}
+
+// IsEqual --
+func (x *G1) IsEqual(rhs *G1) bool {
+	p, q := x.point(), rhs.point()
+	return p.IsEqual(&q)
+}
+
+// IsZero --
+func (x *G1) IsZero() bool {
+	p := x.point()
+	return p.IsIdentity()
+}
+
+// IsValid --
+func (x *G1) IsValid() bool {
+	p := x.point()
+	return p.IsOnG1()
+}
+
+// IsValidOrder --
+func (x *G1) IsValidOrder() bool {
+	p := x.point()
+	return p.IsOnG1()
+}
+
+// HashAndMapTo --
+func (x *G1) HashAndMapTo(buf []byte) error {
+	x.p.Hash(buf, dstG1)
+	return nil
+}
+
+// GetString --
+func (x *G1) GetString(base int) string {
+	p := x.point()
+	return affineString(p.Bytes(), base)
+}
+
+// Serialize --
+func (x *G1) Serialize() []byte {
+	p := x.point()
+	return p.BytesCompressed()
+}
+
+// Deserialize --
+// also checks the point is in G1
+func (x *G1) Deserialize(buf []byte) error {
+	if len(buf) != G1_LEN {
+		return errors.LengthInvalidError()
+	}
+	return x.p.SetBytes(buf)
+}
+
+// G1Neg --
+func G1Neg(out *G1, x *G1) {
+	out.p = x.point()
+	out.p.Neg()
+}
+
+// G1Add --
+func G1Add(out *G1, x *G1, y *G1) {
+	p, q := x.point(), y.point()
+	out.p.Add(&p, &q)
+}
+
+// G1Sub --
+func G1Sub(out *G1, x *G1, y *G1) {
+	p, q := x.point(), y.point()
+	q.Neg()
+	out.p.Add(&p, &q)
+}
+
+// G1Mul --
+func G1Mul(out *G1, x *G1, y *Fr) {
+	p := x.point()
+	out.p.ScalarMult(&y.s, &p)
+}
+
+// Clear --
+func (x *G2) Clear() {
+	x.p.SetIdentity()
+}
+
+// Random --
+func (x *G2) Random() {
+	x.HashAndMapTo(randomBytes())
+}
+
+// IsEqual --
+func (x *G2) IsEqual(rhs *G2) bool {
+	p, q := x.point(), rhs.point()
+	return p.IsEqual(&q)
+}
+
+// IsZero --
+func (x *G2) IsZero() bool {
+	p := x.point()
+	return p.IsIdentity()
+}
+
+// IsValid --
+func (x *G2) IsValid() bool {
+	p := x.point()
+	return p.IsOnG2()
+}
+
+// IsValidOrder --
+func (x *G2) IsValidOrder() bool {
+	p := x.point()
+	return p.IsOnG2()
+}
+
+// HashAndMapTo --
+func (x *G2) HashAndMapTo(buf []byte) error {
+	x.p.Hash(buf, dstG2)
+	return nil
+}
+
+// GetString --
+func (x *G2) GetString(base int) string {
+	p := x.point()
+	return affineString(p.Bytes(), base)
+}
+
+// Serialize --
+func (x *G2) Serialize() []byte {
+	p := x.point()
+	return p.BytesCompressed()
+}
+
+// Deserialize --
+// also checks the point is in G2
+func (x *G2) Deserialize(buf []byte) error {
+	if len(buf) != G2_LEN {
+		return errors.LengthInvalidError()
+	}
+	return x.p.SetBytes(buf)
+}
+
+// G2Generator sets out to the standard generator of G2
+func G2Generator(out *G2) {
+	out.p = *circl.G2Generator()
+}
+
+// G2Neg --
+func G2Neg(out *G2, x *G2) {
+	out.p = x.point()
+	out.p.Neg()
+}
+
+// G2Add --
+func G2Add(out *G2, x *G2, y *G2) {
+	p, q := x.point(), y.point()
+	out.p.Add(&p, &q)
+}
+
+// G2Sub --
+func G2Sub(out *G2, x *G2, y *G2) {
+	p, q := x.point(), y.point()
+	q.Neg()
+	out.p.Add(&p, &q)
+}
+
+// G2Mul --
+func G2Mul(out *G2, x *G2, y *Fr) {
+	p := x.point()
+	out.p.ScalarMult(&y.s, &p)
+}
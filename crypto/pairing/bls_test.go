@@ -3,21 +3,21 @@ package pairing
 import (
 	"testing"
 
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/pairing/kyber_wrap"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
 )
 
 // port of https://github.com/herumi/mcl/blob/v1.52/sample/bls_sig.cpp
 func TestBLS(t *testing.T) {
 	Q := kyber_wrap.G2Generator
 
-	var secret mcl.Fr
-	var public mcl.G2
+	var secret curve.Fr
+	var public curve.G2
 	KeyGen(&secret, &public, &Q)
 
 	message := []byte("Hello")
 
-	var signature mcl.G1
+	var signature curve.G1
 	Sign(&signature, &secret, message)
 
 	if !Verify(&signature, &Q, &public, message) {
@@ -28,13 +28,13 @@ func TestBLS(t *testing.T) {
 func BenchmarkBLSSign(b *testing.B) {
 	Q := kyber_wrap.G2Generator
 
-	var secret mcl.Fr
-	var public mcl.G2
+	var secret curve.Fr
+	var public curve.G2
 	KeyGen(&secret, &public, &Q)
 
 	message := []byte("Hello")
 
-	var signature mcl.G1
+	var signature curve.G1
 	for i := 0; i < b.N; i++ {
 		Sign(&signature, &secret, message)
 	}
@@ -43,13 +43,13 @@ func BenchmarkBLSSign(b *testing.B) {
 func BenchmarkBLSVerify(b *testing.B) {
 	Q := kyber_wrap.G2Generator
 
-	var secret mcl.Fr
-	var public mcl.G2
+	var secret curve.Fr
+	var public curve.G2
 	KeyGen(&secret, &public, &Q)
 
 	message := []byte("Hello")
 
-	var signature mcl.G1
+	var signature curve.G1
 	Sign(&signature, &secret, message)
 
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,132 @@
+//go:build mcl
+// +build mcl
+
+package curve
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto/pairing/bls12381"
+	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+)
+
+// The mcl build links both backends, so check that they encode and hash the same way
+// and that each reads what the other writes. Needs mcl installed, see crypto/pairing/mcl.
+
+func init() {
+	if err := bls12381.SetDstG1(G1DST); err != nil {
+		panic(err)
+	}
+	if err := bls12381.SetDstG2(G2DST); err != nil {
+		panic(err)
+	}
+}
+
+type encoded interface {
+	Serialize() []byte
+	Deserialize(buf []byte) error
+}
+
+// deserialize b into into and check it serializes back to the same bytes
+func roundTrip(t *testing.T, name string, b []byte, into encoded) {
+	if err := into.Deserialize(b); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(into.Serialize(), b) {
+		t.Fatalf("%s: wrote %x, read back %x", name, b, into.Serialize())
+	}
+}
+
+func TestBackendsEncoding(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		var f mcl.Fr
+		var g1 mcl.G1
+		var g2 mcl.G2
+		f.Random()
+		g1.Random()
+		g2.Random()
+		roundTrip(t, "mcl Fr", f.Serialize(), &bls12381.Fr{})
+		roundTrip(t, "mcl G1", g1.Serialize(), &bls12381.G1{})
+		roundTrip(t, "mcl G2", g2.Serialize(), &bls12381.G2{})
+
+		var pf bls12381.Fr
+		var pg1 bls12381.G1
+		var pg2 bls12381.G2
+		pf.Random()
+		pg1.Random()
+		pg2.Random()
+		roundTrip(t, "purego Fr", pf.Serialize(), &mcl.Fr{})
+		roundTrip(t, "purego G1", pg1.Serialize(), &mcl.G1{})
+		roundTrip(t, "purego G2", pg2.Serialize(), &mcl.G2{})
+	}
+	// the identity has its own encoding
+	var zero1 mcl.G1
+	var zero2 mcl.G2
+	roundTrip(t, "mcl G1 zero", zero1.Serialize(), &bls12381.G1{})
+	roundTrip(t, "mcl G2 zero", zero2.Serialize(), &bls12381.G2{})
+
+	var gen bls12381.G2
+	bls12381.G2Generator(&gen)
+	if !bytes.Equal(gen.Serialize(), g2Generator.Serialize()) {
+		t.Fatalf("G2 generators differ: %x %x", gen.Serialize(), g2Generator.Serialize())
+	}
+}
+
+func TestBackendsHash(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		msg := []byte(fmt.Sprintf("lightning %d", i))
+		var g1 mcl.G1
+		var pg1 bls12381.G1
+		if err := g1.HashAndMapTo(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := pg1.HashAndMapTo(msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(g1.Serialize(), pg1.Serialize()) {
+			t.Fatalf("G1 hashes of %q differ: %x %x", msg, g1.Serialize(), pg1.Serialize())
+		}
+		var g2 mcl.G2
+		var pg2 bls12381.G2
+		if err := g2.HashAndMapTo(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := pg2.HashAndMapTo(msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(g2.Serialize(), pg2.Serialize()) {
+			t.Fatalf("G2 hashes of %q differ: %x %x", msg, g2.Serialize(), pg2.Serialize())
+		}
+	}
+}
+
+// a signature made with mcl verifies with the pure Go backend
+func TestBackendsSignature(t *testing.T) {
+	var sk mcl.Fr
+	var pk, negGen mcl.G2
+	var h, sig mcl.G1
+	sk.Random()
+	G2Generator(&negGen)
+	mcl.G2Mul(&pk, &negGen, &sk)
+	mcl.G2Neg(&negGen, &negGen)
+	if err := h.HashAndMapTo([]byte("lightning")); err != nil {
+		t.Fatal(err)
+	}
+	mcl.G1Mul(&sig, &h, &sk)
+
+	var ppk, pNegGen bls12381.G2
+	var ph, psig bls12381.G1
+	roundTrip(t, "public key", pk.Serialize(), &ppk)
+	roundTrip(t, "negated generator", negGen.Serialize(), &pNegGen)
+	roundTrip(t, "hash", h.Serialize(), &ph)
+	roundTrip(t, "signature", sig.Serialize(), &psig)
+	// e(sig, -g2) e(h, pk) = 1
+	if !bls12381.PairingCheck(&psig, bls12381.NewPrecomputed(&pNegGen), &ph, bls12381.NewPrecomputed(&ppk)) {
+		t.Fatal("Pure Go backend rejects an mcl signature")
+	}
+	if !PairingCheck(&sig, NewPrecomputed(&negGen), &h, NewPrecomputed(&pk)) {
+		t.Fatal("mcl rejects its own signature")
+	}
+}
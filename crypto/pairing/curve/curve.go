@@ -0,0 +1,94 @@
+// Package curve selects the BLS12-381 backend used for tokens and pairings.
+// The default is the pure Go backend in crypto/pairing/bls12381, building with
+// the mcl tag uses the faster mcl cgo library instead. Both encode points the
+// same way and hash with the same tags, so keys, tokens and signatures made by
+// one backend are accepted by the other.
+package curve
+
+// domain separation tags for hashing to G1 and G2 (RFC 9380)
+const G1DST = "LIGHTNING-V01-CS01-with-BLS12381G1_XMD:SHA-256_SSWU_RO_"
+const G2DST = "LIGHTNING-V01-CS01-with-BLS12381G2_XMD:SHA-256_SSWU_RO_"
+
+// Scalar is what a backend's Fr must provide
+type Scalar interface {
+	Clear()
+	SetInt64(v int64)
+	Random()
+	IsEqual(rhs *Fr) bool
+	IsZero() bool
+	IsOne() bool
+	SetBigEndianMod(buf []byte) error
+	GetString(base int) string
+	Serialize() []byte
+	Deserialize(buf []byte) error
+	Len() int
+	PackTo(b []byte)
+	InterpretFrom(b []byte) error
+}
+
+// G1Point is what a backend's G1 must provide
+type G1Point interface {
+	Clear()
+	Random()
+	IsEqual(rhs *G1) bool
+	IsZero() bool
+	IsValid() bool
+	IsValidOrder() bool
+	HashAndMapTo(buf []byte) error
+	GetString(base int) string
+	Serialize() []byte
+	Deserialize(buf []byte) error
+	Len() int
+	PackTo(b []byte)
+	InterpretFrom(b []byte) error
+}
+
+// G2Point is what a backend's G2 must provide
+type G2Point interface {
+	Clear()
+	Random()
+	IsEqual(rhs *G2) bool
+	IsZero() bool
+	IsValidOrder() bool
+	HashAndMapTo(buf []byte) error
+	GetString(base int) string
+	Serialize() []byte
+	Deserialize(buf []byte) error
+	Len() int
+	PackTo(b []byte)
+	InterpretFrom(b []byte) error
+}
+
+// Target is what a backend's GT must provide
+type Target interface {
+	IsEqual(rhs *GT) bool
+	IsOne() bool
+}
+
+// the element types and operations each backend file must define
+var (
+	_ Scalar  = (*Fr)(nil)
+	_ G1Point = (*G1)(nil)
+	_ G2Point = (*G2)(nil)
+	_ Target  = (*GT)(nil)
+
+	_ func(out, x, y *Fr)                                         = FrAdd
+	_ func(out, x, y *Fr)                                         = FrSub
+	_ func(out, x, y *Fr)                                         = FrMul
+	_ func(out, x, y *Fr)                                         = FrDiv
+	_ func(out, x *Fr)                                            = FrNeg
+	_ func(out, x *Fr)                                            = FrInv
+	_ func(out, x, y *G1)                                         = G1Add
+	_ func(out, x *G1, y *Fr)                                     = G1Mul
+	_ func(out, x, y *G2)                                         = G2Add
+	_ func(out, x, y *G2)                                         = G2Sub
+	_ func(out, x *G2)                                            = G2Neg
+	_ func(out, x *G2, y *Fr)                                     = G2Mul
+	_ func(out *G2)                                               = G2Generator
+	_ func(out *GT, x *G1, y *G2)                                 = Pairing
+	_ func(q *G2) *Precomputed                                    = NewPrecomputed
+	_ func(out *GT, x *G1, q *Precomputed)                        = PrecomputedPairing
+	_ func(x1 *G1, q1 *Precomputed, x2 *G1, q2 *Precomputed) bool = PairingCheck
+	_ func() string                                               = GetCurveOrder
+	_ string                                                      = Backend
+)
@@ -0,0 +1,88 @@
+//go:build mcl
+// +build mcl
+
+package curve
+
+import (
+	"encoding/hex"
+
+	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+)
+
+const Backend = "mcl"
+
+type Fr = mcl.Fr
+type G1 = mcl.G1
+type G2 = mcl.G2
+type GT = mcl.GT
+
+type Precomputed struct {
+	data []uint64
+}
+
+var FR_LEN = mcl.FR_LEN
+var G1_LEN = mcl.G1_LEN
+var G2_LEN = mcl.G2_LEN
+
+// the standard generator in ZCash encoding, which mcl cannot produce by itself
+const g2GeneratorHex = "93e02b6052719f607dacd3a088274f65596bd0d09920b61ab5da61bbdc7f5049334cf11213945d57e5ac7d055d042b7e024aa2b2f08f0a91260805272dc51051c6e47ad4fa403b02b4510b647ae3d1770bac0326a805bbefd48056c8c121bdb8"
+
+var g2Generator mcl.G2
+
+func init() {
+	// match the pure Go backend: ZCash encoding and RFC 9380 hashing
+	// this changed the wire format of mcl builds, older builds cannot read it (see README)
+	mcl.SetETHserialization(true)
+	if err := mcl.SetMapToMode(mcl.IRTF); err != nil {
+		panic(err)
+	}
+	if err := mcl.SetDstG1(G1DST); err != nil {
+		panic(err)
+	}
+	if err := mcl.SetDstG2(G2DST); err != nil {
+		panic(err)
+	}
+	b, _ := hex.DecodeString(g2GeneratorHex)
+	if err := g2Generator.Deserialize(b); err != nil {
+		panic(err)
+	}
+}
+
+func FrAdd(out, x, y *Fr) { mcl.FrAdd(out, x, y) }
+func FrSub(out, x, y *Fr) { mcl.FrSub(out, x, y) }
+func FrMul(out, x, y *Fr) { mcl.FrMul(out, x, y) }
+func FrDiv(out, x, y *Fr) { mcl.FrDiv(out, x, y) }
+func FrNeg(out, x *Fr)    { mcl.FrNeg(out, x) }
+func FrInv(out, x *Fr)    { mcl.FrInv(out, x) }
+
+func G1Add(out, x, y *G1)     { mcl.G1Add(out, x, y) }
+func G1Mul(out, x *G1, y *Fr) { mcl.G1Mul(out, x, y) }
+
+func G2Add(out, x, y *G2)     { mcl.G2Add(out, x, y) }
+func G2Sub(out, x, y *G2)     { mcl.G2Sub(out, x, y) }
+func G2Neg(out, x *G2)        { mcl.G2Neg(out, x) }
+func G2Mul(out, x *G2, y *Fr) { mcl.G2Mul(out, x, y) }
+func G2Generator(out *G2)     { *out = g2Generator }
+
+func Pairing(out *GT, x *G1, y *G2) { mcl.Pairing(out, x, y) }
+
+func NewPrecomputed(q *G2) *Precomputed {
+	p := &Precomputed{data: make([]uint64, mcl.GetUint64NumToPrecompute())}
+	mcl.PrecomputeG2(p.data, q)
+	return p
+}
+
+func PrecomputedPairing(out *GT, x *G1, q *Precomputed) {
+	mcl.PrecomputedMillerLoop(out, x, q.data)
+	mcl.FinalExp(out, out)
+}
+
+func PairingCheck(x1 *G1, q1 *Precomputed, x2 *G1, q2 *Precomputed) bool {
+	// https://hackmd.io/@benjaminion/bls12-381#Final-exponentiation
+	var e mcl.GT
+	mcl.PrecomputedMillerLoop2(&e, x1, q1.data, x2, q2.data)
+	mcl.FinalExp(&e, &e)
+	return e.IsOne()
+}
+
+func GetCurveOrder() string { return mcl.GetCurveOrder() }
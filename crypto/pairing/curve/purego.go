@@ -0,0 +1,57 @@
+//go:build !mcl
+// +build !mcl
+
+package curve
+
+import "github.com/simonlangowski/lightning1/crypto/pairing/bls12381"
+
+const Backend = "purego"
+
+type Fr = bls12381.Fr
+type G1 = bls12381.G1
+type G2 = bls12381.G2
+type GT = bls12381.GT
+type Precomputed = bls12381.Precomputed
+
+const FR_LEN = bls12381.FR_LEN
+const G1_LEN = bls12381.G1_LEN
+const G2_LEN = bls12381.G2_LEN
+
+func init() {
+	if err := bls12381.SetDstG1(G1DST); err != nil {
+		panic(err)
+	}
+	if err := bls12381.SetDstG2(G2DST); err != nil {
+		panic(err)
+	}
+}
+
+func FrAdd(out, x, y *Fr) { bls12381.FrAdd(out, x, y) }
+func FrSub(out, x, y *Fr) { bls12381.FrSub(out, x, y) }
+func FrMul(out, x, y *Fr) { bls12381.FrMul(out, x, y) }
+func FrDiv(out, x, y *Fr) { bls12381.FrDiv(out, x, y) }
+func FrNeg(out, x *Fr)    { bls12381.FrNeg(out, x) }
+func FrInv(out, x *Fr)    { bls12381.FrInv(out, x) }
+
+func G1Add(out, x, y *G1)     { bls12381.G1Add(out, x, y) }
+func G1Mul(out, x *G1, y *Fr) { bls12381.G1Mul(out, x, y) }
+
+func G2Add(out, x, y *G2)     { bls12381.G2Add(out, x, y) }
+func G2Sub(out, x, y *G2)     { bls12381.G2Sub(out, x, y) }
+func G2Neg(out, x *G2)        { bls12381.G2Neg(out, x) }
+func G2Mul(out, x *G2, y *Fr) { bls12381.G2Mul(out, x, y) }
+func G2Generator(out *G2)     { bls12381.G2Generator(out) }
+
+func Pairing(out *GT, x *G1, y *G2) { bls12381.Pairing(out, x, y) }
+
+func NewPrecomputed(q *G2) *Precomputed { return bls12381.NewPrecomputed(q) }
+
+func PrecomputedPairing(out *GT, x *G1, q *Precomputed) {
+	bls12381.PrecomputedPairing(out, x, q)
+}
+
+func PairingCheck(x1 *G1, q1 *Precomputed, x2 *G1, q2 *Precomputed) bool {
+	return bls12381.PairingCheck(x1, q1, x2, q2)
+}
+
+func GetCurveOrder() string { return bls12381.GetCurveOrder() }
@@ -4,30 +4,24 @@ import (
 	"crypto/cipher"
 	"math/big"
 
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/util/random"
 )
 
-// Implement the point interface of Group for the mcl.G2 point
+// Implement the point interface of Group for the curve.G2 point
 
 var CURVE_MOD *big.Int
-var One mcl.Fp2
-var G2Generator mcl.G2
+var G2Generator curve.G2
 
 func init() {
-	curveOrder := mcl.GetCurveOrder()
+	curveOrder := curve.GetCurveOrder()
 	CURVE_MOD, _ = new(big.Int).SetString(curveOrder, 10)
-	One.D[0].SetInt64(1)
-	One.D[1].SetInt64(0)
-	if !One.IsOne() {
-		panic("Error setting one")
-	}
-	mcl.MapToG2(&G2Generator, &One)
+	curve.G2Generator(&G2Generator)
 }
 
 type Point struct {
-	g2 mcl.G2
+	g2 curve.G2
 }
 
 func (p *Point) Equal(P2 kyber.Point) bool {
@@ -59,19 +53,19 @@ func (p *Point) Base() kyber.Point {
 func (p *Point) Add(P1, P2 kyber.Point) kyber.Point {
 	E1 := P1.(*Point)
 	E2 := P2.(*Point)
-	mcl.G2Add(&p.g2, &E1.g2, &E2.g2)
+	curve.G2Add(&p.g2, &E1.g2, &E2.g2)
 	return p
 }
 
 func (p *Point) Sub(P1, P2 kyber.Point) kyber.Point {
 	E1 := P1.(*Point)
 	E2 := P2.(*Point)
-	mcl.G2Sub(&p.g2, &E1.g2, &E2.g2)
+	curve.G2Sub(&p.g2, &E1.g2, &E2.g2)
 	return p
 }
 
 func (p *Point) Neg(A kyber.Point) kyber.Point {
-	mcl.G2Neg(&p.g2, &A.(*Point).g2)
+	curve.G2Neg(&p.g2, &A.(*Point).g2)
 	return p
 }
 
@@ -81,7 +75,7 @@ func (p *Point) Mul(s kyber.Scalar, A kyber.Point) kyber.Point {
 		A = p.Base()
 	}
 	a := A.(*Point)
-	mcl.G2Mul(&p.g2, &a.g2, &sc.fr)
+	curve.G2Mul(&p.g2, &a.g2, &sc.fr)
 	return p
 }
 
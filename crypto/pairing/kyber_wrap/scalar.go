@@ -3,15 +3,15 @@ package kyber_wrap
 import (
 	"crypto/cipher"
 
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/util/random"
 )
 
-// Implement the scalar interface for the group for mcl.Fr
+// Implement the scalar interface for the group for curve.Fr
 
 type Scalar struct {
-	fr mcl.Fr
+	fr curve.Fr
 }
 
 func (s *Scalar) Equal(s2 kyber.Scalar) bool {
@@ -42,20 +42,20 @@ func (s *Scalar) Zero() kyber.Scalar {
 func (s *Scalar) Add(a, b kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
 	e2 := b.(*Scalar)
-	mcl.FrAdd(&s.fr, &e1.fr, &e2.fr)
+	curve.FrAdd(&s.fr, &e1.fr, &e2.fr)
 	return s
 }
 
 func (s *Scalar) Sub(a, b kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
 	e2 := b.(*Scalar)
-	mcl.FrSub(&s.fr, &e1.fr, &e2.fr)
+	curve.FrSub(&s.fr, &e1.fr, &e2.fr)
 	return s
 }
 
 func (s *Scalar) Neg(a kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
-	mcl.FrNeg(&s.fr, &e1.fr)
+	curve.FrNeg(&s.fr, &e1.fr)
 	return s
 }
 
@@ -67,20 +67,20 @@ func (s *Scalar) One() kyber.Scalar {
 func (s *Scalar) Mul(a, b kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
 	e2 := b.(*Scalar)
-	mcl.FrMul(&s.fr, &e1.fr, &e2.fr)
+	curve.FrMul(&s.fr, &e1.fr, &e2.fr)
 	return s
 }
 
 func (s *Scalar) Div(a, b kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
 	e2 := b.(*Scalar)
-	mcl.FrDiv(&s.fr, &e1.fr, &e2.fr)
+	curve.FrDiv(&s.fr, &e1.fr, &e2.fr)
 	return s
 }
 
 func (s *Scalar) Inv(a kyber.Scalar) kyber.Scalar {
 	e1 := a.(*Scalar)
-	mcl.FrInv(&s.fr, &e1.fr)
+	curve.FrInv(&s.fr, &e1.fr)
 	return s
 }
 
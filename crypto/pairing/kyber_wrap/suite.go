@@ -1,7 +1,7 @@
 package kyber_wrap
 
 import (
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"go.dedis.ch/kyber/v3"
 )
 
@@ -30,7 +30,7 @@ func (g *BLS12_381Group) String() string {
 }
 
 func (g *BLS12_381Group) ScalarLen() int {
-	return mcl.FR_LEN
+	return curve.FR_LEN
 }
 
 func (g *BLS12_381Group) Scalar() kyber.Scalar {
@@ -39,7 +39,7 @@ func (g *BLS12_381Group) Scalar() kyber.Scalar {
 }
 
 func (g *BLS12_381Group) PointLen() int {
-	return mcl.G2_LEN
+	return curve.G2_LEN
 }
 
 func (g *BLS12_381Group) Point() kyber.Point {
@@ -1,3 +1,6 @@
+//go:build mcl
+// +build mcl
+
 package mcl
 
 // from https://github.com/herumi/mcl/blob/master/ffi/go/mcl/init.go
@@ -1,3 +1,6 @@
+//go:build mcl
+// +build mcl
+
 package mcl
 
 import "testing"
@@ -1,3 +1,6 @@
+//go:build mcl
+// +build mcl
+
 package mcl
 
 // from https://github.com/herumi/mcl/blob/master/ffi/go/mcl/mcl.go
@@ -147,6 +150,28 @@ func SetMapToMode(mode int) error {
 	return nil
 }
 
+// SetDstG1 --
+func SetDstG1(s string) error {
+	buf := []byte(s)
+	// #nosec
+	err := C.mclBnG1_setDst((*C.char)(getPointer(buf)), C.size_t(len(buf)))
+	if err != 0 {
+		return fmt.Errorf("err mclBnG1_setDst %x", err)
+	}
+	return nil
+}
+
+// SetDstG2 --
+func SetDstG2(s string) error {
+	buf := []byte(s)
+	// #nosec
+	err := C.mclBnG2_setDst((*C.char)(getPointer(buf)), C.size_t(len(buf)))
+	if err != 0 {
+		return fmt.Errorf("err mclBnG2_setDst %x", err)
+	}
+	return nil
+}
+
 // Fr --
 type Fr struct {
 	v C.mclBnFr
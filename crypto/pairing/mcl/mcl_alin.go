@@ -1,3 +1,6 @@
+//go:build mcl
+// +build mcl
+
 package mcl
 
 // from https://github.com/alinush/go-mcl/blob/master/mcl.go
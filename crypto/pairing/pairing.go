@@ -1,8 +1,8 @@
 package pairing
 
 import (
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/pairing/kyber_wrap"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
 )
 
 var G2GeneratorPrecompute *Precompute
@@ -10,43 +10,34 @@ var NegatedPrecompute *Precompute
 
 func init() {
 	G2GeneratorPrecompute = NewPrecompute(&kyber_wrap.G2Generator)
-	var minusOne mcl.G2
-	mcl.G2Neg(&minusOne, &kyber_wrap.G2Generator)
+	var minusOne curve.G2
+	curve.G2Neg(&minusOne, &kyber_wrap.G2Generator)
 	NegatedPrecompute = NewPrecompute(&minusOne)
 }
 
 type Precompute struct {
-	data []uint64
+	q *curve.Precomputed
 }
 
-func NewPrecompute(base *mcl.G2) *Precompute {
-	precomputeSize := mcl.GetUint64NumToPrecompute()
-	p := &Precompute{data: make([]uint64, precomputeSize)}
-	mcl.PrecomputeG2(p.data, base)
-	return p
+func NewPrecompute(base *curve.G2) *Precompute {
+	return &Precompute{q: curve.NewPrecomputed(base)}
 }
 
-func (p *Precompute) Pairing(out *mcl.GT, val *mcl.G1) {
-	mcl.PrecomputedMillerLoop(out, val, p.data)
-	mcl.FinalExp(out, out)
+func (p *Precompute) Pairing(out *curve.GT, val *curve.G1) {
+	curve.PrecomputedPairing(out, val, p.q)
 }
 
-func (p *Precompute) PrecomputedPairingCheck(val1 *mcl.G1, val2 *mcl.G1) bool {
-	// https://hackmd.io/@benjaminion/bls12-381#Final-exponentiation
-	var e1, e2 mcl.GT
-	mcl.PrecomputedMillerLoop(&e1, val1, NegatedPrecompute.data) // e1^-1 = e(sign, -Q)
-	mcl.PrecomputedMillerLoop(&e2, val2, p.data)                 // e2 = e(hash, sQ)
-	mcl.GTMul(&e1, &e1, &e2)                                     // e1^-1 * e2 = 1
-	mcl.FinalExp(&e1, &e1)
-	return e1.IsOne()
+func (p *Precompute) PrecomputedPairingCheck(val1 *curve.G1, val2 *curve.G1) bool {
+	// e(sign, -Q) * e(hash, sQ) = 1
+	return curve.PairingCheck(val1, NegatedPrecompute.q, val2, p.q)
 }
 
-func AdditiveShares(secret *mcl.Fr, numShares int) []mcl.Fr {
-	signingShares := make([]mcl.Fr, numShares)
+func AdditiveShares(secret *curve.Fr, numShares int) []curve.Fr {
+	signingShares := make([]curve.Fr, numShares)
 	signingShares[0] = *secret
 	for i := 1; i < len(signingShares); i++ {
 		signingShares[i].Random()
-		mcl.FrSub(&signingShares[0], &signingShares[0], &signingShares[i])
+		curve.FrSub(&signingShares[0], &signingShares[0], &signingShares[i])
 	}
 	return signingShares
 }
@@ -1,8 +1,6 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 
 	"github.com/simonlangowski/lightning1/errors"
@@ -10,10 +8,13 @@ import (
 
 // derived from box.SecretSeal and box.SecretOpen
 // perform encrypt then sign non-repudiable encryption
+// the primitives of each suite are in suite.go
 
 const NONCE_SIZE = 24
 const SignedMetadataSize = 12
 const SymmetricKeySize = 16
+
+// overhead of the legacy suite, see CipherSuite.Overhead
 const Overhead = SIGNATURE_SIZE
 
 // ID of receiving party must be signed
@@ -27,7 +28,7 @@ func Nonce(round, layer, destId int) [NONCE_SIZE]byte {
 
 // return the signature from a signed message
 func ReadSignature(box []byte) Signature {
-	return box[len(box)-Overhead:]
+	return box[len(box)-SIGNATURE_SIZE:]
 }
 
 // write the additional signed metadata before the message
@@ -38,65 +39,18 @@ func PackSignedData(round, layer, server int, raw []byte, offset int) []byte {
 	binary.LittleEndian.PutUint64(raw[offset-24:offset-16], uint64(round))
 	binary.LittleEndian.PutUint64(raw[offset-16:offset-8], uint64(layer))
 	binary.LittleEndian.PutUint64(raw[offset-8:offset], uint64(server))
-	return raw[offset-24 : len(raw)-Overhead]
+	return raw[offset-24 : len(raw)-SIGNATURE_SIZE]
 }
 
-// AES encryption with the symmetric key
-// Encrypt-then-sign non repudiable encryption
+// Encrypt-then-sign non repudiable encryption with the legacy suite
 func SignedSecretSeal(message []byte, nonce *[NONCE_SIZE]byte, key DHSharedKey, signingKey SigningKey) []byte {
-	// keys := hkdf.New(sha256.New, key, (*nonce)[:], nil)
-	// aesKey := make([]byte, SymmetricKeySize)
-
-	// n, err := keys.Read(aesKey)
-	// if err != nil {
-	// 	panic("Could not generate aes key")
-	// } else if n != len(aesKey) {
-	// 	panic("Could not read enough bytes for aes key")
-	// }
-
-	out := make([]byte, len(message)+Overhead+NONCE_SIZE)
-
-	iv := (*nonce)[:aes.BlockSize]
-
-	block, err := aes.NewCipher(key[:SymmetricKeySize])
-	if err != nil {
-		panic("Could not create new aes cipher")
-	}
-
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(out[NONCE_SIZE:len(out)-Overhead], message)
-
-	// Sign nonce and ciphertext!
-	copy(out[:NONCE_SIZE], nonce[:])
-	s := Sign(signingKey, out[:len(out)-Overhead])
-	copy(out[len(out)-Overhead:], s)
-	// Nonce here can be filled in by the verifying machine, and not included
-	errors.DebugPrint("Encrypted %v %v: %v to %v", nonce, key, message, out[NONCE_SIZE:len(out)-Overhead])
-	return out[NONCE_SIZE:]
+	return SuiteLegacy.SignedSecretSeal(message, nonce, key, signingKey)
 }
 
 func SecretOpen(box []byte, nonce *[24]byte, key DHSharedKey) []byte {
-	// keys := hkdf.New(sha256.New, key, (*nonce)[:], nil)
-	// aesKey := [SymmetricKeySize]byte{}
-
-	// n, err := keys.Read(aesKey[:])
-	// if err != nil {
-	// 	panic("Could not generate aes key")
-	// } else if n != len(aesKey) {
-	// 	panic("Could not read enough bytes for aes key")
-	// }
-
-	iv := (*nonce)[:aes.BlockSize]
-	out := make([]byte, len(box)-Overhead)
-
-	block, err := aes.NewCipher(key[:SymmetricKeySize])
+	out, err := SuiteLegacy.SecretOpen(box, nonce, key)
 	if err != nil {
-		panic("Could not create new aes cipher")
+		panic(err)
 	}
-
-	stream := cipher.NewCTR(block, iv[:])
-	stream.XORKeyStream(out, box[:len(box)-Overhead])
-
-	errors.DebugPrint("Decrypted %v %v: %v from %v", nonce, key, out, box[:len(box)-Overhead])
 	return out
 }
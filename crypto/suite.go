@@ -0,0 +1,175 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/simonlangowski/lightning1/errors"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A cipher suite fixes how each onion layer is encrypted and signed
+// The coordinator chooses the suite of every round in RoundInfo,
+// so the network can move to new primitives one round at a time
+
+type CipherSuite int32
+
+const (
+	// AES-128-CTR keyed directly by the DH point, with Ed25519 signatures
+	// used by rounds that do not choose a suite
+	SuiteLegacy CipherSuite = 0
+	// AES-128-CTR keyed by the KDF, with Ed25519 signatures
+	SuiteAESCTR CipherSuite = 1
+	// XChaCha20 keyed by the KDF, with Ed25519 signatures
+	SuiteChaCha20 CipherSuite = 2
+	// XChaCha20-Poly1305 keyed by the KDF, with Ed25519 signatures over the sealed box
+	SuiteChaCha20Poly1305 CipherSuite = 3
+)
+
+// the suite coordinators choose for new rounds
+const DefaultSuite = SuiteAESCTR
+
+var suiteNames = []string{"legacy", "aes-ctr", "chacha20", "chacha20-poly1305"}
+
+var kdfSalt = []byte("lightning onion layer v1")
+
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	for id, n := range suiteNames {
+		if n == name {
+			return CipherSuite(id), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+func (s CipherSuite) String() string {
+	if !s.Supported() {
+		return fmt.Sprintf("suite(%d)", int32(s))
+	}
+	return suiteNames[s]
+}
+
+func (s CipherSuite) Supported() bool {
+	return s >= 0 && int(s) < len(suiteNames)
+}
+
+// bytes added by one layer of encryption
+func (s CipherSuite) Overhead() int {
+	if s == SuiteChaCha20Poly1305 {
+		return SIGNATURE_SIZE + chacha20poly1305.Overhead
+	}
+	return SIGNATURE_SIZE
+}
+
+func (s CipherSuite) keySize() int {
+	switch s {
+	case SuiteChaCha20, SuiteChaCha20Poly1305:
+		return chacha20.KeySize
+	default:
+		return SymmetricKeySize
+	}
+}
+
+// derive the key of one message from the DH shared key
+// the key is bound to the suite and to the round, layer and receiving server in the nonce
+func (s CipherSuite) DeriveKey(shared DHSharedKey, nonce *[NONCE_SIZE]byte) []byte {
+	if s == SuiteLegacy {
+		return shared[:SymmetricKeySize]
+	}
+	info := make([]byte, 4+NONCE_SIZE)
+	binary.LittleEndian.PutUint32(info[:4], uint32(s))
+	copy(info[4:], nonce[:])
+	key := make([]byte, s.keySize())
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, kdfSalt, info), key)
+	if err != nil {
+		panic("Could not derive key")
+	}
+	return key
+}
+
+// encrypt-then-sign non repudiable encryption
+// the signature covers the nonce and the ciphertext, the nonce is not included in the output
+func (s CipherSuite) SignedSecretSeal(message []byte, nonce *[NONCE_SIZE]byte, key DHSharedKey, signingKey SigningKey) []byte {
+	k := s.DeriveKey(key, nonce)
+	out := make([]byte, NONCE_SIZE+len(message)+s.Overhead())
+	box := out[NONCE_SIZE : len(out)-SIGNATURE_SIZE]
+	switch s {
+	case SuiteLegacy, SuiteAESCTR:
+		aesCTR(k, nonce).XORKeyStream(box, message)
+	case SuiteChaCha20:
+		xchacha(k, nonce).XORKeyStream(box, message)
+	case SuiteChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(k)
+		if err != nil {
+			panic("Could not create new chacha20poly1305 cipher")
+		}
+		aead.Seal(box[:0], nonce[:], message, nil)
+	default:
+		panic(errors.UnimplementedError())
+	}
+	copy(out[:NONCE_SIZE], nonce[:])
+	sig := Sign(signingKey, out[:len(out)-SIGNATURE_SIZE])
+	copy(out[len(out)-SIGNATURE_SIZE:], sig)
+	errors.DebugPrint("Encrypted %v %v: %v to %v", nonce, key, message, box)
+	return out[NONCE_SIZE:]
+}
+
+// decrypt a box made by SignedSecretSeal, the signature is checked separately
+func (s CipherSuite) SecretOpen(box []byte, nonce *[NONCE_SIZE]byte, key DHSharedKey) ([]byte, error) {
+	return s.OpenWithKey(box, nonce, s.DeriveKey(key, nonce))
+}
+
+// decrypt a box with the key derived for it, which can be revealed without revealing the shared key
+func (s CipherSuite) OpenWithKey(box []byte, nonce *[NONCE_SIZE]byte, k []byte) ([]byte, error) {
+	if len(box) < s.Overhead() {
+		return nil, errors.LengthInvalidError()
+	}
+	if len(k) != s.keySize() {
+		return nil, errors.LengthInvalidError()
+	}
+	sealed := box[:len(box)-SIGNATURE_SIZE]
+	var out []byte
+	switch s {
+	case SuiteLegacy, SuiteAESCTR:
+		out = make([]byte, len(sealed))
+		aesCTR(k, nonce).XORKeyStream(out, sealed)
+	case SuiteChaCha20:
+		out = make([]byte, len(sealed))
+		xchacha(k, nonce).XORKeyStream(out, sealed)
+	case SuiteChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(k)
+		if err != nil {
+			panic("Could not create new chacha20poly1305 cipher")
+		}
+		out, err = aead.Open(nil, nonce[:], sealed, nil)
+		if err != nil {
+			return nil, errors.DecryptionFailure()
+		}
+	default:
+		return nil, errors.UnimplementedError()
+	}
+	errors.DebugPrint("Decrypted %v %v: %v from %v", nonce, k, out, sealed)
+	return out, nil
+}
+
+func aesCTR(key []byte, nonce *[NONCE_SIZE]byte) cipher.Stream {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("Could not create new aes cipher")
+	}
+	return cipher.NewCTR(block, nonce[:aes.BlockSize])
+}
+
+func xchacha(key []byte, nonce *[NONCE_SIZE]byte) cipher.Stream {
+	c, err := chacha20.NewUnauthenticatedCipher(key, nonce[:])
+	if err != nil {
+		panic("Could not create new chacha20 cipher")
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+var allSuites = []CipherSuite{SuiteLegacy, SuiteAESCTR, SuiteChaCha20, SuiteChaCha20Poly1305}
+
+func TestSuiteSealOpen(t *testing.T) {
+	sk, pk := NewDHKeyPair()
+	vk, k := NewSigningKeyPair()
+	shared := sk.SharedKey(&pk)
+	round, layer, server := 5, 10, 15
+	nonce := Nonce(round, layer, server)
+	message := make([]byte, 100)
+	rand.Read(message)
+	for _, suite := range allSuites {
+		box := suite.SignedSecretSeal(message, &nonce, shared, k)
+		if len(box) != len(message)+suite.Overhead() {
+			t.Fatalf("%v: box of length %d", suite, len(box))
+		}
+		o := make([]byte, NONCE_SIZE+len(box))
+		copy(o[NONCE_SIZE:], box)
+		if !Verify(vk, PackSignedData(round, layer, server, o, NONCE_SIZE), ReadSignature(box)) {
+			t.Fatalf("%v: message not signed correctly", suite)
+		}
+		decrypted, err := suite.SecretOpen(box, &nonce, shared)
+		if err != nil || !bytes.Equal(message, decrypted) {
+			t.Fatalf("%v: original message not recovered %v", suite, err)
+		}
+	}
+}
+
+// the legacy suite is the encryption used before suites were negotiated
+func TestSuiteLegacy(t *testing.T) {
+	sk, pk := NewDHKeyPair()
+	_, k := NewSigningKeyPair()
+	shared := sk.SharedKey(&pk)
+	nonce := Nonce(1, 2, 3)
+	message := make([]byte, 64)
+	rand.Read(message)
+	box := SuiteLegacy.SignedSecretSeal(message, &nonce, shared, k)
+	if !bytes.Equal(SecretOpen(box, &nonce, shared), message) {
+		t.Fatal("legacy suite does not match SecretOpen")
+	}
+	if !bytes.Equal(SuiteLegacy.DeriveKey(shared, &nonce), shared[:SymmetricKeySize]) {
+		t.Fatal("legacy suite uses the shared key")
+	}
+}
+
+func TestSuiteKeyBinding(t *testing.T) {
+	sk, pk := NewDHKeyPair()
+	shared := sk.SharedKey(&pk)
+	nonces := [][NONCE_SIZE]byte{Nonce(1, 2, 3), Nonce(2, 2, 3), Nonce(1, 3, 3), Nonce(1, 2, 4)}
+	keys := make(map[string]bool)
+	for _, suite := range allSuites[1:] {
+		for _, n := range nonces {
+			n := n
+			key := suite.DeriveKey(shared, &n)
+			if bytes.Equal(key[:SymmetricKeySize], shared[:SymmetricKeySize]) {
+				t.Fatalf("%v: key is not derived", suite)
+			}
+			keys[string(key)] = true
+		}
+	}
+	if len(keys) != len(nonces)*(len(allSuites)-1) {
+		t.Fatal("keys are not bound to the suite, round, layer and server")
+	}
+
+	// opening under the wrong round does not recover the message
+	_, k := NewSigningKeyPair()
+	message := make([]byte, 32)
+	rand.Read(message)
+	for _, suite := range allSuites[1:] {
+		box := suite.SignedSecretSeal(message, &nonces[0], shared, k)
+		decrypted, err := suite.SecretOpen(box, &nonces[1], shared)
+		if err == nil && bytes.Equal(decrypted, message) {
+			t.Fatalf("%v: opened with the nonce of another round", suite)
+		}
+	}
+}
+
+func TestSuiteAEAD(t *testing.T) {
+	sk, pk := NewDHKeyPair()
+	_, k := NewSigningKeyPair()
+	shared := sk.SharedKey(&pk)
+	nonce := Nonce(0, 1, 2)
+	box := SuiteChaCha20Poly1305.SignedSecretSeal(make([]byte, 50), &nonce, shared, k)
+	box[0] ^= 1
+	if _, err := SuiteChaCha20Poly1305.SecretOpen(box, &nonce, shared); err == nil {
+		t.Fatal("opened a modified box")
+	}
+	if _, err := SuiteChaCha20Poly1305.SecretOpen(box[:10], &nonce, shared); err == nil {
+		t.Fatal("opened a short box")
+	}
+}
+
+// the derived key opens only the box of its round, layer and server
+func TestSuiteOpenWithKey(t *testing.T) {
+	sk, pk := NewDHKeyPair()
+	_, k := NewSigningKeyPair()
+	shared := sk.SharedKey(&pk)
+	nonce := Nonce(3, 1, 2)
+	message := make([]byte, 40)
+	rand.Read(message)
+	for _, suite := range allSuites[1:] {
+		box := suite.SignedSecretSeal(message, &nonce, shared, k)
+		decrypted, err := suite.OpenWithKey(box, &nonce, suite.DeriveKey(shared, &nonce))
+		if err != nil || !bytes.Equal(decrypted, message) {
+			t.Fatalf("%v: original message not recovered %v", suite, err)
+		}
+		other := Nonce(4, 1, 2)
+		decrypted, err = suite.OpenWithKey(box, &nonce, suite.DeriveKey(shared, &other))
+		if err == nil && bytes.Equal(decrypted, message) {
+			t.Fatalf("%v: opened with the key of another round", suite)
+		}
+		if _, err := suite.OpenWithKey(box, &nonce, shared[:5]); err == nil {
+			t.Fatalf("%v: opened with a short key", suite)
+		}
+	}
+}
+
+func TestParseCipherSuite(t *testing.T) {
+	for _, suite := range allSuites {
+		parsed, err := ParseCipherSuite(suite.String())
+		if err != nil || parsed != suite {
+			t.Fatalf("%v: parsed as %v %v", suite, parsed, err)
+		}
+	}
+	if _, err := ParseCipherSuite("rot13"); err == nil {
+		t.Fatal("parsed an unknown suite")
+	}
+	if CipherSuite(len(allSuites)).Supported() || CipherSuite(-1).Supported() {
+		t.Fatal("unknown suite supported")
+	}
+}
+
+func BenchmarkSuiteOpen(b *testing.B) {
+	sk, pk := NewDHKeyPair()
+	_, k := NewSigningKeyPair()
+	shared := sk.SharedKey(&pk)
+	nonce := Nonce(0, 0, 0)
+	message := make([]byte, 2000)
+	for _, suite := range allSuites {
+		box := suite.SignedSecretSeal(message, &nonce, shared, k)
+		b.Run(suite.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				suite.SecretOpen(box, &nonce, shared)
+			}
+		})
+	}
+}
@@ -2,29 +2,29 @@ package token
 
 import (
 	"github.com/simonlangowski/lightning1/crypto/pairing"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/pairing/kyber_wrap"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
 )
 
 type TokenPublicKey struct {
-	X          mcl.G2
+	X          curve.G2
 	precompute *pairing.Precompute
 }
 
 type TokenSigningKey struct {
-	X     mcl.G2
-	Share mcl.Fr
+	X     curve.G2
+	Share curve.Fr
 }
 
-func NewTokenSigningKey(share *mcl.Fr) *TokenSigningKey {
+func NewTokenSigningKey(share *curve.Fr) *TokenSigningKey {
 	t := &TokenSigningKey{
 		Share: *share,
 	}
-	mcl.G2Mul(&t.X, &kyber_wrap.G2Generator, share)
+	curve.G2Mul(&t.X, &kyber_wrap.G2Generator, share)
 	return t
 }
 
-func NewTokenPublicKey(key *mcl.G2) *TokenPublicKey {
+func NewTokenPublicKey(key *curve.G2) *TokenPublicKey {
 	return &TokenPublicKey{
 		X:          *key,
 		precompute: pairing.NewPrecompute(key),
@@ -32,12 +32,12 @@ func NewTokenPublicKey(key *mcl.G2) *TokenPublicKey {
 }
 
 func KeyGenShares(numShares int) ([]*TokenSigningKey, *TokenPublicKey, *TokenSigningKey) {
-	s := &mcl.Fr{}
+	s := &curve.Fr{}
 	s.Random()
 	return MockKeyGen(numShares, s)
 }
 
-func MockKeyGen(numShares int, secret *mcl.Fr) ([]*TokenSigningKey, *TokenPublicKey, *TokenSigningKey) {
+func MockKeyGen(numShares int, secret *curve.Fr) ([]*TokenSigningKey, *TokenPublicKey, *TokenSigningKey) {
 	masterSigningKey := NewTokenSigningKey(secret)
 	shares := pairing.AdditiveShares(secret, numShares)
 	partialSigningKeys := make([]*TokenSigningKey, numShares)
@@ -2,11 +2,11 @@ package token
 
 import (
 	"github.com/simonlangowski/lightning1/crypto/pairing"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 )
 
 func (t *TokenPublicKey) Len() int {
-	return mcl.G2_LEN
+	return curve.G2_LEN
 }
 
 func (t *TokenPublicKey) PackTo(b []byte) {
@@ -23,7 +23,7 @@ func (t *TokenPublicKey) InterpretFrom(b []byte) error {
 }
 
 func (t *SignedToken) Len() int {
-	return mcl.G1_LEN
+	return curve.G1_LEN
 }
 
 func (t *SignedToken) PackTo(b []byte) {
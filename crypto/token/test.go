@@ -2,7 +2,7 @@ package token
 
 import (
 	"github.com/simonlangowski/lightning1/config"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 )
 
 // a fixed secret, public key pair to generate messages quickly
@@ -11,14 +11,15 @@ var SecretKey *TokenSigningKey
 var PublicKey *TokenPublicKey
 
 func init() {
-	secret := &mcl.Fr{}
-	secret.InterpretFrom(config.Seed[:mcl.FR_LEN])
+	secret := &curve.Fr{}
+	// reduce so every backend reads the seed the same way
+	secret.SetBigEndianMod(config.Seed[:curve.FR_LEN])
 	SecretKey = NewTokenSigningKey(secret)
 	PublicKey = NewTokenPublicKey(&SecretKey.X)
 }
 
 func SkipToken(message []byte) *SignedToken {
-	var hash mcl.G1
+	var hash curve.G1
 	t := &SignedToken{}
 	PublicKey.hashToCurvePoint(message, &hash)
 	SecretKey.BlindSign(&t.T, &hash)
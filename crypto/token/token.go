@@ -4,24 +4,24 @@ import (
 	"crypto/sha256"
 
 	"github.com/simonlangowski/lightning1/config"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/errors"
 )
 
-var TOKEN_SIZE = mcl.G1_LEN
+var TOKEN_SIZE = curve.G1_LEN
 
 type SignedToken struct {
-	T mcl.G1
+	T curve.G1
 }
 
 type TokenIssuanceInformation struct {
 	key      *TokenPublicKey
-	hash     mcl.G1
-	blinding mcl.Fr
+	hash     curve.G1
+	blinding curve.Fr
 }
 
-func (t *TokenPublicKey) Prepare(message []byte) (*mcl.G1, *TokenIssuanceInformation) {
-	blindedHash := &mcl.G1{}
+func (t *TokenPublicKey) Prepare(message []byte) (*curve.G1, *TokenIssuanceInformation) {
+	blindedHash := &curve.G1{}
 	info := &TokenIssuanceInformation{key: t}
 	t.hashToCurvePoint(message, &info.hash)
 	info.blinding.Random()
@@ -29,7 +29,7 @@ func (t *TokenPublicKey) Prepare(message []byte) (*mcl.G1, *TokenIssuanceInforma
 	return blindedHash, info
 }
 
-func (t *TokenSigningKey) BlindSign(out *mcl.G1, blindedHash *mcl.G1) error {
+func (t *TokenSigningKey) BlindSign(out *curve.G1, blindedHash *curve.G1) error {
 	// prevent subgroup leaking bits of key
 	// https://eprint.iacr.org/2015/247.pdf
 	// This is a scalar multiplication by the prime field order
@@ -38,11 +38,11 @@ func (t *TokenSigningKey) BlindSign(out *mcl.G1, blindedHash *mcl.G1) error {
 		return errors.BadElementError()
 	}
 	// Technicaly this scalar multiplication reuses the same base as the valid order check, so one could reuse the doublings
-	mcl.G1Mul(out, blindedHash, &t.Share)
+	curve.G1Mul(out, blindedHash, &t.Share)
 	return nil
 }
 
-func (info *TokenIssuanceInformation) Create(partials []mcl.G1) (*SignedToken, error) {
+func (info *TokenIssuanceInformation) Create(partials []curve.G1) (*SignedToken, error) {
 	token := &SignedToken{}
 	t := info.key
 	t.combine(partials, &token.T)
@@ -57,32 +57,32 @@ func (info *TokenIssuanceInformation) Create(partials []mcl.G1) (*SignedToken, e
 }
 
 func (t *TokenPublicKey) VerifyMessage(token *SignedToken, message []byte) bool {
-	var hash mcl.G1
+	var hash curve.G1
 	t.hashToCurvePoint(message, &hash)
 	return t.verify(&token.T, &hash)
 }
 
-func (t *TokenPublicKey) hashToCurvePoint(message []byte, out *mcl.G1) {
+func (t *TokenPublicKey) hashToCurvePoint(message []byte, out *curve.G1) {
 	out.HashAndMapTo(message)
 }
 
-func (t *TokenPublicKey) blind(out *mcl.G1, m *mcl.G1, r *mcl.Fr) {
-	mcl.G1Mul(out, m, r)
+func (t *TokenPublicKey) blind(out *curve.G1, m *curve.G1, r *curve.Fr) {
+	curve.G1Mul(out, m, r)
 }
 
-func (t *TokenPublicKey) combine(partials []mcl.G1, final *mcl.G1) {
+func (t *TokenPublicKey) combine(partials []curve.G1, final *curve.G1) {
 	final.Clear()
 	for i := range partials {
-		mcl.G1Add(final, final, &partials[i])
+		curve.G1Add(final, final, &partials[i])
 	}
 }
 
-func (t *TokenPublicKey) unblind(m *mcl.G1, r *mcl.Fr) {
-	mcl.FrInv(r, r)
-	mcl.G1Mul(m, m, r)
+func (t *TokenPublicKey) unblind(m *curve.G1, r *curve.Fr) {
+	curve.FrInv(r, r)
+	curve.G1Mul(m, m, r)
 }
 
-func (t *TokenPublicKey) verify(signature *mcl.G1, hash *mcl.G1) bool {
+func (t *TokenPublicKey) verify(signature *curve.G1, hash *curve.G1) bool {
 	// Assume we hashed hash to G1 correctly, so it is in G1
 	// Assume we checked the keys already
 	// check signature order - See https://datatracker.ietf.org/doc/html/draft-boneh-bls-signature-00#section-3.2
@@ -92,13 +92,13 @@ func (t *TokenPublicKey) verify(signature *mcl.G1, hash *mcl.G1) bool {
 	// e(sign, Q) = e(hash, sQ)
 
 	/*
-		var e1, e2 mcl.GT
-		mcl.Pairing(&e1, signature, &G2Generator) // e1 = e(sign, Q)
-		mcl.Pairing(&e2, hash, &t.X)              // e2 = e(Hm, sQ)
+		var e1, e2 curve.GT
+		curve.Pairing(&e1, signature, &G2Generator) // e1 = e(sign, Q)
+		curve.Pairing(&e2, hash, &t.X)              // e2 = e(Hm, sQ)
 		return e1.IsEqual(&e2)
 	*/
 	/*
-		var e1, e2 mcl.GT
+		var e1, e2 curve.GT
 		G2GeneratorPrecompute.Pairing(&e1, signature)
 		t.precompute.Pairing(&e2, hash)
 		return e1.IsEqual(&e2)
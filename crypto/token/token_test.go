@@ -5,7 +5,7 @@ import (
 	"runtime/pprof"
 	"testing"
 
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 )
 
 func TestToken(t *testing.T) {
@@ -13,7 +13,7 @@ func TestToken(t *testing.T) {
 	partialSigningKeys, publicKey, _ := KeyGenShares(numSigners)
 	message := []byte("Hi")
 	blindedHash, info := publicKey.Prepare(message)
-	blindedHashes := make([]mcl.G1, numSigners)
+	blindedHashes := make([]curve.G1, numSigners)
 	for i := range partialSigningKeys {
 		err := partialSigningKeys[i].BlindSign(&blindedHashes[i], blindedHash)
 		if err != nil {
@@ -43,7 +43,7 @@ func TestProfile(t *testing.T) {
 
 		message := []byte("Hi")
 		blindedHash, info := publicKey.Prepare(message)
-		blindedHashes := make([]mcl.G1, numSigners)
+		blindedHashes := make([]curve.G1, numSigners)
 		for i := range partialSigningKeys {
 			err := partialSigningKeys[i].BlindSign(&blindedHashes[i], blindedHash)
 			if err != nil {
@@ -73,7 +73,7 @@ func BenchmarkTokenSign(b *testing.B) {
 
 	message := []byte("Hi")
 	blindedHash, _ := publicKey.Prepare(message)
-	blindedHashes := make([]mcl.G1, numSigners)
+	blindedHashes := make([]curve.G1, numSigners)
 	b.ResetTimer()
 	for j := 0; j < b.N; j++ {
 		for i := range partialSigningKeys {
@@ -91,7 +91,7 @@ func BenchmarkTokenVerify(b *testing.B) {
 	message := []byte("Hi")
 	blindedHash, info := publicKey.Prepare(message)
 	signingKey.BlindSign(blindedHash, blindedHash)
-	blindedHashes := []mcl.G1{*blindedHash}
+	blindedHashes := []curve.G1{*blindedHash}
 	token, err := info.Create(blindedHashes)
 	if err != nil {
 		b.FailNow()
@@ -0,0 +1,102 @@
+package token
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
+)
+
+// Every backend must produce these bytes, so servers built with and without
+// mcl can verify each other's tokens. Run with and without -tags mcl,
+// crypto/pairing/curve also checks the two backends against each other with -tags mcl.
+const (
+	vectorMessage   = "lightning"
+	vectorSecret    = "03bd91a10f9e910602328fa072a0701bdaa82b489dccb24fabf048f853c9c555"
+	vectorPublicKey = "805eea95eba5f78911690142dfffa0b94a89e5d6241b96f73b14697ba323705632108d4700f1daeadb7639d2deb46c6e0f235a6567c298522d1360ed95f01ec5ddcac75f76b30a9b5d3623bb54c2ed95c37a0ce47dd3e2414c07f6cb89501767"
+	vectorHash      = "90c8ec4309c9d97b0c66dbf386cf1e14cb295e2e341fe66b1525736f97b8dc8882be22c7298189943db035cacc8df969"
+	vectorToken     = "b83d2d874136373e27a1ecb343ec68d40b2beb6e028de533b3a74682bf896ca81c736ef080895c7aa2a44e80e8db9bb5"
+
+	// the fixed key in test.go
+	vectorSkipPublicKey = "af35721603285ddd638ce313fd64aeee2657a3eca5add6c18413ef907e81ae35edfa98e5211ea1986977cd7e5b9447651187650fd4aee2c752278b60ab7ceea976608a9a4657631633efc33b40bc1d128467e6b13ce3021e23c4bcf4099e3526"
+	vectorSkipToken     = "8543c0119c9ccc56be6ff8e427355552f83e2ce78d575e1a73e795abfc953f8959102799a5f3df2a7cc7a3886f6bb610"
+)
+
+func vectorKey(t *testing.T) *curve.Fr {
+	seed := sha256.Sum256([]byte("lightning token vectors"))
+	secret := &curve.Fr{}
+	secret.SetBigEndianMod(seed[:])
+	if hex.EncodeToString(secret.Serialize()) != vectorSecret {
+		t.Fatalf("%s secret: %x", curve.Backend, secret.Serialize())
+	}
+	return secret
+}
+
+func TestVectors(t *testing.T) {
+	secret := vectorKey(t)
+	numSigners := 3
+	partialSigningKeys, publicKey, _ := MockKeyGen(numSigners, secret)
+	if hex.EncodeToString(publicKey.X.Serialize()) != vectorPublicKey {
+		t.Fatalf("%s public key: %x", curve.Backend, publicKey.X.Serialize())
+	}
+
+	var hash curve.G1
+	publicKey.hashToCurvePoint([]byte(vectorMessage), &hash)
+	if hex.EncodeToString(hash.Serialize()) != vectorHash {
+		t.Fatalf("%s hash: %x", curve.Backend, hash.Serialize())
+	}
+
+	// blinding and the key shares are random, the token is not
+	blindedHash, info := publicKey.Prepare([]byte(vectorMessage))
+	blindedHashes := make([]curve.G1, numSigners)
+	for i := range partialSigningKeys {
+		err := partialSigningKeys[i].BlindSign(&blindedHashes[i], blindedHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := info.Create(blindedHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(token.T.Serialize()) != vectorToken {
+		t.Fatalf("%s token: %x", curve.Backend, token.T.Serialize())
+	}
+}
+
+func TestSkipTokenVectors(t *testing.T) {
+	if hex.EncodeToString(PublicKey.X.Serialize()) != vectorSkipPublicKey {
+		t.Fatalf("%s public key: %x", curve.Backend, PublicKey.X.Serialize())
+	}
+	token := SkipToken([]byte(vectorMessage))
+	if hex.EncodeToString(token.T.Serialize()) != vectorSkipToken {
+		t.Fatalf("%s token: %x", curve.Backend, token.T.Serialize())
+	}
+}
+
+func TestVectorsVerify(t *testing.T) {
+	pk, _ := hex.DecodeString(vectorPublicKey)
+	tb, _ := hex.DecodeString(vectorToken)
+	publicKey := &TokenPublicKey{}
+	if err := publicKey.InterpretFrom(pk); err != nil {
+		t.Fatal(err)
+	}
+	token := &SignedToken{}
+	if err := token.InterpretFrom(tb); err != nil {
+		t.Fatal(err)
+	}
+	if !publicKey.VerifyMessage(token, []byte(vectorMessage)) {
+		t.Fatalf("%s rejected the vector token", curve.Backend)
+	}
+	if publicKey.VerifyMessage(token, []byte("lightning1")) {
+		t.Fatalf("%s accepted the token for another message", curve.Backend)
+	}
+
+	b := make([]byte, token.Len())
+	token.PackTo(b)
+	if !bytes.Equal(b, tb) {
+		t.Fatal("token encoding is not canonical")
+	}
+}
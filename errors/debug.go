@@ -1,11 +1,13 @@
 package errors
 
-import "log"
+import (
+	"fmt"
 
-const debug = false
+	"github.com/simonlangowski/lightning1/logging"
+)
 
-func DebugPrint(fmt string, args ...interface{}) {
-	if debug {
-		log.Printf(fmt, args...)
+func DebugPrint(format string, args ...interface{}) {
+	if logger.Enabled(logging.Debug) {
+		logger.Debug(fmt.Sprintf(format, args...))
 	}
 }
@@ -2,60 +2,121 @@ package errors
 
 import (
 	"bufio"
-	"errors"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"os"
 	"runtime/pprof"
-	"sync"
+	"sync/atomic"
+
+	"github.com/simonlangowski/lightning1/logging"
 )
 
-var mu sync.Mutex
 var Addr = "unset"
 
-// write error to log file
-func LogError(e error) {
-	log.Print(e)
-	// only allow the first error - all other errors will block here forever (assuming a panic is coming!)
-	mu.Lock()
+// write a goroutine dump to error<Addr>.log on the first error, to debug where servers were stuck
+var DumpOnError = false
+var dumped int32
+
+var logger = logging.For("errors")
+
+// log a failure where it is handled, once its round, layer, peer and key are attached
+// kv are fields of the caller, used for the details the error does not carry
+func Log(log *logging.Logger, level logging.Level, msg string, e error, kv ...interface{}) {
+	log.Log(level, msg, Fields(e, kv...)...)
+	if DumpOnError && atomic.CompareAndSwapInt32(&dumped, 0, 1) {
+		dumpGoroutines(e)
+	}
+}
+
+// the details of an error as log fields, followed by the fields in kv that it does not carry
+func Fields(err error, kv ...interface{}) []interface{} {
+	fields := []interface{}{"error", err.Error()}
+	e := &Error{}
+	if As(err, &e) {
+		fields = append(fields, "kind", e.Kind.reason)
+		if e.Known(RoundField) {
+			fields = append(fields, "round", e.Round)
+		}
+		if e.Known(LayerField) {
+			fields = append(fields, "layer", e.Layer)
+		}
+		if e.Known(PeerField) {
+			fields = append(fields, "peer", e.Peer)
+		}
+		if e.Known(KeyField) {
+			fields = append(fields, "key", hex.EncodeToString(e.Key))
+		}
+		if e.Cause != nil {
+			fields = append(fields, "cause", e.Cause.Error())
+		}
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if !hasField(fields, kv[i]) {
+			fields = append(fields, kv[i], kv[i+1])
+		}
+	}
+	return fields
+}
+
+func hasField(fields []interface{}, key interface{}) bool {
+	for i := 0; i < len(fields); i += 2 {
+		if fields[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func dumpGoroutines(e error) {
 	f, err := os.Create(fmt.Sprintf("error%s.log", Addr))
-	defer f.Close()
-	if err == nil {
-		b := bufio.NewWriter(f)
-		pprof.Lookup("goroutine").WriteTo(b, 1)
-		b.WriteString(fmt.Sprintf("%v", e))
-		b.Flush()
+	if err != nil {
+		logger.Warn("could not write goroutine dump", "error", err)
+		return
 	}
+	defer f.Close()
+	b := bufio.NewWriter(f)
+	pprof.Lookup("goroutine").WriteTo(b, 1)
+	b.WriteString(fmt.Sprintf("%v", e))
+	b.Flush()
+}
+
+// wrap errors from other sources
+func NetworkError(cause error) error {
+	e := newError(ErrNetwork)
+	e.Cause = cause
+	return e
 }
 
-// wrap errors from other sources with stack
-func NetworkError(err error) error {
-	LogError(err)
-	return err
+// the round cannot continue
+func RoundAborted(cause error) *Error {
+	e := newError(ErrRoundAborted)
+	e.Cause = cause
+	return e
 }
 
-func err(reason string) error {
-	err := errors.New(reason)
-	LogError(err)
-	return err
+// errors are logged where they are handled, after their details are attached
+func err(kind *Kind) *Error {
+	return newError(kind)
 }
 
-func UnimplementedError() error   { return err("Function not implemented") }
-func UnrecognizedError() error    { return err("Message type not recognized") }
-func SignatureError() error       { return err("Invalid message signature") }
-func LengthInvalidError() error   { return err("Message length invalid") }
-func BadElementError() error      { return err("Element is not on curve") }
-func GroupAgreementError() error  { return err("Anytrust group disagrees") }
-func ClientNotFoundError() error  { return err("Client not found") }
-func KeyNotFound() error          { return err("Key not found") }
-func Duplicate() error            { return err("Duplicate message") }
-func MissingMessages() error      { return err("Messages are missing") }
-func BadMetadataError() error     { return err("Metadata does not match") }
-func WrongServerError() error     { return err("Message sent to wrong server") }
-func DecryptionFailure() error    { return err("Unable to decrypt message") }
-func ProofFailure() error         { return err("Proof failure") }
-func TokenInvalid() error         { return err("Token invalid") }
-func CommitFailure() error        { return err("Commitment invalid") }
-func WrongReceipt() error         { return err("Receipt incorrect") }
-func LinkOverflow() error         { return err("Link overflow") }
-func SynchronizationError() error { return err("Multiple messages from same server") }
+func UnimplementedError() *Error   { return err(ErrUnimplemented) }
+func UnrecognizedError() *Error    { return err(ErrUnrecognized) }
+func SignatureError() *Error       { return err(ErrSignature) }
+func LengthInvalidError() *Error   { return err(ErrLengthInvalid) }
+func BadElementError() *Error      { return err(ErrBadElement) }
+func GroupAgreementError() *Error  { return err(ErrGroupAgreement) }
+func ClientNotFoundError() *Error  { return err(ErrClientNotFound) }
+func KeyNotFound() *Error          { return err(ErrKeyNotFound) }
+func Duplicate() *Error            { return err(ErrDuplicate) }
+func MissingMessages() *Error      { return err(ErrMissingMessages) }
+func BadMetadataError() *Error     { return err(ErrBadMetadata) }
+func WrongServerError() *Error     { return err(ErrWrongServer) }
+func DecryptionFailure() *Error    { return err(ErrDecryption) }
+func ProofFailure() *Error         { return err(ErrProof) }
+func TokenInvalid() *Error         { return err(ErrTokenInvalid) }
+func CommitFailure() *Error        { return err(ErrCommit) }
+func WrongReceipt() *Error         { return err(ErrWrongReceipt) }
+func LinkOverflow() *Error         { return err(ErrLinkOverflow) }
+func SynchronizationError() *Error { return err(ErrSynchronization) }
+func AuthenticationError() *Error  { return err(ErrAuthentication) }
+func QuotaExceeded() *Error        { return err(ErrQuotaExceeded) }
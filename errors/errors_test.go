@@ -1,10 +1,102 @@
 package errors
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
 	"testing"
+
+	"github.com/simonlangowski/lightning1/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestErrorPrinting(t *testing.T) {
-	log.Print(UnimplementedError())
+	b := &bytes.Buffer{}
+	logging.SetOutput(b)
+	defer logging.SetOutput(os.Stderr)
+	err := Duplicate()
+	if b.Len() != 0 {
+		t.Fatalf("Logged before the details were attached %s", b.String())
+	}
+	// details attached after construction are in the record, the caller's round is not repeated
+	err.At(3, 1).From(7)
+	Log(logging.For("test"), logging.Warn, "dropped envelope", err, "round", 9, "sender", 2)
+	line := map[string]interface{}{}
+	if e := json.Unmarshal(b.Bytes(), &line); e != nil {
+		t.Fatal(e)
+	}
+	if line["level"] != "warn" || line["component"] != "test" || line["msg"] != "dropped envelope" ||
+		line["kind"] != "Duplicate message" || line["round"] != 3.0 || line["layer"] != 1.0 ||
+		line["peer"] != 7.0 || line["sender"] != 2.0 {
+		t.Fatalf("Wrong log line %s", b.String())
+	}
+}
+
+func TestRepeatedErrors(t *testing.T) {
+	logging.SetOutput(&bytes.Buffer{})
+	defer logging.SetOutput(os.Stderr)
+	Addr = "Test"
+	DumpOnError = true
+	defer func() { DumpOnError = false }()
+	defer os.Remove("errorTest.log")
+	log := logging.For("test")
+	// later errors must not block
+	for i := 0; i < 3; i++ {
+		Log(log, logging.Error, "failed", Duplicate())
+	}
+	dump, err := os.ReadFile("errorTest.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(dump, []byte("goroutine")) || !bytes.HasSuffix(dump, []byte(fmt.Sprint(Duplicate()))) {
+		t.Fatalf("Wrong dump %s", dump)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	logging.SetOutput(&bytes.Buffer{})
+	defer logging.SetOutput(os.Stderr)
+	key := []byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	var err error = Duplicate().At(3, 1).From(7).WithKey(key)
+	if !Is(err, ErrDuplicate) || Is(err, ErrSignature) {
+		t.Fatal("Wrong kind")
+	}
+	wrapped := fmt.Errorf("processing: %w", err)
+	e := &Error{}
+	if !As(wrapped, &e) || e.Round != 3 || e.Layer != 1 || e.Peer != 7 || !bytes.Equal(e.Key, key) {
+		t.Fatalf("Lost details %v", e)
+	}
+	if e.Known(KeyField|PeerField) == false || SignatureError().Known(PeerField) {
+		t.Fatal("Wrong known fields")
+	}
+	if err.Error() != "Duplicate message (round 3, layer 1, peer 7, key deadbeef01020304)" {
+		t.Fatalf("Wrong message %s", err.Error())
+	}
+	if Code(wrapped) != codes.AlreadyExists || status.Code(err) != codes.AlreadyExists {
+		t.Fatal("Wrong code")
+	}
+
+	cause := fmt.Errorf("connection reset")
+	network := NetworkError(cause)
+	if !Is(network, ErrNetwork) || !Is(network, cause) || Code(network) != codes.Unavailable {
+		t.Fatalf("Wrong network error %v", network)
+	}
+}
+
+func TestFromRPC(t *testing.T) {
+	logging.SetOutput(&bytes.Buffer{})
+	defer logging.SetOutput(os.Stderr)
+	// what a client sees after the server returns the error
+	sent := TokenInvalid().At(2, 0).From(5)
+	received := status.Error(status.Code(sent), sent.Error())
+	err := FromRPC(received)
+	if !Is(err, ErrTokenInvalid) || Code(err) != codes.PermissionDenied {
+		t.Fatalf("Lost kind %v", err)
+	}
+	other := status.Error(codes.ResourceExhausted, "over rate")
+	if FromRPC(other) != other || FromRPC(nil) != nil {
+		t.Fatal("Changed an error of unknown kind")
+	}
 }
@@ -0,0 +1,195 @@
+package errors
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A kind of failure, compare with Is(err, ErrDuplicate)
+type Kind struct {
+	reason string
+	code   codes.Code
+}
+
+func (k *Kind) Error() string {
+	return k.reason
+}
+
+// the grpc status code returned to callers
+func (k *Kind) Code() codes.Code {
+	return k.code
+}
+
+var (
+	ErrUnimplemented   = &Kind{"Function not implemented", codes.Unimplemented}
+	ErrUnrecognized    = &Kind{"Message type not recognized", codes.InvalidArgument}
+	ErrSignature       = &Kind{"Invalid message signature", codes.Unauthenticated}
+	ErrLengthInvalid   = &Kind{"Message length invalid", codes.InvalidArgument}
+	ErrBadElement      = &Kind{"Element is not on curve", codes.InvalidArgument}
+	ErrGroupAgreement  = &Kind{"Anytrust group disagrees", codes.Aborted}
+	ErrClientNotFound  = &Kind{"Client not found", codes.NotFound}
+	ErrKeyNotFound     = &Kind{"Key not found", codes.NotFound}
+	ErrDuplicate       = &Kind{"Duplicate message", codes.AlreadyExists}
+	ErrMissingMessages = &Kind{"Messages are missing", codes.Aborted}
+	ErrBadMetadata     = &Kind{"Metadata does not match", codes.InvalidArgument}
+	ErrWrongServer     = &Kind{"Message sent to wrong server", codes.FailedPrecondition}
+	ErrDecryption      = &Kind{"Unable to decrypt message", codes.InvalidArgument}
+	ErrProof           = &Kind{"Proof failure", codes.InvalidArgument}
+	ErrTokenInvalid    = &Kind{"Token invalid", codes.PermissionDenied}
+	ErrCommit          = &Kind{"Commitment invalid", codes.InvalidArgument}
+	ErrWrongReceipt    = &Kind{"Receipt incorrect", codes.DataLoss}
+	ErrLinkOverflow    = &Kind{"Link overflow", codes.ResourceExhausted}
+	ErrSynchronization = &Kind{"Multiple messages from same server", codes.FailedPrecondition}
+	ErrAuthentication  = &Kind{"Peer is not the server it claims to be", codes.Unauthenticated}
+	ErrQuotaExceeded   = &Kind{"Posting quota exceeded", codes.ResourceExhausted}
+	ErrNetwork         = &Kind{"Network failure", codes.Unavailable}
+	ErrRoundAborted    = &Kind{"Round aborted", codes.Aborted}
+)
+
+var kinds = []*Kind{
+	ErrUnimplemented, ErrUnrecognized, ErrSignature, ErrLengthInvalid, ErrBadElement,
+	ErrGroupAgreement, ErrClientNotFound, ErrKeyNotFound, ErrDuplicate, ErrMissingMessages,
+	ErrBadMetadata, ErrWrongServer, ErrDecryption, ErrProof, ErrTokenInvalid, ErrCommit,
+	ErrWrongReceipt, ErrLinkOverflow, ErrSynchronization, ErrAuthentication, ErrQuotaExceeded,
+	ErrNetwork, ErrRoundAborted,
+}
+
+type Field int
+
+const (
+	RoundField Field = 1 << iota
+	LayerField
+	PeerField
+	KeyField
+)
+
+// A failure and where it happened
+// the peer is the server or client that sent the offending message
+type Error struct {
+	Kind  *Kind
+	Round int
+	Layer int
+	Peer  int
+	Key   []byte
+	// underlying error, e.g. from the network
+	Cause error
+	known Field
+}
+
+func newError(kind *Kind) *Error {
+	return &Error{Kind: kind}
+}
+
+func (e *Error) InRound(round int) *Error {
+	e.Round = round
+	e.known |= RoundField
+	return e
+}
+
+func (e *Error) At(round, layer int) *Error {
+	e.Round, e.Layer = round, layer
+	e.known |= RoundField | LayerField
+	return e
+}
+
+func (e *Error) From(peer int) *Error {
+	e.Peer = peer
+	e.known |= PeerField
+	return e
+}
+
+func (e *Error) WithKey(key []byte) *Error {
+	e.Key = key
+	e.known |= KeyField
+	return e
+}
+
+func (e *Error) Known(f Field) bool {
+	return e.known&f == f
+}
+
+func (e *Error) Error() string {
+	details := make([]string, 0, 4)
+	if e.Known(RoundField) {
+		details = append(details, fmt.Sprintf("round %d", e.Round))
+	}
+	if e.Known(LayerField) {
+		details = append(details, fmt.Sprintf("layer %d", e.Layer))
+	}
+	if e.Known(PeerField) {
+		details = append(details, fmt.Sprintf("peer %d", e.Peer))
+	}
+	if e.Known(KeyField) {
+		key := hex.EncodeToString(e.Key)
+		if len(key) > 16 {
+			key = key[:16]
+		}
+		details = append(details, "key "+key)
+	}
+	s := e.Kind.reason
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// used by grpc to send the error's code to the caller
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(e.Kind.code, e.Error())
+}
+
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
+func Unwrap(err error) error {
+	return errors.Unwrap(err)
+}
+
+// the grpc code for any error, Unknown if it has none
+func Code(err error) codes.Code {
+	e := &Error{}
+	if As(err, &e) {
+		return e.Kind.code
+	}
+	k := &Kind{}
+	if As(err, &k) {
+		return k.code
+	}
+	return status.Code(err)
+}
+
+// recover the kind of error returned by an rpc, so callers can use Is
+// errors of unknown kinds are returned unchanged
+func FromRPC(err error) error {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.OK {
+		return err
+	}
+	for _, k := range kinds {
+		if k.code == s.Code() && strings.HasPrefix(s.Message(), k.reason) {
+			return &Error{Kind: k, Cause: err}
+		}
+	}
+	return err
+}
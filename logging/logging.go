@@ -0,0 +1,219 @@
+package logging
+
+// Structured logging: one JSON object per line with a time, level, component and message
+// followed by the fields of the logger and the call, e.g. round, layer, sender, dest and type
+// Each component (server, network, client, ...) has its own level
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+	Off
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l Level) String() string {
+	if l < Debug || l > Off {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Off, fmt.Errorf("unknown log level %q", s)
+}
+
+var (
+	mu           sync.Mutex
+	out          io.Writer = os.Stderr
+	buf          *bufio.Writer
+	defaultLevel = Info
+	levels       = make(map[string]*int32)
+)
+
+type Logger struct {
+	component string
+	level     *int32
+	fields    []interface{}
+}
+
+// the logger for a component, which logs at the component's configured level
+func For(component string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return &Logger{component: component, level: componentLevel(component)}
+}
+
+func componentLevel(component string) *int32 {
+	l, ok := levels[component]
+	if !ok {
+		l = new(int32)
+		*l = int32(defaultLevel)
+		levels[component] = l
+	}
+	return l
+}
+
+// a logger that adds the key value pairs to every line
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{component: l.component, level: l.level, fields: fields}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(l.level))
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(Debug, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.Log(Info, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.Log(Warn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(Error, msg, kv...) }
+
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	b := &bytes.Buffer{}
+	b.WriteString(`{"time":`)
+	writeValue(b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeValue(b, level.String())
+	b.WriteString(`,"component":`)
+	writeValue(b, l.component)
+	b.WriteString(`,"msg":`)
+	writeValue(b, msg)
+	writeFields(b, l.fields)
+	writeFields(b, kv)
+	b.WriteString("}\n")
+
+	mu.Lock()
+	defer mu.Unlock()
+	out.Write(b.Bytes())
+}
+
+func writeFields(b *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		b.WriteByte(',')
+		writeValue(b, fmt.Sprint(kv[i]))
+		b.WriteByte(':')
+		if i+1 < len(kv) {
+			writeValue(b, kv[i+1])
+		} else {
+			b.WriteString("null")
+		}
+	}
+}
+
+func writeValue(b *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		// seconds, like the metrics
+		v = t.Seconds()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	e, err := json.Marshal(v)
+	if err != nil {
+		e, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(e)
+}
+
+// set the level of all components from a spec like "info" or "warn,network=debug,server=info"
+func Configure(spec string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component := ""
+		if i := strings.Index(part, "="); i >= 0 {
+			component, part = part[:i], part[i+1:]
+		}
+		level, err := ParseLevel(part)
+		if err != nil {
+			return err
+		}
+		if component == "" {
+			defaultLevel = level
+			for _, l := range levels {
+				atomic.StoreInt32(l, int32(level))
+			}
+		} else {
+			atomic.StoreInt32(componentLevel(component), int32(level))
+		}
+	}
+	return nil
+}
+
+// levels for the commands, e.g. LIGHTNING_LOG=warn,network=debug
+const LevelEnv = "LIGHTNING_LOG"
+
+// set DumpEnv to write a goroutine dump on the first error
+const DumpEnv = "LIGHTNING_DUMP_ON_ERROR"
+
+func ConfigureFromEnv() error {
+	return Configure(os.Getenv(LevelEnv))
+}
+
+func SetLevel(component string, level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	atomic.StoreInt32(componentLevel(component), int32(level))
+}
+
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+	buf = nil
+}
+
+// log to a buffered file, call Flush before exiting
+func OpenFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	buf = bufio.NewWriter(f)
+	out = buf
+	return nil
+}
+
+func Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+	if buf != nil {
+		buf.Flush()
+	}
+}
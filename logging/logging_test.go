@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capture(t *testing.T) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	SetOutput(b)
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		Configure("info")
+	})
+	return b
+}
+
+func lines(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	ls := make([]map[string]interface{}, 0)
+	for _, l := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("Invalid line %s: %v", l, err)
+		}
+		ls = append(ls, m)
+	}
+	return ls
+}
+
+func TestFields(t *testing.T) {
+	b := capture(t)
+	l := For("test").With("round", 2, "layer", 1)
+	l.Info("received", "sender", 3, "took", 1500*time.Millisecond, "error", errors.New("bad \"quote\""), "odd")
+	ls := lines(t, b)
+	if len(ls) != 1 {
+		t.Fatalf("Expected one line, got %s", b.String())
+	}
+	m := ls[0]
+	if m["level"] != "info" || m["component"] != "test" || m["msg"] != "received" {
+		t.Fatalf("Wrong header %v", m)
+	}
+	if m["round"] != 2.0 || m["layer"] != 1.0 || m["sender"] != 3.0 || m["took"] != 1.5 {
+		t.Fatalf("Wrong fields %v", m)
+	}
+	if m["error"] != `bad "quote"` || m["odd"] != nil {
+		t.Fatalf("Wrong fields %v", m)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m["time"].(string)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	b := capture(t)
+	network := For("network")
+	server := For("server")
+	err := Configure("warn,network=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.Debug("network debug")
+	server.Info("server info")
+	server.Warn("server warn")
+	// components created after configuring get the default level
+	For("client").Info("client info")
+	ls := lines(t, b)
+	if len(ls) != 2 || ls[0]["msg"] != "network debug" || ls[1]["msg"] != "server warn" {
+		t.Fatalf("Wrong lines %s", b.String())
+	}
+	if Configure("loud") == nil {
+		t.Fatal("Accepted unknown level")
+	}
+}
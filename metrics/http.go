@@ -0,0 +1,10 @@
+package metrics
+
+import (
+	"net/http"
+)
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
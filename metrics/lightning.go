@@ -0,0 +1,34 @@
+package metrics
+
+// Metrics recorded by servers and clients
+
+var (
+	LayerTime = NewSummaryVec("lightning_layer_seconds",
+		"Time from the start of a layer until all of its messages are processed", "round_type", "layer")
+	BytesSent = NewCounterVec("lightning_peer_sent_bytes_total",
+		"Bytes sent to each server over the tcp mesh", "peer")
+	BytesReceived = NewCounterVec("lightning_peer_received_bytes_total",
+		"Bytes received from each server over the tcp mesh", "peer")
+	EnvelopesDecrypted = NewCounter("lightning_envelopes_decrypted_total",
+		"Onion envelopes successfully decrypted")
+	Dummies = NewCounter("lightning_dummies_total",
+		"Dummy envelopes received and discarded")
+	LinkOverflows = NewCounter("lightning_link_overflows_total",
+		"Envelopes that did not fit in the bin for their next server")
+	TokensServed = NewCounter("lightning_token_requests_served_total",
+		"Blind token requests signed for clients")
+	TicketsServed = NewCounter("lightning_tickets_served_total",
+		"Blind posting tickets signed for clients")
+	TicketsSpent = NewCounterVec("lightning_tickets_spent_total",
+		"Posting tickets checked by anytrust groups, by result", "result")
+	PoolQueueDepth = NewGaugeFuncVec("lightning_worker_pool_queue_depth",
+		"Envelopes waiting for a worker", "server")
+	SyncWaitTime = NewSummary("lightning_synchronizer_wait_seconds",
+		"Time spent waiting for the synchronizer to reach a layer")
+	PendingRequests = NewGaugeFuncVec("lightning_pending_client_requests",
+		"Client requests admitted and waiting for a response", "server")
+	RejectedRequests = NewCounterVec("lightning_rejected_client_requests_total",
+		"Client requests rejected by admission control", "reason")
+	Submissions = NewCounterVec("lightning_client_submissions_total",
+		"Envelopes submitted by clients, by result", "result")
+)
@@ -0,0 +1,273 @@
+package metrics
+
+// Counters, gauges and summaries for monitoring live deployments
+// exported over http in the Prometheus text format
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type family interface {
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	names    []string
+	families map[string]family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// the registry served by Serve
+var Default = NewRegistry()
+
+func (r *Registry) register(name string, f family) family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.families[name]; ok {
+		return existing
+	}
+	r.names = append(r.names, name)
+	r.families[name] = f
+	return f
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+		f.write(w)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, t string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, t)
+}
+
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i := range names {
+		parts[i] = fmt.Sprintf("%s=%q", names[i], values[i])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// series of one metric, one for each combination of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newVec(name, help string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+	}
+}
+
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("%s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string{}, values...)
+	}
+	return s
+}
+
+func (v *vec) each(f func(values []string, s interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	v.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.mu.Lock()
+		s, values := v.series[k], v.values[k]
+		v.mu.Unlock()
+		f(values, s)
+	}
+}
+
+// a value that only increases, safe for concurrent use
+type Counter struct {
+	bits uint64
+}
+
+func (c *Counter) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&c.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+type CounterVec struct {
+	*vec
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return r.register(name, &CounterVec{newVec(name, help, labels)}).(*CounterVec)
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// the counter for these label values, callers on hot paths should keep it
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, values), formatValue(s.(*Counter).Value()))
+	})
+}
+
+// the count and total of observed values, e.g. durations in seconds
+type Summary struct {
+	mu    sync.Mutex
+	count uint64
+	sum   float64
+}
+
+func (s *Summary) Observe(v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.sum += v
+}
+
+func (s *Summary) ObserveDuration(d time.Duration) {
+	s.Observe(d.Seconds())
+}
+
+func (s *Summary) Value() (uint64, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.sum
+}
+
+type SummaryVec struct {
+	*vec
+}
+
+func (r *Registry) NewSummaryVec(name, help string, labels ...string) *SummaryVec {
+	return r.register(name, &SummaryVec{newVec(name, help, labels)}).(*SummaryVec)
+}
+
+func NewSummaryVec(name, help string, labels ...string) *SummaryVec {
+	return Default.NewSummaryVec(name, help, labels...)
+}
+
+func NewSummary(name, help string) *Summary {
+	return NewSummaryVec(name, help).With()
+}
+
+func (s *SummaryVec) With(values ...string) *Summary {
+	return s.get(values, func() interface{} { return &Summary{} }).(*Summary)
+}
+
+func (s *SummaryVec) write(w io.Writer) {
+	writeHeader(w, s.name, s.help, "summary")
+	s.each(func(values []string, series interface{}) {
+		count, sum := series.(*Summary).Value()
+		labels := labelString(s.labels, values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", s.name, labels, formatValue(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", s.name, labels, count)
+	})
+}
+
+// gauges read when metrics are collected, e.g. the length of a queue
+type GaugeFuncVec struct {
+	*vec
+}
+
+func (r *Registry) NewGaugeFuncVec(name, help string, labels ...string) *GaugeFuncVec {
+	return r.register(name, &GaugeFuncVec{newVec(name, help, labels)}).(*GaugeFuncVec)
+}
+
+func NewGaugeFuncVec(name, help string, labels ...string) *GaugeFuncVec {
+	return Default.NewGaugeFuncVec(name, help, labels...)
+}
+
+type gaugeFunc struct {
+	mu sync.Mutex
+	f  func() float64
+}
+
+// set the function for these label values, replacing any earlier one
+func (g *GaugeFuncVec) Set(f func() float64, values ...string) {
+	s := g.get(values, func() interface{} { return &gaugeFunc{} }).(*gaugeFunc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f = f
+}
+
+func (g *GaugeFuncVec) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	g.each(func(values []string, series interface{}) {
+		s := series.(*gaugeFunc)
+		s.mu.Lock()
+		f := s.f
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString(g.labels, values), formatValue(f()))
+	})
+}
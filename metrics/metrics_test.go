@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTextFormat(t *testing.T) {
+	r := NewRegistry()
+	sent := r.NewCounterVec("test_sent_bytes_total", "Bytes sent", "peer")
+	layer := r.NewSummaryVec("test_layer_seconds", "Layer time", "layer")
+	depth := r.NewGaugeFuncVec("test_queue_depth", "Queue depth", "server")
+
+	sent.With("1").Add(10)
+	sent.With("0").Add(2.5)
+	sent.With("1").Inc()
+	layer.With("0").ObserveDuration(1500 * time.Millisecond)
+	layer.With("0").Observe(0.5)
+	depth.Set(func() float64 { return 7 }, "3")
+
+	b := &bytes.Buffer{}
+	r.Write(b)
+	expected := `# HELP test_layer_seconds Layer time
+# TYPE test_layer_seconds summary
+test_layer_seconds_sum{layer="0"} 2
+test_layer_seconds_count{layer="0"} 2
+# HELP test_queue_depth Queue depth
+# TYPE test_queue_depth gauge
+test_queue_depth{server="3"} 7
+# HELP test_sent_bytes_total Bytes sent
+# TYPE test_sent_bytes_total counter
+test_sent_bytes_total{peer="0"} 2.5
+test_sent_bytes_total{peer="1"} 11
+`
+	if b.String() != expected {
+		t.Fatalf("Wrong output:\n%s", b.String())
+	}
+}
+
+func TestConcurrentCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.With().Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if c.With().Value() != 8000 {
+		t.Fatalf("Counted %v", c.With().Value())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_requests_total", "Requests", "code").With("200").Inc()
+	// registering again returns the same metric
+	r.NewCounterVec("test_requests_total", "Requests", "code").With("200").Inc()
+	server := httptest.NewServer(r)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("Wrong content type %s", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `test_requests_total{code="200"} 2`) {
+		t.Fatalf("Wrong body:\n%s", body)
+	}
+}
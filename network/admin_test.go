@@ -0,0 +1,57 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/admin"
+	"github.com/simonlangowski/lightning1/config"
+	coord "github.com/simonlangowski/lightning1/coordinator/messages"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type adminHandler struct {
+	coord.UnimplementedCoordinatorHandlerServer
+	admin.UnimplementedAdminServer
+}
+
+func (adminHandler) Status(context.Context, *admin.StatusRequest) (*admin.Status, error) {
+	return &admin.Status{Server: 3}, nil
+}
+
+func TestAdminListener(t *testing.T) {
+	cert, key := testCertificate("127.0.0.1")
+	addr := "127.0.0.1:25000"
+	cfgs := map[int64]*config.Server{3: {Id: 3, Address: addr, Identity: cert, PrivateIdentity: key}}
+	h := adminHandler{}
+	public := StartServer(nil, h, cfgs, addr)
+	defer public.Stop()
+	private := StartAdminServer(h, cfgs, addr)
+	defer private.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// clients of the public port cannot query the admin service
+	conns, err := GetConnections(cfgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conns[3].Close()
+	if _, err := admin.NewAdminClient(conns[3]).Status(ctx); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Admin service on the public port: %v", err)
+	}
+	adminConns, err := GetAdminConnections(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adminConns[3].Close()
+	s, err := admin.NewAdminClient(adminConns[3]).Status(ctx)
+	if err != nil || s.Server != 3 {
+		t.Fatalf("Wrong status %v: %v", s, err)
+	}
+	if config.AdminRPCAddress(addr) != "127.0.0.1:28000" {
+		t.Fatalf("Admin listens on %s", config.AdminRPCAddress(addr))
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"hash"
 	"io"
 	"net"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network/buffers"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/common"
@@ -49,6 +53,7 @@ func NewConnectionReader(numMessages, messageSize, baseBatchSize int, excludeDum
 }
 
 func (c *ConnectionReader) ContinuousReader(m *messages.Metadata) {
+	received := metrics.BytesReceived.With(strconv.Itoa(m.Sender))
 	for i := 0; i < c.numMessages; i += c.baseBatchSize {
 		baseBatchSize := c.baseBatchSize
 		if c.numMessages-i < c.baseBatchSize {
@@ -57,12 +62,15 @@ func (c *ConnectionReader) ContinuousReader(m *messages.Metadata) {
 		b := make([]byte, c.messageSize*baseBatchSize)
 		readStart := time.Now()
 		_, err := io.ReadFull(c.conn, b)
-		config.LogTime("Read: %v part %d in %v", m, i, time.Since(readStart))
+		if log.Enabled(logging.Debug) {
+			log.Debug("read batch", append(m.LogFields(), "part", i, "took", time.Since(readStart))...)
+		}
 		if err != nil {
 			c.Err = errors.NetworkError(err)
 			close(c.Buff)
 			return
 		} else {
+			received.Add(float64(len(b)))
 			for pos := 0; pos < len(b); pos += c.messageSize {
 				c.Buff <- b[pos : pos+c.messageSize]
 			}
@@ -100,8 +108,20 @@ func (c *ConnectionReader) CheckSignature(h hash.Hash, vk *crypto.ExpandedVerifi
 // 	}
 // }
 
+// writes to a destination are serialized so concurrent senders cannot interleave messages
 func (c *ConnectionManager) Send(b []byte, dest int) (chan error, error) {
-	err := send(c.OutgoingConnections[dest], b)
+	conn, lock := c.sendingTo(dest)
+	if conn == nil {
+		return nil, errors.WrongServerError().From(dest)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	err := send(conn, b)
+	if err == nil {
+		metrics.BytesSent.With(strconv.Itoa(dest)).Add(float64(len(b)))
+	} else {
+		c.setOutgoingLink(dest, LinkFailed)
+	}
 	return nil, err
 }
 
@@ -112,48 +132,66 @@ func (c *ConnectionManager) SendShuffleMessages(Messages map[int]*buffers.MemRea
 		Layer:       layer,
 		Sender:      common.MyId,
 		NumMessages: uint32(common.BinSize),
+		Trace:       common.Trace,
 	}
 	inProgress, err := c.SendSignedMessageChunks(&m, Messages, common)
 	go c.FinishSends(inProgress)
 	return err
 }
 
+// shuffle, sign and send to each destination, up to config.SendWorkers at a time
+// each destination is handled by a single worker, so its data is written in order
+// after an error no new destinations are started and the first error is returned
 func (c *ConnectionManager) SendSignedMessageChunks(m *messages.Metadata, Messages map[int]*buffers.MemReadWriter, common *common.CommonState) ([]chan error, error) {
-	// done := make(chan error)
+	return c.sendSignedMessageChunks(m, Messages, config.SendWorkers)
+}
+
+func (c *ConnectionManager) sendSignedMessageChunks(m *messages.Metadata, Messages map[int]*buffers.MemReadWriter, workers int) ([]chan error, error) {
 	jobs := c.caller.GetJobs()
-	// timeout := BandwidthTimeout(Messages[0].Len())
-	inProgress := make([]chan error, len(Messages))
-	// for i := 0; i < numWorkers; i++ {
-	// 	go func() {
-	for {
-		sid, ok := <-jobs
-		if !ok {
-			break
-		}
-		f := Messages[sid]
-		f.Shuffle(!config.NoDummies)
-		sm := messages.NewSignedMessage(f.Len(), m.Round, m.Layer, m.Sender, 0, sid, f.NumMessages(), m.Type)
-		r, err := f.ReadNextChunk(sm.Data)
-		if err != nil {
-			return inProgress, err //done <- err
-		}
-		sm.Data = sm.Data[:r]
-		PreHashSign(c.MyCfg.SignatureKey, sm)
-		inProgress[sid], err = c.Send(sm.AsArray(), sid)
-		if err != nil {
-			return inProgress, err //done <- err
+	inProgress := make([]chan error, c.idSpace())
+	if len(jobs) < workers {
+		workers = len(jobs)
+	}
+	var failed int32
+	done := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for sid := range jobs {
+				if atomic.LoadInt32(&failed) != 0 {
+					break
+				}
+				var err error
+				inProgress[sid], err = c.sendSignedMessageChunk(m, Messages[sid], sid)
+				if err != nil {
+					atomic.StoreInt32(&failed, 1)
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	var err error
+	for i := 0; i < workers; i++ {
+		e := <-done
+		if e != nil && err == nil {
+			err = e
 		}
 	}
-	// 		done <- nil
-	// 	}()
-	// }
-	// for i := 0; i < numWorkers; i++ {
-	// 	err := <-done
-	// 	if err != nil {
-	// 		return inProgress, err
-	// 	}
-	// }
-	return inProgress, nil
+	return inProgress, err
+}
+
+func (c *ConnectionManager) sendSignedMessageChunk(m *messages.Metadata, f *buffers.MemReadWriter, sid int) (chan error, error) {
+	f.Shuffle(!config.NoDummies)
+	sm := messages.NewSignedMessage(f.Len(), m.Round, m.Layer, m.Sender, 0, sid, f.NumMessages(), m.Type)
+	sm.Trace = m.Trace
+	r, err := f.ReadNextChunk(sm.Data)
+	if err != nil {
+		return nil, err
+	}
+	sm.Data = sm.Data[:r]
+	PreHashSign(c.MyCfg.SignatureKey, sm)
+	return c.Send(sm.AsArray(), sid)
 }
 
 // ensure all writes have finished
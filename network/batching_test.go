@@ -0,0 +1,228 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/network/buffers"
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+// a sender (server 0) connected over the mock network to numServers peers
+// returns the connection each peer reads from
+func newTestConnectionManager(numServers int) (*ConnectionManager, []net.Conn, crypto.VerificationKey) {
+	ver, sign := crypto.NewSigningKeyPair()
+	cfgs := make(map[int64]*config.Server)
+	for i := 0; i < numServers; i++ {
+		cfgs[int64(i)] = &config.Server{
+			Id:           int64(i),
+			Address:      fmt.Sprintf("127.0.0.1:%d", 9000+100*i),
+			SignatureKey: sign,
+		}
+	}
+	mock := NewMockConnNetwork()
+	newManager := func(id int) *ConnectionManager {
+		return &ConnectionManager{
+			Mock:                mock,
+			configs:             cfgs,
+			MyCfg:               cfgs[int64(id)],
+			OutgoingConnections: make([]net.Conn, numServers),
+			IncomingConnections: make([]net.Conn, numServers),
+			locks:               make([]sync.Mutex, numServers),
+		}
+	}
+	c := newManager(0)
+	sorted := make([]int, numServers)
+	for i := range sorted {
+		sorted[i] = i
+	}
+	c.SetCaller(&Caller{ServersSortedByLatency: sorted})
+
+	peers := make([]net.Conn, numServers)
+	for sid := 0; sid < numServers; sid++ {
+		var err error
+		peers[sid], err = newManager(sid).Accept(0)
+		if err != nil {
+			panic(err)
+		}
+		c.OutgoingConnections[sid], err = c.Connect(sid)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return c, peers, ver
+}
+
+func newTestMessages(numServers, binSize, messageSize int) (map[int]*buffers.MemReadWriter, map[int][][]byte) {
+	Messages := make(map[int]*buffers.MemReadWriter)
+	written := make(map[int][][]byte)
+	for sid := 0; sid < numServers; sid++ {
+		f := buffers.NewMemReadWriter(messageSize, binSize, config.NewPRGShuffler(rand.Reader))
+		for i := 0; i < binSize; i++ {
+			b := make([]byte, messageSize)
+			rand.Read(b)
+			f.Write(b)
+			written[sid] = append(written[sid], b)
+		}
+		Messages[sid] = f
+	}
+	return Messages, written
+}
+
+func sortedElements(data []byte, size int) [][]byte {
+	elements := make([][]byte, 0, len(data)/size)
+	for pos := 0; pos < len(data); pos += size {
+		elements = append(elements, data[pos:pos+size])
+	}
+	sort.Slice(elements, func(i, j int) bool { return bytes.Compare(elements[i], elements[j]) < 0 })
+	return elements
+}
+
+func pending(conn net.Conn) int {
+	in := conn.(*MockConn).in
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.buffer.Len()
+}
+
+func TestSendSignedMessageChunks(t *testing.T) {
+	numServers, binSize, messageSize := 6, 5, 48
+	c, peers, ver := newTestConnectionManager(numServers)
+	vk, err := ver.ExpandKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Messages, written := newTestMessages(numServers, binSize, messageSize)
+	m := &messages.Metadata{Type: messages.NetworkMessage_ServerMessageForward, Round: 2, Layer: 3}
+	m.Trace.TraceID[0], m.Trace.SpanID[7] = 1, 2
+	_, err = c.sendSignedMessageChunks(m, Messages, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length := messages.Metadata_size + binSize*messageSize + crypto.SIGNATURE_SIZE
+	for sid, conn := range peers {
+		raw := make([]byte, length)
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			t.Fatal(err)
+		}
+		if n := pending(conn); n != 0 {
+			t.Fatalf("%d extra bytes sent to %d", n, sid)
+		}
+		received := &messages.Metadata{}
+		received.InterpretFrom(raw)
+		if received.Dest != sid || received.Layer != m.Layer || received.Round != m.Round || received.NumMessages != uint32(binSize) || received.Trace != m.Trace {
+			t.Fatalf("Wrong metadata for %d: %v", sid, received)
+		}
+		h := sha512.New()
+		h.Write(raw[:length-crypto.SIGNATURE_SIZE])
+		if !crypto.PreHashVerify(h, vk, raw[length-crypto.SIGNATURE_SIZE:]) {
+			t.Fatalf("Bad signature for %d", sid)
+		}
+		data := sortedElements(raw[messages.Metadata_size:length-crypto.SIGNATURE_SIZE], messageSize)
+		expected := sortedElements(bytes.Join(written[sid], nil), messageSize)
+		for i := range expected {
+			if !bytes.Equal(data[i], expected[i]) {
+				t.Fatalf("Wrong messages sent to %d", sid)
+			}
+		}
+	}
+}
+
+var errTestWrite = errors.New("write failed")
+
+type failingConn struct {
+	net.Conn
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	return 0, errTestWrite
+}
+
+func TestSendSignedMessageChunksError(t *testing.T) {
+	numServers := 4
+	c, peers, _ := newTestConnectionManager(numServers)
+	// jobs are taken longest latency first, so this is the first destination
+	failing := c.caller.ServersSortedByLatency[numServers-1]
+	c.OutgoingConnections[failing] = &failingConn{c.OutgoingConnections[failing]}
+	m := &messages.Metadata{Type: messages.NetworkMessage_ServerMessageForward}
+
+	Messages, _ := newTestMessages(numServers, 2, 16)
+	_, err := c.SendSignedMessageChunks(m, Messages, nil)
+	if err != errTestWrite {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+
+	// a single worker stops at the failed destination
+	for _, conn := range peers {
+		io.ReadFull(conn, make([]byte, pending(conn)))
+	}
+	Messages, _ = newTestMessages(numServers, 2, 16)
+	_, err = c.sendSignedMessageChunks(m, Messages, 1)
+	if err != errTestWrite {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+	for sid, conn := range peers {
+		if n := pending(conn); n != 0 {
+			t.Fatalf("Sent %d bytes to %d after an error", n, sid)
+		}
+	}
+}
+
+// writes no faster than the configured bandwidth
+type bandwidthConn struct {
+	net.Conn
+}
+
+func (c *bandwidthConn) Write(b []byte) (int, error) {
+	time.Sleep(BandwidthTimeout(len(b)))
+	return c.Conn.Write(b)
+}
+
+// time until every peer has received a layer, serially and with the worker pool
+func BenchmarkSendSignedMessageChunks(b *testing.B) {
+	binSize, messageSize := 256, 1024
+	length := messages.Metadata_size + binSize*messageSize + crypto.SIGNATURE_SIZE
+	for _, numServers := range []int{2, 4, 8, 16, 32} {
+		c, peers, _ := newTestConnectionManager(numServers)
+		for sid := range c.OutgoingConnections {
+			c.OutgoingConnections[sid] = &bandwidthConn{c.OutgoingConnections[sid]}
+		}
+		for _, workers := range []int{1, config.SendWorkers} {
+			b.Run(fmt.Sprintf("servers=%d/workers=%d", numServers, workers), func(b *testing.B) {
+				m := &messages.Metadata{Type: messages.NetworkMessage_ServerMessageForward}
+				b.SetBytes(int64(numServers * length))
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					Messages, _ := newTestMessages(numServers, binSize, messageSize)
+					m.Layer = i
+					b.StartTimer()
+					wg := sync.WaitGroup{}
+					for _, conn := range peers {
+						wg.Add(1)
+						go func(conn net.Conn) {
+							defer wg.Done()
+							io.ReadFull(conn, make([]byte, length))
+						}(conn)
+					}
+					_, err := c.sendSignedMessageChunks(m, Messages, workers)
+					if err != nil {
+						b.Fatal(err)
+					}
+					wg.Wait()
+				}
+			})
+		}
+	}
+}
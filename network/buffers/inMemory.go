@@ -5,6 +5,7 @@ import (
 
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 )
 
 // If I had a read/write mmap I could just map anonymous for in memory and map file otherwise
@@ -53,6 +54,7 @@ func (m *MemReadWriter) Write(b []byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.elementCount >= len(m.data) {
+		metrics.LinkOverflows.Inc()
 		return errors.LinkOverflow()
 	}
 	m.data[m.elementCount] = b
@@ -4,38 +4,46 @@ package network
 
 import (
 	"crypto/tls"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/simonlangowski/lightning1/admin"
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
+var log = logging.For("network")
+
 func RunServer(handler messages.MessageHandlersServer, coordHandler coord.CoordinatorHandlerServer, servercfgs map[int64]*config.Server, addr string) {
 	server := StartServer(handler, coordHandler, servercfgs, addr)
+	// servers also answer status queries, on a listener of their own
+	var adminServer *grpc.Server
+	if a, ok := coordHandler.(admin.AdminServer); ok {
+		adminServer = StartAdminServer(a, servercfgs, addr)
+	}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
 	server.Stop()
-	log.Printf("Server %v stopped", addr)
+	if adminServer != nil {
+		adminServer.Stop()
+	}
+	log.Info("server stopped", "addr", addr)
 }
 
 func StartServer(handler messages.MessageHandlersServer, coordHandler coord.CoordinatorHandlerServer, servercfgs map[int64]*config.Server, addr string) *grpc.Server {
-	id, myCfg := FindConfig(addr, servercfgs)
-	if id < 0 {
-		panic("Could not find " + addr)
-	}
-	cert, err := tls.X509KeyPair(myCfg.Identity, myCfg.PrivateIdentity)
-	if err != nil {
-		panic(err)
-	}
-	cred := credentials.NewServerTLSFromCert(&cert)
+	// servers authenticate to each other with their certificates, clients do not have one
+	cred := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCertificate(servercfgs, addr)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ServerCertPool(servercfgs),
+	})
 	grpcServer := grpc.NewServer(grpc.Creds(cred),
 		grpc.MaxRecvMsgSize(2*config.StreamSize), grpc.MaxSendMsgSize(2*config.StreamSize))
 	if handler != nil {
@@ -44,19 +52,50 @@ func StartServer(handler messages.MessageHandlersServer, coordHandler coord.Coor
 	if coordHandler != nil {
 		coord.RegisterCoordinatorHandlerServer(grpcServer, coordHandler)
 	}
-	lis, err := net.Listen("tcp", config.Port(addr))
+	registerHealth(grpcServer, coordHandler)
+	serve(grpcServer, config.Port(addr), addr)
+	log.Info("server started", "addr", addr)
+	return grpcServer
+}
+
+// the admin service is not on the public port, it listens on the admin host (loopback by default)
+func StartAdminServer(a admin.AdminServer, servercfgs map[int64]*config.Server, addr string) *grpc.Server {
+	cred := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCertificate(servercfgs, addr)},
+	})
+	grpcServer := grpc.NewServer(grpc.Creds(cred))
+	admin.RegisterAdminServer(grpcServer, a)
+	adminAddr := config.AdminRPCAddress(addr)
+	serve(grpcServer, adminAddr, addr)
+	log.Info("admin server started", "addr", adminAddr)
+	return grpcServer
+}
+
+func serverCertificate(servercfgs map[int64]*config.Server, addr string) tls.Certificate {
+	id, myCfg := FindConfig(addr, servercfgs)
+	if id < 0 {
+		panic("Could not find " + addr)
+	}
+	cert, err := tls.X509KeyPair(myCfg.Identity, myCfg.PrivateIdentity)
 	if err != nil {
-		log.Fatal("Could not listen:", addr, err)
+		panic(err)
 	}
+	return cert
+}
 
+func serve(grpcServer *grpc.Server, listenAddr, addr string) {
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Error("could not listen", "addr", listenAddr, "error", err)
+		os.Exit(1)
+	}
 	go func() {
 		err := grpcServer.Serve(lis)
 		if err != nil && err != grpc.ErrServerStopped {
-			log.Fatal("Serve err:", err)
+			log.Error("serve failed", "addr", addr, "error", err)
+			os.Exit(1)
 		}
 	}()
-	log.Printf("Server %v started", addr)
-	return grpcServer
 }
 
 func FindConfig(addr string, servercfgs map[int64]*config.Server) (int64, *config.Server) {
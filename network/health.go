@@ -0,0 +1,67 @@
+package network
+
+import (
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Standard grpc health checks, e.g. for kubernetes grpc probes or grpc_health_probe
+// a server is live unless it is stuck in a layer, and ready once its keys are set
+// and its links with every other server are up
+// the empty service name reports readiness
+
+const (
+	LiveService  = "lightning.Live"
+	ReadyService = "lightning.Ready"
+)
+
+type Health struct {
+	Live  bool
+	Ready bool
+	// why the server is not ready
+	Reason string
+}
+
+type HealthReporter interface {
+	Health() Health
+}
+
+func registerHealth(grpcServer *grpc.Server, handler interface{}) {
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, hs)
+	r, ok := handler.(HealthReporter)
+	if !ok {
+		// nothing more to check than that it answers
+		reportHealth(hs, Health{Live: true, Ready: true})
+		return
+	}
+	go func() {
+		last := Health{Live: true, Ready: true}
+		for {
+			h := r.Health()
+			if h != last {
+				log.Info("health changed", "live", h.Live, "ready", h.Ready, "reason", h.Reason)
+				last = h
+			}
+			reportHealth(hs, h)
+			time.Sleep(config.HealthInterval * time.Millisecond)
+		}
+	}()
+}
+
+func servingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+func reportHealth(hs *health.Server, h Health) {
+	hs.SetServingStatus(LiveService, servingStatus(h.Live))
+	hs.SetServingStatus(ReadyService, servingStatus(h.Ready))
+	hs.SetServingStatus("", servingStatus(h.Ready))
+}
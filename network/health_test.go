@@ -0,0 +1,53 @@
+package network
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestReportHealth(t *testing.T) {
+	hs := health.NewServer()
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	reportHealth(hs, Health{Live: true, Reason: "no keys"})
+	if check(LiveService) != healthpb.HealthCheckResponse_SERVING || check(ReadyService) != healthpb.HealthCheckResponse_NOT_SERVING || check("") != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatal("Wrong status before ready")
+	}
+	reportHealth(hs, Health{Live: true, Ready: true})
+	if check(ReadyService) != healthpb.HealthCheckResponse_SERVING || check("") != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("Not ready")
+	}
+	reportHealth(hs, Health{Reason: "stuck"})
+	if check(LiveService) != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatal("Stuck server is live")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	c := &ConnectionManager{incoming: make([]int32, 2), outgoing: make([]int32, 2)}
+	c.setLink(c.incoming, 0, LinkUp)
+	c.setLink(c.outgoing, 0, LinkUp)
+	c.setLink(c.incoming, 1, LinkUp)
+	if c.AllLinksUp() {
+		t.Fatal("Link to 1 is down")
+	}
+	c.setLink(c.outgoing, 1, LinkUp)
+	if !c.AllLinksUp() {
+		t.Fatal("All links are up")
+	}
+	c.setLink(c.outgoing, 1, LinkFailed)
+	if in, out := c.Links(1); in != LinkUp || out != LinkFailed {
+		t.Fatalf("Wrong link state %v %v", in, out)
+	}
+	if in, _ := c.Links(5); in != LinkDown {
+		t.Fatal("Unknown server is not down")
+	}
+}
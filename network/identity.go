@@ -0,0 +1,96 @@
+package network
+
+// Bind TLS certificates to server ids
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// the DER encoding of the first certificate in a PEM identity
+func certificateDER(identity []byte) []byte {
+	for {
+		var block *pem.Block
+		block, identity = pem.Decode(identity)
+		if block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			return block.Bytes
+		}
+	}
+}
+
+// find the server configured with exactly this certificate
+func IdentifyCertificate(cert *x509.Certificate, configs map[int64]*config.Server) (int, bool) {
+	for id, cfg := range configs {
+		der := certificateDER(cfg.Identity)
+		if der != nil && bytes.Equal(der, cert.Raw) {
+			return int(id), true
+		}
+	}
+	return -1, false
+}
+
+// all server certificates, to verify peers against
+func ServerCertPool(configs map[int64]*config.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cfg := range configs {
+		if !pool.AppendCertsFromPEM(cfg.Identity) {
+			panic("Could not create cert pool for TLS connection")
+		}
+	}
+	return pool
+}
+
+// the server that authenticated on the other end of a connection
+// mock connections are in process and are trusted
+func (c *ConnectionManager) PeerId(conn net.Conn) (int, error) {
+	switch conn := conn.(type) {
+	case *MockConn:
+		return conn.s1, nil
+	case *tls.Conn:
+		err := conn.Handshake()
+		if err != nil {
+			return -1, err
+		}
+		certs := conn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			return -1, errors.AuthenticationError()
+		}
+		id, ok := IdentifyCertificate(certs[0], c.configs)
+		if !ok {
+			return -1, errors.AuthenticationError()
+		}
+		return id, nil
+	default:
+		return -1, errors.AuthenticationError()
+	}
+}
+
+// the server that made a grpc call, from its client certificate
+// calls from the mock caller have no peer and are trusted
+func CallerId(ctx context.Context, configs map[int64]*config.Server) (int, bool, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return -1, false, nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return -1, true, errors.AuthenticationError()
+	}
+	id, ok := IdentifyCertificate(info.State.PeerCertificates[0], configs)
+	if !ok {
+		return -1, true, errors.AuthenticationError()
+	}
+	return id, true, nil
+}
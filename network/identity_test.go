@@ -0,0 +1,153 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+func testCertificate(ip string) ([]byte, []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	name := pkix.Name{CommonName: ip}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Issuer:                name,
+		Subject:               name,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP(ip)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		panic(err)
+	}
+	key, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+}
+
+func newTLSManagers(numServers, basePort int) []*ConnectionManager {
+	cfgs := make(map[int64]*config.Server)
+	for i := 0; i < numServers; i++ {
+		cert, key := testCertificate("127.0.0.1")
+		cfgs[int64(i)] = &config.Server{
+			Id:              int64(i),
+			Address:         fmt.Sprintf("127.0.0.1:%d", basePort+10*i),
+			Identity:        cert,
+			PrivateIdentity: key,
+		}
+	}
+	managers := make([]*ConnectionManager, numServers)
+	for i := range managers {
+		managers[i] = &ConnectionManager{
+			configs:             cfgs,
+			MyCfg:               cfgs[int64(i)],
+			OutgoingConnections: make([]net.Conn, numServers),
+			IncomingConnections: make([]net.Conn, numServers),
+			locks:               make([]sync.Mutex, numServers),
+		}
+	}
+	return managers
+}
+
+func TestTLSPeerIdentity(t *testing.T) {
+	managers := newTLSManagers(3, 23000)
+	accepted := make(chan error)
+	go func() {
+		conn, err := managers[0].Accept(1)
+		managers[0].IncomingConnections[1] = conn
+		accepted <- err
+	}()
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		conn, err = managers[1].Connect(0)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+	id, err := managers[1].PeerId(conn)
+	if err != nil || id != 0 {
+		t.Fatalf("Server identified as %d: %v", id, err)
+	}
+	id, err = managers[0].PeerId(managers[0].IncomingConnections[1])
+	if err != nil || id != 1 {
+		t.Fatalf("Client identified as %d: %v", id, err)
+	}
+
+	m := messages.Metadata{Sender: 1}
+	b := make([]byte, messages.Metadata_size)
+	m.PackTo(b)
+	send(conn, b)
+	if _, _, err := managers[0].ReadMetadata(1); err != nil {
+		t.Fatal(err)
+	}
+	// server 1 cannot claim to be server 2
+	m.Sender = 2
+	m.PackTo(b)
+	send(conn, b)
+	if _, _, err := managers[0].ReadMetadata(1); err == nil {
+		t.Fatal("Accepted a message from the wrong sender")
+	}
+}
+
+func TestTLSImpersonation(t *testing.T) {
+	managers := newTLSManagers(3, 24000)
+	accepted := make(chan error)
+	go func() {
+		_, err := managers[0].Accept(1)
+		accepted <- err
+	}()
+	// server 2 connects to the port reserved for server 1
+	ip, port := CalculateAddress(managers[0].MyCfg.Address, 1)
+	certificate, err := tls.X509KeyPair(managers[2].MyCfg.Identity, managers[2].MyCfg.PrivateIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &tls.Config{
+		RootCAs:      ServerCertPool(managers[2].configs),
+		Certificates: []tls.Certificate{certificate},
+	}
+	for i := 0; i < 50; i++ {
+		var conn *tls.Conn
+		conn, err = tls.Dial("tcp", ip+port, conf)
+		if err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := <-accepted; err == nil {
+		t.Fatal("Accepted a connection from the wrong server")
+	}
+}
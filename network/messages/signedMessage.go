@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 
 	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 type Metadata struct {
@@ -14,6 +15,8 @@ type Metadata struct {
 	Dest        int
 	Group       int32
 	NumMessages uint32
+	// the span that sent this message, only set between servers
+	Trace tracing.SpanContext
 }
 
 type SignedMessage struct {
@@ -23,8 +26,13 @@ type SignedMessage struct {
 	Signature []byte
 }
 
+// key value pairs for structured logging
+func (m *Metadata) LogFields() []interface{} {
+	return []interface{}{"type", m.Type, "round", m.Round, "layer", m.Layer, "sender", m.Sender, "dest", m.Dest}
+}
+
 // we also sign the chunk number and chunk length, but these can vary between chunks
-const Metadata_size = 4 * 7 // 7 uint32 of metadata
+const Metadata_size = 4*7 + tracing.ContextSize // 7 uint32 and the trace context
 
 // Create a message with fields for metadata
 func NewSignedMessage(dataLen, round, layer, sender, group, dest, numMessages int, t NetworkMessage_MessageType) *SignedMessage {
@@ -52,6 +60,7 @@ func (m *Metadata) InterpretFrom(b []byte) {
 	m.Sender = int(binary.LittleEndian.Uint32(b[16:20]))
 	m.Dest = int(binary.LittleEndian.Uint32(b[20:24]))
 	m.Group = int32(binary.LittleEndian.Uint32(b[24:28]))
+	m.Trace.InterpretFrom(b[28:Metadata_size])
 }
 
 // Parse a message's metadata fields
@@ -76,6 +85,7 @@ func (s *Metadata) PackTo(b []byte) {
 	binary.LittleEndian.PutUint32(b[16:20], uint32(s.Sender))
 	binary.LittleEndian.PutUint32(b[20:24], uint32(s.Dest))
 	binary.LittleEndian.PutUint32(b[24:28], uint32(s.Group))
+	s.Trace.PackTo(b[28:Metadata_size])
 }
 
 // Get the byte array that is signed by the signature (including the metadata)
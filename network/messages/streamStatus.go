@@ -0,0 +1,69 @@
+package messages
+
+import (
+	"encoding/binary"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Result of one message sent on a submission stream
+// messages are numbered in the order they were sent, results may arrive in any order
+type StreamStatus struct {
+	Sequence uint32
+	Code     codes.Code
+	Message  string
+	Response *NetworkMessage // the handler's response when Code is OK
+}
+
+const streamStatusHeaderSize = 12
+
+func NewStreamStatus(sequence uint32, response *NetworkMessage, err error) *StreamStatus {
+	s := status.Convert(err)
+	return &StreamStatus{
+		Sequence: sequence,
+		Code:     s.Code(),
+		Message:  s.Message(),
+		Response: response,
+	}
+}
+
+func (s *StreamStatus) Err() error {
+	return status.Error(s.Code, s.Message)
+}
+
+// sequence || code || message length || message || response data, signed by the response signature
+func (s *StreamStatus) AsNetworkMessage() *NetworkMessage {
+	var data, signature []byte
+	t := NetworkMessage_MessageType(0)
+	if s.Response != nil {
+		data, signature, t = s.Response.Data, s.Response.Signature, s.Response.MessageType
+	}
+	b := make([]byte, streamStatusHeaderSize+len(s.Message)+len(data))
+	binary.LittleEndian.PutUint32(b[0:4], s.Sequence)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(s.Code))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(s.Message)))
+	pos := streamStatusHeaderSize + copy(b[streamStatusHeaderSize:], s.Message)
+	copy(b[pos:], data)
+	return &NetworkMessage{MessageType: t, Data: b, Signature: signature}
+}
+
+func ParseStreamStatus(m *NetworkMessage) *StreamStatus {
+	if len(m.Data) < streamStatusHeaderSize {
+		return nil
+	}
+	msgLen := int(binary.LittleEndian.Uint32(m.Data[8:12]))
+	if msgLen > len(m.Data)-streamStatusHeaderSize {
+		return nil
+	}
+	pos := streamStatusHeaderSize + msgLen
+	s := &StreamStatus{
+		Sequence: binary.LittleEndian.Uint32(m.Data[0:4]),
+		Code:     codes.Code(binary.LittleEndian.Uint32(m.Data[4:8])),
+		Message:  string(m.Data[streamStatusHeaderSize:pos]),
+	}
+	if s.Code == codes.OK {
+		s.Response = &NetworkMessage{MessageType: m.MessageType, Data: m.Data[pos:], Signature: m.Signature}
+	}
+	return s
+}
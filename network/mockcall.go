@@ -37,6 +37,8 @@ func NewMockCallStream(s messages.MessageHandlersServer) *MockCallStream {
 	}
 	go func() {
 		s.HandleSignedMessageStream(r)
+		// the client sees the end of the stream
+		close(r.send)
 	}()
 	return &MockCallStream{
 		send: r.recv,
@@ -3,6 +3,7 @@ package network
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -21,6 +22,7 @@ type MockChan struct {
 	mu     sync.Mutex
 	cond   *sync.Cond
 	buffer bytes.Buffer
+	closed bool
 }
 
 type MockConn struct {
@@ -52,6 +54,9 @@ func (c *MockChan) Read(b []byte) (int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for len(c.buffer.Bytes()) < len(b) {
+		if c.closed {
+			return 0, io.EOF
+		}
 		c.cond.Wait()
 	}
 	n, err := c.buffer.Read(b)
@@ -78,7 +83,12 @@ func (c *MockConn) Write(b []byte) (int, error) {
 	return c.out.Write(b)
 }
 
+// the reader on this end stops, the other end is not told
 func (c *MockConn) Close() error {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	c.in.closed = true
+	c.in.cond.Broadcast()
 	return nil
 }
 
@@ -114,16 +124,22 @@ func (c *MockConn) SetWriteDeadline(t time.Time) error {
 	panic(errors.UnimplementedError())
 }
 
+// links are found by the ids at their ends rather than by port,
+// since ports calculated from ids can collide once servers with larger ids join
+func mockLink(from, to int) string {
+	return fmt.Sprintf("%d->%d", from, to)
+}
+
 func (c *ConnectionManager) MockListen(address string, from int) (net.Conn, error) {
 	id := int(c.MyCfg.Id)
 	c1, c2 := NewMockConnPair(from, id)
 	errors.DebugPrint("accept: %v->%v %v", from, id, address)
 	c.Mock.mu.Lock()
 	defer c.Mock.mu.Unlock()
-	if c.Mock.conns[address] != nil {
+	if c.Mock.conns[mockLink(from, id)] != nil {
 		return nil, fmt.Errorf("bind %v already in use", address)
 	}
-	c.Mock.conns[address] = c2
+	c.Mock.conns[mockLink(from, id)] = c2
 	c.Mock.cond.Broadcast()
 	return c1, nil
 }
@@ -133,7 +149,7 @@ func (c *ConnectionManager) MockConnect(address string, to int) (net.Conn, error
 	defer c.Mock.mu.Unlock()
 	errors.DebugPrint("Looking for: %v", address)
 	for {
-		conn, exists := c.Mock.conns[address]
+		conn, exists := c.Mock.conns[mockLink(int(c.MyCfg.Id), to)]
 		if exists {
 			return conn, nil
 		}
@@ -141,6 +157,14 @@ func (c *ConnectionManager) MockConnect(address string, to int) (net.Conn, error
 	}
 }
 
+// a server left, so a server with its id can connect again
+func (m *MockConnNetwork) forget(from, to int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns, mockLink(from, to))
+	delete(m.conns, mockLink(to, from))
+}
+
 // func WrapMessage(m *messages.SignedMessage) *ConnectionReader {
 // 	// signature will be checked in signed encryption
 // 	c := &ConnectionReader{
@@ -3,7 +3,6 @@ package network
 import (
 	"context"
 	"crypto/rand"
-	"log"
 	"sort"
 	"sync"
 	"time"
@@ -41,20 +40,29 @@ func (c *Caller) PingServer(sid int) int {
 }
 
 func (c *Caller) HealthCheck() {
-	medianPingTimes := make([]int, len(c.Network))
+	ids := make([]int, 0, len(c.Network))
+	for sid, cli := range c.Network {
+		if cli != nil {
+			ids = append(ids, sid)
+		}
+	}
+	medianPingTimes := make([]int, len(ids))
 	wg := sync.WaitGroup{}
-	for sid := range medianPingTimes {
+	for i := range medianPingTimes {
 		wg.Add(1)
-		go func(sid int) {
+		go func(i int) {
 			defer wg.Done()
-			medianPingTimes[sid] = c.PingServer(sid)
-		}(sid)
+			medianPingTimes[i] = c.PingServer(ids[i])
+		}(i)
 	}
 	wg.Wait()
 	// c.MedianPingTimes = medianPingTimes
-	c.ServersSortedByLatency = ArgSort(medianPingTimes)
-	log.Printf("Measured times %v", medianPingTimes)
-	config.LogTime("Servers %v %v", c.ServersSortedByLatency, medianPingTimes)
+	sorted := ArgSort(medianPingTimes)
+	for i, idx := range sorted {
+		sorted[i] = ids[idx]
+	}
+	c.ServersSortedByLatency = sorted
+	log.Info("measured ping times", "times", medianPingTimes, "sorted", c.ServersSortedByLatency)
 }
 
 // from the stack overflow https://stackoverflow.com/questions/31141202/get-the-indices-of-the-array-after-sorting-in-golang
@@ -5,6 +5,7 @@ package network
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"sync"
 
@@ -12,10 +13,12 @@ import (
 	"github.com/simonlangowski/lightning1/errors"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 )
 
 type Caller struct {
+	// indexed by server id, nil for servers not in the network
 	Network     []messages.MessageHandlersClient
 	Streams     []messages.MessageHandlers_HandleSignedMessageStreamClient
 	streamLocks []sync.Mutex
@@ -27,9 +30,22 @@ type Caller struct {
 	Groups                 []map[int]int
 	ReverseGroups          [][]int
 	ServersSortedByLatency []int
+	// the servers called and their connections, to close them when servers leave
+	configs     map[int64]*config.Server
+	identity    *config.Server
+	connections map[int]*grpc.ClientConn
 }
 
-func GetConnections(serverConfigs map[int64]*config.Server) (map[int]*grpc.ClientConn, error) {
+// servers pass their own config to present a client certificate, others pass nil
+func GetConnections(serverConfigs map[int64]*config.Server, identity *config.Server) (map[int]*grpc.ClientConn, error) {
+	var certificates []tls.Certificate
+	if identity != nil {
+		certificate, err := tls.X509KeyPair(identity.Identity, identity.PrivateIdentity)
+		if err != nil {
+			return nil, err
+		}
+		certificates = []tls.Certificate{certificate}
+	}
 	conn := make(map[int]*grpc.ClientConn)
 	for id, s := range serverConfigs {
 		pool := x509.NewCertPool()
@@ -37,7 +53,7 @@ func GetConnections(serverConfigs map[int64]*config.Server) (map[int]*grpc.Clien
 		if !ok {
 			panic("Could not create cert pool for TLS connection")
 		}
-		creds := credentials.NewClientTLSFromCert(pool, "")
+		creds := credentials.NewTLS(&tls.Config{RootCAs: pool, Certificates: certificates})
 
 		opts := []grpc.DialOption{
 			grpc.WithTransportCredentials(creds),
@@ -57,18 +73,39 @@ func GetConnections(serverConfigs map[int64]*config.Server) (map[int]*grpc.Clien
 	return conn, nil
 }
 
-func NewCaller(serverConfigs map[int64]*config.Server) (*Caller, error) {
-	conn, err := GetConnections(serverConfigs)
+// connections to the admin service of each server, at its admin address on the admin host
+// the certificate is checked against the server's own address
+func GetAdminConnections(serverConfigs map[int64]*config.Server) (map[int]*grpc.ClientConn, error) {
+	conn := make(map[int]*grpc.ClientConn)
+	for id, s := range serverConfigs {
+		pool := x509.NewCertPool()
+		ok := pool.AppendCertsFromPEM(s.Identity)
+		if !ok {
+			panic("Could not create cert pool for TLS connection")
+		}
+		creds := credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: config.IP(s.Address)})
+		cc, err := grpc.Dial(config.AdminRPCAddress(s.Address), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		conn[int(id)] = cc
+	}
+	return conn, nil
+}
+
+func NewCaller(serverConfigs map[int64]*config.Server, identity *config.Server) (*Caller, error) {
+	conn, err := GetConnections(serverConfigs, identity)
 	if err != nil {
 		return nil, err
 	}
-	callees := make([]messages.MessageHandlersClient, len(conn))
+	n := config.IdSpace(serverConfigs)
+	callees := make([]messages.MessageHandlersClient, n)
 	for id, cc := range conn {
 		callees[id] = messages.NewMessageHandlersClient(cc)
 	}
-	streams := make([]messages.MessageHandlers_HandleSignedMessageStreamClient, len(conn))
-	for id, callee := range callees {
-		streams[id], err = callee.HandleSignedMessageStream(context.Background())
+	streams := make([]messages.MessageHandlers_HandleSignedMessageStreamClient, n)
+	for id := range conn {
+		streams[id], err = callees[id].HandleSignedMessageStream(context.Background())
 		if err != nil {
 			return nil, err
 		}
@@ -76,8 +113,11 @@ func NewCaller(serverConfigs map[int64]*config.Server) (*Caller, error) {
 	return &Caller{mock: false,
 		Network:     callees,
 		Streams:     streams,
-		streamLocks: make([]sync.Mutex, len(streams)),
-		responseIds: make([]int, len(streams))}, nil
+		streamLocks: make([]sync.Mutex, n),
+		responseIds: make([]int, n),
+		configs:     serverConfigs,
+		identity:    identity,
+		connections: conn}, nil
 }
 
 func NewMockCaller(mockNetwork []messages.MessageHandlersServer) *Caller {
@@ -93,6 +133,105 @@ func NewMockCaller(mockNetwork []messages.MessageHandlersServer) *Caller {
 	}
 }
 
+// the servers of a new epoch, called between rounds when nothing is being sent
+// streams to servers that left are closed and servers that joined are dialed
+// servers that joined have not been pinged, so they are sent to last
+// in process the handlers of the new epoch are set with SetMockNetwork instead
+func (c *Caller) Reconfigure(serverConfigs map[int64]*config.Server) error {
+	if c.mock {
+		return nil
+	}
+	added, removed := config.ServerChanges(c.configs, serverConfigs)
+	joined := make(map[int64]*config.Server)
+	for _, sid := range added {
+		joined[sid] = serverConfigs[sid]
+	}
+	conn, err := GetConnections(joined, c.identity)
+	if err != nil {
+		return err
+	}
+	c.resize(config.IdSpace(serverConfigs))
+	for _, sid := range removed {
+		if c.Streams[sid] != nil {
+			c.Streams[sid].CloseSend()
+		}
+		if cc := c.connections[int(sid)]; cc != nil {
+			cc.Close()
+		}
+		c.Network[sid], c.Streams[sid] = nil, nil
+		delete(c.connections, int(sid))
+	}
+	for id, cc := range conn {
+		c.connections[id] = cc
+		c.Network[id] = messages.NewMessageHandlersClient(cc)
+		// made when first used
+		c.Streams[id] = nil
+	}
+	c.configs = serverConfigs
+	c.reorder(serverConfigs)
+	return nil
+}
+
+// the handlers of the servers of a new epoch in process, nil for servers not in the network
+func (c *Caller) SetMockNetwork(mockNetwork []messages.MessageHandlersServer) {
+	c.resize(len(mockNetwork))
+	servers := make(map[int64]*config.Server)
+	for sid, h := range mockNetwork {
+		if h == nil {
+			c.mockStreams[sid] = nil
+		} else if h != c.mockNetwork[sid] {
+			c.mockStreams[sid] = NewMockCallStream(h)
+		}
+		if h != nil {
+			servers[int64(sid)] = nil
+		}
+	}
+	c.mockNetwork = append([]messages.MessageHandlersServer(nil), mockNetwork...)
+	c.reorder(servers)
+}
+
+// grow the slices indexed by server id
+func (c *Caller) resize(n int) {
+	if n <= len(c.streamLocks) {
+		return
+	}
+	if c.mock {
+		c.mockNetwork = append(c.mockNetwork, make([]messages.MessageHandlersServer, n-len(c.mockNetwork))...)
+		c.mockStreams = append(c.mockStreams, make([]messages.MessageHandlers_HandleSignedMessageStreamClient, n-len(c.mockStreams))...)
+	} else {
+		c.Network = append(c.Network, make([]messages.MessageHandlersClient, n-len(c.Network))...)
+		c.Streams = append(c.Streams, make([]messages.MessageHandlers_HandleSignedMessageStreamClient, n-len(c.Streams))...)
+		c.responseIds = append(c.responseIds, make([]int, n-len(c.responseIds))...)
+	}
+	// no streams are in use between rounds
+	c.streamLocks = make([]sync.Mutex, n)
+}
+
+// keep the latency order of the servers that stayed, servers that joined go last
+func (c *Caller) reorder(servers map[int64]*config.Server) {
+	sorted := make([]int, 0, len(servers))
+	for _, sid := range c.ServersSortedByLatency {
+		if _, ok := servers[int64(sid)]; ok {
+			sorted = append(sorted, sid)
+		}
+	}
+	for _, sid := range config.ServerIds(servers) {
+		if !contains(sorted, int(sid)) {
+			sorted = append(sorted, int(sid))
+		}
+	}
+	c.ServersSortedByLatency = sorted
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Caller) SetGroups(groups map[int64]*config.Group) {
 	c.Groups = make([]map[int]int, len(groups))
 	numServers := 0
@@ -117,7 +256,7 @@ func (c *Caller) SendNetworkMessage(dest int, message *messages.NetworkMessage)
 	if !c.mock {
 		resp, err := c.Network[dest].HandleSignedMessage(context.Background(), message)
 		if err != nil || resp == nil {
-			return nil, err
+			return nil, errors.FromRPC(err)
 		}
 		return messages.ParseSignedMessage(resp), nil
 	} else {
@@ -130,6 +269,15 @@ func (c *Caller) SendNetworkMessage(dest int, message *messages.NetworkMessage)
 	}
 }
 
+// errors where the request may not have been processed, and can be sent again
+func Retryable(err error) bool {
+	switch errors.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
 func (c *Caller) SendSignedMessage(dest int, message *messages.SignedMessage) (*messages.SignedMessage, error) {
 	return c.SendNetworkMessage(dest, message.AsNetworkMessage())
 }
@@ -3,8 +3,8 @@ package network
 import (
 	"runtime"
 	"sync"
+	"time"
 
-	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/network/buffers"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/common"
@@ -26,6 +26,7 @@ func CreateGroupMessages(chunks map[int]*buffers.MemReadWriter, c *common.Common
 				messageCounts[j] = f.NumMessages()
 				lengthLeft := f.Len()
 				sm := messages.NewSignedMessage(lengthLeft, c.Round, c.Layer, c.MyId, j, 0, f.NumMessages(), t)
+				sm.Trace = c.Trace
 				f.ReadNextChunk(sm.Data)
 				PreHashSign(c.SecretSigningKey, sm)
 				signedMessages[j] = sm.AsArray()
@@ -39,15 +40,15 @@ func CreateGroupMessages(chunks map[int]*buffers.MemReadWriter, c *common.Common
 // no need for dummies, but do shuffle
 func (c *ConnectionManager) SendGroupShuffleMessages(chunks map[int]*buffers.MemReadWriter, common *common.CommonState, t messages.NetworkMessage_MessageType, responseSize int) ([]int, error) {
 	// first compile and sign all the messages.  No dummies so no extra memory overhead
-	config.LogTime("Starting signing %d", common.Layer)
+	start := time.Now()
 	messageCounts, groupMessages := CreateGroupMessages(chunks, common, common.Layer, t)
-	config.LogTime("Finished signing %d", common.Layer)
+	log.Debug("signed group messages", "round", common.Round, "layer", common.Layer, "took", time.Since(start))
 	// then by order of latency send messages to group members
 	// need a reverse group lookup I guess
 	// done := make(chan error)
 	jobs := c.caller.GetJobs()
 	reverseGroups := c.ReverseGroups()
-	inProgress := make([]chan error, len(jobs))
+	inProgress := make([]chan error, c.idSpace())
 	// for i := 0; i < numWorkers; i++ {
 	// 	go func() {
 	for {
@@ -55,6 +56,10 @@ func (c *ConnectionManager) SendGroupShuffleMessages(chunks map[int]*buffers.Mem
 		if !ok {
 			break
 		}
+		if sid >= len(reverseGroups) || len(reverseGroups[sid]) == 0 {
+			// in no group
+			continue
+		}
 		b := make([]byte, 0)
 		for _, gid := range reverseGroups[sid] {
 			b = append(b, groupMessages[gid]...)
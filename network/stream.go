@@ -0,0 +1,169 @@
+package network
+
+// Bulk submission over a bidirectional stream, for gateways relaying many clients
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/network/messages"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type StreamHandler func(context.Context, *messages.NetworkMessage) (*messages.NetworkMessage, error)
+
+// handle each message on the stream and send back its status
+// at most window messages are handled at once, while the window is full the stream
+// is not read, so grpc flow control pushes back on the sender
+func ServeSubmissionStream(stream messages.MessageHandlers_HandleSignedMessageStreamServer, window int, handle StreamHandler) error {
+	ctx := stream.Context()
+	slots := make(chan struct{}, window)
+	responses := make(chan *messages.NetworkMessage, window)
+	sent := make(chan error)
+	go func() {
+		var err error
+		// keep draining after an error so handlers do not block
+		for r := range responses {
+			if err == nil {
+				err = stream.Send(r)
+			}
+		}
+		sent <- err
+	}()
+
+	wg := sync.WaitGroup{}
+	var recvErr error
+	for sequence := uint32(0); ; sequence++ {
+		m, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				recvErr = err
+			}
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(sequence uint32, m *messages.NetworkMessage) {
+			defer wg.Done()
+			response, err := handle(ctx, m)
+			responses <- messages.NewStreamStatus(sequence, response, err).AsNetworkMessage()
+			<-slots
+		}(sequence, m)
+	}
+	wg.Wait()
+	close(responses)
+	err := <-sent
+	if recvErr != nil {
+		return recvErr
+	}
+	return err
+}
+
+// client side of a submission stream
+// Send blocks while window messages are waiting for their status
+type SubmissionStream struct {
+	stream   messages.MessageHandlers_HandleSignedMessageStreamClient
+	window   chan struct{}
+	closed   chan struct{}
+	mu       sync.Mutex
+	next     uint32
+	Statuses chan *messages.StreamStatus
+	// set when the stream ends, before Statuses is closed
+	Err error
+}
+
+func (c *Caller) NewSubmissionStream(ctx context.Context, dest int, window int) (*SubmissionStream, error) {
+	var stream messages.MessageHandlers_HandleSignedMessageStreamClient
+	if c.mock {
+		stream = NewMockCallStream(c.mockNetwork[dest])
+	} else {
+		var err error
+		stream, err = c.Network[dest].HandleSignedMessageStream(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &SubmissionStream{
+		stream:   stream,
+		window:   make(chan struct{}, window),
+		closed:   make(chan struct{}),
+		Statuses: make(chan *messages.StreamStatus, window),
+	}
+	go s.receive()
+	return s, nil
+}
+
+// returns the sequence number the status will refer to
+func (s *SubmissionStream) Send(m *messages.SignedMessage) (uint32, error) {
+	select {
+	case s.window <- struct{}{}:
+	case <-s.closed:
+		if s.Err != nil {
+			return 0, s.Err
+		}
+		return 0, status.Error(codes.Unavailable, "submission stream closed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sequence := s.next
+	err := s.stream.Send(m.AsNetworkMessage())
+	if err != nil {
+		return 0, err
+	}
+	s.next++
+	return sequence, nil
+}
+
+// no more messages will be sent, statuses continue until all are answered
+func (s *SubmissionStream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.CloseSend()
+}
+
+func (s *SubmissionStream) receive() {
+	defer close(s.Statuses)
+	defer close(s.closed)
+	for {
+		m, err := s.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				s.Err = err
+			}
+			return
+		}
+		st := messages.ParseStreamStatus(m)
+		if st == nil {
+			s.Err = status.Error(codes.Internal, "malformed stream status")
+			return
+		}
+		<-s.window
+		s.Statuses <- st
+	}
+}
+
+// send all messages and return their statuses in the same order
+func (s *SubmissionStream) SubmitAll(ms []*messages.SignedMessage) ([]*messages.StreamStatus, error) {
+	statuses := make([]*messages.StreamStatus, len(ms))
+	sendErr := make(chan error, 1)
+	go func() {
+		for _, m := range ms {
+			if _, err := s.Send(m); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- s.CloseSend()
+	}()
+	for st := range s.Statuses {
+		if int(st.Sequence) < len(statuses) {
+			statuses[st.Sequence] = st
+		}
+	}
+	if err := <-sendErr; err != nil {
+		return statuses, err
+	}
+	return statuses, s.Err
+}
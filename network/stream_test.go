@@ -0,0 +1,118 @@
+package network
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/network/messages"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testStreamServer struct {
+	messages.UnimplementedMessageHandlersServer
+	window int
+	handle StreamHandler
+}
+
+func (t *testStreamServer) HandleSignedMessageStream(stream messages.MessageHandlers_HandleSignedMessageStreamServer) error {
+	return ServeSubmissionStream(stream, t.window, t.handle)
+}
+
+func testSubmissions(n int) []*messages.SignedMessage {
+	ms := make([]*messages.SignedMessage, n)
+	for i := range ms {
+		ms[i] = messages.NewSignedMessage(1, 0, 0, i, 0, 0, 1, messages.NetworkMessage_ClientMessageSubmission)
+		ms[i].Data[0] = byte(i)
+		ms[i].GetSignedData()
+		ms[i].Signature = []byte{byte(i)}
+	}
+	return ms
+}
+
+func TestSubmissionStreamStatus(t *testing.T) {
+	server := &testStreamServer{window: 4, handle: func(_ context.Context, m *messages.NetworkMessage) (*messages.NetworkMessage, error) {
+		sm := messages.ParseSignedMessage(m)
+		// odd senders are rejected, even senders get their data back
+		if sm.Sender%2 == 1 {
+			return nil, status.Error(codes.PermissionDenied, "odd")
+		}
+		return &messages.NetworkMessage{Data: sm.Data, Signature: sm.Signature}, nil
+	}}
+	c := NewMockCaller([]messages.MessageHandlersServer{server})
+	s, err := c.NewSubmissionStream(context.Background(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := s.SubmitAll(testSubmissions(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, st := range statuses {
+		if st == nil || int(st.Sequence) != i {
+			t.Fatalf("Missing status %d", i)
+		}
+		if i%2 == 1 {
+			if st.Code != codes.PermissionDenied || status.Code(st.Err()) != codes.PermissionDenied || st.Message != "odd" {
+				t.Fatalf("Wrong status for %d: %v", i, st.Err())
+			}
+			continue
+		}
+		if st.Code != codes.OK || st.Response.Data[0] != byte(i) || st.Response.Signature[0] != byte(i) {
+			t.Fatalf("Wrong response for %d: %v", i, st)
+		}
+	}
+}
+
+func TestSubmissionStreamFlowControl(t *testing.T) {
+	window := 3
+	var running, maxRunning int32
+	release := make(chan struct{})
+	server := &testStreamServer{window: window, handle: func(_ context.Context, m *messages.NetworkMessage) (*messages.NetworkMessage, error) {
+		r := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&maxRunning)
+			if r <= old || atomic.CompareAndSwapInt32(&maxRunning, old, r) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return &messages.NetworkMessage{}, nil
+	}}
+	c := NewMockCaller([]messages.MessageHandlersServer{server})
+	// the client allows more in flight than the server handles
+	s, err := c.NewSubmissionStream(context.Background(), 0, 2*window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := testSubmissions(4 * window)
+	sent := make(chan int, len(ms))
+	go func() {
+		for _, m := range ms {
+			if _, err := s.Send(m); err != nil {
+				t.Error(err)
+				return
+			}
+			sent <- 1
+		}
+		s.CloseSend()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if len(sent) != 2*window {
+		t.Fatalf("Sent %d messages with a window of %d", len(sent), 2*window)
+	}
+	close(release)
+	n := 0
+	for range s.Statuses {
+		n++
+	}
+	if n != len(ms) || s.Err != nil {
+		t.Fatalf("Received %d statuses: %v", n, s.Err)
+	}
+	if maxRunning > int32(window) {
+		t.Fatalf("%d messages handled at once with a window of %d", maxRunning, window)
+	}
+}
@@ -2,8 +2,10 @@ package synchronization
 
 import (
 	"sync"
+	"time"
 
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 )
 
 // Implement syncrhonized broadcast
@@ -11,7 +13,7 @@ import (
 
 // Basically, hold messages in layer l+1 until those from layer l are processed
 // With correct functioning, we get one message from each server each layer, and so we know we are done
-// Otherwise, if no message is received after a timeout, then start a churn protocol (not implemented)
+// A server whose link fails is churned: the threshold drops so the layer can finish without it
 
 type Synchronizer struct {
 	// current state
@@ -63,23 +65,39 @@ func NewSynchronizer(round int, layer int, threshold int, callback Callback) *Sy
 	return s
 }
 
+// servers are marked by id, which can be larger than the threshold once servers have left the network
+func (s *Synchronizer) SetIdSpace(n int) {
+	s.markLock.Lock()
+	defer s.markLock.Unlock()
+	if n > len(s.started) {
+		started := make([]bool, n)
+		copy(started, s.started)
+		s.started = started
+	}
+}
+
 func (s *Synchronizer) Sync(layer int) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
+	if s.layer == layer {
+		return
+	}
+	start := time.Now()
 	for s.layer != layer {
 		s.wait.Wait()
 	}
+	metrics.SyncWaitTime.ObserveDuration(time.Since(start))
 }
 
 func (s *Synchronizer) SyncOnce(layer int, id int) error {
 	s.Sync(layer)
 	s.markLock.Lock()
 	defer s.markLock.Unlock()
-	if id > len(s.started) || id < 0 {
-		return errors.BadMetadataError()
+	if id >= len(s.started) || id < 0 {
+		return errors.BadMetadataError().At(s.round, layer).From(id)
 	}
 	if s.started[id] {
-		return errors.SynchronizationError()
+		return errors.SynchronizationError().At(s.round, layer).From(id)
 	}
 	s.started[id] = true
 	return nil
@@ -88,7 +106,9 @@ func (s *Synchronizer) SyncOnce(layer int, id int) error {
 func (s *Synchronizer) Done() {
 	s.countLock.Lock()
 	defer s.countLock.Unlock()
+	s.markLock.Lock()
 	s.processed += 1
+	s.markLock.Unlock()
 	if s.processed == s.threshold {
 		go s.Trigger()
 	} else if s.processed > s.threshold {
@@ -96,20 +116,63 @@ func (s *Synchronizer) Done() {
 	}
 }
 
+// how far the current layer is
+type Progress struct {
+	Round     int
+	Layer     int
+	Processed int
+	Threshold int
+	Started   []bool
+}
+
+// does not wait for the callback, which can take as long as sending a layer
+func (s *Synchronizer) Progress() Progress {
+	s.markLock.Lock()
+	defer s.markLock.Unlock()
+	return Progress{
+		Round:     s.round,
+		Layer:     s.layer,
+		Processed: s.processed,
+		Threshold: s.threshold,
+		Started:   append([]bool{}, s.started...),
+	}
+}
+
+// stop waiting for a server that will not send anything more
+// the callback must leave it out of later thresholds
+func (s *Synchronizer) Churn(id int) {
+	s.countLock.Lock()
+	defer s.countLock.Unlock()
+	s.markLock.Lock()
+	started := id >= 0 && id < len(s.started) && s.started[id]
+	if !started {
+		s.threshold--
+	}
+	s.markLock.Unlock()
+	if started {
+		// counted when its stream is done
+		return
+	}
+	if s.processed == s.threshold {
+		go s.Trigger()
+	}
+}
+
 func (s *Synchronizer) Trigger() {
 	s.countLock.Lock()
 	defer s.countLock.Unlock()
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.markLock.Lock()
-	defer s.markLock.Unlock()
+	// senders of the next layer wait on lock, so marks are not needed during the callback
+	threshold, layer := s.threshold, s.layer+1
 	if s.callback != nil {
-		s.threshold, s.layer = s.callback.OnThreshold(s.layer)
-	} else {
-		s.layer++
+		threshold, layer = s.callback.OnThreshold(s.layer)
 	}
-	s.started = make([]bool, s.threshold)
+	s.markLock.Lock()
+	s.threshold, s.layer = threshold, layer
+	s.started = make([]bool, max(len(s.started), s.threshold))
 	s.processed = 0
+	s.markLock.Unlock()
 	s.wait.Broadcast()
 }
 
@@ -124,7 +187,15 @@ func (s *Synchronizer) Reset(round int, layer int, threshold int) {
 	s.layer = layer
 	s.processed = 0
 	s.threshold = threshold
-	s.started = make([]bool, threshold)
+	s.started = make([]bool, max(len(s.started), threshold))
 
 	s.wait.Broadcast()
 }
+
+// ids index started, so it keeps its size when servers are churned
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -5,16 +5,17 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
 	"github.com/simonlangowski/lightning1/network/messages"
 )
 
@@ -30,24 +31,166 @@ type ConnectionManager struct {
 	locks               []sync.Mutex
 	caller              *Caller
 	terminated          bool
+	// LinkState of the connections with each server
+	incoming []int32
+	outgoing []int32
+	// the slices indexed by server id grow when servers join
+	mu sync.RWMutex
+}
+
+// state of a tcp link with another server
+type LinkState int32
+
+const (
+	LinkDown LinkState = iota
+	LinkUp
+	LinkFailed
+)
+
+func (l LinkState) String() string {
+	switch l {
+	case LinkUp:
+		return "up"
+	case LinkFailed:
+		return "failed"
+	default:
+		return "down"
+	}
 }
 
 func NewConnectionManager(cfgs map[int64]*config.Server, id int) *ConnectionManager {
+	n := config.IdSpace(cfgs)
 	c := &ConnectionManager{
 		configs:             cfgs,
 		MyCfg:               cfgs[int64(id)],
-		OutgoingConnections: make([]net.Conn, len(cfgs)),
-		IncomingConnections: make([]net.Conn, len(cfgs)),
-		locks:               make([]sync.Mutex, len(cfgs)),
+		OutgoingConnections: make([]net.Conn, n),
+		IncomingConnections: make([]net.Conn, n),
+		locks:               make([]sync.Mutex, n),
+		incoming:            make([]int32, n),
+		outgoing:            make([]int32, n),
 	}
 	selfConnectionIn, selfConnectionOut := NewMockConnPair(id, id)
 	c.IncomingConnections[id] = selfConnectionIn
 	c.OutgoingConnections[id] = selfConnectionOut
+	c.setLink(c.incoming, id, LinkUp)
+	c.setLink(c.outgoing, id, LinkUp)
 	go c.CatchInterrupt()
 	return c
 }
 
+func (c *ConnectionManager) setLink(links []int32, sid int, state LinkState) {
+	if sid >= 0 && sid < len(links) {
+		atomic.StoreInt32(&links[sid], int32(state))
+	}
+}
+
+func link(links []int32, sid int) LinkState {
+	if sid < 0 || sid >= len(links) {
+		return LinkDown
+	}
+	return LinkState(atomic.LoadInt32(&links[sid]))
+}
+
+// state of the connections from and to a server
+func (c *ConnectionManager) Links(sid int) (incoming, outgoing LinkState) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return link(c.incoming, sid), link(c.outgoing, sid)
+}
+
+func (c *ConnectionManager) setIncomingLink(sid int, state LinkState) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.setLink(c.incoming, sid, state)
+}
+
+func (c *ConnectionManager) setOutgoingLink(sid int, state LinkState) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.setLink(c.outgoing, sid, state)
+}
+
+// whether the connections with every server are up
+func (c *ConnectionManager) AllLinksUp() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for sid := range c.incoming {
+		if _, ok := c.configs[int64(sid)]; !ok && c.configs != nil {
+			// not in the network
+			continue
+		}
+		if link(c.incoming, sid) != LinkUp || link(c.outgoing, sid) != LinkUp {
+			return false
+		}
+	}
+	return true
+}
+
+// the connection a server sends on, nil if it is not in the network
+func (c *ConnectionManager) Incoming(sid int) net.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if sid < 0 || sid >= len(c.IncomingConnections) {
+		return nil
+	}
+	return c.IncomingConnections[sid]
+}
+
+// the connection to send to a server on, nil if it is not in the network
+func (c *ConnectionManager) Outgoing(sid int) net.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if sid < 0 || sid >= len(c.OutgoingConnections) {
+		return nil
+	}
+	return c.OutgoingConnections[sid]
+}
+
+func (c *ConnectionManager) config(sid int) *config.Server {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.configs[int64(sid)]
+}
+
+// the connection to a server and the lock that serializes writes to it
+func (c *ConnectionManager) sendingTo(sid int) (net.Conn, *sync.Mutex) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if sid < 0 || sid >= len(c.OutgoingConnections) {
+		return nil, nil
+	}
+	return c.OutgoingConnections[sid], &c.locks[sid]
+}
+
+// the length of slices indexed by server id
+func (c *ConnectionManager) idSpace() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.OutgoingConnections)
+}
+
+// the other servers of the network
+func (c *ConnectionManager) peers() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	peers := make([]int, 0, len(c.configs))
+	for sid := range c.configs {
+		if sid != c.MyCfg.Id {
+			peers = append(peers, int(sid))
+		}
+	}
+	return peers
+}
+
+func (c *ConnectionManager) isTerminated() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.terminated
+}
+
 func (c *ConnectionManager) ShutDown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.terminated = true
 	for _, conn := range c.OutgoingConnections {
 		if conn != nil {
@@ -61,6 +204,48 @@ func (c *ConnectionManager) ShutDown() {
 	}
 }
 
+// move to the servers of a new epoch, called between rounds
+// the connections with servers that left are closed, those with servers that joined are made by LaunchJoined
+// a server whose config changed has left and joined again
+func (c *ConnectionManager) Reconfigure(cfgs map[int64]*config.Server) (added []int, removed []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, r := config.ServerChanges(c.configs, cfgs)
+	n := config.IdSpace(cfgs)
+	if n > len(c.OutgoingConnections) {
+		c.OutgoingConnections = append(c.OutgoingConnections, make([]net.Conn, n-len(c.OutgoingConnections))...)
+		c.IncomingConnections = append(c.IncomingConnections, make([]net.Conn, n-len(c.IncomingConnections))...)
+		// no sends are in progress between rounds
+		c.locks = make([]sync.Mutex, n)
+		c.incoming = append(c.incoming, make([]int32, n-len(c.incoming))...)
+		c.outgoing = append(c.outgoing, make([]int32, n-len(c.outgoing))...)
+	}
+	for _, sid := range r {
+		if sid == c.MyCfg.Id {
+			continue
+		}
+		for _, conn := range []net.Conn{c.IncomingConnections[sid], c.OutgoingConnections[sid]} {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+		c.IncomingConnections[sid], c.OutgoingConnections[sid] = nil, nil
+		c.setLink(c.incoming, int(sid), LinkDown)
+		c.setLink(c.outgoing, int(sid), LinkDown)
+		if c.Mock != nil {
+			c.Mock.forget(int(sid), int(c.MyCfg.Id))
+		}
+		removed = append(removed, int(sid))
+	}
+	for _, sid := range a {
+		if sid != c.MyCfg.Id {
+			added = append(added, int(sid))
+		}
+	}
+	c.configs = cfgs
+	return added, removed
+}
+
 func (c *ConnectionManager) CatchInterrupt() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -77,8 +262,12 @@ func (c *ConnectionManager) Accept(from int) (net.Conn, error) {
 	if err != nil {
 		panic(err)
 	}
+	peer := c.config(from)
+	if peer == nil {
+		return nil, errors.WrongServerError().From(from)
+	}
 	clientCertPool := x509.NewCertPool()
-	ok := clientCertPool.AppendCertsFromPEM(c.configs[int64(from)].Identity)
+	ok := clientCertPool.AppendCertsFromPEM(peer.Identity)
 	if !ok {
 		panic("Could not create cert pool for TLS connection")
 	}
@@ -87,15 +276,22 @@ func (c *ConnectionManager) Accept(from int) (net.Conn, error) {
 		ClientCAs:  clientCertPool}
 	ln, err := tls.Listen("tcp", port, config)
 	if err != nil {
-		log.Println(err)
+		log.Warn("could not listen", "port", port, "peer", from, "error", err)
 		return nil, err
 	}
 	defer ln.Close()
-	return ln.Accept()
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return conn, c.checkPeer(conn, from)
 }
 
 func (c *ConnectionManager) Connect(id int) (net.Conn, error) {
-	s := c.configs[int64(id)]
+	s := c.config(id)
+	if s == nil {
+		return nil, errors.WrongServerError().From(id)
+	}
 	ip, port := CalculateAddress(s.Address, int(c.MyCfg.Id))
 	if c.Mock != nil {
 		return c.MockConnect(ip+port, id)
@@ -114,7 +310,23 @@ func (c *ConnectionManager) Connect(id int) (net.Conn, error) {
 		Certificates: []tls.Certificate{certificate},
 		// InsecureSkipVerify: true,
 	}
-	return tls.Dial("tcp", ip+port, conf)
+	conn, err := tls.Dial("tcp", ip+port, conf)
+	if err != nil {
+		return nil, err
+	}
+	return conn, c.checkPeer(conn, id)
+}
+
+// the certificate presented must be the one configured for the expected server
+func (c *ConnectionManager) checkPeer(conn net.Conn, expected int) error {
+	id, err := c.PeerId(conn)
+	if err == nil && id != expected {
+		err = errors.AuthenticationError().From(expected)
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return err
 }
 
 func CalculateAddress(address string, offset int) (string, string) {
@@ -134,15 +346,25 @@ func CalculateAddress(address string, offset int) (string, string) {
 
 func (c *ConnectionManager) ReadMetadata(src int) (*messages.Metadata, []byte, error) {
 	m := make([]byte, messages.Metadata_size)
-	_, err := io.ReadFull(c.IncomingConnections[src], m)
+	conn := c.Incoming(src)
+	if conn == nil {
+		// the server left the network
+		return nil, nil, io.EOF
+	}
+	_, err := io.ReadFull(conn, m)
 	if err != nil {
-		if c.terminated {
+		if c.isTerminated() || c.Incoming(src) != conn {
 			return nil, nil, io.EOF
 		}
+		c.setIncomingLink(src, LinkFailed)
 		return nil, nil, err
 	}
 	metadata := &messages.Metadata{}
 	metadata.InterpretFrom(m)
+	// the connection from src was authenticated as src
+	if metadata.Sender != src {
+		return nil, nil, errors.AuthenticationError().At(metadata.Round, metadata.Layer).From(src)
+	}
 	return metadata, m, nil
 }
 
@@ -155,7 +377,10 @@ func (c *ConnectionManager) ReverseGroups() [][]int {
 }
 
 func (c *ConnectionManager) ReadReverse(sid int, length int) (*messages.SignedMessage, error) {
-	conn := c.OutgoingConnections[sid]
+	conn := c.Outgoing(sid)
+	if conn == nil {
+		return nil, errors.WrongServerError().From(sid)
+	}
 	sm := messages.NewSignedMessage(length, 0, 0, 0, 0, 0, 0, 0)
 	_, err := io.ReadFull(conn, sm.Raw)
 	if err != nil {
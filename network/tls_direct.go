@@ -2,51 +2,104 @@ package network
 
 import (
 	"encoding/binary"
+	"net"
 	"sync"
+
+	"github.com/simonlangowski/lightning1/errors"
 )
 
 func (c *ConnectionManager) LaunchAccepts() {
-	for k := range c.configs {
-		if k == c.MyCfg.Id {
-			continue
-		}
+	for _, k := range c.peers() {
 		go func(s int) {
-			conn, err := c.Accept(s)
+			err := c.acceptFrom(s)
 			if err != nil {
 				panic(err)
 			}
-			c.IncomingConnections[s] = conn
-			// log.Printf("Accepted %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
-			// hacky: force handshake
-			b := [4]byte{}
-			conn.Read(b[:])
-			if int(binary.LittleEndian.Uint32(b[:])) != s {
-				panic("Wrong server connected to accept")
-			}
-		}(int(k))
+		}(k)
 	}
 }
 
 func (c *ConnectionManager) LaunchConnects() {
 	wg := sync.WaitGroup{}
-	for k := range c.configs {
-		if k == c.MyCfg.Id {
-			continue
-		}
+	for _, k := range c.peers() {
 		wg.Add(1)
 		go func(s int) {
-			conn, err := c.Connect(s)
+			err := c.connectTo(s)
 			if err != nil {
 				panic(err)
 			}
-			c.OutgoingConnections[s] = conn
-			// log.Printf("Connected %s -> %s", conn.LocalAddr(), conn.RemoteAddr())
-			// hacky: force handshake
-			b := [4]byte{}
-			binary.LittleEndian.PutUint32(b[:], uint32(c.MyCfg.Id))
-			conn.Write(b[:])
 			wg.Done()
-		}(int(k))
+		}(k)
 	}
 	wg.Wait()
 }
+
+// connect with the servers that joined in a new epoch, accepted is called once a server's connection is accepted
+// they accept before the servers already running are reconfigured, a link that cannot be made is marked failed
+func (c *ConnectionManager) LaunchJoined(added []int, accepted func(sid int)) {
+	for _, k := range added {
+		go func(s int) {
+			err := c.acceptFrom(s)
+			if err != nil {
+				log.Warn("could not accept a joining server", "peer", s, "error", err)
+				c.setIncomingLink(s, LinkFailed)
+				return
+			}
+			if accepted != nil {
+				accepted(s)
+			}
+		}(k)
+		go func(s int) {
+			err := c.connectTo(s)
+			if err != nil {
+				log.Warn("could not connect to a joining server", "peer", s, "error", err)
+				c.setOutgoingLink(s, LinkFailed)
+			}
+		}(k)
+	}
+}
+
+func (c *ConnectionManager) acceptFrom(s int) error {
+	conn, err := c.Accept(s)
+	if err != nil {
+		return err
+	}
+	// log.Printf("Accepted %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
+	// hacky: force handshake
+	b := [4]byte{}
+	conn.Read(b[:])
+	if int(binary.LittleEndian.Uint32(b[:])) != s {
+		panic("Wrong server connected to accept")
+	}
+	return c.setConnection(true, s, conn)
+}
+
+func (c *ConnectionManager) connectTo(s int) error {
+	conn, err := c.Connect(s)
+	if err != nil {
+		return err
+	}
+	// log.Printf("Connected %s -> %s", conn.LocalAddr(), conn.RemoteAddr())
+	// hacky: force handshake
+	b := [4]byte{}
+	binary.LittleEndian.PutUint32(b[:], uint32(c.MyCfg.Id))
+	conn.Write(b[:])
+	return c.setConnection(false, s, conn)
+}
+
+// record a connection unless the server left while it was made
+func (c *ConnectionManager) setConnection(incoming bool, s int, conn net.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns, links := c.OutgoingConnections, c.outgoing
+	if incoming {
+		conns, links = c.IncomingConnections, c.incoming
+	}
+	if _, ok := c.configs[int64(s)]; !ok || s >= len(conns) {
+		conn.Close()
+		return errors.WrongServerError().From(s)
+	}
+	conns[s] = conn
+	c.setLink(links, s, LinkUp)
+	return nil
+}
@@ -0,0 +1,153 @@
+package results
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rounds with the same configuration are compared with each other
+type Key struct {
+	NumServers        int
+	NumUsers          int
+	F                 float64
+	NumLayers         int
+	BinSize           int
+	MessageSize       int64
+	CipherSuite       string
+	KeyAgreement      string
+	Verifiable        bool
+	PathEstablishment bool
+	Bandwidth         int
+	Latency           int
+}
+
+func (k Key) String() string {
+	kind := "lightning"
+	if k.PathEstablishment {
+		kind = "path"
+	} else if k.Verifiable {
+		kind = "verifiable"
+	}
+	return fmt.Sprintf("%s servers=%d users=%d f=%v layers=%d bins=%d size=%d suite=%s keys=%s bw=%d lat=%d",
+		kind, k.NumServers, k.NumUsers, k.F, k.NumLayers, k.BinSize, k.MessageSize, k.CipherSuite, k.KeyAgreement, k.Bandwidth, k.Latency)
+}
+
+func (r *Record) Key() Key {
+	agreement := r.KeyAgreement
+	if agreement == "" {
+		agreement = "dh"
+	}
+	return Key{
+		NumServers:        r.Params.NumServers,
+		NumUsers:          r.Params.NumUsers,
+		F:                 r.Params.F,
+		NumLayers:         r.Params.NumLayers,
+		BinSize:           r.Params.BinSize,
+		MessageSize:       r.MessageSize,
+		CipherSuite:       r.CipherSuite,
+		KeyAgreement:      agreement,
+		Verifiable:        r.Verifiable,
+		PathEstablishment: r.PathEstablishment,
+		Bandwidth:         r.Params.Bandwidth,
+		Latency:           r.Params.Latency,
+	}
+}
+
+type Comparison struct {
+	Key Key
+	// median ServerRoundTime of the rounds that completed
+	Base    time.Duration
+	Current time.Duration
+	// number of rounds on each side
+	BaseRounds    int
+	CurrentRounds int
+	// relative change of the current time over the base time
+	Change     float64
+	Regression bool
+}
+
+// median round time of each configuration
+func Summarize(records []*Record) (map[Key]time.Duration, map[Key]int) {
+	times := make(map[Key][]time.Duration)
+	for _, r := range records {
+		if r.Error != "" {
+			continue
+		}
+		k := r.Key()
+		times[k] = append(times[k], r.ServerRoundTime)
+	}
+	medians := make(map[Key]time.Duration)
+	counts := make(map[Key]int)
+	for k, t := range times {
+		sort.Slice(t, func(i, j int) bool { return t[i] < t[j] })
+		medians[k] = t[len(t)/2]
+		if len(t)%2 == 0 {
+			medians[k] = (t[len(t)/2-1] + t[len(t)/2]) / 2
+		}
+		counts[k] = len(t)
+	}
+	return medians, counts
+}
+
+// compare the configurations found in both runs
+// a configuration regresses when its round time grows by more than threshold, e.g. 0.1 for 10%
+func Compare(base, current []*Record, threshold float64) []Comparison {
+	baseTimes, baseCounts := Summarize(base)
+	curTimes, curCounts := Summarize(current)
+	comparisons := make([]Comparison, 0)
+	for k, cur := range curTimes {
+		b, ok := baseTimes[k]
+		if !ok || b == 0 {
+			continue
+		}
+		change := float64(cur-b) / float64(b)
+		comparisons = append(comparisons, Comparison{
+			Key:           k,
+			Base:          b,
+			Current:       cur,
+			BaseRounds:    baseCounts[k],
+			CurrentRounds: curCounts[k],
+			Change:        change,
+			Regression:    change > threshold,
+		})
+	}
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Key.String() < comparisons[j].Key.String()
+	})
+	return comparisons
+}
+
+// the columns read by the plotting scripts
+var csvHeader = []string{"numservers", "numusers", "f", "messagesize", "numlayers", "binsize", "bandwidth", "latency", "round", "pathestablishment", "roundtime"}
+
+func WriteCsv(w io.Writer, records []*Record) error {
+	c := csv.NewWriter(w)
+	err := c.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		err = c.Write([]string{
+			strconv.Itoa(r.Params.NumServers),
+			strconv.Itoa(r.Params.NumUsers),
+			strconv.FormatFloat(r.Params.F, 'g', -1, 64),
+			strconv.FormatInt(r.MessageSize, 10),
+			strconv.Itoa(r.Params.NumLayers),
+			strconv.Itoa(r.Params.BinSize),
+			strconv.Itoa(r.Params.Bandwidth),
+			strconv.Itoa(r.Params.Latency),
+			strconv.FormatInt(r.Round, 10),
+			strconv.FormatBool(r.PathEstablishment),
+			strconv.FormatInt(int64(r.ServerRoundTime), 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,162 @@
+package results
+
+// Results of coordinator experiments, one json record per round in a jsonl file
+// Every record carries the parameters of the run and the machine it ran on,
+// so files from different runs can be concatenated and compared
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// increment when a field changes meaning or is removed
+const SchemaVersion = 1
+
+type Record struct {
+	Schema int
+	// name of the experiment directory
+	Experiment        string
+	Round             int64
+	PathEstablishment bool
+	MessageSize       int64
+	CipherSuite       string
+	// key agreement of the path keys, records from before it was chosen used dh
+	KeyAgreement string `json:",omitempty"`
+	// servers committed to each layer they mixed
+	Verifiable  bool `json:",omitempty"`
+	NumMessages int
+	Passed      bool
+	Error       string `json:",omitempty"`
+	Start       time.Time
+	// durations in nanoseconds
+	KeyGenTime               time.Duration
+	SetupTime                time.Duration
+	ClientAndServerTokenTime time.Duration
+	ServerRoundTime          time.Duration
+	Params                   Params
+	Environment              Environment
+}
+
+// parameters of the network, the same for every round of an experiment
+type Params struct {
+	NumServers       int
+	NumUsers         int
+	F                float64
+	NumLayers        int
+	NumGroups        int
+	GroupSize        int
+	BinSize          int
+	LimitSize        int
+	NumClientServers int
+	RunType          int
+	Bandwidth        int
+	Latency          int
+	Notes            string `json:",omitempty"`
+}
+
+type Environment struct {
+	GoVersion string
+	OS        string
+	Arch      string
+	NumCPU    int
+	Hostname  string
+}
+
+func CurrentEnvironment() Environment {
+	host, _ := os.Hostname()
+	return Environment{
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Hostname:  host,
+	}
+}
+
+// appends records to a jsonl file
+type Store struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenStore(fn string) (*Store, error) {
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{f: f}, nil
+}
+
+func (s *Store) Append(r *Record) error {
+	if r.Schema == 0 {
+		r.Schema = SchemaVersion
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+func (s *Store) Close() error {
+	return s.f.Close()
+}
+
+func Read(r io.Reader) ([]*Record, error) {
+	records := make([]*Record, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := &Record{}
+		err := json.Unmarshal(scanner.Bytes(), rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if rec.Schema != SchemaVersion {
+			return nil, fmt.Errorf("line %d: schema version %d, expected %d", line, rec.Schema, SchemaVersion)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// load the records of a jsonl file, or of the results file in an experiment directory
+func Load(fn string) ([]*Record, error) {
+	info, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		fn = StorePath(fn)
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return records, nil
+}
+
+// the results file of an experiment directory
+func StorePath(dir string) string {
+	return filepath.Join(dir, "results.jsonl")
+}
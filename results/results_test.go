@@ -0,0 +1,147 @@
+package results
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(round int64, path bool, t time.Duration) *Record {
+	return &Record{
+		Round:             round,
+		PathEstablishment: path,
+		MessageSize:       1024,
+		Passed:            true,
+		ServerRoundTime:   t,
+		Params:            Params{NumServers: 10, NumUsers: 100, F: 0.2, NumLayers: 10},
+		Environment:       CurrentEnvironment(),
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(StorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Append(record(int64(i), false, time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	// appending to an existing store keeps the earlier records
+	s, err = OpenStore(StorePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append(record(3, true, time.Second))
+	s.Close()
+
+	records, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Round != int64(i) || r.Schema != SchemaVersion || r.ServerRoundTime != time.Second {
+			t.Fatalf("wrong record %+v", r)
+		}
+	}
+	if records[0].Environment.GoVersion == "" || records[0].Params.F != 0.2 {
+		t.Fatalf("missing fields %+v", records[0])
+	}
+}
+
+func TestReadSchema(t *testing.T) {
+	_, err := Read(strings.NewReader(`{"Schema": 2, "Round": 1}`))
+	if err == nil {
+		t.Fatal("read a newer schema")
+	}
+	_, err = Read(strings.NewReader(`{"Round": 1}` + "\n" + `{"Round": 2}`))
+	if err == nil {
+		t.Fatal("read records without a schema")
+	}
+	// the old concatenated format is rejected
+	_, err = Read(strings.NewReader("{\n \"Schema\": 1\n}{\n \"Schema\": 1\n}"))
+	if err == nil {
+		t.Fatal("read indented json")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := []*Record{
+		record(0, true, 10*time.Second),
+		record(10, false, time.Second),
+		record(11, false, 2*time.Second),
+		record(12, false, 3*time.Second),
+	}
+	failed := record(13, false, time.Hour)
+	failed.Error = "round aborted"
+	current := []*Record{
+		record(0, true, 10*time.Second),
+		record(10, false, 3*time.Second),
+		record(11, false, 3*time.Second),
+		failed,
+	}
+	other := record(0, false, time.Minute)
+	other.Params.NumServers = 20
+	current = append(current, other)
+
+	comparisons := Compare(base, current, 0.1)
+	if len(comparisons) != 2 {
+		t.Fatalf("expected 2 comparisons, got %d", len(comparisons))
+	}
+	for _, c := range comparisons {
+		if c.Key.PathEstablishment {
+			if c.Regression || c.Change != 0 {
+				t.Fatalf("path rounds did not change %+v", c)
+			}
+		} else {
+			if !c.Regression || c.Base != 2*time.Second || c.Current != 3*time.Second || c.CurrentRounds != 2 {
+				t.Fatalf("lightning rounds regressed %+v", c)
+			}
+		}
+	}
+	// records from before the key agreement was recorded used dh
+	for _, r := range current {
+		r.KeyAgreement = "dh"
+	}
+	if len(Compare(base, current, 0.1)) != 2 {
+		t.Fatal("dh records do not match records without a key agreement")
+	}
+	if len(Compare(base, current, 0.6)) != 2 {
+		t.Fatal("threshold changed the configurations")
+	}
+	for _, c := range Compare(base, current, 0.6) {
+		if c.Regression {
+			t.Fatalf("50%% increase is below the threshold %+v", c)
+		}
+	}
+}
+
+func TestWriteCsv(t *testing.T) {
+	b := &bytes.Buffer{}
+	err := WriteCsv(b, []*Record{record(4, false, 1500*time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][0] != "numservers" || len(rows[1]) != len(rows[0]) {
+		t.Fatalf("wrong csv %v", rows)
+	}
+	if rows[1][8] != "4" || rows[1][9] != "false" || rows[1][10] != "1500000000" {
+		t.Fatalf("wrong row %v", rows[1])
+	}
+	if filepath.Base(StorePath("x")) != "results.jsonl" {
+		t.Fatal("wrong store path")
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+// Admission control for client requests
+// so a burst of clients cannot pin all goroutines waiting for a round to start
+
+import (
+	"sync"
+	"time"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/metrics"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type AdmissionLimits struct {
+	// requests per second and burst size for each client and for all clients
+	ClientRate  float64
+	ClientBurst float64
+	GlobalRate  float64
+	GlobalBurst float64
+	// requests admitted but not yet answered
+	MaxPending int
+}
+
+func DefaultAdmissionLimits() AdmissionLimits {
+	return AdmissionLimits{
+		ClientRate:  config.ClientRequestRate,
+		ClientBurst: config.ClientRequestBurst,
+		GlobalRate:  config.GlobalRequestRate,
+		GlobalBurst: config.GlobalRequestBurst,
+		MaxPending:  config.MaxPendingRequests,
+	}
+}
+
+type AdmissionStats struct {
+	Pending     int
+	MaxPending  int
+	Admitted    uint64
+	RateLimited uint64
+	QueueFull   uint64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+type Admission struct {
+	limits  AdmissionLimits
+	mu      sync.Mutex
+	global  tokenBucket
+	clients map[int]*tokenBucket
+	pruneAt int
+	stats   AdmissionStats
+	now     func() time.Time
+}
+
+const minPruneSize = 1024
+
+func NewAdmission(limits AdmissionLimits) *Admission {
+	a := &Admission{
+		limits:  limits,
+		clients: make(map[int]*tokenBucket),
+		pruneAt: minPruneSize,
+		now:     time.Now,
+	}
+	a.global = tokenBucket{tokens: limits.GlobalBurst, last: a.now()}
+	return a
+}
+
+// only requests from clients are limited
+func isClientRequest(t messages.NetworkMessage_MessageType) bool {
+	return t == messages.NetworkMessage_ClientRegister ||
+		t == messages.NetworkMessage_ClientTokenRequest ||
+		t == messages.NetworkMessage_ClientTicketRequest ||
+		t == messages.NetworkMessage_ClientMessageSubmission ||
+		t == messages.NetworkMessage_ClientGetReceipt
+}
+
+// admit a request from a client, call Release when it has been answered
+// rejections are expected under load so they are not logged as errors
+func (a *Admission) Admit(client int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stats.Pending >= a.limits.MaxPending {
+		a.stats.QueueFull++
+		metrics.RejectedRequests.With("queue_full").Inc()
+		return status.Errorf(codes.ResourceExhausted, "%d requests already waiting", a.stats.Pending)
+	}
+	now := a.now()
+	b, ok := a.clients[client]
+	if !ok {
+		if len(a.clients) >= a.pruneAt {
+			a.prune(now)
+		}
+		b = &tokenBucket{tokens: a.limits.ClientBurst, last: now}
+		a.clients[client] = b
+	}
+	a.global.refill(now, a.limits.GlobalRate, a.limits.GlobalBurst)
+	b.refill(now, a.limits.ClientRate, a.limits.ClientBurst)
+	if b.tokens < 1 {
+		a.stats.RateLimited++
+		metrics.RejectedRequests.With("rate").Inc()
+		return status.Errorf(codes.ResourceExhausted, "client %d is over its request rate", client)
+	}
+	if a.global.tokens < 1 {
+		a.stats.RateLimited++
+		metrics.RejectedRequests.With("rate").Inc()
+		return status.Error(codes.ResourceExhausted, "server is over its request rate")
+	}
+	b.tokens--
+	a.global.tokens--
+	a.stats.Admitted++
+	a.stats.Pending++
+	if a.stats.Pending > a.stats.MaxPending {
+		a.stats.MaxPending = a.stats.Pending
+	}
+	return nil
+}
+
+func (a *Admission) Release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.Pending--
+}
+
+// forget clients whose buckets have refilled, they are the same as new clients
+func (a *Admission) prune(now time.Time) {
+	for client, b := range a.clients {
+		if now.Sub(b.last).Seconds()*a.limits.ClientRate+b.tokens >= a.limits.ClientBurst {
+			delete(a.clients, client)
+		}
+	}
+	a.pruneAt = 2 * len(a.clients)
+	if a.pruneAt < minPruneSize {
+		a.pruneAt = minPruneSize
+	}
+}
+
+func (a *Admission) Stats() AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestAdmission(limits AdmissionLimits) (*Admission, *time.Time) {
+	now := time.Unix(0, 0)
+	a := NewAdmission(limits)
+	a.now = func() time.Time { return now }
+	a.global.last = now
+	return a, &now
+}
+
+func expectExhausted(t *testing.T, err error) {
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected resource exhausted, got %v", err)
+	}
+}
+
+func TestAdmissionClientRate(t *testing.T) {
+	a, now := newTestAdmission(AdmissionLimits{ClientRate: 1, ClientBurst: 2, GlobalRate: 100, GlobalBurst: 100, MaxPending: 100})
+	for i := 0; i < 2; i++ {
+		if err := a.Admit(1); err != nil {
+			t.Fatal(err)
+		}
+		a.Release()
+	}
+	expectExhausted(t, a.Admit(1))
+	// other clients are not affected
+	if err := a.Admit(2); err != nil {
+		t.Fatal(err)
+	}
+	a.Release()
+	*now = now.Add(time.Second)
+	if err := a.Admit(1); err != nil {
+		t.Fatal(err)
+	}
+	a.Release()
+	stats := a.Stats()
+	if stats.Admitted != 4 || stats.RateLimited != 1 || stats.Pending != 0 {
+		t.Fatalf("Wrong stats %+v", stats)
+	}
+}
+
+func TestAdmissionGlobalRate(t *testing.T) {
+	a, _ := newTestAdmission(AdmissionLimits{ClientRate: 10, ClientBurst: 10, GlobalRate: 1, GlobalBurst: 3, MaxPending: 100})
+	for i := 0; i < 3; i++ {
+		if err := a.Admit(i); err != nil {
+			t.Fatal(err)
+		}
+		a.Release()
+	}
+	expectExhausted(t, a.Admit(4))
+}
+
+func TestAdmissionMaxPending(t *testing.T) {
+	a, _ := newTestAdmission(AdmissionLimits{ClientRate: 10, ClientBurst: 10, GlobalRate: 100, GlobalBurst: 100, MaxPending: 2})
+	for i := 0; i < 2; i++ {
+		if err := a.Admit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectExhausted(t, a.Admit(3))
+	a.Release()
+	if err := a.Admit(3); err != nil {
+		t.Fatal(err)
+	}
+	stats := a.Stats()
+	if stats.Pending != 2 || stats.MaxPending != 2 || stats.QueueFull != 1 {
+		t.Fatalf("Wrong stats %+v", stats)
+	}
+}
+
+func TestAdmissionPrune(t *testing.T) {
+	a, now := newTestAdmission(AdmissionLimits{ClientRate: 1, ClientBurst: 1, GlobalRate: 1e6, GlobalBurst: 1e6, MaxPending: 1e6})
+	for i := 0; i < minPruneSize; i++ {
+		a.Admit(i)
+		a.Release()
+	}
+	*now = now.Add(time.Second)
+	a.Admit(minPruneSize)
+	if len(a.clients) != 1 {
+		t.Fatalf("%d clients remembered after prune", len(a.clients))
+	}
+}
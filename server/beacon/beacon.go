@@ -0,0 +1,232 @@
+package beacon
+
+// A commit-reveal randomness beacon among the servers
+// For each label (an epoch, or a verifiable round) every server commits to a random value,
+// and only reveals it once it has the signed commitments of every server,
+// so no server can choose its value after seeing the others.
+// The output hashes all the values, so it is unpredictable and unbiased if any server is honest.
+// A server that does not reveal can stop a run but not change its output, and the transcript names it.
+// Anyone can run the beacon (see Run) and check the transcript with the servers' verification keys.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+const VALUE_SIZE = sha256.Size
+
+// what an output is used for
+const (
+	// anytrust groups and any other public randomness of an epoch
+	PurposeEpoch = "epoch"
+	// the link challenges of a verifiable round
+	PurposeRound = "round"
+)
+
+const maxPurposeLength = 64
+
+var (
+	commitDomain = []byte("lightning beacon commitment v1")
+	outputDomain = []byte("lightning beacon output v1")
+)
+
+type Output [VALUE_SIZE]byte
+
+// each label has one output
+type Label struct {
+	Purpose string
+	Number  int
+}
+
+func EpochLabel(epoch int) Label {
+	return Label{Purpose: PurposeEpoch, Number: epoch}
+}
+
+func RoundLabel(round int) Label {
+	return Label{Purpose: PurposeRound, Number: round}
+}
+
+func (l Label) String() string {
+	return fmt.Sprintf("%s %d", l.Purpose, l.Number)
+}
+
+func (l Label) Len() int {
+	return 4 + len(l.Purpose) + 4
+}
+
+// returns the number of bytes written
+func (l Label) PackTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(l.Purpose)))
+	pos := 4 + copy(b[4:], l.Purpose)
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(l.Number))
+	return pos + 4
+}
+
+// returns the number of bytes read
+func (l *Label) InterpretFrom(b []byte) (int, error) {
+	if len(b) < 4 {
+		return 0, errors.LengthInvalidError()
+	}
+	n := int(binary.LittleEndian.Uint32(b[0:4]))
+	if n > maxPurposeLength || len(b) < 4+n+4 {
+		return 0, errors.LengthInvalidError()
+	}
+	l.Purpose = string(b[4 : 4+n])
+	l.Number = int(binary.LittleEndian.Uint32(b[4+n : 8+n]))
+	return 8 + n, nil
+}
+
+// the label a request is for
+func ReadLabel(b []byte) (Label, error) {
+	l := Label{}
+	_, err := l.InterpretFrom(b)
+	return l, err
+}
+
+// binds the value to the label and the server
+func commit(label Label, server int, value *Output) Output {
+	h := sha256.New()
+	h.Write(commitDomain)
+	b := make([]byte, label.Len()+4)
+	pos := label.PackTo(b)
+	binary.LittleEndian.PutUint32(b[pos:], uint32(server))
+	h.Write(b)
+	h.Write(value[:])
+	var c Output
+	h.Sum(c[:0])
+	return c
+}
+
+// a signed commitment or reveal has the label followed by the hash or the value
+func newMessage(label Label, server int, v *Output, t messages.NetworkMessage_MessageType) *messages.SignedMessage {
+	m := messages.NewSignedMessage(label.Len()+VALUE_SIZE, label.Number, 0, server, 0, 0, 1, t)
+	pos := label.PackTo(m.Data)
+	copy(m.Data[pos:], v[:])
+	return m
+}
+
+// read the commitment or reveal server signed with vk
+func readMessage(m *messages.SignedMessage, t messages.NetworkMessage_MessageType, server int, vk crypto.VerificationKey) (Label, *Output, error) {
+	if m == nil || m.Type != t || m.Sender != server {
+		return Label{}, nil, errors.BadMetadataError().From(server)
+	}
+	if !crypto.Verify(vk, m.GetSignedData(), m.Signature) {
+		return Label{}, nil, errors.SignatureError().From(server)
+	}
+	label := Label{}
+	n, err := label.InterpretFrom(m.Data)
+	if err != nil {
+		return Label{}, nil, err
+	}
+	if len(m.Data)-n != VALUE_SIZE || label.Number != m.Round {
+		return Label{}, nil, errors.BadMetadataError().From(server)
+	}
+	v := &Output{}
+	copy(v[:], m.Data[n:])
+	return label, v, nil
+}
+
+// the commitment server signed for label, and its hash
+func ReadCommitment(m *messages.SignedMessage, label Label, server int, vk crypto.VerificationKey) (*Output, error) {
+	l, hash, err := readMessage(m, messages.NetworkMessage_BeaconCommitment, server, vk)
+	if err != nil {
+		return nil, err
+	}
+	if l != label {
+		return nil, errors.BadMetadataError().From(server)
+	}
+	return hash, nil
+}
+
+// a server's part in the beacon, which picks one value per label
+type Participant struct {
+	mu     sync.Mutex
+	server int
+	key    crypto.SigningKey
+	values map[Label]*Output
+	// the latest epoch revealed, earlier epochs are not run again
+	epoch int
+}
+
+func NewParticipant(server int, key crypto.SigningKey) *Participant {
+	return &Participant{
+		server: server,
+		key:    key,
+		values: make(map[Label]*Output),
+	}
+}
+
+// the signed commitment to this server's value for the label
+// epochs are run in order: after revealing an epoch a server only commits to that epoch and the next
+func (p *Participant) Commit(label Label) (*messages.SignedMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if label.Purpose == PurposeEpoch && (label.Number < p.epoch || label.Number > p.epoch+1) {
+		return nil, errors.BadMetadataError().InRound(label.Number).From(p.server)
+	}
+	v, ok := p.values[label]
+	if !ok {
+		v = &Output{}
+		_, err := rand.Read(v[:])
+		if err != nil {
+			return nil, err
+		}
+		p.values[label] = v
+	}
+	c := commit(label, p.server, v)
+	m := newMessage(label, p.server, &c, messages.NetworkMessage_BeaconCommitment)
+	m.Signature = crypto.SignData(p.key, m.GetSignedData())
+	return m, nil
+}
+
+// reveal this server's value once every server has committed
+// the commitments are indexed by server, and checked with vks
+func (p *Participant) Reveal(label Label, commitments []*messages.SignedMessage, vks []crypto.VerificationKey) (*messages.SignedMessage, error) {
+	if len(commitments) != len(vks) || p.server >= len(vks) {
+		return nil, errors.MissingMessages().InRound(label.Number)
+	}
+	hashes := make([]*Output, len(commitments))
+	for sid := range commitments {
+		if len(vks[sid]) == 0 {
+			// not in the network
+			continue
+		}
+		var err error
+		hashes[sid], err = ReadCommitment(commitments[sid], label, sid, vks[sid])
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.values[label]
+	if !ok || hashes[p.server] == nil || *hashes[p.server] != commit(label, p.server, v) {
+		return nil, errors.CommitFailure().InRound(label.Number).From(p.server)
+	}
+	if label.Purpose == PurposeEpoch && label.Number > p.epoch {
+		// no other value can be revealed for earlier epochs
+		p.epoch = label.Number
+		for l := range p.values {
+			if l.Purpose == PurposeEpoch && l.Number < p.epoch {
+				delete(p.values, l)
+			}
+		}
+	}
+	m := newMessage(label, p.server, v, messages.NetworkMessage_BeaconReveal)
+	m.Signature = crypto.SignData(p.key, m.GetSignedData())
+	return m, nil
+}
+
+// drop the value for a label that will not be revealed again
+func (p *Participant) Forget(label Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.values, label)
+}
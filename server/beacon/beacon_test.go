@@ -0,0 +1,131 @@
+package beacon
+
+import (
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+func testParticipants(n int) ([]*Participant, []crypto.VerificationKey) {
+	participants := make([]*Participant, n)
+	vks := make([]crypto.VerificationKey, n)
+	for sid := range participants {
+		var sk crypto.SigningKey
+		vks[sid], sk = crypto.NewSigningKeyPair()
+		participants[sid] = NewParticipant(sid, sk)
+	}
+	return participants, vks
+}
+
+// answers requests as a server's handler does, without a network
+func sendTo(participants []*Participant, vks []crypto.VerificationKey) func(int, *messages.SignedMessage) (*messages.SignedMessage, error) {
+	return func(sid int, m *messages.SignedMessage) (*messages.SignedMessage, error) {
+		m = messages.ParseSignedMessage(m.AsNetworkMessage())
+		if m.Type == messages.NetworkMessage_BeaconCommitment {
+			label, err := ReadLabel(m.Data)
+			if err != nil {
+				return nil, err
+			}
+			return participants[sid].Commit(label)
+		}
+		label, commitments, err := ReadRevealRequest(m)
+		if err != nil {
+			return nil, err
+		}
+		return participants[sid].Reveal(label, commitments, vks)
+	}
+}
+
+func TestBeacon(t *testing.T) {
+	participants, vks := testParticipants(4)
+	send := sendTo(participants, vks)
+	transcript, output, err := Run(RoundLabel(7), 0, vks, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := &Transcript{}
+	err = checked.InterpretFrom(transcript.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := checked.Verify(vks)
+	if err != nil || o != output {
+		t.Fatalf("%x %v", o, err)
+	}
+	b := transcript.Marshal()
+	if checked.InterpretFrom(b[:len(b)-1]) == nil || checked.InterpretFrom(append(b, 0)) == nil {
+		t.Fatal("accepted a transcript of the wrong length")
+	}
+	// the values are kept, so running again gives the same output
+	_, again, err := Run(RoundLabel(7), 1, vks, send)
+	if err != nil || again != output {
+		t.Fatalf("%x %v", again, err)
+	}
+	_, other, err := Run(RoundLabel(8), 0, vks, send)
+	if err != nil || other == output {
+		t.Fatalf("%x %v", other, err)
+	}
+}
+
+func TestBeaconFailures(t *testing.T) {
+	participants, vks := testParticipants(3)
+	send := sendTo(participants, vks)
+	transcript, _, err := Run(EpochLabel(1), 0, vks, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a server reveals another value than it committed to
+	participants[2].Forget(EpochLabel(1))
+	commitment, err := participants[2].Commit(EpochLabel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments := append([]*messages.SignedMessage{}, transcript.Commitments...)
+	commitments[2] = commitment
+	reveal, err := participants[2].Reveal(EpochLabel(1), commitments, vks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := &Transcript{Label: transcript.Label, Commitments: transcript.Commitments, Reveals: append([]*messages.SignedMessage{}, transcript.Reveals...)}
+	changed.Reveals[2] = reveal
+	_, err = changed.Verify(vks)
+	e := &errors.Error{}
+	if !errors.As(err, &e) || !errors.Is(err, errors.ErrCommit) || e.Peer != 2 {
+		t.Fatalf("server 2 not blamed: %v", err)
+	}
+
+	// a commitment signed by another server
+	changed = &Transcript{Label: transcript.Label, Commitments: append([]*messages.SignedMessage{}, transcript.Commitments...), Reveals: transcript.Reveals}
+	changed.Commitments[0], changed.Commitments[1] = changed.Commitments[1], changed.Commitments[0]
+	if _, err := changed.Verify(vks); err == nil {
+		t.Fatal("accepted commitments from the wrong servers")
+	}
+
+	// servers do not reveal without every commitment
+	if _, err := participants[0].Reveal(EpochLabel(1), transcript.Commitments[:2], vks); err == nil {
+		t.Fatal("revealed without every commitment")
+	}
+	// or for commitments to another label
+	if _, err := participants[0].Reveal(EpochLabel(2), transcript.Commitments, vks); err == nil {
+		t.Fatal("revealed for commitments to another label")
+	}
+}
+
+func TestBeaconEpochs(t *testing.T) {
+	participants, vks := testParticipants(2)
+	send := sendTo(participants, vks)
+	for _, epoch := range []int{1, 2} {
+		if _, _, err := Run(EpochLabel(epoch), 0, vks, send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := Run(EpochLabel(1), 0, vks, send); err == nil {
+		t.Fatal("ran an earlier epoch again")
+	}
+	if _, _, err := Run(EpochLabel(4), 0, vks, send); err == nil {
+		t.Fatal("skipped an epoch")
+	}
+}
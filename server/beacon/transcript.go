@@ -0,0 +1,230 @@
+package beacon
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+// the signed messages of one run, indexed by server
+// servers not in the network have no key, take no part and have no messages
+type Transcript struct {
+	Label       Label
+	Commitments []*messages.SignedMessage
+	Reveals     []*messages.SignedMessage
+}
+
+// check every commitment and reveal, and compute the output
+// vks are the verification keys of all the servers in the beacon
+func (t *Transcript) Verify(vks []crypto.VerificationKey) (Output, error) {
+	if len(t.Commitments) != len(vks) || len(t.Reveals) != len(vks) {
+		return Output{}, errors.MissingMessages().InRound(t.Label.Number)
+	}
+	h := sha256.New()
+	h.Write(outputDomain)
+	b := make([]byte, t.Label.Len())
+	t.Label.PackTo(b)
+	h.Write(b)
+	for sid := range vks {
+		if len(vks[sid]) == 0 {
+			if t.Commitments[sid] != nil || t.Reveals[sid] != nil {
+				return Output{}, errors.WrongServerError().InRound(t.Label.Number).From(sid)
+			}
+			continue
+		}
+		hash, err := ReadCommitment(t.Commitments[sid], t.Label, sid, vks[sid])
+		if err != nil {
+			return Output{}, err
+		}
+		label, v, err := readMessage(t.Reveals[sid], messages.NetworkMessage_BeaconReveal, sid, vks[sid])
+		if err != nil {
+			return Output{}, err
+		}
+		if label != t.Label || *hash != commit(label, sid, v) {
+			e := errors.CommitFailure().InRound(t.Label.Number).From(sid)
+			e.Cause = fmt.Errorf("revealed a value it did not commit to for %v", t.Label)
+			return Output{}, e
+		}
+		h.Write(v[:])
+	}
+	var o Output
+	h.Sum(o[:0])
+	return o, nil
+}
+
+// run the beacon for the label among the servers with the verification keys vks
+// send delivers a request to a server and returns its answer, as network.Caller.SendSignedMessage does
+// a request carries the label, and a reveal request the commitments of every server
+func Run(label Label, sender int, vks []crypto.VerificationKey, send func(server int, m *messages.SignedMessage) (*messages.SignedMessage, error)) (*Transcript, Output, error) {
+	t := &Transcript{Label: label}
+	// requests are not signed, and carry the label rather than a round so servers answer them at any time
+	req := messages.NewSignedMessage(label.Len(), 0, 0, sender, 0, 0, 1, messages.NetworkMessage_BeaconCommitment)
+	label.PackTo(req.Data)
+	req.GetSignedData()
+	var err error
+	t.Commitments, err = sendAll(vks, req, send)
+	if err != nil {
+		return nil, Output{}, err
+	}
+	for sid := range t.Commitments {
+		if len(vks[sid]) == 0 {
+			continue
+		}
+		_, err := ReadCommitment(t.Commitments[sid], label, sid, vks[sid])
+		if err != nil {
+			return nil, Output{}, err
+		}
+	}
+	commitments := packMessages(t.Commitments)
+	req = messages.NewSignedMessage(label.Len()+len(commitments), 0, 0, sender, 0, 0, 1, messages.NetworkMessage_BeaconReveal)
+	pos := label.PackTo(req.Data)
+	copy(req.Data[pos:], commitments)
+	req.GetSignedData()
+	t.Reveals, err = sendAll(vks, req, send)
+	if err != nil {
+		return nil, Output{}, err
+	}
+	o, err := t.Verify(vks)
+	if err != nil {
+		return nil, Output{}, err
+	}
+	return t, o, nil
+}
+
+// the label and commitments of a reveal request
+func ReadRevealRequest(m *messages.SignedMessage) (Label, []*messages.SignedMessage, error) {
+	label := Label{}
+	n, err := label.InterpretFrom(m.Data)
+	if err != nil {
+		return label, nil, err
+	}
+	commitments, m2, err := interpretMessages(m.Data[n:])
+	if err != nil {
+		return label, nil, err
+	}
+	if n+m2 != len(m.Data) {
+		return label, nil, errors.LengthInvalidError()
+	}
+	return label, commitments, nil
+}
+
+func sendAll(vks []crypto.VerificationKey, req *messages.SignedMessage, send func(int, *messages.SignedMessage) (*messages.SignedMessage, error)) ([]*messages.SignedMessage, error) {
+	answers := make([]*messages.SignedMessage, len(vks))
+	errs := make(chan error)
+	for sid := range answers {
+		if len(vks[sid]) == 0 {
+			continue
+		}
+		go func(sid int) {
+			var err error
+			answers[sid], err = send(sid, req)
+			if err != nil {
+				err = fmt.Errorf("server %d: %v", sid, err)
+			}
+			errs <- err
+		}(sid)
+	}
+	var err error
+	for sid := range answers {
+		if len(vks[sid]) == 0 {
+			continue
+		}
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return answers, err
+}
+
+// a missing message is only its zero length
+func messageLength(m *messages.SignedMessage) int {
+	if m == nil {
+		return 4
+	}
+	return 4 + messages.Metadata_size + len(m.Data) + crypto.SIGNATURE_SIZE
+}
+
+func packMessages(ms []*messages.SignedMessage) []byte {
+	n := 4
+	for _, m := range ms {
+		n += messageLength(m)
+	}
+	b := make([]byte, n)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(ms)))
+	pos := 4
+	for _, m := range ms {
+		if m == nil {
+			pos += 4
+			continue
+		}
+		raw := m.GetSignedData()
+		binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(raw)))
+		pos += 4
+		pos += copy(b[pos:], raw)
+		pos += copy(b[pos:pos+crypto.SIGNATURE_SIZE], m.Signature)
+	}
+	return b
+}
+
+// a count followed by that many signed messages, which are copied
+func interpretMessages(b []byte) ([]*messages.SignedMessage, int, error) {
+	if len(b) < 4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	count := int(binary.LittleEndian.Uint32(b[0:4]))
+	pos := 4
+	if count > (len(b)-pos)/4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	ms := make([]*messages.SignedMessage, count)
+	for i := range ms {
+		if len(b)-pos < 4 {
+			return nil, 0, errors.LengthInvalidError()
+		}
+		n := int(binary.LittleEndian.Uint32(b[pos : pos+4]))
+		pos += 4
+		if n == 0 {
+			continue
+		}
+		if n < messages.Metadata_size || n > len(b)-pos-crypto.SIGNATURE_SIZE {
+			return nil, 0, errors.LengthInvalidError()
+		}
+		raw := make([]byte, n+crypto.SIGNATURE_SIZE)
+		copy(raw, b[pos:pos+n+crypto.SIGNATURE_SIZE])
+		pos += n + crypto.SIGNATURE_SIZE
+		ms[i] = messages.ParseSignedMessage(&messages.NetworkMessage{Data: raw[:n], Signature: raw[n:]})
+	}
+	return ms, pos, nil
+}
+
+func (t *Transcript) Marshal() []byte {
+	b := make([]byte, t.Label.Len())
+	t.Label.PackTo(b)
+	b = append(b, packMessages(t.Commitments)...)
+	return append(b, packMessages(t.Reveals)...)
+}
+
+func (t *Transcript) InterpretFrom(b []byte) error {
+	n, err := t.Label.InterpretFrom(b)
+	if err != nil {
+		return err
+	}
+	pos := n
+	t.Commitments, n, err = interpretMessages(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	t.Reveals, n, err = interpretMessages(b[pos:])
+	if err != nil {
+		return err
+	}
+	if pos+n != len(b) {
+		return errors.LengthInvalidError()
+	}
+	return nil
+}
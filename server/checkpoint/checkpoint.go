@@ -5,6 +5,7 @@ import (
 
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/network/synchronization"
 	"github.com/simonlangowski/lightning1/server/common"
@@ -16,12 +17,19 @@ type VerificationKeyTable struct {
 	count int
 }
 
+// serials of the tickets spent at a group, kept across rounds until the next epoch
+type TicketTable struct {
+	mu      sync.Mutex
+	serials map[[common.TICKET_SERIAL_SIZE]byte]bool
+}
+
 type Checkpoint struct {
 	commonState          *common.CommonState
 	myGroupId            int
 	numGroups            int
 	groupKeyShare        *crypto.DHPrivateKey
 	AnonymousSigningKeys VerificationKeyTable
+	SpentTickets         TicketTable
 	synchronizer         *synchronization.Synchronizer
 	mu                   sync.Mutex
 	FinalMessages        [][]byte
@@ -38,6 +46,9 @@ func NewCheckpointState(c *common.CommonState, myGroupId int, secret *crypto.DHP
 			keys:  make(map[[32]byte]bool),
 			count: 0,
 		},
+		SpentTickets: TicketTable{
+			serials: make(map[[common.TICKET_SERIAL_SIZE]byte]bool),
+		},
 		synchronizer:  synchronizer,
 		FinalMessages: make([][]byte, 0),
 	}
@@ -114,13 +125,68 @@ func (c *Checkpoint) HandleTrusteeMessage(metadata *messages.Metadata, message [
 	if !crypto.VerifyMessage(fm.AnonymousVerificationKey, fm.Message, fm.Signature) {
 		return errors.DecryptionFailure()
 	}
+	post := fm.Message
+	if c.commonState.PostingQuota > 0 {
+		var ticket *common.Ticket
+		ticket, post, err = common.DetachTicket(fm.Message)
+		if err != nil {
+			return err
+		}
+		err = c.spendTicket(ticket)
+		if err != nil {
+			return err
+		}
+	}
 	c.mu.Lock()
-	c.FinalMessages = append(c.FinalMessages, fm.Message)
+	c.FinalMessages = append(c.FinalMessages, post)
 	c.mu.Unlock()
 
 	return nil
 }
 
+// a ticket is spent once, at the group it names
+func (c *Checkpoint) spendTicket(ticket *common.Ticket) error {
+	key := c.commonState.TicketKey
+	if key == nil || !key.VerifyMessage(&ticket.Token, common.TicketContent(c.myGroupId, &ticket.Serial)) {
+		metrics.TicketsSpent.With("invalid").Inc()
+		return errors.TokenInvalid().InRound(c.commonState.Round)
+	}
+	err := c.SpentTickets.Spend(&ticket.Serial)
+	if err != nil {
+		metrics.TicketsSpent.With("spent").Inc()
+		return err
+	}
+	metrics.TicketsSpent.With("accepted").Inc()
+	return nil
+}
+
+func (s *TicketTable) Spend(serial *[common.TICKET_SERIAL_SIZE]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.serials[*serial] {
+		return errors.Duplicate().WithKey(serial[:])
+	}
+	s.serials[*serial] = true
+	return nil
+}
+
+// the tickets of a new epoch are signed with a new key
+func (s *TicketTable) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serials = make(map[[common.TICKET_SERIAL_SIZE]byte]bool)
+}
+
 func (c *Checkpoint) AllSignaturesAccountedFor() bool {
-	return c.AnonymousSigningKeys.count == len(c.AnonymousSigningKeys.keys)
+	return c.MissingSignatures() == 0
+}
+
+func (c *Checkpoint) MissingSignatures() int {
+	return c.AnonymousSigningKeys.Missing()
+}
+
+func (s *VerificationKeyTable) Missing() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys) - s.count
 }
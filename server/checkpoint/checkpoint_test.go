@@ -4,6 +4,11 @@ import (
 	"testing"
 
 	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
+	"github.com/simonlangowski/lightning1/crypto/token"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/common"
 )
 
 func TestDecryptionLogic(t *testing.T) {
@@ -24,3 +29,84 @@ func TestDecryptionLogic(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func ticketFor(t *testing.T, secret *curve.Fr, pk *token.TokenPublicKey, group int) *common.Ticket {
+	ticket := common.NewTicket()
+	blindedHash, info := pk.Prepare(common.TicketContent(group, &ticket.Serial))
+	partial := curve.G1{}
+	err := token.NewTokenSigningKey(secret).BlindSign(&partial, blindedHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := info.Create([]curve.G1{partial})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket.Token = *signed
+	return ticket
+}
+
+// a post signed under a new anonymous key known to the checkpoint
+func trusteeMessage(c *Checkpoint, post []byte) []byte {
+	vk, sk := crypto.NewSigningKeyPair()
+	c.AnonymousSigningKeys.Add(vk)
+	fm := common.FinalLightningMessage{
+		AnonymousVerificationKey: vk,
+		Message:                  post,
+	}
+	fm.Signature = crypto.SignData(sk, fm.MarshalSigned())
+	return fm.Marshal()
+}
+
+func TestTickets(t *testing.T) {
+	secret := curve.Fr{}
+	secret.Random()
+	_, pk, _ := token.MockKeyGen(1, &secret)
+	cs := &common.CommonState{NumGroups: 2, PostingQuota: 2, TicketKey: pk}
+	groupShare, _ := crypto.NewDHKeyPair()
+	c := NewCheckpointState(cs, 1, &groupShare, nil)
+	metadata := &messages.Metadata{}
+
+	ticket := ticketFor(t, &secret, pk, 1)
+	err := c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticket, []byte("first"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.FinalMessages) != 1 || string(c.FinalMessages[0]) != "first" {
+		t.Fatalf("posted %q", c.FinalMessages)
+	}
+	// the same ticket on another path
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticket, []byte("again"))))
+	if !errors.Is(err, errors.ErrDuplicate) {
+		t.Fatalf("spent a ticket twice: %v", err)
+	}
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticketFor(t, &secret, pk, 0), []byte("other group"))))
+	if !errors.Is(err, errors.ErrTokenInvalid) {
+		t.Fatalf("spent a ticket of another group: %v", err)
+	}
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, []byte("no ticket")))
+	if err == nil {
+		t.Fatal("posted without a ticket")
+	}
+	if len(c.FinalMessages) != 1 {
+		t.Fatalf("posted %q", c.FinalMessages)
+	}
+
+	// spent tickets are kept across rounds, until the next epoch's key
+	c.AnonymousSigningKeys.ResetSignatureMarking()
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticket, []byte("next round"))))
+	if !errors.Is(err, errors.ErrDuplicate) {
+		t.Fatalf("spent a ticket again in the next round: %v", err)
+	}
+	secret.Random()
+	_, cs.TicketKey, _ = token.MockKeyGen(1, &secret)
+	c.SpentTickets.Reset()
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticket, []byte("next epoch"))))
+	if !errors.Is(err, errors.ErrTokenInvalid) {
+		t.Fatalf("spent a ticket of the last epoch: %v", err)
+	}
+	err = c.HandleTrusteeMessage(metadata, trusteeMessage(c, common.AttachTicket(ticketFor(t, &secret, cs.TicketKey, 1), []byte("next epoch"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
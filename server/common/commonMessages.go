@@ -1,10 +1,13 @@
 package common
 
 import (
+	"crypto/sha256"
+
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/network/messages"
 
 	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
 )
 
 // See shuffleMessages.go as well
@@ -15,6 +18,7 @@ type LightningEnvelope struct {
 }
 
 type PathEstablishmentEnvelope struct {
+	InKem   []byte // kem ciphertext to this server in hybrid rounds, empty otherwise
 	InKey   crypto.VerificationKey
 	InToken token.SignedToken // token signs round||layer||sending-server||InKey
 	// Signs round||layer||this-server||ciphertext under InKey
@@ -24,8 +28,10 @@ type PathEstablishmentEnvelope struct {
 
 // message encrypted in path establishment message
 type PathEstablishmentInfo struct {
-	OutKey   crypto.VerificationKey // for processing messages in reverse direction, this is the key they will be signed under
-	OutToken token.SignedToken      // token signs round||layer||this-server||OutKey
+	OutKem    []byte                 // kem ciphertext to the next server in hybrid rounds, sent as its InKem
+	ReturnKem []byte                 // kem ciphertext to this server for the reverse direction shared key under OutKey
+	OutKey    crypto.VerificationKey // for processing messages in reverse direction, this is the key they will be signed under
+	OutToken  token.SignedToken      // token signs round||layer||this-server||OutKey
 
 	BoomerangEnvelope []byte // receipt message to be decrypted and reverse onion routed.  Add lookup key from InKey and return as LightningEnvelope
 	NextEnvelope      []byte // next path establishment message.  Add key from OutKey and OutToken as InKey and InToken and send as PathEstablishmentEnvelope
@@ -38,6 +44,13 @@ type FinalLightningMessage struct {
 	Message                  []byte                 // The user's message
 }
 
+// returned to a client when the first server accepts its envelope
+type SubmissionReceipt struct {
+	EnvelopeHash [sha256.Size]byte
+	Round        int
+	Server       int
+}
+
 // Zero is not a point on the curve
 // We can do this because hidden by TLS, but isn't constant time
 func (l *LightningEnvelope) IsDummy() bool {
@@ -51,3 +64,35 @@ func ValidateSignature(key crypto.VerificationKey, m *messages.SignedMessage) bo
 func SignMessage(key crypto.SigningKey, m *messages.SignedMessage) {
 	m.Signature = crypto.SignData(key, m.GetSignedData())
 }
+
+// sign a receipt for an accepted submission, addressed to the submitting client
+func NewSubmissionReceipt(c *CommonState, submission *messages.SignedMessage) *messages.SignedMessage {
+	r := SubmissionReceipt{
+		EnvelopeHash: sha256.Sum256(submission.Data),
+		Round:        c.Round,
+		Server:       c.MyId,
+	}
+	m := messages.NewSignedMessage(r.Len(), c.Round, 0, c.MyId, int(submission.Group), submission.Sender, 1, submission.Type)
+	r.PackTo(m.Data)
+	c.Sign(m)
+	return m
+}
+
+// check that the receipt from server dest is for this submission
+func (c *CommonState) VerifySubmissionReceipt(dest int, submission, receipt *messages.SignedMessage) (*SubmissionReceipt, error) {
+	r := &SubmissionReceipt{}
+	if receipt == nil || receipt.Sender != dest {
+		return nil, errors.WrongReceipt()
+	}
+	err := r.InterpretFrom(receipt.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Verify(receipt) {
+		return nil, errors.SignatureError()
+	}
+	if r.EnvelopeHash != sha256.Sum256(submission.Data) || r.Round != submission.Round || r.Server != dest {
+		return nil, errors.WrongReceipt()
+	}
+	return r, nil
+}
@@ -3,11 +3,14 @@ package common
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/tracing"
+	"google.golang.org/protobuf/proto"
 )
 
 type CommonState struct {
@@ -23,6 +26,21 @@ type CommonState struct {
 	PathMessageLengths      []int
 	OnionMessageLengths     []int
 	BoomerangMessageLengths []int
+	// encryption of the onion layers in this round
+	Suite crypto.CipherSuite
+	// agreement of the path keys in this round
+	KeyAgreement crypto.KeyAgreement
+	// servers commit to each layer of this round, see processMessages/shuffleProof.go
+	Verifiable bool
+
+	// servers join and leave the network between epochs
+	Epoch int
+	// the protocol parameters the directory of the epoch fixes, rounds that differ are refused
+	Parameters config.Parameters
+	// posts each client can make in an epoch, each with a ticket, see tickets.go. 0 if posts need no ticket
+	PostingQuota int
+	// the servers of this epoch in increasing order, server ids index the slices of server keys
+	ServerIds []int
 
 	Configs         map[int64]*config.Server
 	GroupConfigs    *config.Groups
@@ -36,7 +54,11 @@ type CommonState struct {
 	ServerPublicKeys []crypto.DHPublicKey // server authenticated encryption public keys
 	ServerSecretKey  crypto.DHPrivateKey  // corresponding to the public diffie helman key parts for each server
 
+	ServerKemKeys   []crypto.KEMPublicKey // server ML-KEM encapsulation keys, nil if a server has none
+	ServerKemSecret *crypto.KEMPrivateKey // my decapsulation key, nil without one
+
 	CombinedKey *token.TokenPublicKey // public key shared by all anytrust groups
+	TicketKey   *token.TokenPublicKey // public key of the tickets of this epoch, shared by all anytrust groups
 	// PublicGroupKeys [][]*token.TokenPublicKey // group, server, used for signing tokens
 
 	GroupPublicKey crypto.DHPublicKey // public key shared by all anytrust groups
@@ -45,53 +67,173 @@ type CommonState struct {
 	RevokedKeys []map[crypto.DHPublicKey]bool // user keys revoked at each layer
 
 	Shufflers []*config.Shuffler
+
+	// span sending the current layer, carried in the metadata of outgoing batches
+	Trace tracing.SpanContext
 }
 
 func NewCommonState(configs map[int64]*config.Server, myId int64, groups *config.Groups) *CommonState {
-	c := &CommonState{
-		MyId:    int(myId),
-		Configs: configs,
-
-		Layer:      0,
-		NumServers: len(configs),
-
-		GroupConfigs:    groups,
-		NumGroups:       len(groups.Groups),
-		MasterGroupSize: len(groups.Groups[config.MASTER_GROUP].Servers),
-
-		// public signatures on links
-		VerificationKeys:         make([]crypto.VerificationKey, len(configs)),
-		ExpandedVerificationKeys: make([]*crypto.ExpandedVerificationKey, len(configs)),
-		SecretSigningKey:         configs[myId].SignatureKey,
-		// public keys for authenticated encryption
-		ServerPublicKeys: make([]crypto.DHPublicKey, len(configs)),
+	c := NewPublicCommonState(configs, groups)
+	c.MyId = int(myId)
+	// only this server's secrets are used, read from its keystore
+	err := config.CheckSecrets(configs[myId])
+	if err != nil {
+		panic(fmt.Sprintf("Bad config: %v", err))
+	}
+	c.SecretSigningKey = configs[myId].SignatureKey
+	err = c.ServerSecretKey.InterpretFrom(configs[myId].PrivateKey)
+	if err != nil {
+		panic("Bad config")
+	}
+	if len(configs[myId].KemPrivateKey) > 0 && crypto.KEMSupported {
+		c.ServerKemSecret = &crypto.KEMPrivateKey{}
+		err := c.ServerKemSecret.InterpretFrom(configs[myId].KemPrivateKey)
+		if err != nil {
+			panic("Bad config")
+		}
+	}
+	return c
+}
 
-		Shufflers: make([]*config.Shuffler, len(configs)),
+// the state of clients, which only have the public keys of the servers
+func NewPublicCommonState(configs map[int64]*config.Server, groups *config.Groups) *CommonState {
+	c := &CommonState{Layer: 0}
+	err := c.setServers(configs, groups)
+	if err != nil {
+		panic(fmt.Sprintf("Bad config: %v", err))
 	}
+	return c
+}
 
-	for i := range c.VerificationKeys {
-		c.VerificationKeys[i] = configs[int64(i)].VerificationKey
+// the servers and groups of an epoch, the keys of servers are indexed by their ids
+func (c *CommonState) setServers(configs map[int64]*config.Server, groups *config.Groups) error {
+	master, ok := groups.Groups[config.MASTER_GROUP]
+	if !ok {
+		return fmt.Errorf("no group %d", config.MASTER_GROUP)
+	}
+	n := config.IdSpace(configs)
+	ids := make([]int, 0, len(configs))
+	// public signatures on links
+	verificationKeys := make([]crypto.VerificationKey, n)
+	expandedVerificationKeys := make([]*crypto.ExpandedVerificationKey, n)
+	// public keys for authenticated encryption
+	serverPublicKeys := make([]crypto.DHPublicKey, n)
+	serverKemKeys := make([]crypto.KEMPublicKey, n)
+	shufflers := make([]*config.Shuffler, n)
+	for _, sid := range config.ServerIds(configs) {
+		cfg := configs[sid]
+		if cfg.Id != sid {
+			return fmt.Errorf("server %d has id %d", sid, cfg.Id)
+		}
+		ids = append(ids, int(sid))
+		verificationKeys[sid] = cfg.VerificationKey
 		var err error
-		c.ExpandedVerificationKeys[i], err = c.VerificationKeys[i].ExpandKey()
+		expandedVerificationKeys[sid], err = verificationKeys[sid].ExpandKey()
+		if err != nil {
+			return err
+		}
+		err = serverPublicKeys[sid].InterpretFrom(cfg.PublicKey)
 		if err != nil {
-			panic(err)
+			return err
 		}
+		// configs made before hybrid key agreement have no kem keys
+		serverKemKeys[sid] = cfg.KemPublicKey
+		shufflers[sid] = config.NewPRGShuffler(rand.Reader)
 	}
 
-	err := c.ServerSecretKey.InterpretFrom(configs[myId].PrivateKey)
+	c.Configs = configs
+	c.ServerIds = ids
+	c.NumServers = len(ids)
+	c.GroupConfigs = groups
+	c.NumGroups = len(groups.Groups)
+	c.MasterGroupSize = len(master.Servers)
+	c.VerificationKeys = verificationKeys
+	c.ExpandedVerificationKeys = expandedVerificationKeys
+	c.ServerPublicKeys = serverPublicKeys
+	c.ServerKemKeys = serverKemKeys
+	c.Shufflers = shufflers
+	return nil
+}
+
+// move to the servers and groups of a new epoch, called between rounds
+// a server keeps its id, keys and secrets
+func (c *CommonState) Reconfigure(epoch int, configs map[int64]*config.Server, groups *config.Groups) error {
+	if epoch <= c.Epoch {
+		return fmt.Errorf("epoch %d does not follow epoch %d", epoch, c.Epoch)
+	}
+	if c.SecretSigningKey != nil {
+		mine, ok := configs[int64(c.MyId)]
+		if !ok {
+			return fmt.Errorf("server %d is not in epoch %d", c.MyId, epoch)
+		}
+		current := c.Configs[int64(c.MyId)]
+		if !proto.Equal(config.Public(mine), config.Public(current)) {
+			return fmt.Errorf("the keys of server %d changed in epoch %d", c.MyId, epoch)
+		}
+		// the configs of an epoch are public, this server's secrets stay with it
+		withSecrets := make(map[int64]*config.Server, len(configs))
+		for sid, cfg := range configs {
+			withSecrets[sid] = cfg
+		}
+		withSecrets[int64(c.MyId)] = current
+		configs = withSecrets
+	}
+	err := c.setServers(configs, groups)
 	if err != nil {
-		panic("Bad config")
+		return err
 	}
-	for i := range c.ServerPublicKeys {
-		err := c.ServerPublicKeys[i].InterpretFrom(configs[int64(i)].PublicKey)
-		if err != nil {
-			panic("Bad config")
+	c.Epoch = epoch
+	return nil
+}
+
+// the length of slices indexed by server id, more than the number of servers once servers have left
+func (c *CommonState) IdSpace() int {
+	if len(c.VerificationKeys) > c.NumServers {
+		return len(c.VerificationKeys)
+	}
+	return c.NumServers
+}
+
+// group buffers borrow the shuffler of a server, there is a server with id gid unless it left
+func (c *CommonState) GroupShuffler(gid int) *config.Shuffler {
+	if gid < len(c.Shufflers) && c.Shufflers[gid] != nil {
+		return c.Shufflers[gid]
+	}
+	return c.Shufflers[c.ServerIds[gid%len(c.ServerIds)]]
+}
+
+// the server after sid in id order, wrapping around
+func (c *CommonState) NextServer(sid int) int {
+	for _, id := range c.ServerIds {
+		if id > sid {
+			return id
 		}
 	}
-	for i := range c.Shufflers {
-		c.Shufflers[i] = config.NewPRGShuffler(rand.Reader)
+	return c.ServerIds[0]
+}
+
+// whether a server is in the current epoch
+func (c *CommonState) HasServer(sid int) bool {
+	return sid >= 0 && sid < len(c.VerificationKeys) && c.VerificationKeys[sid] != nil
+}
+
+// every server can take part in a round with this key agreement
+func (c *CommonState) SupportsKeyAgreement(a crypto.KeyAgreement) bool {
+	if !a.Supported() {
+		return false
 	}
-	return c
+	if a == crypto.KeyAgreementHybrid {
+		// a server also needs its decapsulation key, clients have no secrets
+		if c.SecretSigningKey != nil && c.ServerKemSecret == nil {
+			return false
+		}
+		for _, sid := range c.ServerIds {
+			if len(c.ServerKemKeys[sid]) != crypto.KEM_PUBLIC_KEY_SIZE {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 func (c *CommonState) Sign(m *messages.SignedMessage) {
@@ -99,6 +241,9 @@ func (c *CommonState) Sign(m *messages.SignedMessage) {
 }
 
 func (c *CommonState) Verify(m *messages.SignedMessage) bool {
+	if !c.HasServer(m.Sender) {
+		return false
+	}
 	return crypto.VerifyExpanded(c.ExpandedVerificationKeys[m.Sender], m.GetSignedData(), m.Signature)
 }
 
@@ -113,6 +258,11 @@ func NewMockCommonStates(n int, template *CommonState) []*CommonState {
 	states := make([]*CommonState, n)
 	publicSignatureKeys := make([]crypto.VerificationKey, n)
 	authPublicKeys := make([]crypto.DHPublicKey, n)
+	kemPublicKeys := make([]crypto.KEMPublicKey, n)
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i
+	}
 	if template == nil {
 		template = &CommonState{NumServers: n}
 	}
@@ -124,10 +274,20 @@ func NewMockCommonStates(n int, template *CommonState) []*CommonState {
 		states[i] = &CommonState{}
 		*states[i] = *template
 		states[i].MyId = i
+		states[i].ServerIds = ids
 		states[i].VerificationKeys = publicSignatureKeys
 		states[i].SecretSigningKey = signingKey
 		states[i].ServerPublicKeys = authPublicKeys
 		states[i].ServerSecretKey = privateKey
+		if crypto.KEMSupported {
+			kemKey, kemPublicKey, err := crypto.NewKEMKeyPair()
+			if err != nil {
+				panic(err)
+			}
+			kemPublicKeys[i] = kemPublicKey
+			states[i].ServerKemKeys = kemPublicKeys
+			states[i].ServerKemSecret = kemKey
+		}
 	}
 	return states
 }
@@ -137,5 +297,5 @@ func (c *CommonState) HashToGroup(hash *[token.HASH_SIZE]byte) uint64 {
 }
 
 func (c *CommonState) HashToServer(hash *[token.HASH_SIZE]byte) uint64 {
-	return binary.LittleEndian.Uint64(hash[:]) % uint64(c.NumServers)
+	return uint64(c.ServerIds[binary.LittleEndian.Uint64(hash[:])%uint64(c.NumServers)])
 }
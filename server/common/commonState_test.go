@@ -0,0 +1,68 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+)
+
+// clients only read the public servers file
+func TestPublicCommonState(t *testing.T) {
+	servers := make(map[int64]*config.Server)
+	public := make(map[int64]*config.Server)
+	ids := make([]int64, 3)
+	for i := range ids {
+		ids[i] = int64(i)
+		servers[ids[i]] = config.CreateServerWithCertificate(fmt.Sprintf("localhost:%d", 8000+i), ids[i], nil, nil)
+		public[ids[i]] = config.Public(servers[ids[i]])
+	}
+	groups := &config.Groups{Groups: config.CreateSeparateGroupsWithSize(1, 2, ids, nil)}
+	c := NewPublicCommonState(public, groups)
+	if c.NumServers != 3 || c.SecretSigningKey != nil {
+		t.Fatal("wrong state")
+	}
+	if c.SupportsKeyAgreement(crypto.KeyAgreementHybrid) != crypto.KEMSupported {
+		t.Fatal("clients need no decapsulation key")
+	}
+	s := NewCommonState(servers, 1, groups)
+	if s.MyId != 1 || s.SecretSigningKey == nil || s.SupportsKeyAgreement(crypto.KeyAgreementHybrid) != crypto.KEMSupported {
+		t.Fatal("wrong server state")
+	}
+}
+
+// servers keep their ids when others join and leave
+func TestReconfigure(t *testing.T) {
+	servers := make(map[int64]*config.Server)
+	for i := int64(0); i < 4; i++ {
+		servers[i] = config.CreateServerWithCertificate(fmt.Sprintf("localhost:%d", 8000+i), i, nil, nil)
+	}
+	groups := &config.Groups{Groups: config.CreateSeparateGroupsWithSize(2, 2, config.ServerIds(servers), nil)}
+	c := NewCommonState(servers, 2, groups)
+	next := make(map[int64]*config.Server)
+	for sid, s := range servers {
+		if sid != 1 {
+			next[sid] = config.Public(s)
+		}
+	}
+	next[5] = config.Public(config.CreateServerWithCertificate("localhost:8005", 5, nil, nil))
+	nextGroups := &config.Groups{Groups: config.CreateSeparateGroupsWithSize(2, 2, config.ServerIds(next), nil)}
+	if c.Reconfigure(0, next, nextGroups) == nil {
+		t.Fatal("moved to the same epoch")
+	}
+	err := c.Reconfigure(1, next, nextGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Epoch != 1 || c.NumServers != 4 || c.IdSpace() != 6 || c.HasServer(1) || !c.HasServer(5) || c.HasServer(4) {
+		t.Fatalf("servers %v", c.ServerIds)
+	}
+	if c.NextServer(3) != 5 || c.NextServer(5) != 0 || !config.HasSecrets(c.Configs[2]) {
+		t.Fatal("wrong server order or lost secrets")
+	}
+	delete(next, 2)
+	if c.Reconfigure(2, next, nextGroups) == nil {
+		t.Fatal("moved to an epoch without this server")
+	}
+}
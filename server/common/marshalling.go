@@ -44,12 +44,18 @@ func (l *LightningEnvelope) GetSignature() crypto.Signature {
 }
 
 func (p *PathEstablishmentEnvelope) Len() int {
-	return crypto.POINT_SIZE + token.TOKEN_SIZE + len(p.SignedCiphertext)
+	return len(p.InKem) + crypto.POINT_SIZE + token.TOKEN_SIZE + len(p.SignedCiphertext)
 }
 
-func (p *PathEstablishmentEnvelope) InterpretFrom(b []byte) error {
+// kemSize is the ciphertext size of the round's key agreement
+func (p *PathEstablishmentEnvelope) InterpretFrom(b []byte, kemSize int) error {
+	if len(b) < kemSize+crypto.POINT_SIZE+token.TOKEN_SIZE {
+		return errors.LengthInvalidError()
+	}
 	pos := 0
-	err := p.InKey.InterpretFrom(b[:crypto.POINT_SIZE])
+	p.InKem = b[:kemSize]
+	pos += kemSize
+	err := p.InKey.InterpretFrom(b[pos : pos+crypto.POINT_SIZE])
 	pos += crypto.POINT_SIZE
 	p.InToken.InterpretFrom(b[pos : pos+token.TOKEN_SIZE])
 	if err != nil {
@@ -63,7 +69,9 @@ func (p *PathEstablishmentEnvelope) InterpretFrom(b []byte) error {
 
 func (p *PathEstablishmentEnvelope) PackTo(b []byte) {
 	pos := 0
-	p.InKey.PackTo(b[:crypto.POINT_SIZE])
+	copy(b[:len(p.InKem)], p.InKem)
+	pos += len(p.InKem)
+	p.InKey.PackTo(b[pos : pos+crypto.POINT_SIZE])
 	pos += crypto.POINT_SIZE
 	p.InToken.PackTo(b[pos : pos+token.TOKEN_SIZE])
 	pos += token.TOKEN_SIZE
@@ -77,8 +85,8 @@ func (p *PathEstablishmentEnvelope) Marshal() []byte {
 }
 
 func (p *PathEstablishmentEnvelope) GetSignedData(round, layer, server int) []byte {
-	// note that InToken was parsed into a curve element
-	return crypto.PackSignedData(round, layer, server, p.raw, crypto.POINT_SIZE+token.TOKEN_SIZE)
+	// note that InToken was parsed into a curve element, and InKem comes before the overwritten bytes
+	return crypto.PackSignedData(round, layer, server, p.raw, len(p.InKem)+crypto.POINT_SIZE+token.TOKEN_SIZE)
 }
 
 func (p *PathEstablishmentEnvelope) ReadSignature() crypto.Signature {
@@ -99,10 +107,14 @@ func TokenContent(key crypto.VerificationKey, round, layer, server int) []byte {
 }
 
 func (p *PathEstablishmentInfo) Marshal() []byte {
-	l := token.TOKEN_SIZE + crypto.POINT_SIZE + len(p.BoomerangEnvelope) + len(p.NextEnvelope)
+	l := len(p.OutKem) + len(p.ReturnKem) + token.TOKEN_SIZE + crypto.POINT_SIZE + len(p.BoomerangEnvelope) + len(p.NextEnvelope)
 	b := make([]byte, l)
 	pos := 0
-	p.OutKey.PackTo(b[:crypto.POINT_SIZE])
+	copy(b[pos:], p.OutKem)
+	pos += len(p.OutKem)
+	copy(b[pos:], p.ReturnKem)
+	pos += len(p.ReturnKem)
+	p.OutKey.PackTo(b[pos : pos+crypto.POINT_SIZE])
 	pos += crypto.POINT_SIZE
 	p.OutToken.PackTo(b[pos : pos+token.TOKEN_SIZE])
 	pos += token.TOKEN_SIZE
@@ -112,11 +124,15 @@ func (p *PathEstablishmentInfo) Marshal() []byte {
 	return b
 }
 
-func (p *PathEstablishmentInfo) InterpretFrom(b []byte, boomerangLength int) error {
+func (p *PathEstablishmentInfo) InterpretFrom(b []byte, boomerangLength, kemSize int) error {
 	// length has to be checked in advance because it is a function of the number of layers
 	p.raw = b
 	pos := 0
-	err := p.OutKey.InterpretFrom(b[:crypto.POINT_SIZE])
+	p.OutKem = b[pos : pos+kemSize]
+	pos += kemSize
+	p.ReturnKem = b[pos : pos+kemSize]
+	pos += kemSize
+	err := p.OutKey.InterpretFrom(b[pos : pos+crypto.POINT_SIZE])
 	if err != nil {
 		return err
 	}
@@ -134,7 +150,7 @@ func (p *PathEstablishmentInfo) InterpretFrom(b []byte, boomerangLength int) err
 
 func (p *PathEstablishmentInfo) GetSignedData(round, layer, server int) []byte {
 	// note that InToken was parsed into a curve element
-	return crypto.PackSignedData(round, layer, server, p.raw, crypto.POINT_SIZE+token.TOKEN_SIZE)
+	return crypto.PackSignedData(round, layer, server, p.raw, len(p.OutKem)+len(p.ReturnKem)+crypto.POINT_SIZE+token.TOKEN_SIZE)
 }
 
 func (p *PathEstablishmentInfo) GetSignature() crypto.Signature {
@@ -193,3 +209,26 @@ func (l *FinalLightningMessage) Marshal() []byte {
 	l.PackTo(b)
 	return b
 }
+
+func (r *SubmissionReceipt) Len() int {
+	return len(r.EnvelopeHash) + 8
+}
+
+func (r *SubmissionReceipt) PackTo(b []byte) {
+	if len(b) != r.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	pos := copy(b, r.EnvelopeHash[:])
+	binary.LittleEndian.PutUint32(b[pos:], uint32(r.Round))
+	binary.LittleEndian.PutUint32(b[pos+4:], uint32(r.Server))
+}
+
+func (r *SubmissionReceipt) InterpretFrom(b []byte) error {
+	if len(b) != r.Len() {
+		return errors.LengthInvalidError()
+	}
+	pos := copy(r.EnvelopeHash[:], b)
+	r.Round = int(binary.LittleEndian.Uint32(b[pos:]))
+	r.Server = int(binary.LittleEndian.Uint32(b[pos+4:]))
+	return nil
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/token"
+)
+
+func TestPathEstablishmentEnvelopeKem(t *testing.T) {
+	for _, agreement := range []crypto.KeyAgreement{crypto.KeyAgreementDH, crypto.KeyAgreementHybrid} {
+		kemSize := agreement.CiphertextSize()
+		vk, _ := crypto.NewSigningKeyPair()
+		p := &PathEstablishmentEnvelope{
+			InKem:            make([]byte, kemSize),
+			InKey:            vk,
+			SignedCiphertext: make([]byte, 100),
+		}
+		rand.Read(p.InKem)
+		rand.Read(p.SignedCiphertext)
+		b := p.Marshal()
+		if len(b) != kemSize+crypto.POINT_SIZE+token.TOKEN_SIZE+100 {
+			t.Fatalf("%v: envelope of length %d", agreement, len(b))
+		}
+		q := &PathEstablishmentEnvelope{}
+		err := q.InterpretFrom(b, kemSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(q.InKem, p.InKem) || !bytes.Equal(q.InKey, p.InKey) || !bytes.Equal(q.SignedCiphertext, p.SignedCiphertext) {
+			t.Fatalf("%v: envelope changed", agreement)
+		}
+		// the signed data overwrites the token, not the kem ciphertext
+		inKem := append([]byte{}, q.InKem...)
+		signed := q.GetSignedData(1, 2, 3)
+		if !bytes.Equal(q.InKem, inKem) || len(signed) != crypto.NONCE_SIZE+100-crypto.SIGNATURE_SIZE {
+			t.Fatalf("%v: signed data overlaps the kem ciphertext", agreement)
+		}
+		if q.InterpretFrom(b[:kemSize], kemSize) == nil {
+			t.Fatalf("%v: read a short envelope", agreement)
+		}
+	}
+}
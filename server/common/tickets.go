@@ -0,0 +1,83 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/simonlangowski/lightning1/crypto/token"
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+// A ticket lets a client post once in an epoch without the post being linked to the client.
+// The client's home group blind signs PostingQuota tickets for each client in an epoch,
+// and the anytrust group that decrypts the post checks its ticket and that it was not spent.
+// The coordinator deals a new ticket key every epoch, so tickets cannot be saved for a later epoch.
+// A ticket names the group it is spent at, so a group only needs to remember its own spent tickets.
+
+const TICKET_SERIAL_SIZE = 32
+
+var TICKET_SIZE = TICKET_SERIAL_SIZE + token.TOKEN_SIZE
+
+var ticketDomain = []byte("lightning ticket")
+
+type Ticket struct {
+	Serial [TICKET_SERIAL_SIZE]byte // random, so spent tickets cannot be linked to their issuance
+	Token  token.SignedToken        // token signs TicketContent(group, Serial) under the ticket key of the epoch
+}
+
+// a ticket with a fresh serial, to be blind signed
+func NewTicket() *Ticket {
+	t := &Ticket{}
+	rand.Read(t.Serial[:])
+	return t
+}
+
+// pack the bytes that are signed by a ticket's token
+func TicketContent(group int, serial *[TICKET_SERIAL_SIZE]byte) []byte {
+	b := make([]byte, len(ticketDomain)+4+TICKET_SERIAL_SIZE)
+	pos := copy(b, ticketDomain)
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(group))
+	copy(b[pos+4:], serial[:])
+	return b
+}
+
+func (t *Ticket) Len() int {
+	return TICKET_SIZE
+}
+
+func (t *Ticket) PackTo(b []byte) {
+	if len(b) != t.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	copy(b[:TICKET_SERIAL_SIZE], t.Serial[:])
+	t.Token.PackTo(b[TICKET_SERIAL_SIZE:])
+}
+
+func (t *Ticket) InterpretFrom(b []byte) error {
+	if len(b) != t.Len() {
+		return errors.LengthInvalidError()
+	}
+	copy(t.Serial[:], b[:TICKET_SERIAL_SIZE])
+	return t.Token.InterpretFrom(b[TICKET_SERIAL_SIZE:])
+}
+
+// a post carries its ticket in front of the message
+func AttachTicket(t *Ticket, message []byte) []byte {
+	b := make([]byte, TICKET_SIZE+len(message))
+	t.PackTo(b[:TICKET_SIZE])
+	copy(b[TICKET_SIZE:], message)
+	return b
+}
+
+// split a post into its ticket and message
+func DetachTicket(post []byte) (*Ticket, []byte, error) {
+	if len(post) < TICKET_SIZE {
+		return nil, nil, errors.LengthInvalidError()
+	}
+	t := &Ticket{}
+	err := t.InterpretFrom(post[:TICKET_SIZE])
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, post[TICKET_SIZE:], nil
+}
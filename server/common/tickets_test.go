@@ -0,0 +1,62 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
+	"github.com/simonlangowski/lightning1/crypto/token"
+)
+
+// blind sign a ticket with shares of a key, as the members of a home group do
+func signTicket(t *testing.T, shares []*token.TokenSigningKey, pk *token.TokenPublicKey, group int) *Ticket {
+	ticket := NewTicket()
+	blindedHash, info := pk.Prepare(TicketContent(group, &ticket.Serial))
+	partials := make([]curve.G1, len(shares))
+	for i := range shares {
+		err := shares[i].BlindSign(&partials[i], blindedHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	signed, err := info.Create(partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket.Token = *signed
+	return ticket
+}
+
+func TestTicket(t *testing.T) {
+	secret := curve.Fr{}
+	secret.Random()
+	shares, pk, _ := token.MockKeyGen(3, &secret)
+	ticket := signTicket(t, shares, pk, 1)
+	message := []byte("a post")
+	post := AttachTicket(ticket, message)
+	if len(post) != TICKET_SIZE+len(message) {
+		t.Fatalf("post of length %d", len(post))
+	}
+	spent, m, err := DetachTicket(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent.Serial != ticket.Serial || !bytes.Equal(m, message) {
+		t.Fatal("wrong ticket or message")
+	}
+	if !pk.VerifyMessage(&spent.Token, TicketContent(1, &spent.Serial)) {
+		t.Fatal("ticket does not verify")
+	}
+	if pk.VerifyMessage(&spent.Token, TicketContent(0, &spent.Serial)) {
+		t.Fatal("ticket verifies at another group")
+	}
+	secret.Random()
+	_, next, _ := token.MockKeyGen(3, &secret)
+	if next.VerifyMessage(&spent.Token, TicketContent(1, &spent.Serial)) {
+		t.Fatal("ticket verifies under the next epoch's key")
+	}
+	_, _, err = DetachTicket(post[:TICKET_SIZE-1])
+	if err == nil {
+		t.Fatal("read a ticket from a short post")
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+// Failures during a round
+// An invalid envelope (bad signature, duplicate, wrong server, ...) is counted against the server
+// or client that sent it and the round continues without it.
+// A failed link from another server drops that server for the rest of the round (churn),
+// its envelopes are counted as missing.
+// Anything else leaves the round in a state it cannot recover from, so the round is aborted
+// and the coordinator gets the reason from RoundStart and GetMessages.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/errors"
+)
+
+type RoundState int
+
+const (
+	RoundRunning RoundState = iota
+	RoundAborted
+)
+
+func (s RoundState) String() string {
+	if s == RoundAborted {
+		return "aborted"
+	}
+	return "running"
+}
+
+// who sent an envelope that failed, when it is not known
+const UnknownPeer = -1
+
+type FailureReport struct {
+	Round int
+	State RoundState
+	// peer -> kind of error -> count
+	Envelopes map[int]map[string]int
+	// servers dropped from the round and why
+	Churned map[int]string
+	// envelopes expected at the end of a layer that never arrived
+	Missing int
+	Reason  error
+}
+
+func (r *FailureReport) NumEnvelopeErrors() int {
+	n := 0
+	for _, kinds := range r.Envelopes {
+		for _, c := range kinds {
+			n += c
+		}
+	}
+	return n
+}
+
+func (r *FailureReport) String() string {
+	churned := make([]int, 0, len(r.Churned))
+	for sid := range r.Churned {
+		churned = append(churned, sid)
+	}
+	sort.Ints(churned)
+	s := fmt.Sprintf("round %d %v: %d envelope errors, %d missing, churned %v", r.Round, r.State, r.NumEnvelopeErrors(), r.Missing, churned)
+	if r.Reason != nil {
+		s += fmt.Sprintf(", reason: %v", r.Reason)
+	}
+	return s
+}
+
+type roundFailures struct {
+	mu     sync.Mutex
+	report FailureReport
+	// returned to the coordinator once the round is aborted
+	aborted error
+}
+
+func newRoundFailures(round int) *roundFailures {
+	return &roundFailures{report: FailureReport{
+		Round:     round,
+		Envelopes: make(map[int]map[string]int),
+		Churned:   make(map[int]string),
+	}}
+}
+
+// start counting for a new round
+// churned servers stay dropped since their links are not read anymore
+func (f *roundFailures) reset(round int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	churned := f.report.Churned
+	f.report = FailureReport{
+		Round:     round,
+		Envelopes: make(map[int]map[string]int),
+		Churned:   churned,
+	}
+	f.aborted = nil
+}
+
+func (f *roundFailures) round() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.report.Round
+}
+
+func errorPeer(err error) int {
+	e := &errors.Error{}
+	if errors.As(err, &e) && e.Known(errors.PeerField) {
+		return e.Peer
+	}
+	return UnknownPeer
+}
+
+func errorKind(err error) string {
+	e := &errors.Error{}
+	if errors.As(err, &e) {
+		return e.Kind.Error()
+	}
+	return err.Error()
+}
+
+func (f *roundFailures) envelope(err error) {
+	peer, kind := errorPeer(err), errorKind(err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.report.Envelopes[peer] == nil {
+		f.report.Envelopes[peer] = make(map[string]int)
+	}
+	f.report.Envelopes[peer][kind]++
+}
+
+// returns false if the peer was already dropped
+func (f *roundFailures) churn(peer int, err error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.report.Churned[peer]; ok {
+		return false
+	}
+	f.report.Churned[peer] = err.Error()
+	return true
+}
+
+// servers that left the network are not counted as dropped
+func (f *roundFailures) forget(peers []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, peer := range peers {
+		delete(f.report.Churned, peer)
+	}
+}
+
+func (f *roundFailures) numChurned() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.report.Churned)
+}
+
+func (f *roundFailures) missing(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.report.Missing += n
+}
+
+// returns false if the round was already aborted
+func (f *roundFailures) abort(err error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.report.State == RoundAborted {
+		return false
+	}
+	f.report.State = RoundAborted
+	f.report.Reason = err
+	f.aborted = errors.RoundAborted(fmt.Errorf("%s", f.report.String())).InRound(f.report.Round)
+	return true
+}
+
+// the error to give the coordinator, nil unless the round was aborted
+func (f *roundFailures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.aborted
+}
+
+func (f *roundFailures) Report() FailureReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.report
+	r.Envelopes = make(map[int]map[string]int)
+	for peer, kinds := range f.report.Envelopes {
+		r.Envelopes[peer] = make(map[string]int)
+		for k, c := range kinds {
+			r.Envelopes[peer][k] = c
+		}
+	}
+	r.Churned = make(map[int]string)
+	for peer, reason := range f.report.Churned {
+		r.Churned[peer] = reason
+	}
+	return r
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/synchronization"
+)
+
+func TestEnvelopeFailures(t *testing.T) {
+	f := newRoundFailures(3)
+	f.envelope(errors.Duplicate().At(3, 1).From(2))
+	f.envelope(errors.Duplicate().At(3, 1).From(2))
+	f.envelope(errors.SignatureError().From(0))
+	f.envelope(fmt.Errorf("unknown"))
+	f.missing(4)
+	r := f.Report()
+	if r.Envelopes[2][errors.ErrDuplicate.Error()] != 2 || r.Envelopes[0][errors.ErrSignature.Error()] != 1 {
+		t.Fatalf("Wrong attribution %v", r.Envelopes)
+	}
+	if r.Envelopes[UnknownPeer]["unknown"] != 1 {
+		t.Fatalf("Unattributed error not counted %v", r.Envelopes)
+	}
+	if r.NumEnvelopeErrors() != 4 || r.Missing != 4 || r.State != RoundRunning {
+		t.Fatalf("Wrong report %v", r.String())
+	}
+	if f.err() != nil {
+		t.Fatal("Running round has an error")
+	}
+}
+
+func TestChurnAndAbort(t *testing.T) {
+	f := newRoundFailures(1)
+	if !f.churn(2, errors.NetworkError(fmt.Errorf("reset"))) || f.churn(2, fmt.Errorf("again")) {
+		t.Fatal("Churned server twice")
+	}
+	if !f.abort(errors.MissingMessages()) || f.abort(fmt.Errorf("again")) {
+		t.Fatal("Aborted twice")
+	}
+	err := f.err()
+	if !errors.Is(err, errors.ErrRoundAborted) || errors.Code(err) != errors.ErrRoundAborted.Code() {
+		t.Fatalf("Wrong error %v", err)
+	}
+	f.reset(2)
+	r := f.Report()
+	if f.err() != nil || r.State != RoundRunning || r.Round != 2 {
+		t.Fatalf("Not reset %v", r.String())
+	}
+	// the link is not read anymore
+	if f.numChurned() != 1 {
+		t.Fatalf("Churned servers forgotten %v", r.Churned)
+	}
+}
+
+type countLayers struct {
+	layers chan int
+}
+
+func (c *countLayers) OnThreshold(layer int) (int, int) {
+	c.layers <- layer
+	return 2, layer + 1
+}
+
+func TestSynchronizerChurn(t *testing.T) {
+	c := &countLayers{layers: make(chan int, 1)}
+	s := synchronization.NewSynchronizer(0, 0, 3, c)
+	for _, id := range []int{0, 1} {
+		if err := s.SyncOnce(0, id); err != nil {
+			t.Fatal(err)
+		}
+		s.Done()
+	}
+	select {
+	case <-c.layers:
+		t.Fatal("Layer finished without server 2")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.Churn(2)
+	select {
+	case l := <-c.layers:
+		if l != 0 {
+			t.Fatalf("Finished layer %d", l)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Layer did not finish after churn")
+	}
+	// ids keep their slots after the threshold drops
+	s.Sync(1)
+	if err := s.SyncOnce(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SyncOnce(1, 3); err == nil {
+		t.Fatal("Out of range id accepted")
+	}
+}
@@ -2,10 +2,11 @@ package server
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/crypto/token"
-	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/network/synchronization"
 	"github.com/simonlangowski/lightning1/server/checkpoint"
@@ -32,26 +33,33 @@ type groupMember struct {
 	keyExchange            []*keyExchange.KeyExchange
 	messagePreparer        *prepareMessages.MessagePreparer
 	signingKey             token.TokenSigningKey
+	ticketKey              token.TokenSigningKey
 	secretShare            crypto.DHPrivateKey
 	checkpointSynchronizer *synchronization.Synchronizer
 	myGroupNumber          int
 	mu                     sync.Mutex
 	messagesReady          bool
 	messagesWait           *sync.Cond
+	failures               *roundFailures
+	log                    *logging.Logger
+	keysSet                int32
 }
 
-func NewGroupMember(myGroupNumber int, common *common.CommonState) *groupMember {
+func NewGroupMember(myGroupNumber int, common *common.CommonState, failures *roundFailures) *groupMember {
 	g := &groupMember{
 		c:             common,
 		myGroupNumber: myGroupNumber,
+		failures:      failures,
+		log:           logging.For("server").With("server", common.MyId, "group", myGroupNumber),
 		// keyExchange:   make([]*keyExchange.KeyExchange, numLayers),
 	}
 	g.messagesWait = sync.NewCond(&g.mu)
 	g.checkpointSynchronizer = synchronization.NewSynchronizer(g.c.Round, 0, g.c.NumServers, g)
+	g.checkpointSynchronizer.SetIdSpace(g.c.IdSpace())
 
 	// these take pointers to the keys, whose values will be set layer
 	g.CheckpointState = checkpoint.NewCheckpointState(g.c, g.myGroupNumber, &g.secretShare, g.checkpointSynchronizer)
-	g.messagePreparer = prepareMessages.NewMessagePreparer(common, &g.signingKey, myGroupNumber)
+	g.messagePreparer = prepareMessages.NewMessagePreparer(common, &g.signingKey, &g.ticketKey, myGroupNumber)
 
 	// for i := range g.keyExchange {
 	// 	if g.myGroupNumber == config.MASTER_GROUP {
@@ -64,16 +72,16 @@ func NewGroupMember(myGroupNumber int, common *common.CommonState) *groupMember
 }
 
 func (g *groupMember) OnThreshold(layer int) (int, int) {
-	if g.CheckpointState.AllSignaturesAccountedFor() {
-		g.mu.Lock()
-		defer g.mu.Unlock()
-		g.messagesReady = true
-		g.messagesWait.Broadcast()
-	} else {
-		// do blame and recovery protocol
-		panic(errors.MissingMessages())
+	if missing := g.CheckpointState.MissingSignatures(); missing > 0 {
+		// a blame and recovery protocol would find who dropped them, for now post what arrived
+		g.failures.missing(missing)
+		g.log.Warn("signatures missing at checkpoint", "round", g.c.Round, "missing", missing)
 	}
-	return g.c.NumServers, layer + 1
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.messagesReady = true
+	g.messagesWait.Broadcast()
+	return g.c.NumServers - g.failures.numChurned(), layer + 1
 }
 
 func (g *groupMember) NewLightningRound(checkpointLayer int) {
@@ -81,6 +89,8 @@ func (g *groupMember) NewLightningRound(checkpointLayer int) {
 	defer g.mu.Unlock()
 	g.CheckpointState.FinalMessages = make([][]byte, 0)
 	g.messagesReady = false
+	// servers may have joined since the last round
+	g.checkpointSynchronizer.SetIdSpace(g.c.IdSpace())
 	g.checkpointSynchronizer.Reset(g.c.Round, checkpointLayer, g.c.NumServers)
 	g.CheckpointState.AnonymousSigningKeys.ResetSignatureMarking()
 }
@@ -93,6 +103,18 @@ func (g *groupMember) HandleMessageSubmission(m *messages.SignedMessage) error {
 func (g *groupMember) SetKeys(t *token.TokenSigningKey, s *crypto.DHPrivateKey) {
 	g.signingKey = *t
 	g.secretShare = *s
+	atomic.StoreInt32(&g.keysSet, 1)
+}
+
+// a ticket key is dealt every epoch, and starts the quota and the spent tickets over
+func (g *groupMember) SetTicketKey(t *token.TokenSigningKey) {
+	g.ticketKey = *t
+	g.messagePreparer.ResetTickets()
+	g.CheckpointState.SpentTickets.Reset()
+}
+
+func (g *groupMember) hasKeys() bool {
+	return atomic.LoadInt32(&g.keysSet) == 1
 }
 
 func (g *groupMember) GetMessages() [][]byte {
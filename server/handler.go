@@ -10,35 +10,40 @@ import (
 	"github.com/simonlangowski/lightning1/config"
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/network/synchronization"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 type Handlers struct {
 	messages.UnimplementedMessageHandlersServer
-	round        int
-	wait         *sync.Cond
-	mu           sync.RWMutex
-	errorHandler func(error)
-	s            *Server
-}
-
-func DefaultErrorHandler(err error) {
-	// Ideally run some kind of blame protocol
-	// Might be a better idea to handle in errors package and recover where error is thrown
-	// (Have error functions take appropriate arguments to fix error)
-	panic(err)
+	round     int
+	wait      *sync.Cond
+	mu        sync.RWMutex
+	s         *Server
+	admission *Admission
+	log       *logging.Logger
 }
 
 func NewHandler() *Handlers {
-	h := &Handlers{round: synchronization.Blocked, errorHandler: DefaultErrorHandler}
+	h := &Handlers{
+		round:     synchronization.Blocked,
+		admission: NewAdmission(DefaultAdmissionLimits()),
+		log:       logging.For("server"),
+	}
 	h.wait = sync.NewCond(h.mu.RLocker())
 	return h
 }
 
 func (h *Handlers) SetServer(s *Server) {
 	h.s = s
+	h.log = s.log
+}
+
+func (h *Handlers) Admission() *Admission {
+	return h.admission
 }
 
 func (h *Handlers) SetRound(round int) {
@@ -61,29 +66,42 @@ func (h *Handlers) WaitForRound(round int) error {
 }
 
 // called by clients
-func (h *Handlers) HandleSignedMessage(_ context.Context, m *messages.NetworkMessage) (*messages.NetworkMessage, error) {
+func (h *Handlers) HandleSignedMessage(ctx context.Context, m *messages.NetworkMessage) (*messages.NetworkMessage, error) {
 	message := messages.ParseSignedMessage(m)
 	if message == nil {
 		return nil, errors.BadMetadataError()
 	}
+	if isClientRequest(message.Type) {
+		err := h.admission.Admit(message.Sender)
+		if err != nil {
+			return nil, err
+		}
+		defer h.admission.Release()
+	}
 	err := h.WaitForRound(message.Round)
 	if err != nil {
 		return nil, err
 	}
-	groupOp, exists := h.checkForGroup(message.Type, message.Group)
-	if groupOp && !exists {
-		h.errorHandler(errors.WrongServerError())
+	group, exists := h.s.group(message.Group)
+	if h.isGroupOp(message.Type) && !exists {
+		return nil, errors.WrongServerError().At(message.Round, message.Layer).From(message.Sender)
 	}
 	var response *messages.SignedMessage = nil
 	switch message.Type {
 	// Receive a share of a key
 	case messages.NetworkMessage_KeySharePush:
-		response, err = nil, h.s.GroupAliases[message.Group].keyExchange[message.Layer].ReceiveKeyShare(message)
+		err = h.authenticateServer(ctx, message.Sender)
+		if err == nil {
+			response, err = nil, group.keyExchange[message.Layer].ReceiveKeyShare(message)
+		}
 	case messages.NetworkMessage_ClientRegister:
-		response, err = nil, h.s.GroupAliases[message.Group].messagePreparer.RegisterClient(message)
+		response, err = nil, group.messagePreparer.RegisterClient(message)
 		// Request token signing from servers
 	case messages.NetworkMessage_ClientTokenRequest:
-		response, err = h.s.GroupAliases[message.Group].messagePreparer.HandleTokenRequest(message)
+		response, err = group.messagePreparer.HandleTokenRequest(message)
+		// Request the posting tickets of the epoch
+	case messages.NetworkMessage_ClientTicketRequest:
+		response, err = group.messagePreparer.HandleTicketRequest(message)
 		// Submit a message for the next round
 	case messages.NetworkMessage_ClientMessageSubmission:
 		// TODO: Use anytrust group to check this signature
@@ -91,10 +109,24 @@ func (h *Handlers) HandleSignedMessage(_ context.Context, m *messages.NetworkMes
 		response, err = h.s.HandleSubmissionMessage(message)
 	case messages.NetworkMessage_ClientGetReceipt:
 		response, err = h.s.GetReceipt(message)
+	case messages.NetworkMessage_ShuffleCommitment:
+		err = h.authenticateServer(ctx, message.Sender)
+		if err == nil {
+			response, err = nil, h.s.HandleShuffleCommitment(message)
+		}
+		// anyone can audit a verifiable round
+	case messages.NetworkMessage_ShuffleAudit:
+		response, err = h.s.HandleShuffleAudit(message)
+		// anyone can run the beacon
+	case messages.NetworkMessage_BeaconCommitment:
+		response, err = h.s.HandleBeaconCommitment(message)
+	case messages.NetworkMessage_BeaconReveal:
+		response, err = h.s.HandleBeaconReveal(message)
 	default:
 		err = errors.UnrecognizedError()
 	}
 	if err != nil {
+		errors.Log(h.log, logging.Warn, "request failed", err, "type", message.Type.String(), "round", message.Round, "sender", message.Sender)
 		return nil, err
 	}
 	if response == nil {
@@ -105,50 +137,83 @@ func (h *Handlers) HandleSignedMessage(_ context.Context, m *messages.NetworkMes
 
 }
 
+// bulk client messages from gateways, each answered with its own status
+func (h *Handlers) HandleSignedMessageStream(stream messages.MessageHandlers_HandleSignedMessageStreamServer) error {
+	return network.ServeSubmissionStream(stream, config.StreamWindow, h.HandleSignedMessage)
+}
+
+// messages between servers must carry the client certificate of the claimed sender
+func (h *Handlers) authenticateServer(ctx context.Context, sender int) error {
+	id, remote, err := network.CallerId(ctx, h.s.view().servers)
+	if err != nil {
+		return err
+	}
+	if remote && id != sender {
+		return errors.AuthenticationError()
+	}
+	return nil
+}
+
 func (h *Handlers) HandleTcpStream(c *network.ConnectionManager, source int, vk *crypto.ExpandedVerificationKey) {
 	for {
 		metadata, raw, err := c.ReadMetadata(source)
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				h.s.churn(source, errors.NetworkError(err))
 			}
-			h.errorHandler(errors.NetworkError(err))
+			return
 		}
-		config.LogTime("Received message: %v", metadata)
-		err = h.WaitForRound(metadata.Round)
-		start := time.Now()
+		err = h.handleStream(c, source, metadata, raw)
 		if err != nil {
-			h.errorHandler(err)
+			// the rest of the stream was not read, so nothing more from this server can be trusted
+			h.s.churn(source, err)
+			return
 		}
-		groupOp, exists := h.checkForGroup(metadata.Type, metadata.Group)
-		if groupOp && !exists {
-			h.errorHandler(errors.WrongServerError())
-		}
-		stream := h.s.ReadStream(metadata, c.IncomingConnections[source])
-		go stream.ContinuousReader(metadata)
-		if !groupOp {
-			err = h.s.WorkerPoolProcessStream(metadata, raw, stream)
-		} else {
-			err = h.s.WorkerPoolProcessGroup(metadata, raw, stream)
-		}
-		if err != nil {
-			h.errorHandler(err)
-		}
-		config.LogTime("Processed message: %v %v", metadata, time.Since(start))
 	}
 }
 
-func (h *Handlers) checkForGroup(t messages.NetworkMessage_MessageType, group int32) (bool, bool) {
-	if t == messages.NetworkMessage_ClientRegister ||
+func (h *Handlers) handleStream(c *network.ConnectionManager, source int, metadata *messages.Metadata, raw []byte) error {
+	log := h.log.With(metadata.LogFields()...)
+	log.Debug("received stream")
+	receive := tracing.StartSpan(metadata.Trace, "receive", "server", h.s.CommonState.MyId, "sender", metadata.Sender,
+		"round", metadata.Round, "layer", metadata.Layer, "type", metadata.Type.String())
+	defer receive.End()
+	setLabels(metadata.Round, metadata.Layer, "receive")
+	err := h.WaitForRound(metadata.Round)
+	if err != nil {
+		return err
+	}
+	groupOp := h.isGroupOp(metadata.Type)
+	if _, exists := h.s.group(metadata.Group); groupOp && !exists {
+		return errors.WrongServerError().At(metadata.Round, metadata.Layer).From(metadata.Sender)
+	}
+	start := time.Now()
+	process := tracing.StartSpan(receive.Context(), "process", "server", h.s.CommonState.MyId, "sender", metadata.Sender)
+	defer process.End()
+	stream := h.s.ReadStream(metadata, c.Incoming(source))
+	if stream == nil {
+		return errors.UnrecognizedError().At(metadata.Round, metadata.Layer).From(metadata.Sender)
+	}
+	go stream.ContinuousReader(metadata)
+	if !groupOp {
+		err = h.s.WorkerPoolProcessStream(metadata, raw, stream)
+	} else {
+		err = h.s.WorkerPoolProcessGroup(metadata, raw, stream)
+	}
+	log.Debug("processed stream", "took", time.Since(start))
+	return err
+}
+
+// messages handled by one of this server's groups
+func (h *Handlers) isGroupOp(t messages.NetworkMessage_MessageType) bool {
+	return t == messages.NetworkMessage_ClientRegister ||
 		t == messages.NetworkMessage_ClientTokenRequest ||
+		t == messages.NetworkMessage_ClientTicketRequest ||
 		t == messages.NetworkMessage_GroupCheckpointToken ||
-		t == messages.NetworkMessage_GroupCheckpointSignature {
-		_, exists := h.s.GroupAliases[group]
-		return true, exists
-	}
-	return false, true
+		t == messages.NetworkMessage_GroupCheckpointSignature
 }
 
+// a ping to measure latency, health is reported by the grpc health service
 func (h *Handlers) HealthCheck(_ context.Context, _ *messages.NetworkMessage) (*messages.NetworkMessage, error) {
 	m := &messages.NetworkMessage{Data: make([]byte, 64)}
 	return m, nil
@@ -160,7 +225,11 @@ func (h *Handlers) SkipPathGen(_ context.Context, k *messages.SkipPathGenMessage
 		// anonymous verification key
 		verKey := crypto.VerificationKey{}
 		verKey.InterpretFrom(k.SendingKey)
-		h.s.GroupAliases[k.Group].CheckpointState.AnonymousSigningKeys.Add(verKey)
+		group, ok := h.s.group(k.Group)
+		if !ok {
+			return nil, errors.WrongServerError()
+		}
+		group.CheckpointState.AnonymousSigningKeys.Add(verKey)
 	} else {
 		sendingKey := crypto.VerificationKey{}
 		forwardingKey := crypto.VerificationKey{}
@@ -170,8 +239,15 @@ func (h *Handlers) SkipPathGen(_ context.Context, k *messages.SkipPathGenMessage
 		if err != nil {
 			return nil, errors.BadElementError()
 		}
-		sharedKey := h.s.CommonState.ServerSecretKey.SharedKey(p)
-		h.s.Keys[k.Layer].AddKey(sendingKey, sharedKey, int(k.SendingServer), int(k.ForwardingServer), forwardingKey)
+		var kem []byte
+		if h.s.CommonState.KeyAgreement == crypto.KeyAgreementHybrid {
+			kem = k.SendingKem
+		}
+		sharedKey, err := h.s.Keys[k.Layer].SharedKey(p, kem)
+		if err != nil {
+			return nil, errors.DecryptionFailure().WithKey(k.SendingKey)
+		}
+		h.s.Keys[k.Layer].AddKey(sendingKey, sharedKey, int(k.SendingServer), int(k.ForwardingServer), forwardingKey, nil)
 	}
 	return &messages.NetworkMessage{}, nil
 }
@@ -0,0 +1,130 @@
+package server
+
+// Servers join and leave the network at epoch boundaries, see config/membership.go.
+// Between rounds a server moves to the servers and groups of the next epoch without restarting:
+// links to servers that left are closed, servers that joined are connected to,
+// and the groups whose members changed start over and wait for new key shares from the coordinator.
+// In process the coordinator reconfigures servers directly, otherwise a server that follows the
+// signed directory moves to the epoch the coordinator's round or key information is for.
+
+import (
+	"fmt"
+
+	"github.com/simonlangowski/lightning1/config"
+)
+
+// the groups and servers of an epoch, never modified once published
+// status queries and client requests read them without s.mu, which is held while a layer is processed
+type epochView struct {
+	groups       map[int32]*groupMember
+	servers      map[int64]*config.Server
+	groupConfigs map[int64]*config.Group
+}
+
+// publish the current epoch, called with s.mu held after s.GroupAliases or the configs change
+func (s *Server) publishView() {
+	s.current.Store(&epochView{
+		groups:       s.GroupAliases,
+		servers:      s.CommonState.Configs,
+		groupConfigs: s.CommonState.GroupConfigs.Groups,
+	})
+}
+
+// the groups and servers of the current epoch
+func (s *Server) view() *epochView {
+	return s.current.Load().(*epochView)
+}
+
+// this server's member of a group in the current epoch
+func (s *Server) group(gid int32) (*groupMember, bool) {
+	g, ok := s.view().groups[gid]
+	return g, ok
+}
+
+// move to the epoch of the directory in source when the coordinator asks for a newer epoch
+func (s *Server) FollowDirectory(source *config.DirectorySource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.directory = source
+}
+
+// move to the servers and groups of a new epoch, called between rounds
+func (s *Server) Reconfigure(epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reconfigure(epoch, servers, groups)
+}
+
+// called with s.mu held
+func (s *Server) reconfigure(epoch int, servers map[int64]*config.Server, groups map[int64]*config.Group) error {
+	prevServers, prevGroups := s.CommonState.Configs, s.CommonState.GroupConfigs.Groups
+	err := s.CommonState.Reconfigure(epoch, servers, &config.Groups{Groups: groups})
+	if err != nil {
+		return err
+	}
+	// the published map may still be read, so the groups of the new epoch go in a copy
+	aliases := make(map[int32]*groupMember, len(s.GroupAliases))
+	for gid, g := range s.GroupAliases {
+		if _, ok := groups[int64(gid)]; ok {
+			aliases[gid] = g
+		}
+	}
+	for _, gid := range config.ChangedGroups(prevServers, servers, prevGroups, groups) {
+		// the old shares of the group are useless with its new members
+		delete(aliases, int32(gid))
+		for _, sid := range groups[gid].Servers {
+			if sid == int64(s.CommonState.MyId) {
+				aliases[int32(gid)] = NewGroupMember(int(gid), s.CommonState, s.failures)
+				break
+			}
+		}
+	}
+	for _, g := range aliases {
+		// clients register again in every epoch, and get the epoch's tickets from their home group
+		g.messagePreparer.ForgetClients()
+	}
+	s.GroupAliases = aliases
+	s.publishView()
+	// paths may go through servers that left, so clients establish new ones
+	s.Keys = s.Keys[:0]
+	added, removed := s.TcpConnections.Reconfigure(s.CommonState.Configs)
+	s.failures.forget(removed)
+	if s.Caller != nil {
+		err = s.Caller.Reconfigure(s.CommonState.Configs)
+		if err != nil {
+			return err
+		}
+		s.Caller.SetGroups(groups)
+	}
+	// before the first round, the streams of every server are read once the round starts
+	var accepted func(int)
+	if s.started {
+		vks := s.CommonState.ExpandedVerificationKeys
+		accepted = func(sid int) {
+			s.handler.HandleTcpStream(s.TcpConnections, sid, vks[sid])
+		}
+	}
+	s.TcpConnections.LaunchJoined(added, accepted)
+	s.log.Info("moved to epoch", "epoch", epoch, "servers", s.CommonState.ServerIds, "joined", added, "left", removed)
+	return nil
+}
+
+// fetch the directory of the epoch the coordinator is in, called with s.mu held
+func (s *Server) advance(epoch int) error {
+	if epoch <= s.CommonState.Epoch {
+		return nil
+	}
+	if s.directory == nil {
+		return fmt.Errorf("server %d is in epoch %d and does not follow a directory to move to epoch %d", s.CommonState.MyId, s.CommonState.Epoch, epoch)
+	}
+	d, err := s.directory.FetchEpoch(epoch)
+	if err != nil {
+		return err
+	}
+	err = s.reconfigure(d.Epoch, d.Servers, d.Groups)
+	if err != nil {
+		return err
+	}
+	s.CommonState.Parameters = d.Parameters
+	return nil
+}
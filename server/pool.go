@@ -7,6 +7,7 @@ import (
 
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/checkpoint"
@@ -29,18 +30,16 @@ type Job struct {
 // }
 
 type WorkPool struct {
-	jobs         chan Job
-	errorHandler func(error)
-	s            *Server
+	jobs chan Job
+	s    *Server
 }
 
 var numWorkers = runtime.NumCPU()
 
-func NewWorkPool(h *Handlers, s *Server) *WorkPool {
+func NewWorkPool(s *Server) *WorkPool {
 	w := &WorkPool{
-		jobs:         make(chan Job, 100),
-		errorHandler: h.errorHandler,
-		s:            s,
+		jobs: make(chan Job, 100),
+		s:    s,
 	}
 	for i := 0; i < numWorkers; i++ {
 		go w.ProcessThread()
@@ -70,7 +69,9 @@ func (s *Server) WorkerPoolProcessStream(m *messages.Metadata, metadataBytes []b
 				break
 			}
 		}
-		if !allZero {
+		if allZero {
+			metrics.Dummies.Inc()
+		} else {
 			wg.Add(1)
 			s.pool.jobs <- Job{
 				idx:     idx,
@@ -84,9 +85,9 @@ func (s *Server) WorkerPoolProcessStream(m *messages.Metadata, metadataBytes []b
 		return stream.Err
 	}
 	// check signature
-	if !stream.CheckSignature(h, s.CommonState.ExpandedVerificationKeys[m.Sender]) {
+	if !s.CommonState.HasServer(m.Sender) || !stream.CheckSignature(h, s.CommonState.ExpandedVerificationKeys[m.Sender]) {
 		errors.DebugPrint("Verifying %v %v %v", m, s.CommonState.VerificationKeys[m.Sender], stream.Signature)
-		return errors.SignatureError()
+		return errors.SignatureError().At(m.Round, m.Layer).From(m.Sender)
 	}
 	wg.Wait()
 	return nil
@@ -96,7 +97,7 @@ func (s *Server) WorkerPoolProcessGroup(m *messages.Metadata, metadataBytes []by
 	wg := sync.WaitGroup{}
 	h := sha512.New()
 	h.Write(metadataBytes)
-	group := s.GroupAliases[m.Group]
+	group, _ := s.group(m.Group)
 	// the purpose of this is to track when the round is complete
 	// e.g After I have received all of the messages for this group
 	var response *messages.SignedMessage
@@ -128,9 +129,9 @@ func (s *Server) WorkerPoolProcessGroup(m *messages.Metadata, metadataBytes []by
 		return stream.Err
 	}
 	// check signature
-	if !stream.CheckSignature(h, s.CommonState.ExpandedVerificationKeys[m.Sender]) {
+	if !s.CommonState.HasServer(m.Sender) || !stream.CheckSignature(h, s.CommonState.ExpandedVerificationKeys[m.Sender]) {
 		// errors.DebugPrint("Verifying %v %v %v", m, s.CommonState.VerificationKeys[m.Sender], stream.Signature)
-		return errors.SignatureError()
+		return errors.SignatureError().At(m.Round, m.Layer).From(m.Sender)
 	}
 	wg.Wait()
 	if response != nil {
@@ -142,10 +143,16 @@ func (s *Server) WorkerPoolProcessGroup(m *messages.Metadata, metadataBytes []by
 }
 
 func (w *WorkPool) ProcessThread() {
+	labeled := messages.Metadata{Round: -1}
 	for job := range w.jobs {
 		var err error
 		metadata := job.m
 		stream := job.Message
+		// labels are only changed with the layer, setting them allocates
+		if metadata.Round != labeled.Round || metadata.Layer != labeled.Layer {
+			setLabels(metadata.Round, metadata.Layer, "process")
+			labeled = *metadata
+		}
 		// start := time.Now()
 		switch job.m.Type {
 		case messages.NetworkMessage_ServerMessageForward:
@@ -160,15 +167,18 @@ func (w *WorkPool) ProcessThread() {
 
 			// Check Tokens
 		case messages.NetworkMessage_GroupCheckpointToken:
-			err = w.s.GroupAliases[metadata.Group].CheckpointState.HandleCheckpointMessage(metadata, stream, job.Response)
+			group, _ := w.s.group(metadata.Group)
+			err = group.CheckpointState.HandleCheckpointMessage(metadata, stream, job.Response)
 			// Check final decryption
 		case messages.NetworkMessage_GroupCheckpointSignature:
-			err = w.s.GroupAliases[metadata.Group].CheckpointState.HandleTrusteeMessage(metadata, stream)
+			group, _ := w.s.group(metadata.Group)
+			err = group.CheckpointState.HandleTrusteeMessage(metadata, stream)
 		default:
 			err = errors.UnrecognizedError()
 		}
 		if err != nil {
-			w.errorHandler(err)
+			// the envelope is dropped and the rest of the batch is processed
+			w.s.envelopeError(err)
 		}
 		job.wg.Done()
 
@@ -3,13 +3,15 @@ package prepareMessages
 import (
 	"bytes"
 	"crypto/rand"
+	"time"
 
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
 	"github.com/simonlangowski/lightning1/crypto"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/common"
@@ -27,6 +29,13 @@ type Client struct {
 	routingKey               crypto.LookupKey
 	AnonymousVerificationKey crypto.VerificationKey
 	Receipts                 [][]byte
+	SubmissionReceipt        *messages.SignedMessage // signed by the first server for the last submission
+	registered               bool
+	// posting tickets for the group the client's posts go to
+	tickets      []*common.Ticket
+	ticketGroup  int
+	ticketKey    *token.TokenPublicKey // key of the epoch the tickets are for
+	ticketsTaken int                   // tickets requested under ticketKey
 }
 
 type PathKey struct {
@@ -36,6 +45,9 @@ type PathKey struct {
 	Shared       crypto.DHSharedKey
 	PrevServerID int64
 	PrevShared   crypto.DHSharedKey
+	// kem ciphertexts for Shared and PrevShared in hybrid rounds, only needed to make the path establishment message
+	Kem     []byte `json:"-"`
+	PrevKem []byte `json:"-"`
 }
 
 func NewClient(c *common.CommonState, ID int64, group int) (*Client, error) {
@@ -62,18 +74,24 @@ func (t *Client) RegisterClient(c *network.Caller) error {
 	req.PackTo(m.Data)
 	common.SignMessage(t.submissionKey, m)
 	_, err := c.SendToGroup(t.group, m)
+	if err == nil {
+		t.registered = true
+	}
 	return err
 }
 
+func (t *Client) Registered() bool {
+	return t.registered
+}
+
 func (t *Client) SubmitPathEstablishmentMessage(c *network.Caller, message *common.PathEstablishmentEnvelope) error {
 	dest := int(t.PathKeys[0].ServerID)
 	submission := messages.NewSignedMessage(message.Len(), t.Common.Round, 0, int(t.ID), t.group, dest, 1, messages.NetworkMessage_ClientMessageSubmission)
 	message.PackTo(submission.Data)
 	common.SignMessage(t.submissionKey, submission)
-	_, err := c.SendSignedMessage(dest, submission)
 	// post to public bulletin board - send to anytrust group since message is signed
 	// _, err = c.SendToGroup(t.group, submission)
-	return err
+	return t.submit(c, dest, submission)
 }
 
 func (t *Client) MakeOptimizedPathEstablishmentMessage(c *network.Caller, numLayers, boomerangLimit int) (*common.PathEstablishmentEnvelope, [][]byte, error) {
@@ -85,6 +103,8 @@ func (t *Client) MakeOptimizedPathEstablishmentMessage(c *network.Caller, numLay
 	var nextEnvelope []byte = nil
 	for l := numLayers - 1; l >= 0; l-- {
 		pInfo := common.PathEstablishmentInfo{}
+		pInfo.OutKem = t.PathKeys[l+1].Kem
+		pInfo.ReturnKem = t.PathKeys[l+1].PrevKem
 		pInfo.OutToken = *tokens[l+1]
 		pInfo.OutKey = pks[l+1]
 		pInfo.BoomerangEnvelope, receipts[l] = t.BoomerangBase(t.PathKeys, l, boomerangLimit)
@@ -96,6 +116,7 @@ func (t *Client) MakeOptimizedPathEstablishmentMessage(c *network.Caller, numLay
 		nextEnvelope = t.Encrypt(pInfo.Marshal(), t.PathKeys[l], l, l, int(t.PathKeys[l].ServerID), false)
 	}
 	pMessage := &common.PathEstablishmentEnvelope{}
+	pMessage.InKem = t.PathKeys[0].Kem
 	pMessage.InKey = pks[0]
 	pMessage.InToken = *tokens[0]
 	pMessage.SignedCiphertext = nextEnvelope
@@ -129,11 +150,17 @@ func (t *Client) MakeTokensAndPath(c *network.Caller, numLayers int) ([]*token.S
 			SigningKey: sk,
 			Secret:     *secret,
 			ServerID:   int64(nextServer),
-			Shared:     secret.SharedKey(&t.Common.ServerPublicKeys[nextServer]),
+		}
+		t.PathKeys[i].Shared, t.PathKeys[i].Kem, err = t.sharedKey(secret, int(nextServer))
+		if err != nil {
+			return nil, nil, err
 		}
 		if i != 0 {
 			t.PathKeys[i].PrevServerID = int64(prevServer)
-			t.PathKeys[i].PrevShared = secret.SharedKey(&t.Common.ServerPublicKeys[prevServer])
+			t.PathKeys[i].PrevShared, t.PathKeys[i].PrevKem, err = t.sharedKey(secret, prevServer)
+			if err != nil {
+				return nil, nil, err
+			}
 		}
 		publicKeys[i] = pk
 		prevServer = int(nextServer)
@@ -146,6 +173,9 @@ func (t *Client) MakeTokensAndPath(c *network.Caller, numLayers int) ([]*token.S
 		SigningKey:   sk,
 		PrevServerID: int64(prevServer),
 		PrevShared:   secret.SharedKey(&t.GroupPublicKey),
+		// the group shares a DH key but no kem key, the last server ignores these
+		Kem:     make([]byte, t.Common.KeyAgreement.CiphertextSize()),
+		PrevKem: make([]byte, t.Common.KeyAgreement.CiphertextSize()),
 	}
 	publicKeys[numLayers] = pk
 	t.AnonymousVerificationKey = pk
@@ -156,8 +186,23 @@ func (t *Client) MakeTokensAndPath(c *network.Caller, numLayers int) ([]*token.S
 	} else {
 		tokens[numLayers], err = t.GetToken(c, lastTokenContent, numLayers)
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the anytrust group the last server sends the checkpoint to
+	hash := tokens[numLayers].Hash()
+	t.PathKeys[numLayers].ServerID = int64(t.Common.HashToGroup(&hash))
 	t.routingKey = publicKeys[0].LookupKey()
-	return tokens, publicKeys, err
+	return tokens, publicKeys, nil
+}
+
+// the shared key with a server, and the kem ciphertext the server needs to recover it in hybrid rounds
+func (t *Client) sharedKey(secret *crypto.DHPrivateKey, server int) (crypto.DHSharedKey, []byte, error) {
+	shared := secret.SharedKey(&t.Common.ServerPublicKeys[server])
+	if t.Common.KeyAgreement != crypto.KeyAgreementHybrid {
+		return shared, nil, nil
+	}
+	return t.Common.ServerKemKeys[server].HybridEncapsulate(shared)
 }
 
 func (t *Client) GetToken(c *network.Caller, message []byte, layer int) (*token.SignedToken, error) {
@@ -173,7 +218,7 @@ func (t *Client) GetToken(c *network.Caller, message []byte, layer int) (*token.
 	if err != nil {
 		return nil, err
 	}
-	partialSignatures := make([]mcl.G1, len(responses))
+	partialSignatures := make([]curve.G1, len(responses))
 	for i := range partialSignatures {
 		err := partialSignatures[i].InterpretFrom(responses[i].Data)
 		if err != nil {
@@ -183,6 +228,76 @@ func (t *Client) GetToken(c *network.Caller, message []byte, layer int) (*token.
 	return issuanceInfo.Create(partialSignatures)
 }
 
+// request n tickets for posts to group, blind signed by the client's home group
+func (t *Client) GetTickets(c *network.Caller, group, n int) ([]*common.Ticket, error) {
+	tickets := make([]*common.Ticket, n)
+	issuanceInfo := make([]*token.TokenIssuanceInformation, n)
+	tr := TicketRequest{
+		ID:      t.ID,
+		Tickets: make([]curve.G1, n),
+	}
+	for i := range tickets {
+		tickets[i] = common.NewTicket()
+		blindedHash, info := t.Common.TicketKey.Prepare(common.TicketContent(group, &tickets[i].Serial))
+		tr.Tickets[i] = *blindedHash
+		issuanceInfo[i] = info
+	}
+	m := messages.NewSignedMessage(tr.Len(), t.Common.Round, -1, int(t.ID), t.group, 0, 1, messages.NetworkMessage_ClientTicketRequest)
+	tr.PackTo(m.Data)
+	common.SignMessage(t.submissionKey, m)
+	responses, err := c.SendToGroup(t.group, m)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tickets {
+		partialSignatures := make([]curve.G1, len(responses))
+		for j := range partialSignatures {
+			if len(responses[j].Data) != n*curve.G1_LEN {
+				return nil, errors.LengthInvalidError()
+			}
+			err := partialSignatures[j].InterpretFrom(responses[j].Data[i*curve.G1_LEN : (i+1)*curve.G1_LEN])
+			if err != nil {
+				return nil, err
+			}
+		}
+		signed, err := issuanceInfo[i].Create(partialSignatures)
+		if err != nil {
+			return nil, err
+		}
+		tickets[i].Token = *signed
+	}
+	return tickets, nil
+}
+
+// a ticket for a post to group
+// the first post of an epoch to a group requests all of the client's remaining tickets for it,
+// so the home group learns no more than when the client first posts in the epoch
+func (t *Client) NextTicket(c *network.Caller, group int) (*common.Ticket, error) {
+	if t.ticketKey != t.Common.TicketKey {
+		// tickets of the last epoch no longer verify
+		t.tickets, t.ticketKey, t.ticketsTaken = nil, t.Common.TicketKey, 0
+	}
+	if t.ticketGroup != group {
+		// only the group a ticket names accepts it
+		t.tickets, t.ticketGroup = nil, group
+	}
+	if len(t.tickets) == 0 {
+		n := t.Common.PostingQuota - t.ticketsTaken
+		if n <= 0 {
+			return nil, errors.QuotaExceeded().InRound(t.Common.Round).From(int(t.ID))
+		}
+		tickets, err := t.GetTickets(c, group, n)
+		if err != nil {
+			return nil, err
+		}
+		t.tickets = tickets
+		t.ticketsTaken += n
+	}
+	ticket := t.tickets[0]
+	t.tickets = t.tickets[1:]
+	return ticket, nil
+}
+
 func (t *Client) BoomerangBase(currentPath []*PathKey, round, boomerangLimit int) ([]byte, []byte) {
 	nonce := make([]byte, 8)
 	rand.Read(nonce)
@@ -218,7 +333,7 @@ func (t *Client) Encrypt(message []byte, key *PathKey, round, layer, serverId in
 	} else {
 		sharedKey = key.PrevShared
 	}
-	m := crypto.SignedSecretSeal(message, &nonce, sharedKey, key.SigningKey)
+	m := t.Common.Suite.SignedSecretSeal(message, &nonce, sharedKey, key.SigningKey)
 	return m
 }
 
@@ -232,6 +347,14 @@ func (t *Client) GetFinalMessage(numLayers int, message []byte) *common.FinalLig
 
 // Submit the message to the network in lightning round
 func (t *Client) SendLightningMessage(c *network.Caller, keys []*PathKey, message []byte) error {
+	if t.Common.PostingQuota > 0 {
+		// checked by the anytrust group at the end of the path
+		ticket, err := t.NextTicket(c, int(keys[len(keys)-1].ServerID))
+		if err != nil {
+			return err
+		}
+		message = common.AttachTicket(ticket, message)
+	}
 	finalMessage := t.GetFinalMessage(len(keys)-1, message)
 	submission := common.LightningEnvelope{
 		Key:              t.routingKey,
@@ -241,8 +364,38 @@ func (t *Client) SendLightningMessage(c *network.Caller, keys []*PathKey, messag
 	submission.PackTo(submissionMessage.Data)
 	common.SignMessage(t.submissionKey, submissionMessage)
 	// Send to the first server and public bulletin board?
-	_, err := c.SendSignedMessage(int(keys[0].ServerID), submissionMessage)
 	// _, err = c.SendToGroup(t.group, submissionMessage)
+	return t.submit(c, int(keys[0].ServerID), submissionMessage)
+}
+
+// send a submission until the server acknowledges it
+// the server gives the same receipt for the same envelope, so a retry cannot submit twice
+func (t *Client) submit(c *network.Caller, dest int, submission *messages.SignedMessage) error {
+	var err error
+	delay := config.SubmissionRetryDelay * time.Millisecond
+	for attempt := 0; attempt < config.SubmissionAttempts; attempt++ {
+		if attempt > 0 {
+			metrics.Submissions.With("retried").Inc()
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var receipt *messages.SignedMessage
+		receipt, err = c.SendSignedMessage(dest, submission)
+		if err == nil {
+			_, err = t.Common.VerifySubmissionReceipt(dest, submission, receipt)
+			if err == nil {
+				metrics.Submissions.With("accepted").Inc()
+				t.SubmissionReceipt = receipt
+			} else {
+				metrics.Submissions.With("failed").Inc()
+			}
+			return err
+		}
+		if !network.Retryable(err) {
+			break
+		}
+	}
+	metrics.Submissions.With("failed").Inc()
 	return err
 }
 
@@ -270,7 +423,7 @@ func (t *Client) CheckReceipt(c *network.Caller, round int) error {
 // to skip path generation
 func (t *Client) SkipPathGen(c *network.Caller, info *coord.RoundInfo) error {
 	numLayers := int(info.NumLayers)
-	numServers := t.Common.NumServers
+	servers := t.Common.ServerIds
 	t.PathKeys = make([]*PathKey, numLayers+1)
 	publicKeys := make([]crypto.VerificationKey, numLayers+1)
 	r := config.NewPRGShuffler(rand.Reader)
@@ -278,17 +431,25 @@ func (t *Client) SkipPathGen(c *network.Caller, info *coord.RoundInfo) error {
 	for i := 0; i < numLayers; i++ {
 		pk, sk := crypto.NewSigningKeyPair()
 		s, _ := sk.ToScalar()
-		nextServer := r.Intn(numServers)
+		nextServer := servers[r.Intn(len(servers))]
 		t.PathKeys[i] = &PathKey{
 			Secret:     *s,
 			SigningKey: sk,
 			ServerID:   int64(nextServer),
-			Shared:     s.SharedKey(&t.Common.ServerPublicKeys[nextServer]),
+		}
+		var err error
+		t.PathKeys[i].Shared, t.PathKeys[i].Kem, err = t.sharedKey(s, nextServer)
+		if err != nil {
+			return err
 		}
 		publicKeys[i] = pk
 	}
 
 	group := r.Intn(t.Common.NumGroups)
+	if len(t.tickets) > 0 && t.ticketKey == t.Common.TicketKey {
+		// the tickets the client holds are only accepted by their group
+		group = t.ticketGroup
+	}
 	pk, sk := crypto.NewSigningKeyPair()
 	s, _ := sk.ToScalar()
 	t.PathKeys[numLayers] = &PathKey{
@@ -303,6 +464,7 @@ func (t *Client) SkipPathGen(c *network.Caller, info *coord.RoundInfo) error {
 	for l := numLayers - 1; l >= 0; l-- {
 		s := &messages.SkipPathGenMessage{
 			SendingKey:       publicKeys[l].Bytes(),
+			SendingKem:       t.PathKeys[l].Kem,
 			Layer:            int32(l),
 			ForwardingServer: int32(t.PathKeys[l+1].ServerID),
 			ForwardKey:       publicKeys[l+1].Bytes(),
@@ -0,0 +1,22 @@
+package prepareMessages
+
+import (
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto"
+)
+
+// hybrid messages carry an InKem on the wire and an OutKem and ReturnKem in every layer
+func TestPathEstablishmentLengthsHybrid(t *testing.T) {
+	layers, receipt, limit := 10, 8, 5
+	for _, suite := range []crypto.CipherSuite{crypto.SuiteLegacy, crypto.SuiteChaCha20Poly1305} {
+		dh := PathEstablishmentLengths(layers, receipt, limit, suite, crypto.KeyAgreementDH)
+		hybrid := PathEstablishmentLengths(layers, receipt, limit, suite, crypto.KeyAgreementHybrid)
+		for l := range dh {
+			extra := crypto.KEM_CIPHERTEXT_SIZE + 2*crypto.KEM_CIPHERTEXT_SIZE*(layers-l)
+			if hybrid[l]-dh[l] != extra {
+				t.Fatalf("%v layer %d: %d extra bytes, expected %d", suite, l, hybrid[l]-dh[l], extra)
+			}
+		}
+	}
+}
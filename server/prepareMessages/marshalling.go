@@ -4,7 +4,7 @@ import (
 	"encoding/binary"
 
 	"github.com/simonlangowski/lightning1/crypto"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/errors"
 	"github.com/simonlangowski/lightning1/server/common"
 )
@@ -16,7 +16,12 @@ type NewClientRequest struct {
 
 type TokenRequest struct {
 	ID           int64
-	TokenRequest mcl.G1
+	TokenRequest curve.G1
+}
+
+type TicketRequest struct {
+	ID      int64
+	Tickets []curve.G1 // blinded ticket contents
 }
 
 func (t *NewClientRequest) Len() int {
@@ -55,6 +60,33 @@ func (t *TokenRequest) InterpretFrom(b []byte) error {
 	return t.TokenRequest.InterpretFrom(b[8:])
 }
 
+func (t *TicketRequest) Len() int {
+	return 8 + len(t.Tickets)*curve.G1_LEN
+}
+func (t *TicketRequest) PackTo(b []byte) {
+	if len(b) != t.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	binary.LittleEndian.PutUint64(b[:8], uint64(t.ID))
+	for i := range t.Tickets {
+		t.Tickets[i].PackTo(b[8+i*curve.G1_LEN : 8+(i+1)*curve.G1_LEN])
+	}
+}
+func (t *TicketRequest) InterpretFrom(b []byte) error {
+	if len(b) <= 8 || (len(b)-8)%curve.G1_LEN != 0 {
+		return errors.LengthInvalidError()
+	}
+	t.ID = int64(binary.LittleEndian.Uint64(b[:8]))
+	t.Tickets = make([]curve.G1, (len(b)-8)/curve.G1_LEN)
+	for i := range t.Tickets {
+		err := t.Tickets[i].InterpretFrom(b[8+i*curve.G1_LEN : 8+(i+1)*curve.G1_LEN])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // for writing clients to file to test later parts of the system
 type MarshallableClient struct {
 	ID                 int64
@@ -1,10 +1,13 @@
 package prepareMessages
 
 import (
+	"crypto/sha256"
 	"sync"
 
 	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/common"
 
@@ -17,21 +20,26 @@ type MessagePreparer struct {
 	markLock sync.Mutex               // could have a lock in each PerClientInfo
 	Clients  map[int64]*PerClientInfo // map clientID -> info
 	signer   *token.TokenSigningKey
-	group    int
+	// signs the posting tickets of this epoch
+	ticketSigner *token.TokenSigningKey
+	group        int
 }
 
 type PerClientInfo struct {
 	SignatureKey crypto.VerificationKey
 	signed       int // don't sign twice for a client
+	tickets      int // tickets signed in this epoch
 	submitted    bool
+	submission   [sha256.Size]byte // a retry of the same submission is not a duplicate
 }
 
-func NewMessagePreparer(c *common.CommonState, signer *token.TokenSigningKey, group int) *MessagePreparer {
+func NewMessagePreparer(c *common.CommonState, signer, ticketSigner *token.TokenSigningKey, group int) *MessagePreparer {
 	return &MessagePreparer{
-		common:  c,
-		Clients: make(map[int64]*PerClientInfo),
-		signer:  signer,
-		group:   group,
+		common:       c,
+		Clients:      make(map[int64]*PerClientInfo),
+		signer:       signer,
+		ticketSigner: ticketSigner,
+		group:        group,
 	}
 }
 
@@ -43,12 +51,12 @@ func (p *MessagePreparer) RegisterClient(m *messages.SignedMessage) error {
 	}
 	// check that the user owns this signature since they signed the signature
 	if !common.ValidateSignature(n.VerificationKey, m) {
-		return errors.SignatureError()
+		return errors.SignatureError().From(int(n.ID))
 	}
 	p.mapLock.Lock()
 	defer p.mapLock.Unlock()
 	if p.Clients[n.ID] != nil {
-		return errors.Duplicate()
+		return errors.Duplicate().From(int(n.ID))
 	}
 	p.Clients[n.ID] = &PerClientInfo{SignatureKey: n.VerificationKey, signed: -1, submitted: false}
 	return nil
@@ -57,20 +65,32 @@ func (p *MessagePreparer) RegisterClient(m *messages.SignedMessage) error {
 func (p *MessagePreparer) MarkSubmitted(ID int64, m *messages.SignedMessage) error {
 	info := p.Clients[ID]
 	if info == nil {
-		return errors.ClientNotFoundError()
+		return errors.ClientNotFoundError().From(int(ID))
 	}
 	if !common.ValidateSignature(info.SignatureKey, m) {
-		return errors.SignatureError()
+		return errors.SignatureError().From(int(ID))
 	}
 	p.markLock.Lock()
 	defer p.markLock.Unlock()
+	h := sha256.Sum256(m.Data)
 	if info.submitted {
-		return errors.Duplicate()
+		if info.submission == h {
+			return nil
+		}
+		return errors.Duplicate().At(m.Round, m.Layer).From(int(ID))
 	}
 	info.submitted = true
+	info.submission = h
 	return nil
 }
 
+// clients register again in a new epoch
+func (p *MessagePreparer) ForgetClients() {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	p.Clients = make(map[int64]*PerClientInfo)
+}
+
 func (p *MessagePreparer) RevokeClient(ID int64) {
 	p.Clients[ID] = nil
 }
@@ -89,10 +109,10 @@ func (p *MessagePreparer) HandleTokenRequest(m *messages.SignedMessage) (*messag
 	info := p.Clients[request.ID]
 	p.mapLock.RUnlock()
 	if info == nil {
-		return nil, errors.ClientNotFoundError()
+		return nil, errors.ClientNotFoundError().From(int(request.ID))
 	}
 	if !common.ValidateSignature(info.SignatureKey, m) {
-		return nil, errors.SignatureError()
+		return nil, errors.SignatureError().From(int(request.ID))
 	}
 	err = p.signer.BlindSign(&request.TokenRequest, &request.TokenRequest)
 	if err != nil {
@@ -104,13 +124,72 @@ func (p *MessagePreparer) HandleTokenRequest(m *messages.SignedMessage) (*messag
 	p.markLock.Lock()
 	defer p.markLock.Unlock()
 	if info.signed >= m.Layer {
-		return nil, errors.Duplicate()
+		return nil, errors.Duplicate().At(m.Round, m.Layer).From(int(request.ID))
 	} else {
 		info.signed = m.Layer
 	}
+	metrics.TokensServed.Inc()
+	return response, nil
+}
+
+// sign posting tickets for a client, at most PostingQuota in an epoch
+func (p *MessagePreparer) HandleTicketRequest(m *messages.SignedMessage) (*messages.SignedMessage, error) {
+	request := &TicketRequest{}
+	err := request.InterpretFrom(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	// only the home group counts a client's tickets, otherwise a client would get a quota from every group
+	if request.ID%int64(p.common.NumGroups) != int64(p.group) {
+		return nil, errors.WrongServerError().From(int(request.ID))
+	}
+	p.mapLock.RLock()
+	info := p.Clients[request.ID]
+	p.mapLock.RUnlock()
+	if info == nil {
+		return nil, errors.ClientNotFoundError().From(int(request.ID))
+	}
+	if !common.ValidateSignature(info.SignatureKey, m) {
+		return nil, errors.SignatureError().From(int(request.ID))
+	}
+	// reserve the tickets before signing, so that concurrent requests cannot get more than the quota signed
+	p.markLock.Lock()
+	if info.tickets+len(request.Tickets) > p.common.PostingQuota {
+		p.markLock.Unlock()
+		return nil, errors.QuotaExceeded().InRound(m.Round).From(int(request.ID))
+	}
+	info.tickets += len(request.Tickets)
+	p.markLock.Unlock()
+	response := messages.NewSignedMessage(len(request.Tickets)*curve.G1_LEN, p.common.Round, m.Layer, p.common.MyId, p.group, 0, 1, m.Type)
+	for i := range request.Tickets {
+		err = p.ticketSigner.BlindSign(&request.Tickets[i], &request.Tickets[i])
+		if err != nil {
+			// nothing was returned, so the reservation is released
+			p.markLock.Lock()
+			info.tickets -= len(request.Tickets)
+			p.markLock.Unlock()
+			return nil, err
+		}
+		request.Tickets[i].PackTo(response.Data[i*curve.G1_LEN : (i+1)*curve.G1_LEN])
+	}
+	p.common.Sign(response)
+	metrics.TicketsServed.Add(float64(len(request.Tickets)))
 	return response, nil
 }
 
+// a new ticket key starts counting the tickets of the next epoch
+func (p *MessagePreparer) ResetTickets() {
+	p.mapLock.RLock()
+	defer p.mapLock.RUnlock()
+	p.markLock.Lock()
+	defer p.markLock.Unlock()
+	for _, c := range p.Clients {
+		if c != nil {
+			c.tickets = 0
+		}
+	}
+}
+
 func (p *MessagePreparer) ResetSigned() {
 	for _, c := range p.Clients {
 		c.signed = -1
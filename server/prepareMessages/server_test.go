@@ -0,0 +1,122 @@
+package prepareMessages
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
+	"github.com/simonlangowski/lightning1/crypto/token"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/common"
+)
+
+func ticketRequest(id int64, sk crypto.SigningKey, n int) *messages.SignedMessage {
+	tr := TicketRequest{ID: id, Tickets: make([]curve.G1, n)}
+	for i := range tr.Tickets {
+		tr.Tickets[i].HashAndMapTo([]byte(fmt.Sprint(i)))
+	}
+	m := messages.NewSignedMessage(tr.Len(), 0, -1, int(id), int(id%2), 0, 1, messages.NetworkMessage_ClientTicketRequest)
+	tr.PackTo(m.Data)
+	common.SignMessage(sk, m)
+	return m
+}
+
+func register(t *testing.T, p *MessagePreparer, id int64) crypto.SigningKey {
+	vk, sk := crypto.NewSigningKeyPair()
+	req := NewClientRequest{ID: id, VerificationKey: vk}
+	m := messages.NewSignedMessage(req.Len(), 0, -1, int(id), p.group, 0, 1, messages.NetworkMessage_ClientRegister)
+	req.PackTo(m.Data)
+	common.SignMessage(sk, m)
+	err := p.RegisterClient(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk
+}
+
+// the home group signs at most the quota of tickets for a client in an epoch
+func TestTicketQuota(t *testing.T) {
+	servers := make(map[int64]*config.Server)
+	for i := int64(0); i < 2; i++ {
+		servers[i] = config.CreateServerWithCertificate(fmt.Sprintf("localhost:%d", 8000+i), i, nil, nil)
+	}
+	groups := &config.Groups{Groups: config.CreateSeparateGroupsWithSize(2, 1, config.ServerIds(servers), nil)}
+	c := common.NewCommonState(servers, 0, groups)
+	c.PostingQuota = 3
+	secret := curve.Fr{}
+	secret.Random()
+	signer := token.NewTokenSigningKey(&secret)
+	p := NewMessagePreparer(c, &token.TokenSigningKey{}, signer, 0)
+	home := register(t, p, 4)
+	away := register(t, p, 5)
+
+	response, err := p.HandleTicketRequest(ticketRequest(4, home, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 2*curve.G1_LEN || !c.Verify(response) {
+		t.Fatal("wrong response")
+	}
+	_, err = p.HandleTicketRequest(ticketRequest(4, home, 2))
+	if !errors.Is(err, errors.ErrQuotaExceeded) {
+		t.Fatalf("signed more tickets than the quota: %v", err)
+	}
+	_, err = p.HandleTicketRequest(ticketRequest(4, home, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.HandleTicketRequest(ticketRequest(5, away, 1))
+	if !errors.Is(err, errors.ErrWrongServer) {
+		t.Fatalf("signed tickets for a client of another group: %v", err)
+	}
+	_, err = p.HandleTicketRequest(ticketRequest(4, away, 1))
+	if !errors.Is(err, errors.ErrSignature) {
+		t.Fatalf("signed tickets for another client: %v", err)
+	}
+
+	p.ResetTickets()
+	_, err = p.HandleTicketRequest(ticketRequest(4, home, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// concurrent requests reserve their tickets before signing, so together they stay within the quota
+func TestTicketQuotaConcurrent(t *testing.T) {
+	servers := make(map[int64]*config.Server)
+	for i := int64(0); i < 2; i++ {
+		servers[i] = config.CreateServerWithCertificate(fmt.Sprintf("localhost:%d", 8000+i), i, nil, nil)
+	}
+	groups := &config.Groups{Groups: config.CreateSeparateGroupsWithSize(2, 1, config.ServerIds(servers), nil)}
+	c := common.NewCommonState(servers, 0, groups)
+	c.PostingQuota = 3
+	secret := curve.Fr{}
+	secret.Random()
+	p := NewMessagePreparer(c, &token.TokenSigningKey{}, token.NewTokenSigningKey(&secret), 0)
+	home := register(t, p, 4)
+
+	requests := 10
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		m := ticketRequest(4, home, 1)
+		go func() {
+			_, err := p.HandleTicketRequest(m)
+			errs <- err
+		}()
+	}
+	signed := 0
+	for i := 0; i < requests; i++ {
+		err := <-errs
+		if err == nil {
+			signed++
+		} else if !errors.Is(err, errors.ErrQuotaExceeded) {
+			t.Fatal(err)
+		}
+	}
+	if signed != c.PostingQuota {
+		t.Fatalf("signed %d tickets with a quota of %d", signed, c.PostingQuota)
+	}
+}
@@ -34,7 +34,7 @@ func NewCheckpointSender(c *common.CommonState, layer int) *CheckpointSender {
 		toGroupBuffers:  make(map[int]*buffers.MemReadWriter),
 	}
 	for i := 0; i < c.NumGroups; i++ {
-		s.toGroupBuffers[i] = buffers.NewMemReadWriter(checkpoint.TOKEN_MESSAGE_LENGTH, c.GroupBinSize, c.Shufflers[i])
+		s.toGroupBuffers[i] = buffers.NewMemReadWriter(checkpoint.TOKEN_MESSAGE_LENGTH, c.GroupBinSize, c.GroupShuffler(i))
 	}
 	return s
 }
@@ -132,7 +132,10 @@ func (c *CheckpointSender) DecryptGroup(group int) error {
 func (c *CheckpointSender) HandleOne(s *Progress) error {
 	nonce := crypto.Nonce(c.c.NumLayers, c.c.NumLayers, c.c.MyId)
 	sharedKey := s.partialKey.AsShared()
-	decrypted := crypto.SecretOpen(s.boomerang, &nonce, sharedKey)
+	decrypted, err := c.c.Suite.SecretOpen(s.boomerang, &nonce, sharedKey)
+	if err != nil {
+		return err
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if s.used {
@@ -38,6 +38,7 @@ type KeyLookupTable struct {
 	table        map[crypto.LookupKey]*BootstrapKey // by IncomingLookupKey
 	reverseTable map[crypto.LookupKey]*BootstrapKey // by OutgoingLookupKey - used when routing boomerang or in reverse
 	secretKey    *crypto.DHPrivateKey               // the secret key for this layer
+	kemKey       *crypto.KEMPrivateKey              // the decapsulation key for hybrid rounds, nil without one
 	mu           sync.Mutex
 }
 
@@ -46,21 +47,40 @@ func NewKeyLookupTable(c *common.CommonState) *KeyLookupTable {
 		table:        make(map[crypto.LookupKey]*BootstrapKey),
 		reverseTable: make(map[crypto.LookupKey]*BootstrapKey),
 		secretKey:    &c.ServerSecretKey,
+		kemKey:       c.ServerKemSecret,
 	}
 	return t
 }
 
-func (t *KeyLookupTable) AddKey(key crypto.VerificationKey, sharedKey crypto.DHSharedKey, prev, next int, nextKey crypto.VerificationKey) (*BootstrapKey, error) {
+// the shared key with a client key, combined with the kem ciphertext in hybrid rounds
+// kem is nil in DH rounds
+func (t *KeyLookupTable) SharedKey(pt *crypto.DHPublicKey, kem []byte) (crypto.DHSharedKey, error) {
+	shared := t.secretKey.SharedKey(pt)
+	if kem == nil {
+		return shared, nil
+	}
+	if t.kemKey == nil {
+		return nil, errors.UnimplementedError()
+	}
+	return t.kemKey.HybridDecapsulate(shared, kem)
+}
+
+// returnKem is the kem ciphertext for the outgoing shared key in hybrid rounds, nil otherwise
+func (t *KeyLookupTable) AddKey(key crypto.VerificationKey, sharedKey crypto.DHSharedKey, prev, next int, nextKey crypto.VerificationKey, returnKem []byte) (*BootstrapKey, error) {
 	l := key.LookupKey()
 	rl := nextKey.LookupKey()
 	pt, err := nextKey.ToCurvePoint()
 	if err != nil {
 		return nil, errors.BadElementError()
 	}
+	outgoingSharedKey, err := t.SharedKey(pt, returnKem)
+	if err != nil {
+		return nil, err
+	}
 	b := &BootstrapKey{
 		SharedKey:               sharedKey,
 		VerificationKey:         key.Copy(),
-		OutgoingSharedKey:       t.secretKey.SharedKey(pt),
+		OutgoingSharedKey:       outgoingSharedKey,
 		OutgoingVerificationKey: nextKey.Copy(),
 		PrevServer:              prev,
 		NextServer:              next,
@@ -96,6 +116,8 @@ func (t *KeyLookupTable) Lookup(key *crypto.LookupKey, reverse bool) *BootstrapK
 }
 
 func (t *KeyLookupTable) NumKeys() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return len(t.table)
 }
 
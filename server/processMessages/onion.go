@@ -5,6 +5,7 @@ import (
 
 	"github.com/simonlangowski/lightning1/crypto"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network/buffers"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/server/common"
@@ -20,6 +21,8 @@ type OnionParser struct {
 
 type LightningRouter struct {
 	OutgoingBuffers map[int]*buffers.MemReadWriter
+	// links of the layer in verifiable rounds, nil otherwise
+	Transcript *ShuffleTranscript
 }
 
 func NewOnionParser(c *common.CommonState, table *KeyLookupTable, reverse bool) *OnionParser {
@@ -51,7 +54,7 @@ func (o *OnionParser) AuthenticatedOnionParse(metadata *messages.Metadata, messa
 	}
 	key := o.keyTable.Lookup(&lm.Key, o.reverse)
 	if key == nil {
-		return nil, nil, errors.KeyNotFound()
+		return nil, nil, errors.KeyNotFound().At(round, o.c.Layer).From(metadata.Sender).WithKey(lm.Key[:])
 	}
 
 	var verificationKey crypto.VerificationKey
@@ -78,18 +81,22 @@ func (o *OnionParser) AuthenticatedOnionParse(metadata *messages.Metadata, messa
 		ok = crypto.Verify(verificationKey, m, s)
 	}
 	if !ok {
-		return nil, nil, errors.DecryptionFailure()
+		return nil, nil, errors.DecryptionFailure().At(round, o.c.Layer).From(metadata.Sender).WithKey(lm.Key[:])
 	}
 
-	decrypted := crypto.SecretOpen(lm.SignedCiphertext, &nonce, decryptionKey)
+	decrypted, err := o.c.Suite.SecretOpen(lm.SignedCiphertext, &nonce, decryptionKey)
+	if err != nil {
+		return nil, nil, errors.DecryptionFailure().At(round, o.c.Layer).From(metadata.Sender).WithKey(lm.Key[:])
+	}
 	o.usageLock.Lock()
 	if key.used {
 		o.usageLock.Unlock()
-		return nil, nil, errors.Duplicate()
+		return nil, nil, errors.Duplicate().At(round, o.c.Layer).From(metadata.Sender).WithKey(lm.Key[:])
 	}
 	key.used = true
 	o.count++
 	o.usageLock.Unlock()
+	metrics.EnvelopesDecrypted.Inc()
 	return decrypted, key, nil
 }
 
@@ -103,6 +110,15 @@ func (o *OnionParser) AllKeysAccountedFor() bool {
 	return ok
 }
 
+// number of keys not used this layer, usage is reset so the table can be used again
+func (o *OnionParser) Missing() int {
+	o.usageLock.Lock()
+	defer o.usageLock.Unlock()
+	missing := o.keyTable.NumKeys() - o.count
+	o.keyTable.ResetUsage()
+	return missing
+}
+
 func NewLightningRouter(c *common.CommonState, layer int, reverse bool) *LightningRouter {
 	l := &LightningRouter{
 		OutgoingBuffers: make(map[int]*buffers.MemReadWriter),
@@ -113,14 +129,31 @@ func NewLightningRouter(c *common.CommonState, layer int, reverse bool) *Lightni
 	} else {
 		length = c.OnionMessageLengths[layer+1]
 	}
-	for i := 0; i < c.NumServers; i++ {
+	for _, i := range c.ServerIds {
 		l.OutgoingBuffers[i] = buffers.NewMemReadWriter(length, c.BinSize, c.Shufflers[i])
 	}
+	if c.Verifiable && !reverse {
+		l.Transcript = NewShuffleTranscript(c, layer, c.IdSpace())
+	}
 	return l
 }
 
 // Pack the decryptions into lightning messages
 func (l *LightningRouter) AuthenticatedOnionPack(decrypted []byte, k *BootstrapKey, reverse bool) error {
+	_, _, err := l.pack(decrypted, k, reverse)
+	return err
+}
+
+// Pack the decryption of an input recorded by the transcript, and link them in verifiable rounds
+func (l *LightningRouter) LinkedOnionPack(input EnvelopeHash, decrypted []byte, k *BootstrapKey) error {
+	dest, output, err := l.pack(decrypted, k, false)
+	if err == nil && l.Transcript != nil {
+		l.Transcript.Link(input, dest, output, k)
+	}
+	return err
+}
+
+func (l *LightningRouter) pack(decrypted []byte, k *BootstrapKey, reverse bool) (int, []byte, error) {
 	var dest int
 	m := common.LightningEnvelope{
 		SignedCiphertext: decrypted,
@@ -132,9 +165,10 @@ func (l *LightningRouter) AuthenticatedOnionPack(decrypted []byte, k *BootstrapK
 		dest = k.NextServer
 		m.Key = k.OutgoingVerificationKey.LookupKey()
 	}
-	err := l.OutgoingBuffers[dest].Write(m.Marshal())
+	output := m.Marshal()
+	err := l.OutgoingBuffers[dest].Write(output)
 	if err != nil {
-		return err
+		return dest, nil, err
 	}
-	return nil
+	return dest, output, nil
 }
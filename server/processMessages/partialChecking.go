@@ -0,0 +1,619 @@
+package processMessages
+
+// Randomized partial checking of verifiable rounds
+// The layers are paired (0 and 1, 2 and 3, ...), and once every server has committed to both layers of a pair
+// a challenge from the round's beacon splits the envelopes sent between them in half:
+// the first layer opens its links to the envelopes of one half and the second layer its links from the other,
+// so no envelope is linked through both layers of a pair.
+// A server that drops or replaces k envelopes is caught with probability 1-2^-k.
+// An unpaired last layer is not opened.
+// Opening a link reveals the client's keys on both sides of the server (its BootstrapKey),
+// so paths that were opened should be established again before rounds that need them unlinkable.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/beacon"
+	"github.com/simonlangowski/lightning1/server/common"
+)
+
+var challengeDomain = []byte("lightning partial checking v1")
+
+// an opened link, with what is needed to check that the output is the decryption of the input
+type LinkOpening struct {
+	Link
+	// the server, or group in the last layer, the output was sent to
+	Dest int
+	// the input envelope
+	Envelope []byte
+	// the client's keys on the links into and out of the server
+	VerificationKey         crypto.VerificationKey
+	OutgoingVerificationKey crypto.VerificationKey
+	// the key derived for this layer, which only decrypts the input
+	Key []byte
+}
+
+// a server's answer to an audit of one layer
+type ShuffleAudit struct {
+	NumLayers  int
+	Suite      crypto.CipherSuite
+	Challenge  EnvelopeHash
+	Commitment *ShuffleCommitment
+	// the hashes of the commitment's digests, sorted
+	Submitted []EnvelopeHash
+	Received  [][]EnvelopeHash
+	Sent      [][]EnvelopeHash
+	// the sorted commitments to every link
+	Links    []EnvelopeHash
+	Openings []LinkOpening
+	// the transcript of the round's beacon
+	Beacon *beacon.Transcript
+}
+
+// the links of a layer are opened if it has a pair
+func AuditedLayer(layer, numLayers int) bool {
+	return layer%2 == 1 || layer+1 < numLayers
+}
+
+// the challenge of the pair of layers containing layer, from the round's beacon and the commitments to both
+// the beacon is only revealed once the commitments are fixed, so no server can grind the challenge
+func LinkChallenge(round, layer int, output beacon.Output, commitments [][]*ShuffleCommitment) EnvelopeHash {
+	first := layer - layer%2
+	h := sha256.New()
+	h.Write(challengeDomain)
+	h.Write(output[:])
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(round))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(first))
+	h.Write(b)
+	for l := first; l < first+2 && l < len(commitments); l++ {
+		for _, c := range commitments[l] {
+			if c == nil {
+				continue
+			}
+			m := make([]byte, c.Len())
+			c.PackTo(m)
+			h.Write(m)
+		}
+	}
+	var challenge EnvelopeHash
+	h.Sum(challenge[:0])
+	return challenge
+}
+
+// the envelope between the layers of a pair decides which of them opens its links
+func openedByFirst(challenge, middle EnvelopeHash) bool {
+	h := sha256.New()
+	h.Write(challenge[:])
+	h.Write(middle[:])
+	return h.Sum(nil)[0]&1 == 0
+}
+
+// the first layer of a pair opens links by output, the second by input
+func selected(challenge EnvelopeHash, layer int, l *Link) bool {
+	if layer%2 == 0 {
+		return openedByFirst(challenge, l.Output)
+	}
+	return !openedByFirst(challenge, l.Input)
+}
+
+// open the links selected by the challenge
+// a layer must only ever be opened for one challenge, or the openings together link envelopes through the pair
+func (t *ShuffleTranscript) Open(challenge EnvelopeHash, numLayers int, suite crypto.CipherSuite) *ShuffleAudit {
+	c := t.Commit()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := &ShuffleAudit{
+		NumLayers:  numLayers,
+		Suite:      suite,
+		Challenge:  challenge,
+		Commitment: c,
+		Submitted:  sortedHashes(t.submitted),
+		Received:   make([][]EnvelopeHash, len(t.received)),
+		Sent:       make([][]EnvelopeHash, len(t.sent)),
+		Links:      make([]EnvelopeHash, len(t.links)),
+		Openings:   make([]LinkOpening, 0),
+	}
+	for i := range t.received {
+		a.Received[i] = sortedHashes(t.received[i])
+	}
+	for i := range t.sent {
+		a.Sent[i] = sortedHashes(t.sent[i])
+	}
+	audited := AuditedLayer(t.layer, numLayers)
+	nonce := crypto.Nonce(t.round, t.layer, t.server)
+	for i := range t.links {
+		l := &t.links[i]
+		a.Links[i] = l.Commitment(t.round, t.layer, t.server)
+		if !audited || !selected(challenge, t.layer, l) {
+			continue
+		}
+		k := t.keys[i]
+		a.Openings = append(a.Openings, LinkOpening{
+			Link:                    *l,
+			Dest:                    t.dests[i],
+			Envelope:                t.inputs[l.Input],
+			VerificationKey:         k.VerificationKey,
+			OutgoingVerificationKey: k.OutgoingVerificationKey,
+			Key:                     suite.DeriveKey(k.SharedKey, &nonce),
+		})
+	}
+	a.Links = sortedHashes(a.Links)
+	sort.Slice(a.Openings, func(i, j int) bool {
+		return bytes.Compare(a.Openings[i].Input[:], a.Openings[j].Input[:]) < 0
+	})
+	return a
+}
+
+// read the audit a server signed with the key vk
+func ReadAudit(m *messages.SignedMessage, vk crypto.VerificationKey) (*ShuffleAudit, error) {
+	if !crypto.Verify(vk, m.GetSignedData(), m.Signature) {
+		return nil, errors.SignatureError().At(m.Round, m.Layer).From(m.Sender)
+	}
+	a := &ShuffleAudit{}
+	err := a.InterpretFrom(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	if a.Commitment.Round != m.Round || a.Commitment.Layer != m.Layer || a.Commitment.Server != m.Sender {
+		return nil, errors.BadMetadataError().At(m.Round, m.Layer).From(m.Sender)
+	}
+	return a, nil
+}
+
+// the output of the round's beacon included in the audit, checked with the verification keys of every server
+func AuditBeacon(a *ShuffleAudit, round int, vks []crypto.VerificationKey) (beacon.Output, error) {
+	c := a.Commitment
+	if a.Beacon == nil || a.Beacon.Label != beacon.RoundLabel(round) {
+		return beacon.Output{}, c.blame(c.Server, fmt.Errorf("no beacon for the round"))
+	}
+	return a.Beacon.Verify(vks)
+}
+
+// check an audit against the challenge the verifier computed
+// the caller checks that the commitment is the one the server sent
+func VerifyAudit(a *ShuffleAudit, challenge EnvelopeHash) error {
+	c := a.Commitment
+	if a.Challenge != challenge {
+		return c.blame(c.Server, fmt.Errorf("opened links for another challenge"))
+	}
+	if NewBatchDigest(a.Submitted) != c.Submitted || len(a.Received) != len(c.Received) || len(a.Sent) != len(c.Sent) {
+		return c.blame(c.Server, fmt.Errorf("envelopes do not match the commitment"))
+	}
+	inputs := make(map[EnvelopeHash]bool)
+	for _, h := range a.Submitted {
+		inputs[h] = true
+	}
+	for i := range a.Received {
+		if NewBatchDigest(a.Received[i]) != c.Received[i] {
+			return c.blame(c.Server, fmt.Errorf("envelopes from %d do not match the commitment", i))
+		}
+		for _, h := range a.Received[i] {
+			inputs[h] = true
+		}
+	}
+	outputs := make(map[EnvelopeHash]int)
+	for i := range a.Sent {
+		if NewBatchDigest(a.Sent[i]) != c.Sent[i] {
+			return c.blame(c.Server, fmt.Errorf("envelopes to %d do not match the commitment", i))
+		}
+		for _, h := range a.Sent[i] {
+			outputs[h] = i
+		}
+	}
+	if len(a.Links) != c.NumLinks || NewBatchDigest(a.Links).Digest != c.Links {
+		return c.blame(c.Server, fmt.Errorf("links do not match the commitment"))
+	}
+	if !AuditedLayer(c.Layer, a.NumLayers) {
+		if len(a.Openings) > 0 {
+			return c.blame(c.Server, fmt.Errorf("opened links of an unpaired layer"))
+		}
+		return nil
+	}
+	links := make(map[EnvelopeHash]bool)
+	for _, h := range a.Links {
+		links[h] = true
+	}
+	opened := make(map[EnvelopeHash]bool)
+	for i := range a.Openings {
+		o := &a.Openings[i]
+		if !links[o.Commitment(c.Round, c.Layer, c.Server)] {
+			return c.blame(c.Server, fmt.Errorf("opened a link it did not commit to"))
+		}
+		if dest, ok := outputs[o.Output]; !ok || dest != o.Dest || !inputs[o.Input] {
+			return c.blame(c.Server, fmt.Errorf("opened a link between envelopes it did not receive and send"))
+		}
+		if !selected(challenge, c.Layer, &o.Link) || opened[o.Input] || opened[o.Output] {
+			return c.blame(c.Server, fmt.Errorf("opened a link that was not selected"))
+		}
+		opened[o.Input] = true
+		opened[o.Output] = true
+		err := o.verify(a.Suite, c.Round, c.Layer, c.Server, c.Layer == a.NumLayers-1)
+		if err != nil {
+			return c.blame(c.Server, err)
+		}
+	}
+	// every output has a link, but rejected inputs do not
+	unopened := 0
+	if c.Layer%2 == 0 {
+		for h := range outputs {
+			if openedByFirst(challenge, h) && !opened[h] {
+				unopened++
+			}
+		}
+		if unopened > 0 {
+			return c.blame(c.Server, fmt.Errorf("did not open %d selected links", unopened))
+		}
+	} else {
+		for h := range inputs {
+			if !openedByFirst(challenge, h) && !opened[h] {
+				unopened++
+			}
+		}
+		if unopened > c.Rejected() {
+			return c.blame(c.Server, fmt.Errorf("did not open %d selected links with %d inputs rejected", unopened, c.Rejected()))
+		}
+	}
+	return nil
+}
+
+// check the audits of every server in every layer of a round, indexed by layer and server
+// a missing audit is nil, and counts as a missing commitment
+// vks are the verification keys of every server, which check the beacons
+func VerifyAudits(round int, audits [][]*ShuffleAudit, vks []crypto.VerificationKey) []error {
+	failures := make([]error, 0)
+	commitments := make([][]*ShuffleCommitment, len(audits))
+	var suite *crypto.CipherSuite
+	for layer := range audits {
+		commitments[layer] = make([]*ShuffleCommitment, len(audits[layer]))
+		for server, a := range audits[layer] {
+			if a == nil {
+				continue
+			}
+			if suite == nil {
+				suite = &a.Suite
+			}
+			if a.NumLayers != len(audits) || a.Suite != *suite {
+				failures = append(failures, a.Commitment.blame(server, fmt.Errorf("audit of a round with %d layers in suite %v", a.NumLayers, a.Suite)))
+			}
+			commitments[layer][server] = a.Commitment
+		}
+	}
+	failures = append(failures, VerifyShuffles(round, commitments, func(layer, server int) bool {
+		return true
+	})...)
+	for layer := range audits {
+		for _, a := range audits[layer] {
+			if a == nil {
+				continue
+			}
+			output, err := AuditBeacon(a, round, vks)
+			if err != nil {
+				failures = append(failures, err)
+				continue
+			}
+			if err := VerifyAudit(a, LinkChallenge(round, layer, output, commitments)); err != nil {
+				failures = append(failures, err)
+			}
+		}
+	}
+	return failures
+}
+
+// the input was signed by the client for this server, and decrypts to the output with the revealed key
+// without the shared key the verifier cannot tell if the key is the right one,
+// but no other key decrypts the input to an envelope the next server accepts
+func (o *LinkOpening) verify(suite crypto.CipherSuite, round, layer, server int, last bool) error {
+	if HashEnvelope(o.Envelope) != o.Input {
+		return fmt.Errorf("the input does not match the link")
+	}
+	if len(o.Envelope) < crypto.KEY_SIZE+suite.Overhead() {
+		return errors.LengthInvalidError()
+	}
+	// the signed data is written over the envelope
+	raw := make([]byte, len(o.Envelope))
+	copy(raw, o.Envelope)
+	lm := common.LightningEnvelope{}
+	err := lm.InterpretFrom(raw)
+	if err != nil {
+		return err
+	}
+	if lm.Key != o.VerificationKey.LookupKey() {
+		return fmt.Errorf("the input is for another key")
+	}
+	if !crypto.Verify(o.VerificationKey, lm.GetSignedData(round, layer, server), lm.GetSignature()) {
+		return fmt.Errorf("the client did not sign the input")
+	}
+	nonce := crypto.Nonce(round, layer, server)
+	decrypted, err := suite.OpenWithKey(lm.SignedCiphertext, &nonce, o.Key)
+	if err != nil {
+		return err
+	}
+	var output []byte
+	if last {
+		if len(decrypted) < crypto.SIGNATURE_SIZE {
+			return errors.LengthInvalidError()
+		}
+		fm := common.FinalLightningMessage{
+			AnonymousVerificationKey: o.OutgoingVerificationKey,
+			Signature:                decrypted[:crypto.SIGNATURE_SIZE],
+			Message:                  decrypted[crypto.SIGNATURE_SIZE:],
+		}
+		output = fm.Marshal()
+	} else {
+		next := common.LightningEnvelope{
+			Key:              o.OutgoingVerificationKey.LookupKey(),
+			SignedCiphertext: decrypted,
+		}
+		output = next.Marshal()
+	}
+	if HashEnvelope(output) != o.Output {
+		return fmt.Errorf("the output is not the decryption of the input")
+	}
+	return nil
+}
+
+func (o *LinkOpening) Len() int {
+	return 2*sha256.Size + LINK_SALT_SIZE + 4 + 2*crypto.VERIFICATION_KEY_SIZE + 4 + len(o.Key) + 4 + len(o.Envelope)
+}
+
+func (o *LinkOpening) PackTo(b []byte) {
+	if len(b) != o.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	pos := 0
+	pos += copy(b[pos:], o.Input[:])
+	pos += copy(b[pos:], o.Output[:])
+	pos += copy(b[pos:], o.Salt[:])
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(o.Dest))
+	pos += 4
+	o.VerificationKey.PackTo(b[pos : pos+crypto.VERIFICATION_KEY_SIZE])
+	pos += crypto.VERIFICATION_KEY_SIZE
+	o.OutgoingVerificationKey.PackTo(b[pos : pos+crypto.VERIFICATION_KEY_SIZE])
+	pos += crypto.VERIFICATION_KEY_SIZE
+	pos += packBytes(b[pos:], o.Key)
+	packBytes(b[pos:], o.Envelope)
+}
+
+// returns the number of bytes read
+func (o *LinkOpening) InterpretFrom(b []byte) (int, error) {
+	if len(b) < 2*sha256.Size+LINK_SALT_SIZE+4+2*crypto.VERIFICATION_KEY_SIZE {
+		return 0, errors.LengthInvalidError()
+	}
+	pos := 0
+	pos += copy(o.Input[:], b[pos:])
+	pos += copy(o.Output[:], b[pos:])
+	pos += copy(o.Salt[:], b[pos:])
+	o.Dest = int(binary.LittleEndian.Uint32(b[pos : pos+4]))
+	pos += 4
+	err := o.VerificationKey.InterpretFrom(b[pos : pos+crypto.VERIFICATION_KEY_SIZE])
+	if err != nil {
+		return 0, err
+	}
+	pos += crypto.VERIFICATION_KEY_SIZE
+	err = o.OutgoingVerificationKey.InterpretFrom(b[pos : pos+crypto.VERIFICATION_KEY_SIZE])
+	if err != nil {
+		return 0, err
+	}
+	pos += crypto.VERIFICATION_KEY_SIZE
+	var n int
+	o.Key, n, err = interpretBytes(b[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	o.Envelope, n, err = interpretBytes(b[pos:])
+	if err != nil {
+		return 0, err
+	}
+	return pos + n, nil
+}
+
+func (a *ShuffleAudit) Len() int {
+	n := 4 + 4 + sha256.Size + 4 + a.Commitment.Len() + hashListLength(a.Submitted) + 4 + 4 + hashListLength(a.Links) + 4 + 4 + len(a.marshalBeacon())
+	for i := range a.Received {
+		n += hashListLength(a.Received[i])
+	}
+	for i := range a.Sent {
+		n += hashListLength(a.Sent[i])
+	}
+	for i := range a.Openings {
+		n += a.Openings[i].Len()
+	}
+	return n
+}
+
+func (a *ShuffleAudit) PackTo(b []byte) {
+	if len(b) != a.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	binary.LittleEndian.PutUint32(b[0:4], uint32(a.NumLayers))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(a.Suite))
+	pos := 8
+	pos += copy(b[pos:], a.Challenge[:])
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(a.Commitment.Len()))
+	pos += 4
+	a.Commitment.PackTo(b[pos : pos+a.Commitment.Len()])
+	pos += a.Commitment.Len()
+	pos += packHashes(b[pos:], a.Submitted)
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(a.Received)))
+	pos += 4
+	for i := range a.Received {
+		pos += packHashes(b[pos:], a.Received[i])
+	}
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(a.Sent)))
+	pos += 4
+	for i := range a.Sent {
+		pos += packHashes(b[pos:], a.Sent[i])
+	}
+	pos += packHashes(b[pos:], a.Links)
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(a.Openings)))
+	pos += 4
+	for i := range a.Openings {
+		a.Openings[i].PackTo(b[pos : pos+a.Openings[i].Len()])
+		pos += a.Openings[i].Len()
+	}
+	packBytes(b[pos:], a.marshalBeacon())
+}
+
+// an audit without a beacon has an empty transcript
+func (a *ShuffleAudit) marshalBeacon() []byte {
+	if a.Beacon == nil {
+		return nil
+	}
+	return a.Beacon.Marshal()
+}
+
+func (a *ShuffleAudit) InterpretFrom(b []byte) error {
+	if len(b) < 4+4+sha256.Size+4 {
+		return errors.LengthInvalidError()
+	}
+	a.NumLayers = int(binary.LittleEndian.Uint32(b[0:4]))
+	a.Suite = crypto.CipherSuite(binary.LittleEndian.Uint32(b[4:8]))
+	pos := 8
+	pos += copy(a.Challenge[:], b[pos:])
+	n := int(binary.LittleEndian.Uint32(b[pos : pos+4]))
+	pos += 4
+	if n > len(b)-pos {
+		return errors.LengthInvalidError()
+	}
+	a.Commitment = &ShuffleCommitment{}
+	err := a.Commitment.InterpretFrom(b[pos : pos+n])
+	if err != nil {
+		return err
+	}
+	pos += n
+	a.Submitted, n, err = interpretHashes(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	a.Received, n, err = interpretHashLists(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	a.Sent, n, err = interpretHashLists(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	a.Links, n, err = interpretHashes(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	if len(b)-pos < 4 {
+		return errors.LengthInvalidError()
+	}
+	count := int(binary.LittleEndian.Uint32(b[pos : pos+4]))
+	pos += 4
+	// an opening is longer than a hash
+	if count > (len(b)-pos)/sha256.Size {
+		return errors.LengthInvalidError()
+	}
+	a.Openings = make([]LinkOpening, count)
+	for i := range a.Openings {
+		n, err = a.Openings[i].InterpretFrom(b[pos:])
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+	transcript, n, err := interpretBytes(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	a.Beacon = nil
+	if len(transcript) > 0 {
+		a.Beacon = &beacon.Transcript{}
+		err = a.Beacon.InterpretFrom(transcript)
+		if err != nil {
+			return err
+		}
+	}
+	if pos != len(b) {
+		return errors.LengthInvalidError()
+	}
+	return nil
+}
+
+func hashListLength(hashes []EnvelopeHash) int {
+	return 4 + sha256.Size*len(hashes)
+}
+
+func packHashes(b []byte, hashes []EnvelopeHash) int {
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(hashes)))
+	pos := 4
+	for i := range hashes {
+		pos += copy(b[pos:pos+sha256.Size], hashes[i][:])
+	}
+	return pos
+}
+
+// a count followed by that many hashes
+func interpretHashes(b []byte) ([]EnvelopeHash, int, error) {
+	if len(b) < 4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	count := int(binary.LittleEndian.Uint32(b[0:4]))
+	if count > (len(b)-4)/sha256.Size {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	hashes := make([]EnvelopeHash, count)
+	pos := 4
+	for i := range hashes {
+		pos += copy(hashes[i][:], b[pos:pos+sha256.Size])
+	}
+	return hashes, pos, nil
+}
+
+// a count followed by that many lists of hashes
+func interpretHashLists(b []byte) ([][]EnvelopeHash, int, error) {
+	if len(b) < 4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	count := int(binary.LittleEndian.Uint32(b[0:4]))
+	if count > (len(b)-4)/4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	lists := make([][]EnvelopeHash, count)
+	pos := 4
+	for i := range lists {
+		var n int
+		var err error
+		lists[i], n, err = interpretHashes(b[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+	}
+	return lists, pos, nil
+}
+
+func packBytes(b []byte, data []byte) int {
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(data)))
+	return 4 + copy(b[4:4+len(data)], data)
+}
+
+// a length followed by that many bytes, which are copied
+func interpretBytes(b []byte) ([]byte, int, error) {
+	if len(b) < 4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	n := int(binary.LittleEndian.Uint32(b[0:4]))
+	if n > len(b)-4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	data := make([]byte, n)
+	copy(data, b[4:4+n])
+	return data, 4 + n, nil
+}
@@ -0,0 +1,170 @@
+package processMessages
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/server/beacon"
+	"github.com/simonlangowski/lightning1/server/common"
+)
+
+const auditClients = 32
+
+type auditPath struct {
+	keys   [3]crypto.VerificationKey
+	signer [3]crypto.SigningKey
+	shared [2]crypto.DHSharedKey
+}
+
+func newAuditPath() *auditPath {
+	p := &auditPath{}
+	for i := range p.keys {
+		p.keys[i], p.signer[i] = crypto.NewSigningKeyPair()
+	}
+	for i := range p.shared {
+		p.shared[i] = make([]byte, 32)
+		rand.Read(p.shared[i])
+	}
+	return p
+}
+
+// clients send through server 0 in layer 0 and server 1 in the last layer 1 to group 0
+// replace changes the output of a link of server 0
+func testTranscripts(suite crypto.CipherSuite, round int, replace func(client int, output []byte) []byte) [2]*ShuffleTranscript {
+	var transcripts [2]*ShuffleTranscript
+	transcripts[0] = NewShuffleTranscript(&common.CommonState{Round: round, MyId: 0, NumServers: 2}, 0, 2)
+	transcripts[1] = NewShuffleTranscript(&common.CommonState{Round: round, MyId: 1, NumServers: 2}, 1, 1)
+	for client := 0; client < auditClients; client++ {
+		p := newAuditPath()
+		final := common.FinalLightningMessage{Message: []byte(fmt.Sprintf("message %d", client))}
+		final.Signature = crypto.SignData(p.signer[2], final.MarshalSigned())
+		nonce := crypto.Nonce(round, 1, 1)
+		inner := suite.SignedSecretSeal(final.MarshalI(), &nonce, p.shared[1], p.signer[1])
+		nonce = crypto.Nonce(round, 0, 0)
+		submission := common.LightningEnvelope{
+			Key:              p.keys[0].LookupKey(),
+			SignedCiphertext: suite.SignedSecretSeal(inner, &nonce, p.shared[0], p.signer[0]),
+		}
+
+		// server 0
+		in := transcripts[0].Receive(client, submission.Marshal())
+		decrypted, err := suite.SecretOpen(submission.SignedCiphertext, &nonce, p.shared[0])
+		if err != nil {
+			panic(err)
+		}
+		out := (&common.LightningEnvelope{Key: p.keys[1].LookupKey(), SignedCiphertext: decrypted}).Marshal()
+		out = replace(client, out)
+		transcripts[0].Link(in, 1, out, &BootstrapKey{SharedKey: p.shared[0], VerificationKey: p.keys[0], OutgoingVerificationKey: p.keys[1], NextServer: 1})
+
+		// server 1
+		in = transcripts[1].Receive(0, out)
+		nonce = crypto.Nonce(round, 1, 1)
+		decrypted, err = suite.SecretOpen(out[crypto.KEY_SIZE:], &nonce, p.shared[1])
+		if err != nil {
+			continue
+		}
+		final = common.FinalLightningMessage{
+			AnonymousVerificationKey: p.keys[2],
+			Signature:                decrypted[:crypto.SIGNATURE_SIZE],
+			Message:                  decrypted[crypto.SIGNATURE_SIZE:],
+		}
+		transcripts[1].Link(in, 0, final.Marshal(), &BootstrapKey{SharedKey: p.shared[1], VerificationKey: p.keys[1], OutgoingVerificationKey: p.keys[2], NextServer: 0})
+	}
+	return transcripts
+}
+
+func unchanged(client int, output []byte) []byte {
+	return output
+}
+
+func testAudits(suite crypto.CipherSuite, round int, replace func(client int, output []byte) []byte) ([2]*ShuffleAudit, EnvelopeHash) {
+	transcripts := testTranscripts(suite, round, replace)
+	commitments := [][]*ShuffleCommitment{{transcripts[0].Commit(), nil}, {nil, transcripts[1].Commit()}}
+	challenge := LinkChallenge(round, 1, beacon.Output{byte(round)}, commitments)
+	return [2]*ShuffleAudit{transcripts[0].Open(challenge, 2, suite), transcripts[1].Open(challenge, 2, suite)}, challenge
+}
+
+func TestPartialChecking(t *testing.T) {
+	for _, suite := range []crypto.CipherSuite{crypto.SuiteAESCTR, crypto.SuiteChaCha20Poly1305} {
+		audits, challenge := testAudits(suite, 3, unchanged)
+		for _, a := range audits {
+			if err := VerifyAudit(a, challenge); err != nil {
+				t.Fatalf("%v: %v", suite, err)
+			}
+		}
+		// every envelope between the layers is opened on exactly one side
+		opened := make(map[EnvelopeHash]int)
+		for _, o := range audits[0].Openings {
+			opened[o.Output]++
+		}
+		for _, o := range audits[1].Openings {
+			opened[o.Input]++
+		}
+		if len(opened) != auditClients {
+			t.Fatalf("%v: %d of %d envelopes opened", suite, len(opened), auditClients)
+		}
+		for _, n := range opened {
+			if n != 1 {
+				t.Fatalf("%v: envelope opened by both layers", suite)
+			}
+		}
+	}
+}
+
+func TestAuditMarshalling(t *testing.T) {
+	audits, challenge := testAudits(crypto.SuiteAESCTR, 1, unchanged)
+	for _, a := range audits {
+		b := make([]byte, a.Len())
+		a.PackTo(b)
+		a2 := &ShuffleAudit{}
+		err := a2.InterpretFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a2.Openings) != len(a.Openings) || a2.Commitment.Links != a.Commitment.Links {
+			t.Fatalf("%+v", a2)
+		}
+		if err := VerifyAudit(a2, challenge); err != nil {
+			t.Fatal(err)
+		}
+		if a2.InterpretFrom(b[:len(b)-1]) == nil || a2.InterpretFrom(append(b, 0)) == nil {
+			t.Fatal("accepted an audit of the wrong length")
+		}
+	}
+}
+
+func TestAuditFailures(t *testing.T) {
+	audits, challenge := testAudits(crypto.SuiteAESCTR, 2, unchanged)
+	other := challenge
+	other[0] ^= 1
+	blamed(t, []error{VerifyAudit(audits[0], other)}, 0)
+
+	// with 32 envelopes both layers open links
+	a := audits[1]
+	withheld := *a
+	withheld.Openings = a.Openings[1:]
+	blamed(t, []error{VerifyAudit(&withheld, challenge)}, 1)
+
+	wrongKey := *a
+	wrongKey.Openings = append([]LinkOpening{}, a.Openings...)
+	wrongKey.Openings[0].Key = make([]byte, len(a.Openings[0].Key))
+	blamed(t, []error{VerifyAudit(&wrongKey, challenge)}, 1)
+
+	hidden := *a
+	hidden.Sent = [][]EnvelopeHash{a.Sent[0][1:]}
+	blamed(t, []error{VerifyAudit(&hidden, challenge)}, 1)
+
+	// server 0 replaces the envelopes of some clients with its own
+	audits, challenge = testAudits(crypto.SuiteAESCTR, 2, func(client int, output []byte) []byte {
+		if client%2 == 0 {
+			replaced := make([]byte, len(output))
+			copy(replaced, output[:crypto.KEY_SIZE])
+			rand.Read(replaced[crypto.KEY_SIZE:])
+			return replaced
+		}
+		return output
+	})
+	blamed(t, []error{VerifyAudit(audits[0], challenge)}, 0)
+}
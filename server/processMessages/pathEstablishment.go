@@ -29,7 +29,7 @@ func NewPathEstablishmentParser(c *common.CommonState, table *KeyLookupTable, la
 		Checkpoint:      checkpoint,
 	}
 	if checkpoint == nil {
-		for i := 0; i < c.NumServers; i++ {
+		for _, i := range c.ServerIds {
 			p.OutgoingBuffers[i] = buffers.NewMemReadWriter(c.PathMessageLengths[layer+1], c.BinSize, c.Shufflers[i])
 		}
 	}
@@ -44,19 +44,20 @@ func (p *PathEstablishmentParser) ParseRecordAndGetNext(metadata *messages.Metad
 	nonce := crypto.Nonce(round, layer, server)
 	source := metadata.Sender
 
+	kemSize := p.c.KeyAgreement.CiphertextSize()
 	pm := common.PathEstablishmentEnvelope{}
-	err := pm.InterpretFrom(message)
+	err := pm.InterpretFrom(message, kemSize)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// TODO: Batch verification of tokens?
 	if !VerifyToken(p.c.CombinedKey, &pm.InToken, p.c.Round, p.c.Round, source, pm.InKey) {
-		return nil, nil, errors.TokenInvalid()
+		return nil, nil, errors.TokenInvalid().At(round, layer).From(source).WithKey(pm.InKey[:])
 	}
 	tokenHash := pm.InToken.Hash()
 	if p.c.HashToServer(&tokenHash) != uint64(p.c.MyId) {
-		return nil, nil, errors.WrongServerError()
+		return nil, nil, errors.WrongServerError().At(round, layer).From(source).WithKey(pm.InKey[:])
 	}
 
 	inPoint, err := pm.InKey.ToCurvePoint()
@@ -64,27 +65,43 @@ func (p *PathEstablishmentParser) ParseRecordAndGetNext(metadata *messages.Metad
 		return nil, nil, errors.BadElementError()
 	}
 
-	sharedKey := p.table.secretKey.SharedKey(inPoint)
+	var inKem []byte
+	if kemSize > 0 {
+		inKem = pm.InKem
+	}
+	sharedKey, err := p.table.SharedKey(inPoint, inKem)
+	if err != nil {
+		return nil, nil, errors.DecryptionFailure().At(round, layer).From(source).WithKey(pm.InKey[:])
+	}
 	if !crypto.Verify(pm.InKey, pm.GetSignedData(round, layer, server), pm.ReadSignature()) {
-		return nil, nil, errors.DecryptionFailure()
+		return nil, nil, errors.DecryptionFailure().At(round, layer).From(source).WithKey(pm.InKey[:])
+	}
+	decrypted, err := p.c.Suite.SecretOpen(pm.SignedCiphertext, &nonce, sharedKey)
+	if err != nil {
+		return nil, nil, errors.DecryptionFailure().At(round, layer).From(source).WithKey(pm.InKey[:])
 	}
-	decrypted := crypto.SecretOpen(pm.SignedCiphertext, &nonce, sharedKey)
 	pi := common.PathEstablishmentInfo{}
-	err = pi.InterpretFrom(decrypted, boomerangLength)
+	err = pi.InterpretFrom(decrypted, boomerangLength, kemSize)
 	if err != nil {
 		return nil, nil, err
 	}
 	if !VerifyToken(p.c.CombinedKey, &pi.OutToken, p.c.Round+1, p.c.Round+1, p.c.MyId, pi.OutKey) {
-		return nil, nil, errors.TokenInvalid()
+		return nil, nil, errors.TokenInvalid().At(round, layer).From(source).WithKey(pm.InKey[:])
 	}
 	tokenHash = pi.OutToken.Hash()
 	next := int(p.c.HashToServer(&tokenHash))
-	key, err := p.table.AddKey(pm.InKey, sharedKey, source, next, pi.OutKey)
+	// the last server's outgoing key is never used, the client shares its last key with the anytrust group
+	var returnKem []byte
+	if kemSize > 0 && p.Checkpoint == nil {
+		returnKem = pi.ReturnKem
+	}
+	key, err := p.table.AddKey(pm.InKey, sharedKey, source, next, pi.OutKey, returnKem)
 	if err != nil {
 		return nil, nil, errors.BadElementError()
 	}
 	if p.Checkpoint == nil {
 		nextMessage := common.PathEstablishmentEnvelope{
+			InKem:            pi.OutKem,
 			InKey:            pi.OutKey,
 			InToken:          pi.OutToken,
 			SignedCiphertext: pi.NextEnvelope,
@@ -102,7 +119,7 @@ func (p *PathEstablishmentParser) ParseRecordAndGetNext(metadata *messages.Metad
 		signature := pi.GetSignature()
 		errors.DebugPrint("Verifying %v on %v with %v", pi.GetSignature(), pi.GetSignedData(p.c.NumLayers, p.c.NumLayers, server), cm.AnonymousVerificationKey.PublicKey())
 		if !crypto.Verify(cm.AnonymousVerificationKey, signedData, signature) {
-			return nil, nil, errors.DecryptionFailure()
+			return nil, nil, errors.DecryptionFailure().At(round, layer).From(source).WithKey(pm.InKey[:])
 		}
 		err = p.Checkpoint.AddReverseMessage(pi.BoomerangEnvelope, &cm, key, group)
 	}
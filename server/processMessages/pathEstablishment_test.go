@@ -0,0 +1,94 @@
+package processMessages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/crypto/token"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/common"
+	"github.com/simonlangowski/lightning1/server/prepareMessages"
+)
+
+// a client's path establishment message is opened by each server on its path
+// and the servers recover the same keys as the client
+func testPathKeys(t *testing.T, agreement crypto.KeyAgreement) {
+	numServers, numLayers, limit := 4, 4, 2
+	template := &common.CommonState{
+		NumServers:   numServers,
+		NumGroups:    1,
+		NumLayers:    numLayers,
+		BinSize:      10,
+		Suite:        crypto.DefaultSuite,
+		KeyAgreement: agreement,
+		CombinedKey:  token.PublicKey,
+	}
+	_, groupKey := crypto.NewDHKeyPair()
+	template.GroupPublicKey = groupKey
+	template.PathMessageLengths = prepareMessages.PathEstablishmentLengths(numLayers, 8, limit, template.Suite, agreement)
+	template.BoomerangMessageLengths = prepareMessages.BoomerangLengths(numLayers, 8, limit, template.Suite)
+	states := common.NewMockCommonStates(numServers, template)
+
+	clientState := *states[0]
+	clientId := numServers + 1
+	clientState.MyId = clientId
+	client, err := prepareMessages.NewClient(&clientState, int64(clientId), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, _, err := client.MakeOptimizedPathEstablishmentMessage(nil, numLayers, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := envelope.Marshal()
+	if len(message) != template.PathMessageLengths[0] {
+		t.Fatalf("message of length %d, expected %d", len(message), template.PathMessageLengths[0])
+	}
+	sender := clientId
+	// the last layer is opened with the anytrust group
+	for l := 0; l < numLayers-1; l++ {
+		sid := int(client.PathKeys[l].ServerID)
+		c := states[sid]
+		c.Round, c.Layer = l, l
+		c.Shufflers = make([]*config.Shuffler, numServers)
+		for i := range c.Shufflers {
+			c.Shufflers[i] = config.NewPRGShuffler(rand.Reader)
+		}
+		p := NewPathEstablishmentParser(c, NewKeyLookupTable(c), l, nil)
+		_, key, err := p.ParseRecordAndGetNext(&messages.Metadata{Sender: sender}, message)
+		if err != nil {
+			t.Fatalf("layer %d: %v", l, err)
+		}
+		if !bytes.Equal(key.SharedKey, client.PathKeys[l].Shared) {
+			t.Fatalf("layer %d: incoming keys differ", l)
+		}
+		if !bytes.Equal(key.OutgoingSharedKey, client.PathKeys[l+1].PrevShared) {
+			t.Fatalf("layer %d: outgoing keys differ", l)
+		}
+		next := p.OutgoingBuffers[int(client.PathKeys[l+1].ServerID)]
+		if next.NumMessages() != 1 {
+			t.Fatalf("layer %d: message not forwarded", l)
+		}
+		next.Shuffle(false)
+		message = make([]byte, template.PathMessageLengths[l+1])
+		err = next.ReadElement(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender = sid
+	}
+}
+
+func TestPathKeysDH(t *testing.T) {
+	testPathKeys(t, crypto.KeyAgreementDH)
+}
+
+func TestPathKeysHybrid(t *testing.T) {
+	if !crypto.KEMSupported {
+		t.Skip("ML-KEM needs go 1.24")
+	}
+	testPathKeys(t, crypto.KeyAgreementHybrid)
+}
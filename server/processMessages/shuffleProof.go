@@ -0,0 +1,342 @@
+package processMessages
+
+// Verifiable rounds
+// A server cannot prove that its output is a permutation of the decryptions of its input
+// without revealing the permutation, so instead it commits to each layer it mixes:
+// how many envelopes it received from and sent to each server with a digest of their hashes,
+// and a hiding commitment to the link from each input to its output.
+// The signed commitments are sent to every server, and once the round is over each server checks
+// that every commitment conserves envelopes and agrees with the commitments of the servers before and after it.
+// Half of the links are opened after the round, see partialChecking.go
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/server/common"
+)
+
+const LINK_SALT_SIZE = 16
+
+type EnvelopeHash [sha256.Size]byte
+
+func HashEnvelope(envelope []byte) EnvelopeHash {
+	return sha256.Sum256(envelope)
+}
+
+// the number of envelopes in a batch and a digest of their hashes that does not depend on their order
+type BatchDigest struct {
+	Count  int
+	Digest EnvelopeHash
+}
+
+func NewBatchDigest(hashes []EnvelopeHash) BatchDigest {
+	h := sha256.New()
+	for _, e := range sortedHashes(hashes) {
+		h.Write(e[:])
+	}
+	d := BatchDigest{Count: len(hashes)}
+	h.Sum(d.Digest[:0])
+	return d
+}
+
+// a sorted copy, which does not reveal the order envelopes were processed in
+func sortedHashes(hashes []EnvelopeHash) []EnvelopeHash {
+	sorted := make([]EnvelopeHash, len(hashes))
+	copy(sorted, hashes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	return sorted
+}
+
+// an input envelope and the output it was decrypted to
+type Link struct {
+	Input  EnvelopeHash
+	Output EnvelopeHash
+	Salt   [LINK_SALT_SIZE]byte
+}
+
+func (l *Link) Commitment(round, layer, server int) EnvelopeHash {
+	b := make([]byte, 12, 12+2*sha256.Size+LINK_SALT_SIZE)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(round))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(layer))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(server))
+	b = append(b, l.Input[:]...)
+	b = append(b, l.Output[:]...)
+	b = append(b, l.Salt[:]...)
+	return sha256.Sum256(b)
+}
+
+// a server's commitment to one layer
+type ShuffleCommitment struct {
+	Round  int
+	Layer  int
+	Server int
+	// envelopes submitted by clients, only in the first layer
+	Submitted BatchDigest
+	// by sending server
+	Received []BatchDigest
+	// by receiving server, or by group in the last layer
+	Sent []BatchDigest
+	// digest of the sorted link commitments
+	NumLinks int
+	Links    EnvelopeHash
+}
+
+// records the envelopes of one layer
+type ShuffleTranscript struct {
+	round     int
+	layer     int
+	server    int
+	mu        sync.Mutex
+	submitted []EnvelopeHash
+	received  [][]EnvelopeHash
+	sent      [][]EnvelopeHash
+	links     []Link
+	// kept to open the links: the inputs, and the keys and destination of each link
+	inputs map[EnvelopeHash][]byte
+	keys   []*BootstrapKey
+	dests  []int
+}
+
+// numDests is the id space of the servers, or the number of groups in the last layer
+func NewShuffleTranscript(c *common.CommonState, layer, numDests int) *ShuffleTranscript {
+	return &ShuffleTranscript{
+		round:    c.Round,
+		layer:    layer,
+		server:   c.MyId,
+		received: make([][]EnvelopeHash, c.IdSpace()),
+		sent:     make([][]EnvelopeHash, numDests),
+		links:    make([]Link, 0),
+		inputs:   make(map[EnvelopeHash][]byte),
+	}
+}
+
+// record an envelope before it is parsed, senders of the first layer are clients
+func (t *ShuffleTranscript) Receive(sender int, envelope []byte) EnvelopeHash {
+	h := HashEnvelope(envelope)
+	input := make([]byte, len(envelope))
+	copy(input, envelope)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inputs[h] = input
+	if t.layer == 0 {
+		t.submitted = append(t.submitted, h)
+	} else if sender >= 0 && sender < len(t.received) {
+		t.received[sender] = append(t.received[sender], h)
+	}
+	return h
+}
+
+// record the output of an input that was sent to dest, decrypted with key
+func (t *ShuffleTranscript) Link(input EnvelopeHash, dest int, output []byte, key *BootstrapKey) {
+	l := Link{Input: input, Output: HashEnvelope(output)}
+	_, err := rand.Read(l.Salt[:])
+	if err != nil {
+		panic("Could not read salt")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent[dest] = append(t.sent[dest], l.Output)
+	t.links = append(t.links, l)
+	t.keys = append(t.keys, key)
+	t.dests = append(t.dests, dest)
+}
+
+func (t *ShuffleTranscript) Commit() *ShuffleCommitment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := &ShuffleCommitment{
+		Round:     t.round,
+		Layer:     t.layer,
+		Server:    t.server,
+		Submitted: NewBatchDigest(t.submitted),
+		Received:  make([]BatchDigest, len(t.received)),
+		Sent:      make([]BatchDigest, len(t.sent)),
+		NumLinks:  len(t.links),
+	}
+	for i := range t.received {
+		c.Received[i] = NewBatchDigest(t.received[i])
+	}
+	for i := range t.sent {
+		c.Sent[i] = NewBatchDigest(t.sent[i])
+	}
+	commitments := make([]EnvelopeHash, len(t.links))
+	for i := range t.links {
+		commitments[i] = t.links[i].Commitment(t.round, t.layer, t.server)
+	}
+	c.Links = NewBatchDigest(commitments).Digest
+	return c
+}
+
+// envelopes received that were not linked to an output
+func (c *ShuffleCommitment) Rejected() int {
+	return c.NumReceived() - c.NumLinks
+}
+
+func (c *ShuffleCommitment) NumReceived() int {
+	n := c.Submitted.Count
+	for _, d := range c.Received {
+		n += d.Count
+	}
+	return n
+}
+
+func (c *ShuffleCommitment) NumSent() int {
+	n := 0
+	for _, d := range c.Sent {
+		n += d.Count
+	}
+	return n
+}
+
+// every output is linked to a different input
+func (c *ShuffleCommitment) Check() error {
+	if c.NumSent() != c.NumLinks || c.Rejected() < 0 {
+		return c.blame(c.Server, fmt.Errorf("%d links from %d inputs to %d outputs", c.NumLinks, c.NumReceived(), c.NumSent()))
+	}
+	return nil
+}
+
+func (c *ShuffleCommitment) blame(server int, cause error) error {
+	e := errors.CommitFailure().At(c.Round, c.Layer).From(server)
+	e.Cause = cause
+	return e
+}
+
+// check the commitments of a round, indexed by layer and server
+// a missing commitment is nil, and only checked if required
+func VerifyShuffles(round int, commitments [][]*ShuffleCommitment, required func(layer, server int) bool) []error {
+	failures := make([]error, 0)
+	for layer := range commitments {
+		for server, c := range commitments[layer] {
+			if c == nil {
+				if required(layer, server) {
+					e := errors.CommitFailure().At(round, layer).From(server)
+					e.Cause = fmt.Errorf("no commitment")
+					failures = append(failures, e)
+				}
+				continue
+			}
+			err := c.Check()
+			if err != nil {
+				failures = append(failures, err)
+			}
+			if layer == 0 {
+				continue
+			}
+			// what each server of the previous layer says it sent here
+			for prev, p := range commitments[layer-1] {
+				if p == nil || server >= len(p.Sent) || prev >= len(c.Received) {
+					continue
+				}
+				if p.Sent[server] != c.Received[prev] {
+					failures = append(failures, c.blame(prev, fmt.Errorf("sent %d envelopes to server %d, which received %d", p.Sent[server].Count, server, c.Received[prev].Count)))
+				}
+			}
+		}
+	}
+	return failures
+}
+
+const digestLength = 4 + sha256.Size
+
+func (c *ShuffleCommitment) Len() int {
+	return 4*4 + sha256.Size + digestLength + 4 + 4 + digestLength*(len(c.Received)+len(c.Sent))
+}
+
+func (c *ShuffleCommitment) PackTo(b []byte) {
+	if len(b) != c.Len() {
+		panic(errors.LengthInvalidError())
+	}
+	binary.LittleEndian.PutUint32(b[0:4], uint32(c.Round))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(c.Layer))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(c.Server))
+	binary.LittleEndian.PutUint32(b[12:16], uint32(c.NumLinks))
+	pos := 16
+	copy(b[pos:pos+sha256.Size], c.Links[:])
+	pos += sha256.Size
+	pos += packDigests(b[pos:], []BatchDigest{c.Submitted})
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(c.Received)))
+	pos += 4
+	pos += packDigests(b[pos:], c.Received)
+	binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(len(c.Sent)))
+	pos += 4
+	packDigests(b[pos:], c.Sent)
+}
+
+func (c *ShuffleCommitment) InterpretFrom(b []byte) error {
+	if len(b) < 4*4+sha256.Size+digestLength+4 {
+		return errors.LengthInvalidError()
+	}
+	c.Round = int(binary.LittleEndian.Uint32(b[0:4]))
+	c.Layer = int(binary.LittleEndian.Uint32(b[4:8]))
+	c.Server = int(binary.LittleEndian.Uint32(b[8:12]))
+	c.NumLinks = int(binary.LittleEndian.Uint32(b[12:16]))
+	pos := 16
+	copy(c.Links[:], b[pos:pos+sha256.Size])
+	pos += sha256.Size
+	submitted, n, err := interpretDigests(b[pos:], 1)
+	if err != nil {
+		return err
+	}
+	c.Submitted = submitted[0]
+	pos += n
+	c.Received, n, err = interpretDigestList(b[pos:])
+	if err != nil {
+		return err
+	}
+	pos += n
+	c.Sent, n, err = interpretDigestList(b[pos:])
+	if err != nil {
+		return err
+	}
+	if pos+n != len(b) {
+		return errors.LengthInvalidError()
+	}
+	return nil
+}
+
+func packDigests(b []byte, digests []BatchDigest) int {
+	pos := 0
+	for _, d := range digests {
+		binary.LittleEndian.PutUint32(b[pos:pos+4], uint32(d.Count))
+		copy(b[pos+4:pos+digestLength], d.Digest[:])
+		pos += digestLength
+	}
+	return pos
+}
+
+func interpretDigests(b []byte, count int) ([]BatchDigest, int, error) {
+	if count < 0 || len(b) < count*digestLength {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	digests := make([]BatchDigest, count)
+	pos := 0
+	for i := range digests {
+		digests[i].Count = int(binary.LittleEndian.Uint32(b[pos : pos+4]))
+		copy(digests[i].Digest[:], b[pos+4:pos+digestLength])
+		pos += digestLength
+	}
+	return digests, pos, nil
+}
+
+// a count followed by that many digests
+func interpretDigestList(b []byte) ([]BatchDigest, int, error) {
+	if len(b) < 4 {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	count := int(binary.LittleEndian.Uint32(b[0:4]))
+	if count > (len(b)-4)/digestLength {
+		return nil, 0, errors.LengthInvalidError()
+	}
+	digests, n, err := interpretDigests(b[4:], count)
+	return digests, n + 4, err
+}
@@ -0,0 +1,119 @@
+package processMessages
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/server/common"
+)
+
+// two layers of three servers, every server sends one envelope to every server
+func testCommitments(round int) [][]*ShuffleCommitment {
+	numServers, numLayers := 3, 2
+	commitments := make([][]*ShuffleCommitment, numLayers)
+	transcripts := make([][]*ShuffleTranscript, numLayers)
+	for l := range transcripts {
+		transcripts[l] = make([]*ShuffleTranscript, numServers)
+		for sid := range transcripts[l] {
+			c := &common.CommonState{Round: round, MyId: sid, NumServers: numServers}
+			transcripts[l][sid] = NewShuffleTranscript(c, l, numServers)
+		}
+	}
+	for sid := 0; sid < numServers; sid++ {
+		for dest := 0; dest < numServers; dest++ {
+			in := transcripts[0][sid].Receive(100+dest, []byte(fmt.Sprintf("client %d %d", sid, dest)))
+			out := []byte(fmt.Sprintf("envelope %d %d", sid, dest))
+			transcripts[0][sid].Link(in, dest, out, nil)
+			in = transcripts[1][dest].Receive(sid, out)
+			transcripts[1][dest].Link(in, sid, []byte(fmt.Sprintf("final %d %d", sid, dest)), nil)
+		}
+	}
+	for l := range transcripts {
+		commitments[l] = make([]*ShuffleCommitment, numServers)
+		for sid := range transcripts[l] {
+			commitments[l][sid] = transcripts[l][sid].Commit()
+		}
+	}
+	return commitments
+}
+
+func always(layer, server int) bool {
+	return true
+}
+
+func blamed(t *testing.T, failures []error, peer int) {
+	for _, err := range failures {
+		e := &errors.Error{}
+		if !errors.As(err, &e) || !errors.Is(err, errors.ErrCommit) {
+			t.Fatalf("unexpected failure %v", err)
+		}
+		if e.Peer == peer {
+			return
+		}
+	}
+	t.Fatalf("server %d not blamed in %v", peer, failures)
+}
+
+func TestShuffleCommitmentMarshalling(t *testing.T) {
+	c := testCommitments(4)[1][2]
+	b := make([]byte, c.Len())
+	c.PackTo(b)
+	c2 := &ShuffleCommitment{}
+	err := c2.InterpretFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Round != 4 || c2.Layer != 1 || c2.Server != 2 || c2.NumLinks != 3 || c2.Links != c.Links {
+		t.Fatalf("%+v", c2)
+	}
+	for i := range c.Received {
+		if c.Received[i] != c2.Received[i] || c.Sent[i] != c2.Sent[i] {
+			t.Fatalf("digest %d differs", i)
+		}
+	}
+	if c2.InterpretFrom(b[:len(b)-1]) == nil || c2.InterpretFrom(append(b, 0)) == nil {
+		t.Fatal("accepted a commitment of the wrong length")
+	}
+}
+
+func TestBatchDigestOrder(t *testing.T) {
+	a, b := HashEnvelope([]byte("a")), HashEnvelope([]byte("b"))
+	if NewBatchDigest([]EnvelopeHash{a, b}) != NewBatchDigest([]EnvelopeHash{b, a}) {
+		t.Fatal("digest depends on the order")
+	}
+	if NewBatchDigest([]EnvelopeHash{a, a}) == NewBatchDigest([]EnvelopeHash{a, b}) {
+		t.Fatal("different batches have the same digest")
+	}
+}
+
+func TestVerifyShuffles(t *testing.T) {
+	commitments := testCommitments(1)
+	if failures := VerifyShuffles(1, commitments, always); len(failures) != 0 {
+		t.Fatal(failures)
+	}
+
+	// server 0 claims to have received something else from server 2
+	commitments[1][0].Received[2].Digest[0] ^= 1
+	blamed(t, VerifyShuffles(1, commitments, always), 2)
+
+	// server 1 sent an envelope it did not link to an input
+	commitments = testCommitments(1)
+	commitments[0][1].Sent[0].Count++
+	failures := VerifyShuffles(1, commitments, always)
+	blamed(t, failures, 1)
+	if len(failures) != 2 {
+		t.Fatalf("expected the extra envelope and the disagreement, got %v", failures)
+	}
+
+	// a server dropped from the round
+	commitments = testCommitments(1)
+	commitments[1][1] = nil
+	blamed(t, VerifyShuffles(1, commitments, always), 1)
+	failures = VerifyShuffles(1, commitments, func(layer, server int) bool {
+		return server != 1
+	})
+	if len(failures) != 0 {
+		t.Fatal(failures)
+	}
+}
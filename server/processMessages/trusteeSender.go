@@ -9,6 +9,8 @@ import (
 type TrusteeRouter struct {
 	c               *common.CommonState
 	OutgoingBuffers map[int]*buffers.MemReadWriter
+	// links of the last layer in verifiable rounds, nil otherwise
+	Transcript *ShuffleTranscript
 }
 
 func NewTrusteeRouter(c *common.CommonState, layer int) *TrusteeRouter {
@@ -17,20 +19,39 @@ func NewTrusteeRouter(c *common.CommonState, layer int) *TrusteeRouter {
 		OutgoingBuffers: make(map[int]*buffers.MemReadWriter),
 	}
 	for i := 0; i < c.NumGroups; i++ {
-		t.OutgoingBuffers[i] = buffers.NewMemReadWriter(c.OnionMessageLengths[layer], c.GroupBinSize, c.Shufflers[i])
+		t.OutgoingBuffers[i] = buffers.NewMemReadWriter(c.OnionMessageLengths[layer], c.GroupBinSize, c.GroupShuffler(i))
+	}
+	if c.Verifiable {
+		// the layer whose output this is
+		t.Transcript = NewShuffleTranscript(c, layer-1, c.NumGroups)
 	}
 	return t
 }
 
+// Pack the decryption of an input recorded by the transcript, and link them in verifiable rounds
+func (t *TrusteeRouter) LinkedPack(input EnvelopeHash, decrypted []byte, destination *BootstrapKey) error {
+	output, err := t.pack(decrypted, destination)
+	if err == nil && t.Transcript != nil {
+		t.Transcript.Link(input, destination.NextServer, output, destination)
+	}
+	return err
+}
+
 func (t *TrusteeRouter) Pack(decrypted []byte, destination *BootstrapKey) error {
+	_, err := t.pack(decrypted, destination)
+	return err
+}
+
+func (t *TrusteeRouter) pack(decrypted []byte, destination *BootstrapKey) ([]byte, error) {
 	pm := common.FinalLightningMessage{
 		AnonymousVerificationKey: destination.OutgoingVerificationKey,
 		Signature:                decrypted[:crypto.SIGNATURE_SIZE],
 		Message:                  decrypted[crypto.SIGNATURE_SIZE:],
 	}
-	err := t.OutgoingBuffers[destination.NextServer].Write(pm.Marshal())
+	output := pm.Marshal()
+	err := t.OutgoingBuffers[destination.NextServer].Write(output)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return output, nil
 }
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Profiles of a round or layer requested through the admin service
+// profiling starts when the server reaches the round and stops when it moves past it
+
+type profileResult struct {
+	data []byte
+	err  error
+}
+
+type profiler struct {
+	mu      sync.Mutex
+	request *admin.ProfileRequest
+	running bool
+	buf     *bytes.Buffer
+	done    chan profileResult
+	// the layer the server is processing once it started one, and whether layers go up or down
+	started            bool
+	curRound, curLayer int
+	direction          int
+}
+
+func (p *profiler) want(req *admin.ProfileRequest) (chan profileResult, error) {
+	if req.Kind != admin.CPUProfile && req.Kind != admin.HeapProfile {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown profile kind %q", req.Kind)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.request != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "already profiling round %d", p.request.Round)
+	}
+	// the start of the current layer was missed as well
+	if p.started && (p.curRound > req.Round || p.curRound == req.Round && req.Layer != admin.AllLayers && (p.curLayer-req.Layer)*p.direction >= 0) {
+		return nil, status.Errorf(codes.OutOfRange, "round %d layer %d already started", req.Round, req.Layer)
+	}
+	p.request = req
+	p.done = make(chan profileResult, 1)
+	return p.done, nil
+}
+
+// the caller gave up
+func (p *profiler) cancel(done chan profileResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done == done {
+		p.stop()
+	}
+}
+
+func (p *profiler) matches(round, layer int) bool {
+	return round == p.request.Round && (p.request.Layer == admin.AllLayers || layer == p.request.Layer)
+}
+
+// path rounds go down through the layers
+func (p *profiler) passed(round, layer int) bool {
+	return round > p.request.Round || round == p.request.Round && p.request.Layer != admin.AllLayers && (layer-p.request.Layer)*p.direction > 0
+}
+
+// called when the server starts processing a layer
+func (p *profiler) layer(round, layer, direction int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started, p.curRound, p.curLayer, p.direction = true, round, layer, direction
+	if p.request == nil {
+		return
+	}
+	if p.running && !p.matches(round, layer) {
+		p.finish(nil)
+	} else if !p.running && p.matches(round, layer) {
+		p.start()
+	} else if !p.running && p.passed(round, layer) {
+		p.finish(status.Errorf(codes.OutOfRange, "round %d layer %d already passed", p.request.Round, p.request.Layer))
+	}
+}
+
+// called when the server completes or aborts a round
+func (p *profiler) roundDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.request != nil && p.running {
+		p.finish(nil)
+	}
+}
+
+func (p *profiler) start() {
+	p.buf = &bytes.Buffer{}
+	if p.request.Kind == admin.CPUProfile {
+		// fails if someone is using /debug/pprof/profile
+		err := pprof.StartCPUProfile(p.buf)
+		if err != nil {
+			p.finish(status.Errorf(codes.Unavailable, "could not start cpu profile: %v", err))
+			return
+		}
+	}
+	p.running = true
+}
+
+func (p *profiler) finish(err error) {
+	if err == nil && p.request.Kind == admin.HeapProfile {
+		runtime.GC() // get up-to-date statistics
+		err = pprof.Lookup("heap").WriteTo(p.buf, 0)
+	}
+	p.stop()
+	if err != nil {
+		p.done <- profileResult{err: err}
+	} else {
+		p.done <- profileResult{data: p.buf.Bytes()}
+	}
+	p.buf = nil
+}
+
+func (p *profiler) stop() {
+	if p.running && p.request.Kind == admin.CPUProfile {
+		pprof.StopCPUProfile()
+	}
+	p.running = false
+	p.request = nil
+}
+
+func (s *Server) Profile(ctx context.Context, req *admin.ProfileRequest) (*admin.Profile, error) {
+	done, err := s.profiler.want(req)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info("profile requested", "kind", req.Kind, "round", req.Round, "layer", req.Layer)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &admin.Profile{Server: s.CommonState.MyId, Kind: req.Kind, Round: req.Round, Layer: req.Layer, Data: r.data}, nil
+	case <-ctx.Done():
+		s.profiler.cancel(done)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// label samples of this goroutine, so profiles can be filtered with e.g. -tagfocus layer=3
+func setLabels(round, layer int, phase string) {
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(),
+		pprof.Labels("round", strconv.Itoa(round), "layer", strconv.Itoa(layer), "phase", phase)))
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/simonlangowski/lightning1/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProfileLayer(t *testing.T) {
+	p := &profiler{}
+	done, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 2, Layer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 3}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Second request not refused: %v", err)
+	}
+	p.layer(1, 0, 1)
+	p.layer(2, 0, 1)
+	if p.running {
+		t.Fatal("Started before the layer")
+	}
+	p.layer(2, 1, 1)
+	if !p.running {
+		t.Fatal("Not started at the layer")
+	}
+	p.layer(2, 2, 1)
+	r := <-done
+	if r.err != nil || len(r.data) == 0 {
+		t.Fatalf("No profile %v", r.err)
+	}
+	if p.request != nil {
+		t.Fatal("Request not cleared")
+	}
+}
+
+func TestProfileRound(t *testing.T) {
+	p := &profiler{}
+	done, err := p.want(&admin.ProfileRequest{Kind: admin.CPUProfile, Round: 1, Layer: admin.AllLayers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.layer(1, 0, 1)
+	p.layer(1, 1, 1)
+	p.roundDone()
+	r := <-done
+	if r.err != nil || len(r.data) == 0 {
+		t.Fatalf("No profile %v", r.err)
+	}
+
+	done, _ = p.want(&admin.ProfileRequest{Kind: admin.CPUProfile, Round: 1, Layer: admin.AllLayers})
+	p.layer(2, 0, 1)
+	if r := <-done; status.Code(r.err) != codes.OutOfRange {
+		t.Fatalf("Passed round not reported: %v", r.err)
+	}
+	if _, err := p.want(&admin.ProfileRequest{Kind: "block"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Unknown kind accepted: %v", err)
+	}
+}
+
+func TestProfilePassedLayer(t *testing.T) {
+	p := &profiler{}
+	p.layer(3, 2, 1)
+	// the layer already started, so the request fails at once instead of waiting for the next round
+	for _, layer := range []int{1, 2} {
+		if _, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 3, Layer: layer}); status.Code(err) != codes.OutOfRange {
+			t.Fatalf("Passed layer %d not reported: %v", layer, err)
+		}
+	}
+	if _, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 2, Layer: admin.AllLayers}); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Passed round not reported: %v", err)
+	}
+	// waiting for a later layer
+	done, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 3, Layer: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.layer(3, 5, 1)
+	if r := <-done; status.Code(r.err) != codes.OutOfRange {
+		t.Fatalf("Skipped layer not reported: %v", r.err)
+	}
+
+	// path rounds go down through the layers
+	p.layer(4, 3, -1)
+	if _, err := p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 4, Layer: 4}); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Passed layer not reported: %v", err)
+	}
+	done, err = p.want(&admin.ProfileRequest{Kind: admin.HeapProfile, Round: 4, Layer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.layer(4, 2, -1)
+	p.layer(4, 1, -1)
+	p.layer(4, 0, -1)
+	if r := <-done; r.err != nil || len(r.data) == 0 {
+		t.Fatalf("No profile %v", r.err)
+	}
+}
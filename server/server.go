@@ -4,24 +4,29 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"os"
-	"runtime/pprof"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/simonlangowski/lightning1/config"
 	coord "github.com/simonlangowski/lightning1/coordinator/messages"
 	"github.com/simonlangowski/lightning1/crypto"
-	"github.com/simonlangowski/lightning1/crypto/pairing/mcl"
+	"github.com/simonlangowski/lightning1/crypto/pairing/curve"
 	"github.com/simonlangowski/lightning1/crypto/token"
 	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/logging"
+	"github.com/simonlangowski/lightning1/metrics"
 	"github.com/simonlangowski/lightning1/network"
 	"github.com/simonlangowski/lightning1/network/buffers"
 	"github.com/simonlangowski/lightning1/network/messages"
 	"github.com/simonlangowski/lightning1/network/synchronization"
+	"github.com/simonlangowski/lightning1/server/beacon"
 	"github.com/simonlangowski/lightning1/server/checkpoint"
 	"github.com/simonlangowski/lightning1/server/common"
 	"github.com/simonlangowski/lightning1/server/prepareMessages"
 	"github.com/simonlangowski/lightning1/server/processMessages"
+	"github.com/simonlangowski/lightning1/tracing"
 )
 
 type Server struct {
@@ -48,6 +53,15 @@ type Server struct {
 	pathRound                bool
 	pathLayer                int
 	direction                int
+	progress                 progress
+	profiler                 profiler
+	created                  time.Time
+	log                      *logging.Logger
+	// the coordinator's span for this round and the span of the layer being processed
+	roundTrace tracing.SpanContext
+	layerSpan  *tracing.Span
+	// invalid envelopes, dropped servers and why the round was aborted
+	failures *roundFailures
 
 	pool            *WorkPool
 	handler         *Handlers
@@ -55,7 +69,14 @@ type Server struct {
 	roundComplete   *sync.Cond
 	mu              sync.RWMutex
 	receiptLock     sync.Mutex
+	submissions     *submissionTable
+	shuffles        shuffleCommitments
+	beacon          *beacon.Participant
 	started         bool
+	// set when the server moves to new epochs by itself
+	directory *config.DirectorySource
+	// the *epochView of the current epoch
+	current atomic.Value
 	coord.UnimplementedCoordinatorHandlerServer
 }
 
@@ -66,27 +87,35 @@ func NewServer(configs *config.Servers, groups *config.Groups, handler *Handlers
 		CommonState:  common.NewCommonState(configs.Servers, myId, groups),
 		Keys:         make([]*processMessages.KeyLookupTable, 0),
 		handler:      handler,
+		submissions:  newSubmissionTable(),
+		failures:     newRoundFailures(0),
+		created:      time.Now(),
 	}
-	config.InitLogger(s.CommonState.MyId)
+	s.log = logging.For("server").With("server", s.CommonState.MyId)
+	s.beacon = beacon.NewParticipant(s.CommonState.MyId, s.CommonState.SecretSigningKey)
 	for gid, cfg := range groups.Groups {
 		for _, sid := range cfg.Servers {
 			if sid == myId {
-				s.GroupAliases[int32(gid)] = NewGroupMember(int(gid), s.CommonState)
+				s.GroupAliases[int32(gid)] = NewGroupMember(int(gid), s.CommonState, s.failures)
 				break
 			}
 		}
 	}
+	s.publishView()
 	s.roundComplete = sync.NewCond(s.mu.RLocker())
 	s.TcpConnections = network.NewConnectionManager(s.CommonState.Configs, s.CommonState.MyId)
 	handler.SetServer(s)
-	s.pool = NewWorkPool(handler, s)
+	s.pool = NewWorkPool(s)
+	id := strconv.Itoa(s.CommonState.MyId)
+	metrics.PoolQueueDepth.Set(func() float64 { return float64(len(s.pool.jobs)) }, id)
+	metrics.PendingRequests.Set(func() float64 { return float64(handler.Admission().Stats().Pending) }, id)
 	return s
 }
 
 // connect to other servers
 func (s *Server) Connect() error {
 	var err error
-	s.Caller, err = network.NewCaller(s.CommonState.Configs)
+	s.Caller, err = network.NewCaller(s.CommonState.Configs, s.TcpConnections.MyCfg)
 	if err != nil {
 		return err
 	}
@@ -97,15 +126,26 @@ func (s *Server) Connect() error {
 	return nil
 }
 
-// process messages received directly from clients
+// process messages received directly from clients, and return a signed receipt
+// resubmitting the same envelope returns the same receipt
 func (s *Server) HandleSubmissionMessage(m *messages.SignedMessage) (*messages.SignedMessage, error) {
-	// Signature will be checked in signed encryption.
-	s.synchronizer.Sync(0)
-	if s.pathRound {
-		return nil, s.handlePathMessage(&m.Metadata, m.Data)
-	} else {
-		return nil, s.handleLightningMessage(&m.Metadata, m.Data)
-	}
+	return s.submissions.Submit(m.Round, m.Data, func() (*messages.SignedMessage, error) {
+		// Signature will be checked in signed encryption.
+		setLabels(m.Round, 0, "submit")
+		s.synchronizer.Sync(0)
+		// the handlers decrypt in place, so sign for the envelope as it was sent
+		receipt := common.NewSubmissionReceipt(s.CommonState, m)
+		var err error
+		if s.pathRound {
+			err = s.handlePathMessage(&m.Metadata, m.Data)
+		} else {
+			err = s.handleLightningMessage(&m.Metadata, m.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return receipt, nil
+	})
 }
 
 /*
@@ -156,17 +196,31 @@ func (s *Server) checkMessage(m *messages.Metadata) error {
 // parse broadcast round envelopes, decrypt, mark keys used, and pack in buffers for next layer
 func (s *Server) handleLightningMessage(m *messages.Metadata, message []byte) error {
 	layer := s.CommonState.Layer
+	var input processMessages.EnvelopeHash
+	transcript := s.transcript(layer)
+	if transcript != nil {
+		// the parser decrypts in place
+		input = transcript.Receive(m.Sender, message)
+	}
 	decryption, key, err := s.onionParsers[layer].AuthenticatedOnionParse(m, message)
 	if err != nil {
 		return err
 	}
 	if layer != s.lastLayer {
-		return s.lightingRouters[layer].AuthenticatedOnionPack(decryption, key, false)
+		return s.lightingRouters[layer].LinkedOnionPack(input, decryption, key)
 	} else {
-		return s.finalRouter.Pack(decryption, key)
+		return s.finalRouter.LinkedPack(input, decryption, key)
 	}
 }
 
+// records the envelopes of a lightning layer in verifiable rounds, nil otherwise
+func (s *Server) transcript(layer int) *processMessages.ShuffleTranscript {
+	if layer == s.lastLayer {
+		return s.finalRouter.Transcript
+	}
+	return s.lightingRouters[layer].Transcript
+}
+
 // Parse path establishment message, check tokens, record keys, and pack boomerang messages
 func (s *Server) handlePathMessage(m *messages.Metadata, message []byte) error {
 	layer := s.CommonState.Layer
@@ -219,11 +273,20 @@ func (s *Server) recordReceipts(decryption []byte, key *processMessages.Bootstra
 // Called after "synchronizer.Done" is called by the rpc from each server
 // This code handles the sending of envelopes for the next round
 func (s *Server) OnThreshold(layer int) (int, int) {
-	config.LogTime("Finished layer %d", layer)
+	s.log.Debug("finished layer", "round", s.CommonState.Round, "layer", layer)
 	s.mu.Lock()
+	roundType := "lightning"
+	if s.pathRound {
+		roundType = "path"
+	}
+	metrics.LayerTime.With(roundType, strconv.Itoa(layer)).ObserveDuration(s.progress.next(layer + s.direction))
+	s.profiler.layer(s.CommonState.Round, layer+s.direction, s.direction)
+	s.layerSpan.End()
 	if layer != s.pathLayer {
-		if !s.onionParsers[layer].AllKeysAccountedFor() {
-			panic(errors.MissingMessages())
+		if missing := s.onionParsers[layer].Missing(); missing > 0 {
+			// blame would need the messages of every server, for now the round continues without them
+			s.failures.missing(missing)
+			s.log.Warn("messages missing at end of layer", "round", s.CommonState.Round, "layer", layer, "missing", missing)
 		}
 	}
 	// setup next layer
@@ -239,56 +302,30 @@ func (s *Server) OnThreshold(layer int) (int, int) {
 		s.onionParsers[nextLayer] = processMessages.NewOnionParser(s.CommonState, s.Keys[nextLayer], true)
 		s.lightingRouters[nextLayer] = processMessages.NewLightningRouter(s.CommonState, nextLayer, true)
 	}
+	var transcript *processMessages.ShuffleTranscript
+	if !s.pathRound {
+		transcript = s.transcript(layer)
+	}
+	send := tracing.StartSpan(s.roundTrace, "send", "server", s.CommonState.MyId, "round", s.CommonState.Round, "layer", nextLayer)
+	s.CommonState.Trace = send.Context()
+	s.layerSpan = nil
+	if layer != s.receiptLayer && (layer != s.lastLayer || s.pathRound) {
+		s.layerSpan = tracing.StartSpan(s.roundTrace, "layer", "server", s.CommonState.MyId, "round", s.CommonState.Round, "layer", nextLayer)
+	}
 	// start sending messages to next layer
 	go func(lBufs map[int]*buffers.MemReadWriter) {
-		var err error = nil
-		if layer == s.receiptLayer {
-			// Mark round completed
-			// Release receipts
-			// Wait for all clients to confirm receipt delivery and then continue
-			s.isRoundComplete = true
-			s.roundComplete.Broadcast()
-		} else {
-			if layer != s.lastLayer {
-				// send regular onion messages
-				t := messages.NetworkMessage_ServerMessageForward
-				if s.pathRound {
-					// boomerang messages
-					t = messages.NetworkMessage_ServerMessageReverse
-				}
-				err = s.TcpConnections.SendShuffleMessages(lBufs, s.CommonState, nextLayer, t)
-			} else {
-				if !s.pathRound {
-					// send to trustees
-					_, err := s.TcpConnections.SendGroupShuffleMessages(s.finalRouter.OutgoingBuffers, s.CommonState, messages.NetworkMessage_GroupCheckpointSignature, 0)
-					if err != nil {
-						panic(err)
-					}
-					s.isRoundComplete = true
-					s.roundComplete.Broadcast()
-				} else {
-					// route through anytrust group
-					checkpoint := s.pathEstablishmentRouters[layer].Checkpoint
-					// this waits for all groups to respond
-					err = checkpoint.SendAndRecieve(s.TcpConnections)
-					if err != nil {
-						panic(err)
-					}
-					decryptions, keys := checkpoint.GetDecrypted()
-					// unless there's only one layer, this is never the receipt layer as well
-					for idx := range decryptions {
-						err = s.lightingRouters[layer].AuthenticatedOnionPack(decryptions[idx], keys[idx], true)
-						if err != nil {
-							panic(err)
-						}
-					}
-					// send back boomerang messages
-					err = s.TcpConnections.SendShuffleMessages(s.lightingRouters[layer].OutgoingBuffers, s.CommonState, s.CommonState.Layer, messages.NetworkMessage_ServerMessageReverse)
-				}
+		setLabels(s.CommonState.Round, nextLayer, "send")
+		if transcript != nil {
+			// a missing commitment is reported by the servers checking them
+			err := s.publishShuffle(transcript)
+			if err != nil {
+				s.log.Warn("could not send shuffle commitment", "round", s.CommonState.Round, "layer", layer, "error", err)
 			}
 		}
+		err := s.sendLayer(layer, nextLayer, lBufs, send)
+		send.End()
 		if err != nil {
-			panic(err)
+			s.abort(err)
 		}
 		// free memory - actually needs to be stored until end of round for blame protocols (e.g on disk?)
 		s.onionParsers[layer] = nil
@@ -297,7 +334,97 @@ func (s *Server) OnThreshold(layer int) (int, int) {
 		// (go lets another thread unlock the mutex)
 		s.mu.Unlock()
 	}(s.lightingRouters[layer].OutgoingBuffers)
-	return s.CommonState.NumServers, nextLayer
+	return s.activeServers(), nextLayer
+}
+
+// send the envelopes of a finished layer, called with s.mu held
+func (s *Server) sendLayer(layer, nextLayer int, lBufs map[int]*buffers.MemReadWriter, send *tracing.Span) error {
+	if layer == s.receiptLayer {
+		// Mark round completed
+		// Release receipts
+		// Wait for all clients to confirm receipt delivery and then continue
+		s.completeRound()
+		return nil
+	}
+	if layer != s.lastLayer {
+		// send regular onion messages
+		t := messages.NetworkMessage_ServerMessageForward
+		if s.pathRound {
+			// boomerang messages
+			t = messages.NetworkMessage_ServerMessageReverse
+		}
+		return s.TcpConnections.SendShuffleMessages(lBufs, s.CommonState, nextLayer, t)
+	}
+	if !s.pathRound {
+		// send to trustees
+		_, err := s.TcpConnections.SendGroupShuffleMessages(s.finalRouter.OutgoingBuffers, s.CommonState, messages.NetworkMessage_GroupCheckpointSignature, 0)
+		if err != nil {
+			return err
+		}
+		s.completeRound()
+		return nil
+	}
+	// route through anytrust group
+	checkpoint := s.pathEstablishmentRouters[layer].Checkpoint
+	// this waits for all groups to respond
+	exchange := tracing.StartSpan(send.Context(), "checkpoint", "server", s.CommonState.MyId, "round", s.CommonState.Round, "layer", layer)
+	s.CommonState.Trace = exchange.Context()
+	err := checkpoint.SendAndRecieve(s.TcpConnections)
+	exchange.End()
+	s.CommonState.Trace = send.Context()
+	if err != nil {
+		return err
+	}
+	decryptions, keys := checkpoint.GetDecrypted()
+	// unless there's only one layer, this is never the receipt layer as well
+	for idx := range decryptions {
+		err = s.lightingRouters[layer].AuthenticatedOnionPack(decryptions[idx], keys[idx], true)
+		if err != nil {
+			// only this envelope is lost
+			s.envelopeError(err)
+		}
+	}
+	// send back boomerang messages
+	return s.TcpConnections.SendShuffleMessages(s.lightingRouters[layer].OutgoingBuffers, s.CommonState, s.CommonState.Layer, messages.NetworkMessage_ServerMessageReverse)
+}
+
+// an invalid envelope is dropped, the round goes on without it
+func (s *Server) envelopeError(err error) {
+	s.failures.envelope(err)
+	errors.Log(s.log, logging.Warn, "dropped envelope", err, "round", s.CommonState.Round)
+}
+
+// stop processing anything more from a server whose link failed
+func (s *Server) churn(peer int, err error) {
+	if !s.failures.churn(peer, err) {
+		return
+	}
+	errors.Log(s.log, logging.Warn, "dropping server", err, "peer", peer)
+	if synchronizer := s.progress.sync(); synchronizer != nil {
+		synchronizer.Churn(peer)
+	}
+}
+
+// servers expected to send each layer
+func (s *Server) activeServers() int {
+	return s.CommonState.NumServers - s.failures.numChurned()
+}
+
+// give up on the round and wake up the coordinator's RoundStart and GetMessages calls
+// called with s.mu held
+func (s *Server) abort(err error) {
+	if !s.failures.abort(err) {
+		return
+	}
+	errors.Log(s.log, logging.Error, "aborting round", err, "round", s.CommonState.Round, "layer", s.CommonState.Layer)
+	s.layerSpan.End()
+	s.layerSpan = nil
+	s.completeRound()
+}
+
+// what went wrong in the current round
+func (s *Server) Failures() FailureReport {
+	return s.failures.Report()
 }
 
 func (s *Server) SetupNewPathEstablishmentRound(numLayers, receipt_size, boomerangLimit int, last bool) {
@@ -307,9 +434,10 @@ func (s *Server) SetupNewPathEstablishmentRound(numLayers, receipt_size, boomera
 	s.lastLayer = numLayers - 1
 	s.pathRound = true
 	s.direction = -1
-	s.CommonState.PathMessageLengths = prepareMessages.PathEstablishmentLengths(numLayers, receipt_size, boomerangLimit)
-	s.CommonState.BoomerangMessageLengths = prepareMessages.BoomerangLengths(numLayers, receipt_size, boomerangLimit)
-	s.CommonState.OnionMessageLengths = prepareMessages.WireBoomerangLengths(numLayers, receipt_size, boomerangLimit)
+	suite := s.CommonState.Suite
+	s.CommonState.PathMessageLengths = prepareMessages.PathEstablishmentLengths(numLayers, receipt_size, boomerangLimit, suite, s.CommonState.KeyAgreement)
+	s.CommonState.BoomerangMessageLengths = prepareMessages.BoomerangLengths(numLayers, receipt_size, boomerangLimit, suite)
+	s.CommonState.OnionMessageLengths = prepareMessages.WireBoomerangLengths(numLayers, receipt_size, boomerangLimit, suite)
 	s.pathEstablishmentRouters = make([]*processMessages.PathEstablishmentParser, numLayers)
 	// initalize first path establishment round
 	s.pathEstablishmentRouters[0] = processMessages.NewPathEstablishmentParser(s.CommonState, s.Keys[0], 0, nil)
@@ -322,7 +450,7 @@ func (s *Server) SetupNewLightningRound(numLayers, payloadSize int) {
 	s.pathLayer = -1
 	s.pathRound = false
 	s.direction = 1
-	s.CommonState.OnionMessageLengths = prepareMessages.LightningMessageLengths(numLayers, payloadSize)
+	s.CommonState.OnionMessageLengths = prepareMessages.LightningMessageLengths(numLayers, payloadSize, s.CommonState.Suite)
 	s.onionParsers = make([]*processMessages.OnionParser, numLayers)
 	s.lightingRouters = make([]*processMessages.LightningRouter, numLayers)
 	// initialize first lightning layer
@@ -371,7 +499,18 @@ func (s *Server) SetupNewLightningRound(numLayers, payloadSize int) {
 // 	return m, nil
 // }
 
-func (s *Server) RoundSetup(_ context.Context, m *coord.RoundInfo) (*coord.Empty, error) {
+func (s *Server) RoundSetup(ctx context.Context, m *coord.RoundInfo) (*coord.Empty, error) {
+	span := tracing.StartSpan(tracing.Extract(ctx), "RoundSetup", "server", s.CommonState.MyId, "round", int(m.Round))
+	defer span.End()
+	suite := crypto.CipherSuite(m.CipherSuite)
+	agreement := crypto.KeyAgreement(m.KeyAgreement)
+	if !suite.Supported() || !s.CommonState.SupportsKeyAgreement(agreement) {
+		return nil, errors.UnrecognizedError().InRound(int(m.Round))
+	}
+	// opening a link reveals the key of one message, which is the path key in the legacy suite
+	if m.Verifiable && suite == crypto.SuiteLegacy {
+		return nil, errors.UnrecognizedError().InRound(int(m.Round))
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.Caller == nil {
@@ -380,19 +519,42 @@ func (s *Server) RoundSetup(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 			return nil, err
 		}
 	}
-	if m.Round == 0 && m.Interval > 0 {
-		errors.MonitorMemory("server", s.CommonState.MyId, m.Interval)
+	err := s.advance(int(m.Epoch))
+	if err != nil {
+		return nil, err
 	}
+	// the coordinator cannot run rounds the signed directory does not allow
+	err = s.CommonState.Parameters.CheckRound(config.Parameters{
+		NumLayers:    int(m.NumLayers),
+		BinSize:      int(m.BinSize),
+		MessageSize:  int(m.MessageSize),
+		CipherSuite:  int(m.CipherSuite),
+		KeyAgreement: int(m.KeyAgreement),
+		Verifiable:   m.Verifiable,
+	}, m.PathEstablishment)
+	if err != nil {
+		return nil, fmt.Errorf("server %d refuses round %d: %v", s.CommonState.MyId, m.Round, err)
+	}
+	// the previous round's beacon is not revealed again
+	s.beacon.Forget(beacon.RoundLabel(s.CommonState.Round))
 	s.CommonState.Round = int(m.Round)
+	s.CommonState.Suite = suite
+	s.CommonState.KeyAgreement = agreement
+	s.CommonState.Verifiable = m.Verifiable && !m.PathEstablishment
+	s.CommonState.PostingQuota = int(m.PostingQuota)
 	s.CommonState.BinSize = int(m.BinSize)
 	// TODO: chernoff on M messages / n * numGroups (rather than n * n * L for regular bin size)
 	s.CommonState.GroupBinSize = int(m.BinSize) * s.CommonState.NumServers
 	s.CommonState.NumLayers = int(m.NumLayers)
 	s.CommonState.Layer = 0
 	s.isRoundComplete = false
-	s.synchronizer = synchronization.NewSynchronizer(s.CommonState.Round, 0, s.CommonState.NumServers, s)
+	s.failures.reset(s.CommonState.Round)
+	s.synchronizer = synchronization.NewSynchronizer(s.CommonState.Round, 0, s.activeServers(), s)
+	s.synchronizer.SetIdSpace(s.CommonState.IdSpace())
 	numLayers := int(m.NumLayers)
-	if m.Round == 0 {
+	s.shuffles.reset(s.CommonState.Round, numLayers, s.CommonState.IdSpace())
+	// and again once servers left or joined
+	if m.Round == 0 || len(s.Keys) == 0 {
 		s.Keys = make([]*processMessages.KeyLookupTable, numLayers)
 		for i := range s.Keys {
 			s.Keys[i] = processMessages.NewKeyLookupTable(s.CommonState)
@@ -403,27 +565,35 @@ func (s *Server) RoundSetup(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 	if m.PathEstablishment {
 		s.SetupNewPathEstablishmentRound(int(m.NumLayers), int(m.MessageSize), int(m.BoomerangLimit), m.LastLayer)
 	} else {
-		s.SetupNewLightningRound(int(m.NumLayers), int(m.MessageSize))
+		messageSize := int(m.MessageSize)
+		if s.CommonState.PostingQuota > 0 {
+			// each post carries its ticket
+			messageSize += common.TICKET_SIZE
+		}
+		s.SetupNewLightningRound(int(m.NumLayers), messageSize)
 	}
+	s.progress.setup(s.CommonState.Round, s.pathRound, s.synchronizer, s.Keys)
 	// this will allow processing of messages for this round
 	s.handler.SetRound(s.CommonState.Round)
-	// log.Printf("%d: round setup", s.CommonState.MyId)
 	return &coord.Empty{}, nil
 }
 
 // I think this function could wait for all of the messages to be sent and for the round to complete
 // Then check could just skip getmessages and it would be much simpler
-func (s *Server) RoundStart(_ context.Context, m *coord.RoundInfo) (*coord.Empty, error) {
+func (s *Server) RoundStart(ctx context.Context, m *coord.RoundInfo) (*coord.Empty, error) {
 	// path establishment -> forward one layer -> send to group -> boomerang back send receipts
 	// coordinator asks clients to check receipts and then calls this again
 	// broadcast round -> forward messages through all layers -> send to trustees
-	if m.Interval == -1 {
-		f, err := os.Create(fmt.Sprintf("%dRound%d.pprof", s.CommonState.MyId, m.Round))
-		if err == nil {
-			pprof.StartCPUProfile(f)
-			defer pprof.StopCPUProfile()
-		}
+	s.mu.Lock()
+	s.roundTrace = tracing.Extract(ctx)
+	firstLayer := 0
+	if s.pathRound {
+		firstLayer = int(m.NextLayer)
 	}
+	s.progress.start(int(m.Round), firstLayer)
+	s.profiler.layer(int(m.Round), firstLayer, s.direction)
+	s.layerSpan = tracing.StartSpan(s.roundTrace, "layer", "server", s.CommonState.MyId, "round", int(m.Round), "layer", firstLayer)
+	s.mu.Unlock()
 	if !s.started {
 		s.started = true
 		for sid := range s.CommonState.Configs {
@@ -437,6 +607,9 @@ func (s *Server) RoundStart(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 		s.pathLayer = int(m.NextLayer)
 		s.CommonState.Layer = s.pathLayer
 		s.isRoundComplete = false
+		if s.failures.round() != s.CommonState.Round {
+			s.failures.reset(s.CommonState.Round)
+		}
 		startingLayer := s.pathLayer - 1
 		s.receiptLayer = int(m.ReceiptLayer)
 		s.receipts = make(map[int64][]byte)
@@ -453,12 +626,17 @@ func (s *Server) RoundStart(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 			}
 		}
 		s.lightingRouters[s.pathLayer] = processMessages.NewLightningRouter(s.CommonState, s.pathLayer, true)
-		s.synchronizer.Reset(int(m.Round), s.pathLayer, s.CommonState.NumServers)
+		s.synchronizer.Reset(int(m.Round), s.pathLayer, s.activeServers())
 		// this will allow processing of messages for this round
 		s.handler.SetRound(s.CommonState.Round)
+		send := tracing.StartSpan(s.roundTrace, "send", "server", s.CommonState.MyId, "round", s.CommonState.Round, "layer", s.CommonState.Layer)
+		s.CommonState.Trace = send.Context()
 		err := s.TcpConnections.SendShuffleMessages(s.pathEstablishmentRouters[startingLayer].OutgoingBuffers, s.CommonState, s.CommonState.Layer, messages.NetworkMessage_PathMessageForward)
+		send.End()
 		if err != nil {
-			return nil, err
+			s.abort(err)
+			s.mu.Unlock()
+			return nil, s.failures.err()
 		}
 		// free memory
 		s.pathEstablishmentRouters[startingLayer] = nil
@@ -471,6 +649,9 @@ func (s *Server) RoundStart(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 	for !s.isRoundComplete {
 		s.roundComplete.Wait()
 	}
+	if err := s.failures.err(); err != nil {
+		return nil, err
+	}
 	if !s.pathRound {
 		for _, g := range s.GroupAliases {
 			g.GetMessages()
@@ -479,7 +660,9 @@ func (s *Server) RoundStart(_ context.Context, m *coord.RoundInfo) (*coord.Empty
 	return &coord.Empty{}, nil
 }
 
-func (s *Server) GetMessages(_ context.Context, m *coord.RoundInfo) (*coord.ServerMessages, error) {
+func (s *Server) GetMessages(ctx context.Context, m *coord.RoundInfo) (*coord.ServerMessages, error) {
+	span := tracing.StartSpan(tracing.Extract(ctx), "GetMessages", "server", s.CommonState.MyId, "round", int(m.Round))
+	defer span.End()
 	if s.pathRound {
 		s.handler.WaitForRound(int(m.Round))
 	}
@@ -488,6 +671,18 @@ func (s *Server) GetMessages(_ context.Context, m *coord.RoundInfo) (*coord.Serv
 	for !s.isRoundComplete {
 		s.roundComplete.Wait()
 	}
+	if err := s.failures.err(); err != nil {
+		return nil, err
+	}
+	if s.CommonState.Verifiable {
+		err := s.verifyShuffles()
+		if err == nil {
+			err = s.auditShuffles()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	resp := &coord.ServerMessages{
 		Messages: make([][]byte, 0),
 	}
@@ -538,12 +733,21 @@ func (s *Server) GetReceipt(m *messages.SignedMessage) (*messages.SignedMessage,
 func (s *Server) KeySet(_ context.Context, info *coord.KeyInformation) (*coord.KeyInformation, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// shares of a group are for its members in one epoch
+	err := s.advance(int(info.Epoch))
+	if err != nil {
+		return nil, err
+	}
+	if int(info.Epoch) < s.CommonState.Epoch {
+		// the shares of a group that has since changed
+		return nil, errors.SynchronizationError()
+	}
 	// set keys for each group based on the info
 
 	if s.CommonState.CombinedKey == nil {
 		tokenPublicKey := &token.TokenPublicKey{}
 		groupPublicKey := crypto.DHPublicKey{}
-		err := tokenPublicKey.InterpretFrom(info.TokenPublicKey)
+		err = tokenPublicKey.InterpretFrom(info.TokenPublicKey)
 		if err != nil {
 			return nil, err
 		}
@@ -555,16 +759,25 @@ func (s *Server) KeySet(_ context.Context, info *coord.KeyInformation) (*coord.K
 		s.CommonState.CombinedKey = tokenPublicKey
 		s.CommonState.GroupPublicKey = groupPublicKey
 	}
+	if len(info.TicketPublicKey) > 0 {
+		// unlike the token key, the ticket key changes every epoch
+		ticketPublicKey := &token.TokenPublicKey{}
+		err = ticketPublicKey.InterpretFrom(info.TicketPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		s.CommonState.TicketKey = ticketPublicKey
+	}
 
 	g := s.GroupAliases[int32(info.GroupId)]
 
 	if g == nil {
 		return &coord.KeyInformation{}, nil
 	}
-	tokenShare := mcl.Fr{}
+	tokenShare := curve.Fr{}
 	groupShare := &crypto.DHPrivateKey{}
 
-	err := tokenShare.InterpretFrom(info.TokenKeyShare)
+	err = tokenShare.InterpretFrom(info.TokenKeyShare)
 	if err != nil {
 		return nil, err
 	}
@@ -575,6 +788,14 @@ func (s *Server) KeySet(_ context.Context, info *coord.KeyInformation) (*coord.K
 	}
 
 	g.SetKeys(tokenSigningKey, groupShare)
+	if len(info.TicketKeyShare) > 0 {
+		ticketShare := curve.Fr{}
+		err = ticketShare.InterpretFrom(info.TicketKeyShare)
+		if err != nil {
+			return nil, err
+		}
+		g.SetTicketKey(token.NewTokenSigningKey(&ticketShare))
+	}
 	return &coord.KeyInformation{}, nil
 }
 
@@ -0,0 +1,333 @@
+package server
+
+// Commitments to the layers of a verifiable round, see processMessages/shuffleProof.go
+// Each server sends its commitment to every server before sending the layer,
+// so all of them have arrived by the time the coordinator asks for the messages of the round.
+// Once the round is complete anyone can audit a layer, which opens the links the challenge selects,
+// see processMessages/partialChecking.go. Each server audits the next server.
+// The challenges come from the round's beacon, which servers only reveal once the round is complete, see beacon/beacon.go.
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/crypto"
+	"github.com/simonlangowski/lightning1/errors"
+	"github.com/simonlangowski/lightning1/network/messages"
+	"github.com/simonlangowski/lightning1/server/beacon"
+	"github.com/simonlangowski/lightning1/server/common"
+	"github.com/simonlangowski/lightning1/server/processMessages"
+)
+
+type shuffleCommitments struct {
+	mu    sync.Mutex
+	round int
+	// by layer and server
+	layers [][]*processMessages.ShuffleCommitment
+	// this server's layers, kept until the next round to be audited
+	transcripts []*processMessages.ShuffleTranscript
+	audits      map[int]*messages.SignedMessage
+	// no commitments are accepted once the beacon is revealed, so the challenges cannot change
+	sealed bool
+	// the round's beacon, run by the first audit
+	beacon *beacon.Transcript
+	output beacon.Output
+}
+
+func (t *shuffleCommitments) reset(round, numLayers, numServers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.round = round
+	t.layers = make([][]*processMessages.ShuffleCommitment, numLayers)
+	for i := range t.layers {
+		t.layers[i] = make([]*processMessages.ShuffleCommitment, numServers)
+	}
+	t.transcripts = make([]*processMessages.ShuffleTranscript, numLayers)
+	t.audits = make(map[int]*messages.SignedMessage)
+	t.sealed = false
+	t.beacon = nil
+}
+
+func (t *shuffleCommitments) add(c *processMessages.ShuffleCommitment) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.Round != t.round || c.Layer < 0 || c.Layer >= len(t.layers) || c.Server < 0 || c.Server >= len(t.layers[c.Layer]) {
+		return errors.BadMetadataError().At(c.Round, c.Layer).From(c.Server)
+	}
+	if t.layers[c.Layer][c.Server] != nil {
+		return errors.Duplicate().At(c.Round, c.Layer).From(c.Server)
+	}
+	if t.sealed {
+		return errors.RoundAborted(fmt.Errorf("commitment after the beacon was revealed")).At(c.Round, c.Layer).From(c.Server)
+	}
+	t.layers[c.Layer][c.Server] = c
+	return nil
+}
+
+// this server's commitment, and the transcript to open it
+func (t *shuffleCommitments) own(c *processMessages.ShuffleCommitment, transcript *processMessages.ShuffleTranscript) error {
+	err := t.add(c)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transcripts[c.Layer] = transcript
+	return nil
+}
+
+// stop accepting commitments for the round before revealing its beacon
+func (t *shuffleCommitments) seal(round int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if round != t.round {
+		return errors.BadMetadataError().InRound(round)
+	}
+	t.sealed = true
+	return nil
+}
+
+func (t *shuffleCommitments) getBeacon(round int) *beacon.Transcript {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if round != t.round {
+		return nil
+	}
+	return t.beacon
+}
+
+func (t *shuffleCommitments) setBeacon(round int, transcript *beacon.Transcript, output beacon.Output) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if round == t.round && t.beacon == nil {
+		t.beacon = transcript
+		t.output = output
+	}
+}
+
+// open the links of one of this server's layers in the round, signed by c with the suite of the round
+// every audit of a layer gets the same answer
+func (t *shuffleCommitments) audit(round, layer int, suite crypto.CipherSuite, c *common.CommonState) (*messages.SignedMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if round != t.round || t.beacon == nil || layer < 0 || layer >= len(t.transcripts) || t.transcripts[layer] == nil {
+		return nil, errors.BadMetadataError().At(round, layer)
+	}
+	if m, ok := t.audits[layer]; ok {
+		return m, nil
+	}
+	challenge := processMessages.LinkChallenge(t.round, layer, t.output, t.layers)
+	a := t.transcripts[layer].Open(challenge, len(t.layers), suite)
+	a.Beacon = t.beacon
+	m := messages.NewSignedMessage(a.Len(), t.round, layer, c.MyId, 0, 0, 1, messages.NetworkMessage_ShuffleAudit)
+	a.PackTo(m.Data)
+	c.Sign(m)
+	t.audits[layer] = m
+	return m, nil
+}
+
+func (t *shuffleCommitments) get() (int, [][]*processMessages.ShuffleCommitment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	layers := make([][]*processMessages.ShuffleCommitment, len(t.layers))
+	for i := range t.layers {
+		layers[i] = append([]*processMessages.ShuffleCommitment{}, t.layers[i]...)
+	}
+	return t.round, layers
+}
+
+// sign the commitment to a layer and send it to every server still in the round
+func (s *Server) publishShuffle(transcript *processMessages.ShuffleTranscript) error {
+	c := transcript.Commit()
+	m := messages.NewSignedMessage(c.Len(), c.Round, c.Layer, s.CommonState.MyId, 0, 0, 1, messages.NetworkMessage_ShuffleCommitment)
+	c.PackTo(m.Data)
+	s.CommonState.Sign(m)
+	err := s.shuffles.own(c, transcript)
+	if err != nil {
+		return err
+	}
+	churned := s.failures.Report().Churned
+	done := make(chan error)
+	peers := 0
+	for _, sid := range s.CommonState.ServerIds {
+		if _, dropped := churned[sid]; dropped || sid == s.CommonState.MyId {
+			continue
+		}
+		peers++
+		go func(sid int) {
+			_, err := s.Caller.SendSignedMessage(sid, m)
+			done <- err
+		}(sid)
+	}
+	for i := 0; i < peers; i++ {
+		if e := <-done; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// a commitment from another server
+func (s *Server) HandleShuffleCommitment(m *messages.SignedMessage) error {
+	if !s.CommonState.Verify(m) {
+		return errors.SignatureError().At(m.Round, m.Layer).From(m.Sender)
+	}
+	c := &processMessages.ShuffleCommitment{}
+	err := c.InterpretFrom(m.Data)
+	if err != nil {
+		return err
+	}
+	if c.Round != m.Round || c.Layer != m.Layer || c.Server != m.Sender {
+		return errors.BadMetadataError().At(m.Round, m.Layer).From(m.Sender)
+	}
+	return s.shuffles.add(c)
+}
+
+// check the commitments of every layer once the round is over
+// servers dropped from the round may not have sent theirs
+func (s *Server) verifyShuffles() error {
+	round, layers := s.shuffles.get()
+	churned := s.failures.Report().Churned
+	failures := processMessages.VerifyShuffles(round, layers, func(layer, server int) bool {
+		_, dropped := churned[server]
+		return s.CommonState.HasServer(server) && !dropped
+	})
+	for _, err := range failures {
+		s.log.Warn("shuffle commitments disagree", "round", round, "error", err)
+	}
+	if len(failures) > 0 {
+		return errors.RoundAborted(fmt.Errorf("%d shuffle commitments disagree, first: %v", len(failures), failures[0])).InRound(round)
+	}
+	return nil
+}
+
+// an audit from anyone, answered once the round is complete
+func (s *Server) HandleShuffleAudit(m *messages.SignedMessage) (*messages.SignedMessage, error) {
+	s.mu.RLock()
+	for !s.isRoundComplete {
+		s.roundComplete.Wait()
+	}
+	round, suite, verifiable := s.CommonState.Round, s.CommonState.Suite, s.CommonState.Verifiable
+	s.mu.RUnlock()
+	if m.Round != round || !verifiable {
+		return nil, errors.BadMetadataError().At(m.Round, m.Layer).From(m.Sender)
+	}
+	// the beacon asks this server too, so it runs without the lock
+	err := s.roundBeacon(round)
+	if err != nil {
+		return nil, err
+	}
+	return s.shuffles.audit(round, m.Layer, suite, s.CommonState)
+}
+
+// run the beacon for the link challenges of the round, once
+func (s *Server) roundBeacon(round int) error {
+	if s.shuffles.getBeacon(round) != nil {
+		return nil
+	}
+	transcript, output, err := beacon.Run(beacon.RoundLabel(round), s.CommonState.MyId, s.CommonState.VerificationKeys, s.Caller.SendSignedMessage)
+	if err != nil {
+		return errors.RoundAborted(fmt.Errorf("beacon: %v", err)).InRound(round)
+	}
+	s.shuffles.setBeacon(round, transcript, output)
+	return nil
+}
+
+// a request for this server's commitment to a beacon value, from anyone running the beacon
+// a round's value is only picked while it is the current verifiable round
+func (s *Server) HandleBeaconCommitment(m *messages.SignedMessage) (*messages.SignedMessage, error) {
+	label, err := beacon.ReadLabel(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	if label.Purpose == beacon.PurposeRound {
+		s.mu.RLock()
+		current := label.Number == s.CommonState.Round && s.CommonState.Verifiable
+		s.mu.RUnlock()
+		if !current {
+			return nil, errors.BadMetadataError().InRound(label.Number).From(m.Sender)
+		}
+	} else if label.Purpose != beacon.PurposeEpoch {
+		return nil, errors.UnrecognizedError()
+	}
+	return s.beacon.Commit(label)
+}
+
+// a request to reveal this server's beacon value, with every server's commitment
+// a round's value is only revealed once the round is complete and no more shuffle commitments are accepted
+func (s *Server) HandleBeaconReveal(m *messages.SignedMessage) (*messages.SignedMessage, error) {
+	label, commitments, err := beacon.ReadRevealRequest(m)
+	if err != nil {
+		return nil, err
+	}
+	if label.Purpose == beacon.PurposeRound {
+		s.mu.RLock()
+		for !s.isRoundComplete {
+			s.roundComplete.Wait()
+		}
+		current := label.Number == s.CommonState.Round && s.CommonState.Verifiable
+		s.mu.RUnlock()
+		if !current {
+			return nil, errors.BadMetadataError().InRound(label.Number).From(m.Sender)
+		}
+		err = s.shuffles.seal(label.Number)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.beacon.Reveal(label, commitments, s.CommonState.VerificationKeys)
+}
+
+// audit every opened layer of the next server still in the round
+func (s *Server) auditShuffles() error {
+	round, layers := s.shuffles.get()
+	peer := s.CommonState.NextServer(s.CommonState.MyId)
+	if _, dropped := s.failures.Report().Churned[peer]; dropped || peer == s.CommonState.MyId {
+		return nil
+	}
+	failures := make([]error, 0)
+	for layer := range layers {
+		if !processMessages.AuditedLayer(layer, len(layers)) || layers[layer][peer] == nil {
+			continue
+		}
+		err := s.auditShuffle(round, layer, peer, layers)
+		if err != nil {
+			s.log.Warn("shuffle audit failed", "round", round, "layer", layer, "server", peer, "error", err)
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) > 0 {
+		return errors.RoundAborted(fmt.Errorf("%d shuffle audits failed, first: %v", len(failures), failures[0])).InRound(round)
+	}
+	return nil
+}
+
+func (s *Server) auditShuffle(round, layer, peer int, layers [][]*processMessages.ShuffleCommitment) error {
+	req := messages.NewSignedMessage(0, round, layer, s.CommonState.MyId, 0, 0, 1, messages.NetworkMessage_ShuffleAudit)
+	s.CommonState.Sign(req)
+	resp, err := s.Caller.SendSignedMessage(peer, req)
+	if err != nil {
+		return err
+	}
+	a, err := processMessages.ReadAudit(resp, s.CommonState.VerificationKeys[peer])
+	if err != nil {
+		return err
+	}
+	if a.Commitment.Round != round || a.Commitment.Layer != layer || a.Commitment.Server != peer ||
+		a.NumLayers != len(layers) || a.Suite != s.CommonState.Suite || !sameCommitment(a.Commitment, layers[layer][peer]) {
+		return errors.CommitFailure().At(round, layer).From(peer)
+	}
+	output, err := processMessages.AuditBeacon(a, round, s.CommonState.VerificationKeys)
+	if err != nil {
+		return err
+	}
+	return processMessages.VerifyAudit(a, processMessages.LinkChallenge(round, layer, output, layers))
+}
+
+func sameCommitment(a, b *processMessages.ShuffleCommitment) bool {
+	ab, bb := make([]byte, a.Len()), make([]byte, b.Len())
+	a.PackTo(ab)
+	b.PackTo(bb)
+	return bytes.Equal(ab, bb)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simonlangowski/lightning1/admin"
+	"github.com/simonlangowski/lightning1/config"
+	"github.com/simonlangowski/lightning1/network"
+	"github.com/simonlangowski/lightning1/network/synchronization"
+	"github.com/simonlangowski/lightning1/server/processMessages"
+)
+
+// what the server is working on
+// s.mu is held while a layer is sent, so this is kept separately for status queries
+type progress struct {
+	mu           sync.Mutex
+	round        int
+	layer        int
+	pathRound    bool
+	complete     bool
+	layerStart   time.Time
+	synchronizer *synchronization.Synchronizer
+	keys         []*processMessages.KeyLookupTable
+}
+
+func (p *progress) setup(round int, pathRound bool, synchronizer *synchronization.Synchronizer, keys []*processMessages.KeyLookupTable) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.round, p.layer, p.pathRound, p.complete = round, 0, pathRound, false
+	p.synchronizer, p.keys = synchronizer, keys
+	// layers are timed from RoundStart, clients may take a while to submit before then
+	p.layerStart = time.Time{}
+}
+
+func (p *progress) start(round, layer int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.round, p.layer, p.complete = round, layer, false
+	p.layerStart = time.Now()
+}
+
+// returns how long the previous layer took
+func (p *progress) next(layer int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	took := now.Sub(p.layerStart)
+	p.layer, p.layerStart = layer, now
+	return took
+}
+
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.complete = true
+}
+
+func (p *progress) sync() *synchronization.Synchronizer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.synchronizer
+}
+
+// the layer being processed and for how long, ok is false if no round is running
+func (p *progress) current() (round, layer int, since time.Duration, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.complete || p.layerStart.IsZero() {
+		return p.round, p.layer, 0, false
+	}
+	return p.round, p.layer, time.Since(p.layerStart), true
+}
+
+// live unless stuck in a layer, ready once keys are set and links are up
+func (s *Server) Health() network.Health {
+	round, layer, since, running := s.progress.current()
+	if running && since > config.LayerTimeout*time.Second {
+		return network.Health{Reason: fmt.Sprintf("stuck in round %d layer %d for %v", round, layer, since.Round(time.Second))}
+	}
+	h := network.Health{Live: true}
+	view := s.view()
+	for gid, g := range view.groups {
+		if !g.hasKeys() {
+			h.Reason = fmt.Sprintf("no keys for group %d", gid)
+			return h
+		}
+	}
+	for sid := range view.servers {
+		in, out := s.TcpConnections.Links(int(sid))
+		if in != network.LinkUp || out != network.LinkUp {
+			h.Reason = fmt.Sprintf("link with server %d is %v in, %v out", sid, in, out)
+			return h
+		}
+	}
+	h.Ready = true
+	return h
+}
+
+// mark the round complete and wake up the coordinator's calls, called with s.mu held
+func (s *Server) completeRound() {
+	s.isRoundComplete = true
+	s.progress.finish()
+	s.profiler.roundDone()
+	s.roundComplete.Broadcast()
+}
+
+// answer the admin service without waiting for the layer being processed
+func (s *Server) Status(_ context.Context, _ *admin.StatusRequest) (*admin.Status, error) {
+	p := &s.progress
+	p.mu.Lock()
+	st := &admin.Status{
+		Server:        s.CommonState.MyId,
+		Round:         p.round,
+		Layer:         p.layer,
+		PathRound:     p.pathRound,
+		RoundComplete: p.complete,
+		Keys:          make([]int, len(p.keys)),
+	}
+	keys, synchronizer := p.keys, p.synchronizer
+	p.mu.Unlock()
+	view := s.view()
+	if cfg := view.servers[int64(s.CommonState.MyId)]; cfg != nil {
+		st.Address = cfg.Address
+	}
+	h := s.Health()
+	st.Live, st.Ready, st.HealthReason = h.Live, h.Ready, h.Reason
+	for i, k := range keys {
+		if k != nil {
+			st.Keys[i] = k.NumKeys()
+		}
+	}
+	if synchronizer != nil {
+		progress := synchronizer.Progress()
+		st.Synchronizer = admin.SyncStatus{
+			Round:     progress.Round,
+			Layer:     progress.Layer,
+			Processed: progress.Processed,
+			Threshold: progress.Threshold,
+			Started:   make([]int, 0),
+			Waiting:   make([]int, 0),
+		}
+		for sid, started := range progress.Started {
+			if _, ok := view.servers[int64(sid)]; !ok {
+				continue
+			}
+			if started {
+				st.Synchronizer.Started = append(st.Synchronizer.Started, sid)
+			} else {
+				st.Synchronizer.Waiting = append(st.Synchronizer.Waiting, sid)
+			}
+		}
+	}
+
+	report := s.Failures()
+	st.Failures = admin.FailureStatus{
+		State:          report.State.String(),
+		EnvelopeErrors: report.Envelopes,
+		Churned:        report.Churned,
+		Missing:        report.Missing,
+	}
+	if report.Reason != nil {
+		st.Failures.Reason = report.Reason.Error()
+	}
+
+	for sid, cfg := range view.servers {
+		in, out := s.TcpConnections.Links(int(sid))
+		_, churned := report.Churned[int(sid)]
+		st.Peers = append(st.Peers, admin.PeerStatus{
+			Id:       int(sid),
+			Address:  cfg.Address,
+			Incoming: in.String(),
+			Outgoing: out.String(),
+			Churned:  churned,
+		})
+	}
+	sort.Slice(st.Peers, func(i, j int) bool { return st.Peers[i].Id < st.Peers[j].Id })
+
+	for gid, g := range view.groups {
+		group := admin.GroupStatus{Id: int(gid), MissingSignatures: g.CheckpointState.MissingSignatures()}
+		if cfg := view.groupConfigs[int64(gid)]; cfg != nil {
+			for _, sid := range cfg.Servers {
+				group.Servers = append(group.Servers, int(sid))
+			}
+		}
+		st.Groups = append(st.Groups, group)
+	}
+	sort.Slice(st.Groups, func(i, j int) bool { return st.Groups[i].Id < st.Groups[j].Id })
+
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+	st.Memory = admin.MemoryStatus{
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		Sys:        mem.Sys,
+		NumGC:      mem.NumGC,
+		Goroutines: runtime.NumGoroutine(),
+		Uptime:     time.Since(s.created),
+	}
+	return st, nil
+}
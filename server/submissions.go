@@ -0,0 +1,58 @@
+package server
+
+// Clients retry submissions whose response was lost
+// an identical envelope is processed once per round and gets the same receipt
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+type submission struct {
+	done    chan struct{}
+	receipt *messages.SignedMessage
+	err     error
+}
+
+type submissionTable struct {
+	mu      sync.Mutex
+	round   int
+	entries map[[sha256.Size]byte]*submission
+}
+
+func newSubmissionTable() *submissionTable {
+	return &submissionTable{entries: make(map[[sha256.Size]byte]*submission)}
+}
+
+// process the envelope unless it was already submitted this round
+// concurrent copies wait for the first and share its result
+// a failed submission is forgotten so that it can be retried
+func (t *submissionTable) Submit(round int, envelope []byte, process func() (*messages.SignedMessage, error)) (*messages.SignedMessage, error) {
+	h := sha256.Sum256(envelope)
+	t.mu.Lock()
+	if round != t.round {
+		t.round = round
+		t.entries = make(map[[sha256.Size]byte]*submission)
+	}
+	if s, exists := t.entries[h]; exists {
+		t.mu.Unlock()
+		<-s.done
+		return s.receipt, s.err
+	}
+	s := &submission{done: make(chan struct{})}
+	t.entries[h] = s
+	t.mu.Unlock()
+
+	s.receipt, s.err = process()
+	if s.err != nil {
+		t.mu.Lock()
+		if t.entries[h] == s {
+			delete(t.entries, h)
+		}
+		t.mu.Unlock()
+	}
+	close(s.done)
+	return s.receipt, s.err
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/simonlangowski/lightning1/network/messages"
+)
+
+func TestSubmissionIdempotent(t *testing.T) {
+	table := newSubmissionTable()
+	var processed int32
+	release := make(chan struct{})
+	process := func() (*messages.SignedMessage, error) {
+		atomic.AddInt32(&processed, 1)
+		<-release
+		return &messages.SignedMessage{}, nil
+	}
+	envelope := []byte("envelope")
+	receipts := make([]*messages.SignedMessage, 4)
+	wg := sync.WaitGroup{}
+	for i := range receipts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			receipts[i], err = table.Submit(1, envelope, process)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+	if processed != 1 {
+		t.Fatalf("Envelope processed %d times", processed)
+	}
+	for _, r := range receipts {
+		if r != receipts[0] {
+			t.Fatal("Different receipts for the same envelope")
+		}
+	}
+
+	// a different envelope, or the same envelope next round, is new
+	table.Submit(1, []byte("other"), process)
+	table.Submit(2, envelope, process)
+	if processed != 3 {
+		t.Fatalf("Expected 3 envelopes processed, got %d", processed)
+	}
+}
+
+func TestSubmissionRetryAfterFailure(t *testing.T) {
+	table := newSubmissionTable()
+	fail := errors.New("failed")
+	_, err := table.Submit(0, []byte("envelope"), func() (*messages.SignedMessage, error) {
+		return nil, fail
+	})
+	if err != fail {
+		t.Fatalf("Expected failure, got %v", err)
+	}
+	r, err := table.Submit(0, []byte("envelope"), func() (*messages.SignedMessage, error) {
+		return &messages.SignedMessage{}, nil
+	})
+	if err != nil || r == nil {
+		t.Fatalf("Retry was not processed: %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package tracing
+
+// Spans of a round across the coordinator and all servers
+// The coordinator starts a trace for each round and passes it to servers in grpc metadata
+// servers pass the span that sent a batch in its signed metadata, so one trace shows the
+// critical path through every layer
+// Spans are written as OTLP json lines, which the OpenTelemetry collector can import
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+// identifies a span, the zero value means there is no trace
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// packed size in message metadata
+const ContextSize = 16 + 8
+
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != TraceID{} && s.SpanID != SpanID{}
+}
+
+func (s SpanContext) PackTo(b []byte) {
+	copy(b[:16], s.TraceID[:])
+	copy(b[16:ContextSize], s.SpanID[:])
+}
+
+func (s *SpanContext) InterpretFrom(b []byte) {
+	copy(s.TraceID[:], b[:16])
+	copy(s.SpanID[:], b[16:ContextSize])
+}
+
+// W3C trace context header
+func (s SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]))
+}
+
+func ParseTraceparent(h string) (SpanContext, bool) {
+	s := SpanContext{}
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return s, false
+	}
+	t, err := hex.DecodeString(parts[1])
+	if err != nil || len(t) != len(s.TraceID) {
+		return s, false
+	}
+	p, err := hex.DecodeString(parts[2])
+	if err != nil || len(p) != len(s.SpanID) {
+		return s, false
+	}
+	copy(s.TraceID[:], t)
+	copy(s.SpanID[:], p)
+	return s, s.IsValid()
+}
+
+const traceparentKey = "traceparent"
+
+// add the span to the metadata of outgoing rpcs
+func Inject(ctx context.Context, s SpanContext) context.Context {
+	if !s.IsValid() {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, traceparentKey, s.Traceparent())
+}
+
+// the span that made this rpc
+// in process calls pass the caller's context directly, so outgoing metadata is checked too
+func Extract(ctx context.Context) SpanContext {
+	for _, get := range []func(context.Context) (metadata.MD, bool){metadata.FromIncomingContext, metadata.FromOutgoingContext} {
+		md, ok := get(ctx)
+		if !ok {
+			continue
+		}
+		for _, h := range md.Get(traceparentKey) {
+			if s, ok := ParseTraceparent(h); ok {
+				return s
+			}
+		}
+	}
+	return SpanContext{}
+}
+
+type exporter struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	service string
+}
+
+var (
+	mu  sync.RWMutex
+	exp *exporter
+)
+
+// spans are only recorded after Start
+// directory for span files, e.g. LIGHTNING_TRACE=traces
+const DirEnv = "LIGHTNING_TRACE"
+
+// write spans to <dir>/<service>.jsonl
+func Start(dir string, service string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, service+".jsonl"))
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if exp != nil {
+		exp.close()
+	}
+	exp = &exporter{f: f, w: bufio.NewWriter(f), service: service}
+	return nil
+}
+
+// start tracing if DirEnv is set
+func StartFromEnv(service string) error {
+	dir := os.Getenv(DirEnv)
+	if dir == "" {
+		return nil
+	}
+	return Start(dir, service)
+}
+
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exp != nil
+}
+
+func Flush() {
+	mu.RLock()
+	defer mu.RUnlock()
+	if exp != nil {
+		exp.mu.Lock()
+		exp.w.Flush()
+		exp.mu.Unlock()
+	}
+}
+
+// flush and stop recording spans
+func Stop() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if exp == nil {
+		return nil
+	}
+	err := exp.close()
+	exp = nil
+	return err
+}
+
+func (e *exporter) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Flush()
+	return e.f.Close()
+}
+
+// a timed operation, nil when tracing is off so callers do not need to check
+type Span struct {
+	name       string
+	context    SpanContext
+	parent     SpanID
+	start      time.Time
+	attributes []interface{}
+}
+
+// start a span, in a new trace if the parent is not valid
+func StartSpan(parent SpanContext, name string, kv ...interface{}) *Span {
+	if !Enabled() {
+		return nil
+	}
+	s := &Span{name: name, start: time.Now(), attributes: kv}
+	if parent.IsValid() {
+		s.context.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.context.TraceID[:])
+	}
+	rand.Read(s.context.SpanID[:])
+	return s
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes = append(s.attributes, kv...)
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	mu.RLock()
+	defer mu.RUnlock()
+	if exp == nil {
+		return
+	}
+	b, err := json.Marshal(exp.record(s, end))
+	if err != nil {
+		return
+	}
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+	exp.w.Write(b)
+	exp.w.WriteByte('\n')
+}
+
+// OTLP json encoding, see opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// internal spans
+const spanKindInternal = 1
+
+func (e *exporter) record(s *Span, end time.Time) *otlpTraces {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attributes(s.attributes),
+	}
+	if s.parent != (SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	return &otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes([]interface{}{"service.name", e.service})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "lightning"}, Spans: []otlpSpan{span}}},
+	}}}
+}
+
+func attributes(kv []interface{}) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		a := otlpAttribute{Key: fmt.Sprint(kv[i])}
+		switch v := kv[i+1].(type) {
+		case int:
+			n := strconv.FormatInt(int64(v), 10)
+			a.Value.IntValue = &n
+		case int64:
+			n := strconv.FormatInt(v, 10)
+			a.Value.IntValue = &n
+		case float64:
+			a.Value.DoubleValue = &v
+		case bool:
+			a.Value.BoolValue = &v
+		default:
+			str := fmt.Sprint(v)
+			a.Value.StringValue = &str
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
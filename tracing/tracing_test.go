@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTraceparent(t *testing.T) {
+	s := SpanContext{}
+	for i := range s.TraceID {
+		s.TraceID[i] = byte(i + 1)
+	}
+	s.SpanID[0] = 0xab
+	h := s.Traceparent()
+	if h != "00-0102030405060708090a0b0c0d0e0f10-ab00000000000000-01" {
+		t.Fatalf("Wrong header %s", h)
+	}
+	parsed, ok := ParseTraceparent(h)
+	if !ok || parsed != s {
+		t.Fatalf("Parsed %v", parsed)
+	}
+	for _, bad := range []string{"", "00-01-02-01", "01-0102030405060708090a0b0c0d0e0f10-ab00000000000000-01",
+		"00-00000000000000000000000000000000-ab00000000000000-01"} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Fatalf("Accepted %s", bad)
+		}
+	}
+	b := make([]byte, ContextSize)
+	s.PackTo(b)
+	unpacked := SpanContext{}
+	unpacked.InterpretFrom(b)
+	if unpacked != s {
+		t.Fatal("Packing changed the context")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	s := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	// in process calls see the outgoing context
+	ctx := Inject(context.Background(), s)
+	if Extract(ctx) != s {
+		t.Fatal("Lost context in process")
+	}
+	// remote calls see it as incoming metadata
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if Extract(metadata.NewIncomingContext(context.Background(), md)) != s {
+		t.Fatal("Lost context over rpc")
+	}
+	if Extract(context.Background()).IsValid() {
+		t.Fatal("Context without a trace")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	if StartSpan(SpanContext{}, "off").Context().IsValid() {
+		t.Fatal("Recorded a span while disabled")
+	}
+	dir := t.TempDir()
+	err := Start(dir, "server1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := StartSpan(SpanContext{}, "round", "round", 3)
+	layer := StartSpan(round.Context(), "layer", "layer", 1, "path", true, "type", "forward")
+	layer.End()
+	round.End()
+	err = Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "server1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := make([]otlpSpan, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		traces := otlpTraces{}
+		if err := json.Unmarshal(scanner.Bytes(), &traces); err != nil {
+			t.Fatal(err)
+		}
+		rs := traces.ResourceSpans[0]
+		if *rs.Resource.Attributes[0].Value.StringValue != "server1" {
+			t.Fatalf("Wrong resource %v", rs.Resource)
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 2 || spans[0].Name != "layer" || spans[1].Name != "round" {
+		t.Fatalf("Wrong spans %v", spans)
+	}
+	if spans[0].TraceID != spans[1].TraceID || spans[0].ParentSpanID != spans[1].SpanID || spans[1].ParentSpanID != "" {
+		t.Fatalf("Spans not linked %v", spans)
+	}
+	attrs := spans[0].Attributes
+	if *attrs[0].Value.IntValue != "1" || !*attrs[1].Value.BoolValue || *attrs[2].Value.StringValue != "forward" {
+		t.Fatalf("Wrong attributes %v", attrs)
+	}
+}